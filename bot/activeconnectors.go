@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* activeconnectors.go - the robot can be connected to more than one chat
+   platform at once. The connector named by Protocol is the primary
+   connector; ExtraConnectors in gopherbot.yaml start more. Each connector
+   gets a handler tagged with it's protocol, so a message carries it's source
+   through the pipeline and replies go back out the connector it came in on.
+   Messages not started by a user - scheduled jobs, for instance - go to the
+   primary connector.
+
+   User IDs and channels are only meaningful to one platform, so UserRoster
+   and ChannelRoster entries for an ExtraConnector are listed with it's
+   Protocol, and messages from users of an ExtraConnector who aren't listed
+   for it are ignored; otherwise a user on one platform could claim the name
+   of, say, an administrator on another. Messages to a user by name go out
+   the connector for that user's protocol.
+*/
+
+// ExtraConnector configures an additional connector to start with the robot
+type ExtraConnector struct {
+	Protocol       string          // name of a registered connector, e.g. "slack"
+	ProtocolConfig json.RawMessage // the connector's configuration, as for ProtocolConfig
+}
+
+// extraConnectors is read from gopherbot.yaml at start-up, like protocolConfig
+var extraConnectors []ExtraConnector
+
+// activeConnectors holds the running connectors by protocol, including the
+// primary.
+var activeConnectors = struct {
+	sync.RWMutex
+	c map[string]Connector
+}{
+	c: make(map[string]Connector),
+}
+
+// initExtraConnectors initializes the ExtraConnectors after the primary
+// connector; called at start-up before run().
+func initExtraConnectors(logger *log.Logger) {
+	botCfg.RLock()
+	primary := botCfg.protocol
+	botCfg.RUnlock()
+	for _, ec := range extraConnectors {
+		if ec.Protocol == primary {
+			Log(Error, fmt.Sprintf("ExtraConnectors protocol '%s' is the primary Protocol, skipping", ec.Protocol))
+			continue
+		}
+		initializeConnector, ok := connectors[ec.Protocol]
+		if !ok {
+			Log(Error, fmt.Sprintf("No connector registered with name '%s' in ExtraConnectors, skipping", ec.Protocol))
+			continue
+		}
+		activeConnectors.Lock()
+		_, dup := activeConnectors.c[ec.Protocol]
+		activeConnectors.Unlock()
+		if dup {
+			Log(Error, fmt.Sprintf("Duplicate protocol '%s' in ExtraConnectors, skipping", ec.Protocol))
+			continue
+		}
+		conn := initializeConnector(handler{protocol: ec.Protocol}, logger)
+		activeConnectors.Lock()
+		activeConnectors.c[ec.Protocol] = conn
+		activeConnectors.Unlock()
+		logConnector(ec.Protocol, conn)
+	}
+}
+
+// connectorFor returns the active connector for a protocol, or the primary
+// connector for "" or a protocol that isn't running.
+func connectorFor(protocol string) Connector {
+	if len(protocol) > 0 {
+		activeConnectors.RLock()
+		conn, ok := activeConnectors.c[protocol]
+		activeConnectors.RUnlock()
+		if ok {
+			return conn
+		}
+	}
+	botCfg.RLock()
+	conn := botCfg.Connector
+	botCfg.RUnlock()
+	return conn
+}
+
+// userConnector returns the connector for sending to a user by name, with
+// the protocol it's for and the user to send to; a user listed in the
+// UserRoster is on the connector for their Protocol, since their roster ID
+// means nothing to other connectors, and other names go to the source
+// connector as-is.
+func userConnector(maps *userChanMaps, source, user string) (Connector, string, string) {
+	if maps != nil {
+		if ui, ok := maps.user[user]; ok {
+			return connectorFor(ui.Protocol), ui.Protocol, bracket(ui.UserID)
+		}
+	}
+	return connectorFor(source), source, user
+}
+
+// protocolChannel returns the channel to send to for a channel name on a
+// protocol, using the ChannelRoster for that protocol.
+func protocolChannel(maps *userChanMaps, protocol, channel string) string {
+	if maps != nil {
+		if ci, ok := maps.channel[protocolKey{protocol, channel}]; ok {
+			return bracket(ci.ChannelID)
+		}
+	}
+	return channel
+}
+
+// runConnectors starts each active connector's Run loop in it's own
+// goroutine, closing done when they've all returned.
+func runConnectors(stop <-chan struct{}, done chan<- struct{}) {
+	var wg sync.WaitGroup
+	activeConnectors.RLock()
+	for protocol, conn := range activeConnectors.c {
+		wg.Add(1)
+		go func(protocol string, conn Connector) {
+			privThread(fmt.Sprintf("connector loop (%s)", protocol))
+			conn.Run(stop)
+			Log(Debug, fmt.Sprintf("Connector loop for protocol '%s' returned", protocol))
+			wg.Done()
+		}(protocol, conn)
+	}
+	activeConnectors.RUnlock()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// connectionStatus reports the connection state of each active connector,
+// for the 'connection status' admin command.
+func connectionStatus() string {
+	activeConnectors.RLock()
+	protocols := make([]string, 0, len(activeConnectors.c))
+	for protocol := range activeConnectors.c {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	var status strings.Builder
+	for i, protocol := range protocols {
+		if i > 0 {
+			status.WriteString("\n")
+		}
+		if cr, ok := activeConnectors.c[protocol].(ConnectionReporter); ok {
+			_, cs := cr.ConnectionStatus()
+			status.WriteString(fmt.Sprintf("%s: %s", protocol, cs))
+		} else {
+			status.WriteString(fmt.Sprintf("%s: connector doesn't report it's connection status", protocol))
+		}
+	}
+	activeConnectors.RUnlock()
+	return status.String()
+}
+
+// connector returns the connector a Robot's messages go to; the one the
+// triggering message arrived on.
+func (r *Robot) connector() Connector {
+	return connectorFor(r.source)
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* aliases.go - Aliases give a plugin's commands shorter or alternate words,
+   e.g. "d: deploy", without writing more regexes. The alias is replaced
+   with the command text at the start of a command before CommandMatchers
+   are checked, so "d web" matches the same as "deploy web", with the same
+   arguments. Aliases are single words, matched case-insensitively; the
+   command text can be more than one word, e.g. "ci: check in". Help lists
+   the command as written, noting it's aliases.
+*/
+
+// compileAliases checks a plugin's Aliases when the configuration loads,
+// lower-casing them for matching.
+func (plugin *BotPlugin) compileAliases() error {
+	if len(plugin.Aliases) == 0 {
+		return nil
+	}
+	aliases := make(map[string]string, len(plugin.Aliases))
+	plugin.aliasesOf = make(map[string][]string)
+	for alias, command := range plugin.Aliases {
+		if len(alias) == 0 || strings.ContainsAny(alias, " \t\n") {
+			return fmt.Errorf("invalid alias '%s', aliases must be a single word", alias)
+		}
+		command = strings.Join(strings.Fields(command), " ")
+		if len(command) == 0 {
+			return fmt.Errorf("alias '%s' has no command", alias)
+		}
+		lalias, lcommand := strings.ToLower(alias), strings.ToLower(command)
+		if lalias == lcommand {
+			return fmt.Errorf("alias '%s' is the same as it's command", alias)
+		}
+		if _, dup := aliases[lalias]; dup {
+			return fmt.Errorf("duplicate alias '%s'", alias)
+		}
+		aliases[lalias] = command
+		plugin.aliasesOf[lcommand] = append(plugin.aliasesOf[lcommand], alias)
+	}
+	for _, list := range plugin.aliasesOf {
+		sort.Strings(list)
+	}
+	plugin.Aliases = aliases
+	return nil
+}
+
+// resolveAlias replaces an alias at the start of a command with the
+// command text it stands for.
+func (plugin *BotPlugin) resolveAlias(cmsg string) string {
+	if len(plugin.Aliases) == 0 {
+		return cmsg
+	}
+	word, rest := strings.TrimSpace(cmsg), ""
+	if i := strings.IndexByte(word, ' '); i != -1 {
+		word, rest = word[:i], word[i:]
+	}
+	if command, ok := plugin.Aliases[strings.ToLower(word)]; ok {
+		return command + rest
+	}
+	return cmsg
+}
+
+// aliasNote returns " (aliases: ...)" for a help line for a command with
+// aliases, e.g. "(bot), deploy <app> - ...", or "" for other lines.
+func (plugin *BotPlugin) aliasNote(helptext string) string {
+	if len(plugin.aliasesOf) == 0 || !strings.HasPrefix(helptext, "(bot)") {
+		return ""
+	}
+	text := strings.TrimPrefix(helptext, "(bot)")
+	text = strings.TrimSpace(strings.TrimPrefix(text, ","))
+	text = strings.ToLower(strings.Join(strings.Fields(text), " ")) + " "
+	// the longest command wins, e.g. "check in" over "check"
+	matched := ""
+	for command := range plugin.aliasesOf {
+		if len(command) > len(matched) && strings.HasPrefix(text, command+" ") {
+			matched = command
+		}
+	}
+	switch aliases := plugin.aliasesOf[matched]; len(aliases) {
+	case 0:
+		return ""
+	case 1:
+		return " (alias: " + aliases[0] + ")"
+	default:
+		return " (aliases: " + strings.Join(aliases, ", ") + ")"
+	}
+}
+
+// isAlias reports whether a help term is an alias for the keyword
+func (plugin *BotPlugin) isAlias(term, keyword string) bool {
+	command, ok := plugin.Aliases[strings.ToLower(term)]
+	return ok && strings.EqualFold(command, keyword)
+}
@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* artifacts.go - named files saved by a job during a run, e.g. test reports
+   or build outputs, for fetching later with 'show artifact'. Artifacts are
+   stored under <WorkSpace>/artifacts/<job(:namespace)>/run-<index>/, and
+   runs are pruned to the job's HistoryLogs and MaxHistoryAge, the same as
+   histories.
+*/
+
+// maximum size of an artifact shown in a message when the connector can't
+// send files
+const maxArtifactMessage = 4000
+
+// artifact names can't contain path separators
+var artifactNameRe = regexp.MustCompile(`^\w[\w.-]*$`)
+
+// artifactDir returns the directory for a job's artifacts; namespace
+// extensions (repository/branch) use ':' in place of '/'.
+func artifactDir(jobSpec string) string {
+	botCfg.RLock()
+	workSpace := botCfg.workSpace
+	botCfg.RUnlock()
+	jobSpec = strings.Replace(jobSpec, `\`, ":", -1)
+	jobSpec = strings.Replace(jobSpec, `/`, ":", -1)
+	return filepath.Join(workSpace, "artifacts", jobSpec)
+}
+
+// pruneArtifacts removes artifacts for runs older than the last keep runs.
+func pruneArtifacts(dir string, index, keep int) {
+	runs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, run := range runs {
+		if !run.IsDir() || !strings.HasPrefix(run.Name(), "run-") {
+			continue
+		}
+		ri, err := strconv.Atoi(strings.TrimPrefix(run.Name(), "run-"))
+		if err != nil || ri > index-keep {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, run.Name())); err != nil {
+			Log(Error, fmt.Sprintf("Error removing old artifacts '%s': %v", run.Name(), err))
+		}
+	}
+}
+
+// SaveArtifact stores a named artifact for the current job run, which an
+// administrator can retrieve later with 'show artifact <job> <run> <name>'.
+// Artifacts are kept for as many runs as the job's HistoryLogs (at least
+// one), and no longer than it's MaxHistoryAge. Only available in a job
+// pipeline.
+func (r *Robot) SaveArtifact(name string, content io.Reader) RetVal {
+	c := r.getContext()
+	if len(c.jobName) == 0 {
+		r.Log(Error, "SaveArtifact called with no job in progress")
+		return InvalidStage
+	}
+	if !artifactNameRe.MatchString(name) {
+		r.Log(Error, fmt.Sprintf("Invalid artifact name '%s', doesn't match regexp: '%s'", name, artifactNameRe.String()))
+		return FailedArtifactSave
+	}
+	jobSpec := c.jobName
+	if len(c.nsExtension) > 0 {
+		jobSpec += ":" + c.nsExtension
+	}
+	keep := 1
+	if t := c.tasks.getTaskByName(c.jobName); t != nil {
+		if _, _, job := getTask(t); job != nil {
+			runs, _ := job.historyRetention()
+			keep = keepLogs(runs, c.runIndex)
+		}
+	}
+	dir := artifactDir(jobSpec)
+	runDir := filepath.Join(dir, fmt.Sprintf("run-%d", c.runIndex))
+	if err := os.MkdirAll(runDir, 0750); err != nil {
+		r.Log(Error, fmt.Sprintf("Error creating artifact directory '%s': %v", runDir, err))
+		return FailedArtifactSave
+	}
+	path := filepath.Join(runDir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		r.Log(Error, fmt.Sprintf("Error creating artifact '%s': %v", path, err))
+		return FailedArtifactSave
+	}
+	_, err = io.Copy(f, content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		r.Log(Error, fmt.Sprintf("Error writing artifact '%s': %v", path, err))
+		return FailedArtifactSave
+	}
+	r.Log(Debug, fmt.Sprintf("Saved artifact '%s' for job '%s', run %d", name, jobSpec, c.runIndex))
+	pruneArtifacts(dir, c.runIndex, keep)
+	return Ok
+}
+
+// showArtifact is the 'show artifact' admin command; with no name, it lists
+// the artifacts for the run.
+func showArtifact(r *Robot, jobSpec, run, name string) {
+	runDir := filepath.Join(artifactDir(jobSpec), "run-"+run)
+	if len(name) == 0 {
+		files, err := ioutil.ReadDir(runDir)
+		if err != nil || len(files) == 0 {
+			r.Say(fmt.Sprintf("I don't have any artifacts for job '%s', run %s", jobSpec, run))
+			return
+		}
+		al := []string{fmt.Sprintf("Artifacts for job '%s', run %s:", jobSpec, run)}
+		for _, f := range files {
+			al = append(al, fmt.Sprintf("%s (%d bytes)", f.Name(), f.Size()))
+		}
+		r.MessageFormat(Variable).Say(strings.Join(al, "\n"))
+		return
+	}
+	if !artifactNameRe.MatchString(name) {
+		r.Say(fmt.Sprintf("Invalid artifact name '%s'", name))
+		return
+	}
+	path := filepath.Join(runDir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		r.Say(fmt.Sprintf("I don't have an artifact '%s' for job '%s', run %s", name, jobSpec, run))
+		return
+	}
+	defer f.Close()
+	comment := fmt.Sprintf("Artifact '%s' from job '%s', run %s", name, jobSpec, run)
+	ret := r.SendFile(name, f, comment)
+	if ret != FileSendNotSupported {
+		if ret != Ok {
+			r.Say(fmt.Sprintf("Sorry, I wasn't able to send the artifact: %s", ret))
+		}
+		return
+	}
+	// fall back to showing the content
+	f.Seek(0, io.SeekStart)
+	content, _ := ioutil.ReadAll(io.LimitReader(f, maxArtifactMessage+1))
+	if len(content) > maxArtifactMessage {
+		content = append(content[:maxArtifactMessage], []byte("\n(truncated)")...)
+	}
+	r.Fixed().Say(fmt.Sprintf("%s:\n%s", comment, content))
+}
@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* audit.go - an optional audit log of who ran what. When AuditLog is
+   configured, every pipeline run records the user, channel, command,
+   arguments, elevation result and outcome, either as JSON lines appended to
+   a dedicated file, or in a rolling list in the brain. Admins can view the
+   most recent entries with the 'audit log' builtin.
+*/
+
+const (
+	auditLogKey      = "bot:auditLog"
+	defaultAuditKeep = 500
+	defaultAuditShow = 10
+)
+
+// AuditLog configures the audit log of pipeline runs
+type AuditLog struct {
+	File           string // append JSON lines to this file, instead of the brain
+	Keep           int    // how many entries to keep in the brain, default 500
+	RedactElevated bool   // don't record the arguments of commands requiring elevation
+}
+
+// auditEntry records a single pipeline run
+type auditEntry struct {
+	Time      string
+	User      string
+	Channel   string
+	Type      string // what started the pipeline
+	Task      string
+	Command   string
+	Args      []string
+	Elevation string // "not required", "succeeded", "failed" or "already elevated"
+	Outcome   string
+}
+
+var pipelineTypeNames = map[pipelineType]string{
+	plugCommand: "command",
+	plugMessage: "message",
+	catchAll:    "catchall",
+	jobTrigger:  "trigger",
+	spawnedTask: "spawned",
+	scheduled:   "scheduled",
+	jobCmd:      "job command",
+	pipeAdd:     "added",
+	plugAction:  "action",
+}
+
+// commands whose arguments are secrets, never recorded
+var auditRedacted = map[string]map[string]bool{
+	"builtin-dmadmin": {
+		"store":       true,
+		"storeglobal": true,
+		"encrypt":     true,
+	},
+}
+
+// serializes writes to the audit file
+var auditFileLock sync.Mutex
+
+// auditPipeline records a completed pipeline in the audit log, if configured.
+func (c *botContext) auditPipeline(t interface{}, ptype pipelineType, command string, args []string, ret TaskRetVal) {
+	botCfg.RLock()
+	cfg := botCfg.auditLog
+	botCfg.RUnlock()
+	if cfg == nil {
+		return
+	}
+	task, _, _ := getTask(t)
+	entry := auditEntry{
+		Time:      time.Now().Format(time.RFC3339),
+		User:      c.User,
+		Channel:   c.Channel,
+		Type:      pipelineTypeNames[ptype],
+		Task:      task.name,
+		Command:   command,
+		Args:      args,
+		Elevation: c.elevation,
+		Outcome:   ret.String(),
+	}
+	if entry.Elevation == "" {
+		if c.elevated {
+			entry.Elevation = "already elevated"
+		} else {
+			entry.Elevation = "not required"
+		}
+	}
+	switch {
+	case len(args) == 0:
+	case auditRedacted[task.name][command]:
+		entry.Args = []string{"(redacted)"}
+	case c.directMsg:
+		// as for the log in callTask, DMs are where secrets get sent
+		entry.Args = []string{"(omitted for DM)"}
+	case cfg.RedactElevated:
+		if required, _ := elevationRequired(t, command); required {
+			entry.Args = []string{"(redacted)"}
+		}
+	}
+	if len(cfg.File) > 0 {
+		if err := appendAuditFile(cfg.File, entry); err != nil {
+			Log(Error, fmt.Sprintf("Writing audit log entry to '%s': %v", cfg.File, err))
+		}
+		return
+	}
+	keep := cfg.Keep
+	if keep <= 0 {
+		keep = defaultAuditKeep
+	}
+	var entries []auditEntry
+	tok, _, bret := checkoutDatum(auditLogKey, &entries, true)
+	if bret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', audit entry lost: %s", auditLogKey, bret))
+		return
+	}
+	entries = append(entries, entry)
+	if len(entries) > keep {
+		entries = entries[len(entries)-keep:]
+	}
+	if bret := updateDatum(auditLogKey, tok, entries); bret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s': %s", auditLogKey, bret))
+	}
+}
+
+func appendAuditFile(path string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditFileLock.Lock()
+	defer auditFileLock.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recentAuditEntries returns the last n entries from the audit log.
+func recentAuditEntries(cfg *AuditLog, n int) ([]auditEntry, error) {
+	var entries []auditEntry
+	if len(cfg.File) > 0 {
+		auditFileLock.Lock()
+		defer auditFileLock.Unlock()
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry auditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) > n {
+				entries = entries[1:]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+	_, _, ret := checkoutDatum(auditLogKey, &entries, false)
+	if ret != Ok {
+		return nil, fmt.Errorf("retrieving '%s': %s", auditLogKey, ret)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+// showAuditLog is the 'audit log' admin command.
+func showAuditLog(r *Robot, count string) {
+	botCfg.RLock()
+	cfg := botCfg.auditLog
+	botCfg.RUnlock()
+	if cfg == nil {
+		r.Say("The audit log isn't enabled; configure AuditLog in gopherbot.yaml")
+		return
+	}
+	n := defaultAuditShow
+	if len(count) > 0 {
+		fmt.Sscanf(count, "%d", &n)
+	}
+	if n <= 0 {
+		n = defaultAuditShow
+	}
+	entries, err := recentAuditEntries(cfg, n)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Reading the audit log: %v", err))
+		r.Say("Sorry, I had a problem reading the audit log; check the logs")
+		return
+	}
+	if len(entries) == 0 {
+		r.Say("The audit log is empty")
+		return
+	}
+	var lines []string
+	for _, e := range entries {
+		user := e.User
+		if user == "" {
+			user = "(automatic)"
+		}
+		channel := e.Channel
+		if channel == "" {
+			channel = "(direct)"
+		}
+		cmd := e.Command
+		if len(e.Args) > 0 {
+			cmd += " " + strings.Join(e.Args, " ")
+		}
+		lines = append(lines, fmt.Sprintf("%s %s in %s: %s %s/%s, elevation %s: %s", e.Time, user, channel, e.Type, e.Task, cmd, e.Elevation, e.Outcome))
+	}
+	r.Fixed().Say(strings.Join(lines, "\n"))
+}
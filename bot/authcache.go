@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* authcache.go - Authorizer plugins often query LDAP or an HTTP service, so
+   calling one for every command is slow. With AuthCache configured, the
+   result of an authorization is remembered for a user, channel, AuthRequire
+   and command for TTL, and a denial for NegativeTTL. The cache is in memory
+   only; it's flushed on reload, or with 'flush auth cache' after group
+   changes. Changes to builtin-groups groups evict the user's results.
+*/
+
+// AuthCache configures caching of Authorizer results
+type AuthCache struct {
+	TTL         string // how long a successful authorization is remembered, e.g. "5m"
+	NegativeTTL string // how long a denial is remembered, e.g. "30s"; denials aren't cached by default
+}
+
+// authResult is a cached authorization
+type authResult struct {
+	user       string
+	authorized bool
+	expires    time.Time
+}
+
+var authCache = struct {
+	sync.Mutex
+	r map[string]authResult
+}{
+	r: make(map[string]authResult),
+}
+
+// authTTLs parses the AuthCache configuration; zero durations disable
+// caching.
+func (a *AuthCache) authTTLs() (ttl, negative time.Duration) {
+	if a == nil {
+		return
+	}
+	if len(a.TTL) > 0 {
+		if d, err := time.ParseDuration(a.TTL); err == nil && d >= 0 {
+			ttl = d
+		} else {
+			Log(Error, fmt.Sprintf("Parsing AuthCache TTL '%s', authorizations won't be cached", a.TTL))
+		}
+	}
+	if len(a.NegativeTTL) > 0 {
+		if d, err := time.ParseDuration(a.NegativeTTL); err == nil && d >= 0 {
+			negative = d
+		} else {
+			Log(Error, fmt.Sprintf("Parsing AuthCache NegativeTTL '%s', denials won't be cached", a.NegativeTTL))
+		}
+	}
+	return
+}
+
+// authCacheKey identifies an authorization; the task and authorizer are
+// included so the same command name in different plugins doesn't collide,
+// and the channel since an authorizer may decide based on it.
+func authCacheKey(user, channel, authorizer, task, authRequire, command string) string {
+	return strings.Join([]string{user, channel, authorizer, task, authRequire, command}, ":")
+}
+
+// cachedAuth returns a cached authorization result, if there is one
+func cachedAuth(key string) (authorized, found bool) {
+	authCache.Lock()
+	defer authCache.Unlock()
+	res, ok := authCache.r[key]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(res.expires) {
+		delete(authCache.r, key)
+		return false, false
+	}
+	return res.authorized, true
+}
+
+// cacheAuth remembers an authorization result for the configured TTL
+func cacheAuth(key, user string, authorized bool) {
+	botCfg.RLock()
+	ttl := botCfg.authTTL
+	if !authorized {
+		ttl = botCfg.authNegativeTTL
+	}
+	botCfg.RUnlock()
+	if ttl == 0 {
+		return
+	}
+	now := time.Now()
+	authCache.Lock()
+	// drop expired entries so the cache doesn't grow without bound
+	for k, res := range authCache.r {
+		if now.After(res.expires) {
+			delete(authCache.r, k)
+		}
+	}
+	authCache.r[key] = authResult{user, authorized, now.Add(ttl)}
+	authCache.Unlock()
+}
+
+// flushAuthCache empties the cache, returning the number of unexpired
+// results dropped.
+func flushAuthCache() int {
+	now := time.Now()
+	authCache.Lock()
+	defer authCache.Unlock()
+	count := 0
+	for _, res := range authCache.r {
+		if !now.After(res.expires) {
+			count++
+		}
+	}
+	authCache.r = make(map[string]authResult)
+	return count
+}
+
+// evictUserAuth drops the cached results for a user, e.g. when their group
+// membership changes.
+func evictUserAuth(user string) {
+	authCache.Lock()
+	defer authCache.Unlock()
+	for k, res := range authCache.r {
+		if res.user == user {
+			delete(authCache.r, k)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* authgroups.go - a built-in authorizer for robots without LDAP or another
+   directory, with group membership stored in the brain. Admins manage
+   membership with 'add user <u> to group <g>' and friends, and tasks use it
+   by setting 'Authorizer: builtin-groups' (or DefaultAuthorizer) and
+   'AuthRequire: <group>'. All groups are kept in a single datum in the
+   robot's own 'bot:' namespace, so plugin memories can't collide with it, and
+   every edit is a checkout/update of that datum, so edits from two admins
+   are serialized rather than overwriting each other.
+*/
+
+const authGroupsKey = "bot:groups"
+
+// authGroups is the datum stored in the brain; group names are stored
+// lower-case, mapping to a sorted list of users.
+type authGroups map[string][]string
+
+func init() {
+	RegisterPlugin("builtin-groups", PluginHandler{Handler: authgroups})
+}
+
+// groupMembers returns the members of a group, and whether it exists
+func groupMembers(group string) ([]string, bool, RetVal) {
+	var groups authGroups
+	_, _, ret := checkoutDatum(authGroupsKey, &groups, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to look up group '%s'", authGroupsKey, group))
+		return nil, false, ret
+	}
+	members, ok := groups[strings.ToLower(group)]
+	return members, ok, Ok
+}
+
+// userGroups resolves the groups a user belongs to
+func userGroups(user string) ([]string, RetVal) {
+	var groups authGroups
+	_, _, ret := checkoutDatum(authGroupsKey, &groups, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to look up groups for user '%s'", authGroupsKey, user))
+		return nil, ret
+	}
+	var member []string
+	for group, users := range groups {
+		for _, u := range users {
+			if u == user {
+				member = append(member, group)
+				break
+			}
+		}
+	}
+	sort.Strings(member)
+	return member, Ok
+}
+
+// updateGroup adds or removes a user, returning false with no change if the
+// user was already (or wasn't) a member. A group is removed with it's last
+// member.
+func updateGroup(group, user string, add bool) (bool, RetVal) {
+	group = strings.ToLower(group)
+	var groups authGroups
+	tok, _, ret := checkoutDatum(authGroupsKey, &groups, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to update group '%s'", authGroupsKey, group))
+		return false, ret
+	}
+	if groups == nil {
+		groups = make(authGroups)
+	}
+	members := groups[group]
+	found := -1
+	for i, u := range members {
+		if u == user {
+			found = i
+			break
+		}
+	}
+	if (add && found >= 0) || (!add && found < 0) {
+		checkinDatum(authGroupsKey, tok)
+		return false, Ok
+	}
+	if add {
+		members = append(members, user)
+		sort.Strings(members)
+		groups[group] = members
+	} else {
+		members = append(members[:found], members[found+1:]...)
+		if len(members) == 0 {
+			delete(groups, group)
+		} else {
+			groups[group] = members
+		}
+	}
+	if ret := updateDatum(authGroupsKey, tok, groups); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to update group '%s'", authGroupsKey, group))
+		return false, ret
+	}
+	evictUserAuth(user)
+	return true, Ok
+}
+
+func authgroups(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	switch command {
+	case "authorize":
+		// args: task name, AuthRequire, command, command args...
+		if len(args) < 2 || len(args[1]) == 0 {
+			r.Log(Error, fmt.Sprintf("The builtin-groups authorizer requires a group name; task '%s' must set 'AuthRequire'", args[0]))
+			return ConfigurationError
+		}
+		groups, ret := userGroups(r.User)
+		if ret != Ok {
+			return MechanismFail
+		}
+		for _, group := range groups {
+			if strings.EqualFold(group, args[1]) {
+				return Success
+			}
+		}
+		r.Log(Debug, fmt.Sprintf("User '%s' isn't in group '%s'; member of: %s", r.User, args[1], strings.Join(groups, ", ")))
+		return Fail
+	case "add", "remove":
+		user, group := args[0], args[1]
+		if _, ok := r.getContext().maps.user[user]; command == "add" && !ok {
+			r.Log(Warn, fmt.Sprintf("Adding user '%s' to group '%s', but the user isn't in the roster", user, group))
+		}
+		changed, ret := updateGroup(group, user, command == "add")
+		if ret != Ok {
+			r.Say("I had a problem updating the group, check the log")
+			return
+		}
+		switch {
+		case command == "add" && changed:
+			r.Log(Audit, fmt.Sprintf("User '%s' added user '%s' to group '%s'", r.User, user, group))
+			r.Say(fmt.Sprintf("Ok, I added %s to the %s group", user, group))
+		case command == "add":
+			r.Say(fmt.Sprintf("%s is already in the %s group", user, group))
+		case changed:
+			r.Log(Audit, fmt.Sprintf("User '%s' removed user '%s' from group '%s'", r.User, user, group))
+			r.Say(fmt.Sprintf("Ok, I removed %s from the %s group", user, group))
+		default:
+			r.Say(fmt.Sprintf("%s isn't in the %s group", user, group))
+		}
+	case "list":
+		members, exists, ret := groupMembers(args[0])
+		if ret != Ok {
+			r.Say("I had a problem retrieving the group, check the log")
+			return
+		}
+		if !exists {
+			r.Say(fmt.Sprintf("The %s group has no members", args[0]))
+			return
+		}
+		r.Say(fmt.Sprintf("The %s group has the following members:\n%s", args[0], strings.Join(members, "\n")))
+	}
+	return
+}
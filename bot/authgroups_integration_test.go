@@ -0,0 +1,49 @@
+// +build integration
+
+package bot_test
+
+// authgroups_integration_test.go - verification of brain-backed group
+// management with the builtin-groups authorizer.
+
+import (
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func TestAuthGroups(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";list group deployers", []testc.TestMessage{{null, general, "The deployers group has no members"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";add user bob to group Deployers", []testc.TestMessage{{null, general, "Ok, I added bob to the Deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";add user carol to group deployers", []testc.TestMessage{{null, general, "Ok, I added carol to the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";add user bob to group deployers", []testc.TestMessage{{null, general, "bob is already in the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";list group Deployers", []testc.TestMessage{{null, general, "(?m:The Deployers group has the following members:\nbob\ncarol$)"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";remove user bob from group deployers", []testc.TestMessage{{null, general, "Ok, I removed bob from the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";remove user bob from group deployers", []testc.TestMessage{{null, general, "bob isn't in the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";list group deployers", []testc.TestMessage{{null, general, "(?m:The deployers group has the following members:\ncarol$)"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestAuthGroupsCache(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";add user bob to group deployers", []testc.TestMessage{{null, general, "Ok, I added bob to the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, "quiet", "bender, echo one", []testc.TestMessage{{null, "quiet", "one"}}, []Event{GoPluginRan, AuthRanSuccess, CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, "quiet", "bender, echo two", []testc.TestMessage{{null, "quiet", "two"}}, []Event{AuthCacheHit, CommandTaskRan, ExternalTaskRan}, 0},
+		// results are cached per-channel
+		{bobID, "crowded", "!echo three", []testc.TestMessage{{null, "crowded", "three"}}, []Event{GoPluginRan, AuthRanSuccess, CommandTaskRan, ExternalTaskRan}, 0},
+		// a group change evicts bob's cached results
+		{aliceID, general, ";remove user bob from group deployers", []testc.TestMessage{{null, general, "Ok, I removed bob from the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, "quiet", "bender, echo four", []testc.TestMessage{{null, "quiet", "Sorry, you're not authorized for that command"}}, []Event{GoPluginRan, AuthRanFail}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
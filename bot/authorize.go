@@ -53,17 +53,30 @@ func (c *botContext) checkAuthorization(t interface{}, command string, args ...s
 	}
 	_, authPlug, _ := getTask(c.tasks.getTaskByName(authorizer))
 	if authPlug != nil {
+		cacheKey := authCacheKey(c.User, c.Channel, authPlug.name, task.name, task.AuthRequire, command)
+		if authorized, found := cachedAuth(cacheKey); found {
+			emit(AuthCacheHit)
+			if authorized {
+				Log(Audit, fmt.Sprintf("Authorization succeeded (cached) by authorizer '%s' for user '%s' calling command '%s' for task '%s' in channel '%s'; AuthRequire: '%s'", authPlug.name, c.User, command, task.name, c.Channel, task.AuthRequire))
+				return Success
+			}
+			Log(Audit, fmt.Sprintf("Authorization FAILED (cached) by authorizer '%s' for user '%s' calling command '%s' for task '%s' in channel '%s'; AuthRequire: '%s'", authPlug.name, c.User, command, task.name, c.Channel, task.AuthRequire))
+			r.Say("Sorry, you're not authorized for that command")
+			return Fail
+		}
 		args = append([]string{task.name, task.AuthRequire, command}, args...)
 		_, authRet := c.callTask(authPlug, "authorize", args...)
 		if authRet == Success {
 			Log(Audit, fmt.Sprintf("Authorization succeeded by authorizer '%s' for user '%s' calling command '%s' for task '%s' in channel '%s'; AuthRequire: '%s'", authPlug.name, c.User, command, task.name, c.Channel, task.AuthRequire))
 			emit(AuthRanSuccess)
+			cacheAuth(cacheKey, c.User, true)
 			return Success
 		}
 		if authRet == Fail {
 			Log(Audit, fmt.Sprintf("Authorization FAILED by authorizer '%s' for user '%s' calling command '%s' for task '%s' in channel '%s'; AuthRequire: '%s'", authPlug.name, c.User, command, task.name, c.Channel, task.AuthRequire))
 			r.Say("Sorry, you're not authorized for that command")
 			emit(AuthRanFail)
+			cacheAuth(cacheKey, c.User, false)
 			return Fail
 		}
 		if authRet == MechanismFail {
@@ -21,24 +21,33 @@ func (c *botContext) pluginAvailable(task *BotTask, helpSystem, verboseOnly bool
 		nvmsg += c.Channel
 		vmsg += c.Channel
 	}
-	defer func(vmsg string) {
-		if available {
-			c.debugTask(task, vmsg, verboseOnly)
+	available, reason := c.taskAvailability(task, helpSystem)
+	if available {
+		if len(reason) > 0 {
+			vmsg += "; " + reason
 		}
-	}(vmsg)
+		c.debugTask(task, vmsg, verboseOnly)
+	} else {
+		c.debugTask(task, nvmsg+"; "+reason, verboseOnly)
+	}
+	return
+}
+
+// taskAvailability does the work for pluginAvailable, returning the reason
+// when the task isn't available, or the channel pattern that made it
+// available; also used by the explain builtin.
+func (c *botContext) taskAvailability(task *BotTask, helpSystem bool) (bool, string) {
 	if task.Disabled {
-		c.debugTask(task, nvmsg+"; task is disabled, possibly due to configuration error", verboseOnly)
-		return false
+		return false, "task is disabled, possibly due to configuration error"
 	}
 	if !c.directMsg && task.DirectOnly && !helpSystem {
-		c.debugTask(task, nvmsg+"; only available by direct message: DirectOnly is TRUE", verboseOnly)
-		return false
+		return false, "only available by direct message: DirectOnly is TRUE"
 	}
 	if c.directMsg && !task.AllowDirect && !helpSystem {
-		c.debugTask(task, nvmsg+"; not available by direct message: AllowDirect is FALSE", verboseOnly)
-		return false
+		return false, "not available by direct message: AllowDirect is FALSE"
 	}
-	if task.RequireAdmin {
+	cfg := task.forChannel(c.Channel)
+	if cfg.RequireAdmin {
 		isAdmin := false
 		botCfg.RLock()
 		admins := botCfg.adminUsers
@@ -50,40 +59,44 @@ func (c *botContext) pluginAvailable(task *BotTask, helpSystem, verboseOnly bool
 			}
 		}
 		if !isAdmin {
-			c.debugTask(task, nvmsg+"; RequireAdmin is TRUE and user isn't an Admin", verboseOnly)
-			return false
+			return false, "RequireAdmin is TRUE and user isn't an Admin"
 		}
 	}
-	if len(task.Users) > 0 {
+	if len(cfg.Users) > 0 {
 		userOk := false
-		for _, allowedUser := range task.Users {
+		for _, allowedUser := range cfg.Users {
 			match, err := filepath.Match(allowedUser, c.User)
 			if match && err == nil {
 				userOk = true
 			}
 		}
 		if !userOk {
-			c.debugTask(task, nvmsg+"; user is not on the list of allowed users", verboseOnly)
-			return false
+			return false, "user is not on the list of allowed users"
 		}
 	}
 	if c.directMsg && (task.AllowDirect || task.DirectOnly) {
-		return true
+		return true, ""
 	}
 	if len(task.Channels) > 0 {
 		for _, pchannel := range task.Channels {
 			if pchannel == c.Channel {
-				return true
+				return true, ""
+			}
+		}
+		// exact names are checked first, so the log shows a pattern only
+		// when no name matched
+		for _, pattern := range task.channelPatterns {
+			if match, err := filepath.Match(pattern, c.Channel); match && err == nil {
+				return true, fmt.Sprintf("matched channel pattern '%s'", pattern)
 			}
 		}
 	} else {
 		if task.AllChannels {
-			return true
+			return true, ""
 		}
 	}
 	if helpSystem {
-		return true
+		return true, ""
 	}
-	c.debugTask(task, fmt.Sprintf(nvmsg+"; channel '%s' is not on the list of allowed channels: %s", c.Channel, strings.Join(task.Channels, ", ")), verboseOnly)
-	return false
+	return false, fmt.Sprintf("channel '%s' is not on the list of allowed channels: %s", c.Channel, strings.Join(task.Channels, ", "))
 }
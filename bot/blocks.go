@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/* blocks.go - rich messages with Slack Block Kit layout: sections, fields,
+   buttons and the like. Connectors that declare CapBlocks send the blocks
+   as-is; for other connectors the robot derives a plain text fallback from
+   the blocks. When a user clicks a button, the connector hands the robot a
+   ConnectorAction. A plugin's ActionMatchers match the button's action_id
+   and run a command with the button's value as the last argument; or its
+   ActionCommands map the action_id to the text of a command, which is then
+   matched as if the user had typed it. Either way the same security checks
+   apply.
+*/
+
+// ConnectorAction is passed in to the robot when a user clicks a button (or
+// uses another interactive element) in a message sent with SendBlocks.
+type ConnectorAction struct {
+	// Protocol - string name of connector, e.g. "Slack"
+	Protocol string
+	// optional UserName and required internal UserID
+	UserName, UserID string
+	// optional / required channel values
+	ChannelName, ChannelID string
+	// DirectMessage - whether the message with the button was a DM
+	DirectMessage bool
+	// ThreadID - the thread of the message with the button, if any
+	ThreadID string
+	// ActionID - the action_id of the button
+	ActionID string
+	// Value - the value of the button, if any
+	Value string
+	// ActionObject, Client - interfaces for the raw
+	ActionObject, Client interface{}
+}
+
+// actionValue is replaced in ActionCommands by the button's value
+const actionValue = "$value"
+
+// SendBlocks sends a Block Kit message to the current channel, or to the
+// user for a direct message. The blocks can be a JSON string, []byte or
+// json.RawMessage, or anything that marshals to a JSON array of blocks.
+// Connectors without Block Kit support get a plain text version of the
+// message. Returns DataFormatError if the blocks aren't valid.
+func (r *Robot) SendBlocks(blocks interface{}) RetVal {
+	raw, err := marshalBlocks(blocks)
+	if err != nil {
+		r.Log(Error, fmt.Sprintf("Invalid blocks for SendBlocks: %v", err))
+		return DataFormatError
+	}
+	fallback := blocksFallback(raw)
+	conn := r.connector()
+	if connectorSupports(conn, CapBlocks) {
+		if sender, ok := conn.(BlockSender); ok {
+			if r.Channel == "" {
+				user := r.ProtocolUser
+				if len(user) == 0 {
+					user = r.User
+				}
+				return sender.SendProtocolUserBlocks(user, raw, fallback, r.messageOptions())
+			}
+			channel := r.ProtocolChannel
+			if len(channel) == 0 {
+				channel = r.Channel
+			}
+			return sender.SendProtocolChannelBlocks(channel, raw, fallback, r.messageOptions())
+		}
+	}
+	return r.Say(fallback)
+}
+
+// marshalBlocks returns the blocks as a JSON array
+func marshalBlocks(blocks interface{}) (json.RawMessage, error) {
+	var raw []byte
+	switch b := blocks.(type) {
+	case string:
+		raw = []byte(b)
+	case []byte:
+		raw = b
+	case json.RawMessage:
+		raw = b
+	default:
+		var err error
+		if raw, err = json.Marshal(blocks); err != nil {
+			return nil, err
+		}
+	}
+	var check []map[string]interface{}
+	if err := json.Unmarshal(raw, &check); err != nil {
+		return nil, fmt.Errorf("blocks must be a JSON array of objects: %v", err)
+	}
+	if len(check) == 0 {
+		return nil, fmt.Errorf("no blocks")
+	}
+	return raw, nil
+}
+
+// block has the fields of the Block Kit blocks that carry text
+type block struct {
+	Type     string            `json:"type"`
+	Text     json.RawMessage   `json:"text"`
+	Fields   []json.RawMessage `json:"fields"`
+	Elements []json.RawMessage `json:"elements"`
+	Title    json.RawMessage   `json:"title"`
+	AltText  string            `json:"alt_text"`
+}
+
+// blocksFallback renders blocks as plain text, one line per block; buttons
+// are shown in brackets.
+func blocksFallback(raw json.RawMessage) string {
+	var blocks []block
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+	var lines []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "header":
+			lines = append(lines, blockText(b.Text))
+		case "section":
+			if t := blockText(b.Text); len(t) > 0 {
+				lines = append(lines, t)
+			}
+			for _, f := range b.Fields {
+				lines = append(lines, blockText(f))
+			}
+		case "context", "actions":
+			var parts []string
+			for _, e := range b.Elements {
+				var el block
+				if err := json.Unmarshal(e, &el); err != nil {
+					continue
+				}
+				switch el.Type {
+				case "button":
+					parts = append(parts, "["+blockText(el.Text)+"]")
+				case "image":
+					parts = append(parts, el.AltText)
+				case "mrkdwn", "plain_text":
+					parts = append(parts, blockText(e))
+				}
+			}
+			if len(parts) > 0 {
+				lines = append(lines, strings.Join(parts, " "))
+			}
+		case "divider":
+			lines = append(lines, "---")
+		case "image":
+			if t := blockText(b.Title); len(t) > 0 {
+				lines = append(lines, t)
+			} else if len(b.AltText) > 0 {
+				lines = append(lines, b.AltText)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blockText returns the text of a text object, or a bare string
+func blockText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var t struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &t); err == nil {
+		return t.Text
+	}
+	return ""
+}
+
+// IncomingAction accepts a button click from the connector. A plugin with
+// ActionMatchers for the action ID runs directly (see handleAction);
+// otherwise the robot runs the command the plugin's ActionCommands give
+// for the action ID, as if the user had typed it to the robot.
+func (h handler) IncomingAction(act *ConnectorAction) {
+	if len(act.UserID) == 0 || len(act.ActionID) == 0 {
+		Log(Error, "incoming action with no user ID or action ID")
+		return
+	}
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	var command string
+	for _, t := range tasks {
+		task, plugin, _ := getTask(t)
+		if plugin == nil || task.Disabled {
+			continue
+		}
+		if cmd, ok := plugin.ActionCommands[act.ActionID]; ok {
+			command = strings.Replace(cmd, actionValue, act.Value, -1)
+			break
+		}
+	}
+	Log(Debug, fmt.Sprintf("Action '%s' with value '%s' from user ID '%s'; ActionCommands command: '%s'", act.ActionID, act.Value, act.UserID, command))
+	h.incomingMessage(&ConnectorMessage{
+		Protocol:      act.Protocol,
+		UserName:      act.UserName,
+		UserID:        act.UserID,
+		ChannelName:   act.ChannelName,
+		ChannelID:     act.ChannelID,
+		DirectMessage: act.DirectMessage,
+		MessageText:   command,
+		ThreadID:      act.ThreadID,
+		MessageObject: act.ActionObject,
+		Client:        act.Client,
+	}, act)
+}
+
+// handleAction runs the plugin with ActionMatchers matching the action ID,
+// with the button's value as the last argument; failing that, the command
+// text from ActionCommands is handled like any other command. Returns true
+// when the action was handled.
+func (c *botContext) handleAction() bool {
+	if c.checkPluginMatchersAndRun(plugAction) {
+		return true
+	}
+	if len(c.msg) > 0 {
+		return false
+	}
+	Log(Warn, fmt.Sprintf("No plugin has ActionMatchers or ActionCommands for action ID '%s', ignoring", c.action.ActionID))
+	return true
+}
@@ -29,6 +29,14 @@ ROUTINE ======================== github.com/lnxjedi/gopherbot/bot...
 */
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
 	"strings"
@@ -51,6 +59,148 @@ import (
 // Environment setting(s) for expanding installed conf/gopherbot.yaml
 func init() {
 	os.Setenv("GOPHER_PROTOCOL", "test")
+	// a plugin that panics during init should be disabled, not crash the robot
+	RegisterPlugin("panicinit", PluginHandler{
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			if command == "init" {
+				panic("failed to initialize")
+			}
+			return Normal
+		},
+	})
+	// a plugin that panics in a command should be disabled, not crash the robot
+	RegisterPlugin("panicky", PluginHandler{
+		DefaultConfig: `
+CommandMatchers:
+- Command: crash
+  Regex: '(?i:crash and burn)'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			if command == "crash" {
+				panic("crashed and burned")
+			}
+			return Normal
+		},
+	})
+	// a plugin whose command is dropped while an identical one is running
+	RegisterPlugin("singleton", PluginHandler{
+		DefaultConfig: `
+Singleton: true
+CommandMatchers:
+- Command: deploy
+  Regex: '(?i:deploy (\w+) slowly)'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			if command == "deploy" {
+				time.Sleep(500 * time.Millisecond)
+				r.Say("Deployed " + args[0])
+			}
+			return Normal
+		},
+	})
+	// a plugin with aliases for it's commands
+	RegisterPlugin("aliased", PluginHandler{
+		DefaultConfig: `
+Aliases:
+  s: ship
+  ci: check in
+Help:
+- Keywords: [ "ship" ]
+  Helptext: [ "(bot), ship <app> - ship an app" ]
+CommandMatchers:
+- Command: ship
+  Regex: '(?i:ship (\w+))'
+- Command: checkin
+  Regex: '(?i:check in)'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			switch command {
+			case "ship":
+				r.Say("Shipping " + args[0])
+			case "checkin":
+				r.Say("Checked in")
+			}
+			return Normal
+		},
+	})
+	// a plugin that runs until it's pipeline is cancelled
+	RegisterPlugin("ctxwait", PluginHandler{
+		DefaultConfig: `
+CommandMatchers:
+- Command: wait
+  Regex: '(?i:wait for cancel)'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			if command == "wait" {
+				r.Say("Waiting for cancel")
+				<-r.Context().Done()
+				r.Say("Cancelled: " + r.Context().Err().Error())
+			}
+			return Normal
+		},
+	})
+	// a plugin that asks a few questions with Converse
+	RegisterPlugin("survey", PluginHandler{
+		DefaultConfig: `
+CommandMatchers:
+- Command: survey
+  Regex: '(?i:take survey)'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			if command != "survey" {
+				return Normal
+			}
+			answers, ret := r.Converse([]Question{
+				{Name: "name", Prompt: "What's your name?", RegexID: "SimpleString", Validate: func(a string) error {
+					if len(a) < 3 {
+						return errors.New("That's too short")
+					}
+					return nil
+				}},
+				{Name: "likes", Prompt: "Do you like Go?", RegexID: "YesNo", Default: "yes"},
+			})
+			if ret != Ok {
+				r.Say(fmt.Sprintf("Survey ended: %s", ret))
+				return Normal
+			}
+			r.Say(fmt.Sprintf("Thanks %s, likes Go: %s", answers["name"], answers["likes"]))
+			return Normal
+		},
+	})
+	// a plugin that sends Block Kit messages with buttons
+	RegisterPlugin("blocks", PluginHandler{
+		DefaultConfig: `
+CommandMatchers:
+- Command: show
+  Regex: '(?i:show blocks)'
+- Command: approve
+  Regex: '(?i:approve build ([\w-]+))'
+ActionCommands:
+  approve: "approve build $value"
+ActionMatchers:
+- Command: reject
+  Regex: 'reject'
+`,
+		Handler: func(r *Robot, command string, args ...string) TaskRetVal {
+			switch command {
+			case "show":
+				r.SendBlocks(`[
+  {"type": "header", "text": {"type": "plain_text", "text": "Build ready"}},
+  {"type": "section", "text": {"type": "mrkdwn", "text": "Build 42 passed"}, "fields": [{"type": "mrkdwn", "text": "Branch: main"}]},
+  {"type": "divider"},
+  {"type": "actions", "elements": [
+    {"type": "button", "text": {"type": "plain_text", "text": "Approve"}, "action_id": "approve", "value": "build-42"},
+    {"type": "button", "text": {"type": "plain_text", "text": "Reject"}, "action_id": "reject", "value": "build-42"}
+  ]}
+]`)
+			case "approve":
+				r.Say("Approved " + args[0])
+			case "reject":
+				r.Say("Rejected " + args[len(args)-1])
+			}
+			return Normal
+		},
+	})
 }
 
 type testItem struct {
@@ -261,6 +411,48 @@ func TestReload(t *testing.T) {
 	teardown(t, done, conn)
 }
 
+func TestDisableTask(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";disable plugin ping", []testc.TestMessage{{null, general, "Disabled plugin 'ping' until it's enabled again, or the next reload"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";enable plugin ping", []testc.TestMessage{{null, general, "Enabled plugin 'ping'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";disable job ping", []testc.TestMessage{{null, general, "I don't have a job named 'ping'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";disable plugin ping persistent", []testc.TestMessage{{null, general, "Disabled plugin 'ping' until it's enabled again$"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, "reload, bender", []testc.TestMessage{{alice, general, "Configuration reloaded successfully"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "list disabled plugins", []testc.TestMessage{{alice, null, `(?s:.*ping \(at runtime\); reason: disabled at runtime by alice.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";enable plugin ping", []testc.TestMessage{{null, general, "Enabled plugin 'ping'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestChannelAddressing(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, "crowded", ";ping", []testc.TestMessage{}, []Event{}, 0},
+		{aliceID, "crowded", "!ping", []testc.TestMessage{{alice, "crowded", "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, "quiet", ";ping", []testc.TestMessage{}, []Event{}, 0},
+		{aliceID, "quiet", "bender, ping", []testc.TestMessage{{alice, "quiet", "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "!ping", []testc.TestMessage{}, []Event{}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		// CommandPrefixes work alongside the alias, but not where the name is required
+		{aliceID, general, "$ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "bot/ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, "crowded", "$ping", []testc.TestMessage{{alice, "crowded", "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, "quiet", "$ping", []testc.TestMessage{}, []Event{}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
 func TestMessageMatch(t *testing.T) {
 	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
 
@@ -280,6 +472,331 @@ func TestMessageMatch(t *testing.T) {
 	teardown(t, done, conn)
 }
 
+func TestArgLimits(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{bobID, general, ";echo not too long", []testc.TestMessage{{null, general, "not too long"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";echo this message is much too long for the echo plugin", []testc.TestMessage{{bob, general, `Sorry, the arguments for that command are too long \(49 characters, the maximum is 40\)`}}, []Event{}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestChannelOverrides(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{bobID, random, ";ping", []testc.TestMessage{{bob, random, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";ping", []testc.TestMessage{{bob, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{bobID, null, ";ping", []testc.TestMessage{{bob, null, "Sorry, that didn't match.*"}}, []Event{BotDirectMessage, CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestConfigSchema(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "list disabled plugins", []testc.TestMessage{{alice, null, `(?s:.*badconfig; reason: Disabling plugin 'badconfig' - Config doesn't match schema: Config.Port: expected integer, got string; ChannelOverrides.random.Config.Mode: value 'loud' not one of: quiet, verbose.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestChannelPatterns(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, random, ";hello robot", []testc.TestMessage{{null, random, "I'm here"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, ";hello robot", []testc.TestMessage{{null, bottest, "I'm here"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, deadzone, ";hello robot", []testc.TestMessage{{alice, deadzone, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestInitPanic(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "list disabled plugins", []testc.TestMessage{{alice, null, `(?s:.*panicinit; reason: panic during init: failed to initialize.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestCommandPanic(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";crash and burn", []testc.TestMessage{{alice, general, "Plugin 'panicky' crashed and has been disabled.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";crash and burn", []testc.TestMessage{{alice, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "list disabled plugins", []testc.TestMessage{{alice, null, `(?s:.*panicky \(at runtime\); reason: panic in command 'crash': crashed and burned.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "status", []testc.TestMessage{{alice, null, `(?s:.*PLUGIN PANICS/CRASHES: \d+ \(.*PANICKY: 1\).*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";reload", []testc.TestMessage{{alice, general, "Configuration reloaded successfully.*"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";crash and burn", []testc.TestMessage{{alice, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "enable plugin panicky", []testc.TestMessage{{alice, null, "Enabled plugin 'panicky'"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";crash and burn", []testc.TestMessage{{alice, general, "Plugin 'panicky' crashed and has been disabled.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "enable plugin panicky", []testc.TestMessage{{alice, null, "Enabled plugin 'panicky'"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestAliases(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";s web", []testc.TestMessage{{null, general, "Shipping web"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";S   db", []testc.TestMessage{{null, general, "Shipping db"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";ci", []testc.TestMessage{{null, general, "Checked in"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		// only the first word is an alias
+		{aliceID, general, ";ship s", []testc.TestMessage{{null, general, "Shipping s"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";help s", []testc.TestMessage{{null, general, `(?s:^Command\(s\) matching keyword: s\n.*ship <app> - ship an app \(alias: s\))`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";explain ;s web", []testc.TestMessage{{null, general, `(?s:.*PLUGIN 'ALIASED': RESOLVED ALIAS TO 'SHIP WEB'; .*\nRESULT: WOULD RUN COMMAND 'SHIP' FOR PLUGIN 'ALIASED'$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestSingleton(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	conn.SendBotMessage(&testc.TestMessage{aliceID, general, ";deploy web slowly"})
+	time.Sleep(100 * time.Millisecond)
+	tests := []testItem{
+		{aliceID, general, ";deploy web slowly", []testc.TestMessage{{alice, general, "That command is already running.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+	if got, err := conn.GetBotMessage(); err != nil || got.Message != "Deployed web" {
+		t.Errorf("FAILED waiting for the first deploy to finish: %v, %v", got, err)
+	}
+
+	// once the first finishes, the same command runs again
+	time.Sleep(100 * time.Millisecond)
+	tests = []testItem{
+		{aliceID, general, ";deploy web slowly", []testc.TestMessage{{null, general, "Deployed web"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestCancelOnQuit(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	conn.SendBotMessage(&testc.TestMessage{aliceID, general, ";hang"})
+	time.Sleep(500 * time.Millisecond)
+	tests := []testItem{
+		{aliceID, general, ";wait for cancel", []testc.TestMessage{{null, general, "Waiting for cancel"}}, []Event{CommandTaskRan, ExternalTaskRan, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	// The first quit waits on the running plugins, the second cancels them
+	conn.SendBotMessage(&testc.TestMessage{aliceID, null, "quit"})
+	time.Sleep(200 * time.Millisecond)
+	conn.SendBotMessage(&testc.TestMessage{aliceID, null, "quit"})
+	want := map[string]bool{
+		"Cancelled: context canceled":           false,
+		"Task 'hang' was cancelled and stopped": false,
+	}
+	for i := 0; i < len(want); i++ {
+		got, err := conn.GetBotMessage()
+		if err != nil {
+			t.Fatalf("FAILED timeout waiting for cancelled plugins")
+		}
+		if _, ok := want[got.Message]; !ok {
+			t.Errorf("FAILED unexpected message after second quit: \"%s\"", got.Message)
+		}
+		want[got.Message] = true
+	}
+	<-done
+
+	cancelled := false
+	for _, e := range *GetEvents() {
+		if e == ExternalTaskCancelled {
+			cancelled = true
+		}
+	}
+	if !cancelled {
+		t.Errorf("FAILED external task wasn't cancelled")
+	}
+}
+
+func TestConverse(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	// Alice takes the survey in two channels at once
+	tests := []testItem{
+		{aliceID, general, ";take survey", []testc.TestMessage{{alice, general, "What's your name?"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, random, ";take survey", []testc.TestMessage{{alice, random, "What's your name?"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "Al", []testc.TestMessage{{alice, general, "That's too short; What's your name?"}}, []Event{}, 0},
+		{aliceID, random, "Bob", []testc.TestMessage{{alice, random, "Do you like Go?"}}, []Event{}, 0},
+		{aliceID, general, "Alice", []testc.TestMessage{{alice, general, "Do you like Go?"}}, []Event{}, 0},
+		{aliceID, general, "=", []testc.TestMessage{{null, general, "Thanks Alice, likes Go: yes"}}, []Event{}, 0},
+		{aliceID, random, "no", []testc.TestMessage{{null, random, "Thanks Bob, likes Go: no"}}, []Event{}, 0},
+		{aliceID, general, ";take survey", []testc.TestMessage{{alice, general, "What's your name?"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "Carol", []testc.TestMessage{{alice, general, "Do you like Go?"}}, []Event{}, 0},
+		{aliceID, general, "-", []testc.TestMessage{{null, general, "Survey ended: Interrupted"}}, []Event{}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestBlocks(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	// The test connector doesn't support blocks, and gets the text version
+	tests := []testItem{
+		{aliceID, general, ";show blocks", []testc.TestMessage{{null, general, `^Build ready\nBuild 42 passed\nBranch: main\n---\n\[Approve\] \[Reject\]$`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	// Clicking "Approve" runs the mapped command
+	conn.SendBotAction(aliceID, general, "approve", "build-42")
+	want := "Approved build-42"
+	if got, err := conn.GetBotMessage(); err != nil {
+		t.Errorf("FAILED timeout waiting for reply to action; want: \"%s\"", want)
+	} else if got.Message != want || got.Channel != general {
+		t.Errorf("FAILED reply to action; want: \"%s\" in %s; got: \"%s\" in %s", want, general, got.Message, got.Channel)
+	}
+	GetEvents()
+	// ActionMatchers run the plugin directly, with the value as the last argument
+	conn.SendBotAction(aliceID, general, "reject", "build-42")
+	want = "Rejected build-42"
+	if got, err := conn.GetBotMessage(); err != nil {
+		t.Errorf("FAILED timeout waiting for reply to action; want: \"%s\"", want)
+	} else if got.Message != want || got.Channel != general {
+		t.Errorf("FAILED reply to action; want: \"%s\" in %s; got: \"%s\" in %s", want, general, got.Message, got.Channel)
+	}
+	if ev := GetEvents(); len(*ev) == 0 || (*ev)[0] != ActionTaskRan {
+		t.Errorf("FAILED events for action; want: ActionTaskRan first; got: %v", *ev)
+	}
+	// an action no plugin handles is ignored
+	conn.SendBotAction(aliceID, general, "defer", "")
+
+	teardown(t, done, conn)
+}
+
+func TestExplain(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";explain ;ping", []testc.TestMessage{{null, general, `(?s:^EXPLAINING MESSAGE FROM USER 'ALICE' IN CHANNEL 'GENERAL': ;PING\nADDRESSED TO THE ROBOT: YES; COMMAND TEXT: 'PING'\n.*\nRESULT: WOULD RUN COMMAND 'PING' FOR PLUGIN 'PING'$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";explain ;ping", []testc.TestMessage{{null, general, `(?s:.*PLUGIN 'PING': SKIPPED - USER IS NOT ON THE LIST OF ALLOWED USERS\n.*\nRESULT: NOTHING MATCHED; .*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";explain hello robot", []testc.TestMessage{{null, general, `(?s:.*ADDRESSED TO THE ROBOT: NO.*\nRESULT: WOULD RUN COMMAND 'HELLO' FOR PLUGIN 'HELLO' \(AMBIENT MATCH\)$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";explain for bob in general: ;ping", []testc.TestMessage{{null, general, `(?s:^EXPLAINING MESSAGE FROM USER 'BOB'.*\nRESULT: NOTHING MATCHED; .*$)`}}, []Event{AdminCheckPassed, CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";explain for alice in general: ;ping", []testc.TestMessage{{null, general, "Sorry, that command is only available to bot administrators"}}, []Event{AdminCheckFailed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestExportConfig(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "export config echo", []testc.TestMessage{{alice, null, `(?s:^HERE'S MY EFFECTIVE CONFIGURATION, WITH SECRETS REDACTED:\nPLUGINS:\n  ECHO:\n.*MAXARGLENGTH: 40\n.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "export json config", []testc.TestMessage{{alice, null, `(?s:.*"ROBOT": \{.*"ENCRYPTIONKEY": "XXXXXX".*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "export config test", []testc.TestMessage{{alice, null, `(?s:^HERE'S MY EFFECTIVE CONFIGURATION, WITH SECRETS REDACTED:\nPLUGINS:\n  TEST:\n.*CONFIG:\n      GREETING: XXXXXX\n.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "show plugin concurrency", []testc.TestMessage{{alice, null, `(?s:^PLUGIN CONCURRENCY:\nPING: RUNNING 0 OF 2; QUEUED 0 OF 0; REJECTED SINCE START: 0$)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "export config nosuchtask", []testc.TestMessage{{alice, null, "Sorry, I couldn't export the configuration: no task named 'nosuchtask'"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestDefaultConfig(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		// NOTE: the configuration is format = Fixed, which for the test connector is ALL CAPS
+		{aliceID, general, ";defaultconfig echo", []testc.TestMessage{{alice, general, `\(I sent you the default configuration for 'echo' in a private message\)`}, {alice, null, `(?s:^HERE'S THE DEFAULT CONFIGURATION FOR "ECHO":\n.*COMMANDMATCHERS:)`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, null, "default config for builtin-help", []testc.TestMessage{{alice, null, `(?s:^HERE'S THE DEFAULT CONFIGURATION FOR "BUILTIN-HELP":\n)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";defaultconfig junk", []testc.TestMessage{{null, general, "Didn't find a plugin named junk"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{bobID, general, ";defaultconfig echo", []testc.TestMessage{{bob, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestTaskInfo(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "list tasks", []testc.TestMessage{{alice, null, `(?s:^LOADED TASKS \(\d+\):\n.*\nBUILTIN-DMADMIN: PLUGIN \(GO\); ENABLED; DIRECT MESSAGES ONLY\n.*\nHELLO: PLUGIN \(EXTERNAL\); ENABLED; CHANNELS: GENERAL, RANDOM\nHELLO2: .*\nPANICINIT: PLUGIN \(GO\); DISABLED: PANIC DURING INIT: FAILED TO INITIALIZE; CHANNELS: GENERAL, RANDOM\n.*\nPIPELINE: JOB \(EXTERNAL\); ENABLED; CHANNEL: GENERAL\n.*\nSOFTFAIL: TASK \(EXTERNAL\); ENABLED\n.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "task info ping", []testc.TestMessage{{alice, null, `(?s:^TASK 'PING': PLUGIN \(GO\)\nSTATUS: ENABLED\nNAMESPACE: PING\nALL CHANNELS\nUSERS: ALICE, CAROL\nCOMMAND MATCHERS:\n  PING: .*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "task info pipeline", []testc.TestMessage{{alice, null, `^TASK 'PIPELINE': JOB \(EXTERNAL\)\nSTATUS: ENABLED\nNAMESPACE: PIPELINE\nPATH: JOBS/PIPELINE.SH\nCHANNEL: GENERAL$`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "list namespaces", []testc.TestMessage{{alice, null, `(?s:^NAMESPACES SHARED BY MORE THAN ONE TASK:\n.*SSH-INIT: SSH-ADMIN, SSH-INIT.*)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "task info nonesuch", []testc.TestMessage{{alice, null, `^I DON'T HAVE A TASK NAMED 'NONESUCH'$`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{bobID, null, "list tasks", []testc.TestMessage{{bob, null, "Sorry, that didn't match.*"}}, []Event{BotDirectMessage, CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestRunbook(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "run commands: ping", []testc.TestMessage{{alice, null, "PONG"}, {alice, null, `(?s:^RUNBOOK FINISHED, ALL COMMANDS SUCCEEDED:\n1. PING - OK$)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "run commands: no such command", []testc.TestMessage{{alice, null, `(?s:^RUNBOOK FINISHED, 1 OF 1 COMMANDS FAILED:\n1. NO SUCH COMMAND - FAILED: NO COMMAND WAS RUN$)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{bobID, null, "run commands: ping", []testc.TestMessage{{bob, null, "Sorry, that didn't match.*"}}, []Event{BotDirectMessage, CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";run commands: run job aging", []testc.TestMessage{{null, general, `Starting job 'aging', run 0`}, {null, general, `aging run 0`}, {null, general, `Finished job 'aging', run 0`}, {null, general, `(?s:^RUNBOOK FINISHED, ALL COMMANDS SUCCEEDED:\n1. RUN JOB AGING - OK$)`}}, []Event{CommandTaskRan, GoPluginRan, JobTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestRunbookMaxPipelines(t *testing.T) {
+	done, conn := setup("resources/cfg/maxpipelines", "/tmp/bottest.log", t)
+
+	// runbook steps use the runbook's pipeline slot; with MaxPipelines: 1
+	// they'd otherwise wait forever for it
+	tests := []testItem{
+		{aliceID, null, "run commands: ping\nping", []testc.TestMessage{{alice, null, "PONG"}, {alice, null, "PONG"}, {alice, null, `(?s:^RUNBOOK FINISHED, ALL COMMANDS SUCCEEDED:\n1. PING - OK\n2. PING - OK$)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, CommandTaskRan, GoPluginRan, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestIncident(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";open incident sev2: database down", []testc.TestMessage{{null, general, `(?s:^Incident #1 \(SEV2\) opened by alice: database down\n.*)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";incident status failover in progress", []testc.TestMessage{{null, general, "Incident #1 status from alice: failover in progress"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";incident severity sev9", []testc.TestMessage{{null, general, "Sorry, 'sev9' isn't a valid severity; use one of: SEV1, SEV2, SEV3, SEV4"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";incident note restarted the primary", []testc.TestMessage{{null, general, "Added to the timeline for incident #1"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";incident 1 join", []testc.TestMessage{{null, general, "Added bob to the participants for incident #1"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";incident timeline", []testc.TestMessage{{null, general, `(?s:^TIMELINE FOR INCIDENT #1 \(SEV2\): DATABASE DOWN\n.*ALICE: INCIDENT OPENED \(SEV2\): DATABASE DOWN\n.*BOB: JOINED THE INCIDENT$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";close incident: replica promoted", []testc.TestMessage{{null, general, `(?s:^Incident #1 closed: database down\nSeverity: SEV2; duration: .*; last status: failover in progress\nSummary: replica promoted\nParticipants: alice, bob\nTimeline: 5 events.*)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";list incidents", []testc.TestMessage{{null, general, "There are no open incidents"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";incident status all clear", []testc.TestMessage{{null, general, "Sorry, there's no open incident here.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
 func TestVisibility(t *testing.T) {
 	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
 
@@ -294,6 +811,23 @@ func TestVisibility(t *testing.T) {
 	teardown(t, done, conn)
 }
 
+func TestHelpCategories(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";help toys", []testc.TestMessage{{null, general, `(?s:^Command\(s\) in category Toys available in this channel:\n[^\n]*repeat \(me\)[^\n]*\n\n[^\n]*echo <something>[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";help", []testc.TestMessage{{alice, general, `\(the help.*private message\)`}, {alice, null, `(?s:^Command\(s\) available in channel: general\n.*\n\nToys:\n\n[^\n]*repeat \(me\).*echo <something>)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";help echo", []testc.TestMessage{{null, general, `(?s:^Command\(s\) matching keyword: echo\n.*echo <something>)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, deadzone, ";help toys", []testc.TestMessage{{null, deadzone, "Sorry, I didn't find any commands matching your keyword"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "help toys", []testc.TestMessage{{alice, null, `(?s:^Command\(s\) in category Toys available by direct message:\n.*echo <something>)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		// DirectOnly commands are listed in help by direct message
+		{aliceID, null, "help", []testc.TestMessage{{alice, null, `(?s:^Command\(s\) available by direct message:\n.*show log \(page X\))`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
 func TestBuiltins(t *testing.T) {
 	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
 
@@ -341,6 +875,517 @@ func TestPrompting(t *testing.T) {
 	teardown(t, done, conn)
 }
 
+func TestTaskTimeout(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";hang", []testc.TestMessage{{alice, general, `Task 'hang' timed out after 2s and was stopped`}}, []Event{CommandTaskRan, ExternalTaskRan, ExternalTaskTimedOut}, 0},
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestNamedGroups(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";deploy hotfix-2 to prod", []testc.TestMessage{{null, general, `Deploying hotfix-2 to prod \(hotfix-2, prod\)`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestInsensitive(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";shout hello", []testc.TestMessage{{null, general, `HELLO!`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";Shout   Hello", []testc.TestMessage{{null, general, `HELLO!`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestRateLimit(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{bobID, general, ";shout one", []testc.TestMessage{{null, general, `ONE!`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";shout two", []testc.TestMessage{{null, general, `TWO!`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";shout three", []testc.TestMessage{{bob, general, `running 'shout' too often - try again in \d+s`}}, []Event{}, 0},
+		{bobID, general, ";deploy main to stage", []testc.TestMessage{{null, general, `Deploying main to stage`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";shout four", []testc.TestMessage{{null, general, `FOUR!`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestSuggest(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain-noalias", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, "bender, pnig", []testc.TestMessage{{null, general, "Did you mean `ping`\\?"}}, []Event{}, 0},
+		{aliceID, general, "bender, shuot hello", []testc.TestMessage{{null, general, "Did you mean `shout` or `show`\\?"}}, []Event{}, 0},
+		// internal Command names, like the runbook's "fetch", aren't suggested
+		{aliceID, general, "bender, fecth x", []testc.TestMessage{{null, general, "Did you mean `echo` or `flush`\\?"}}, []Event{}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestAuditLog(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain-noalias", "/tmp/bottest.log", t)
+
+	// the test connector upper-cases fixed format messages; pauses let the
+	// pipeline finish recording it's entry
+	tests := []testItem{
+		{bobID, general, "bender: echo hello world", []testc.TestMessage{{null, general, "hello world"}}, []Event{CommandTaskRan, ExternalTaskRan}, 200},
+		{aliceID, general, "bender, ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 200},
+		{aliceID, general, "bender, audit log 2", []testc.TestMessage{{null, general, `(?s)BOB IN GENERAL: COMMAND \S+/ECHO HELLO WORLD, ELEVATION NOT REQUIRED: NORMAL\n.*ALICE IN GENERAL: COMMAND PING/PING, ELEVATION NOT REQUIRED: NORMAL$`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		// arguments sent by DM are never recorded
+		{bobID, null, "echo hush hush", []testc.TestMessage{{bob, null, "hush hush"}}, []Event{BotDirectMessage, CommandTaskRan, ExternalTaskRan}, 200},
+		{aliceID, general, "bender, audit log 1", []testc.TestMessage{{null, general, `COMMAND \S+/ECHO \(OMITTED FOR DM\), ELEVATION NOT REQUIRED: NORMAL$`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+// apiRequest sends a request to the robot's http listener, returning the
+// status code and body.
+func apiRequest(t *testing.T, method, path, token, body string) (int, string) {
+	req, _ := http.NewRequest(method, "http://127.0.0.1:8889"+path, strings.NewReader(body))
+	if len(token) > 0 {
+		req.Header.Set("X-Gopherbot-Token", token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sending %s request for %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	rb, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(rb)
+}
+
+const apiToken = "integration-test-token"
+
+func TestConfigEnv(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";info", []testc.TestMessage{{null, general, `(?s:.*administrative contact for this robot is: David Parsley, <parsley@linuxjedi.org>$)`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+
+	// GOPHERBOT_* overrides the setting in gopherbot.yaml
+	os.Setenv("GOPHERBOT_ADMIN_CONTACT", "Ops On-Call")
+	defer os.Unsetenv("GOPHERBOT_ADMIN_CONTACT")
+	done, conn = setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests = []testItem{
+		{aliceID, general, ";info", []testc.TestMessage{{null, general, `(?s:.*administrative contact for this robot is: Ops On-Call$)`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestMetrics(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	status, metrics := apiRequest(t, "GET", "/metrics", "", "")
+	if status != http.StatusOK {
+		t.Errorf("metrics: want status %d, got %d", http.StatusOK, status)
+	}
+	for _, want := range []string{
+		`gopherbot_pipelines_running{pool="commands"} 0`,
+		`gopherbot_pipelines_max{pool="commands"} 20`,
+		`gopherbot_pipelines_queue_max{pool="commands"} 20`,
+		`gopherbot_plugin_commands_max{plugin="ping"} 2`,
+		"gopherbot_command_shaper_rate 0\n",
+		"gopherbot_command_shaper_queued 0\n",
+		`# TYPE gopherbot_task_panics_total counter`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics: missing '%s' in:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `pool="scheduled"`) {
+		t.Errorf("metrics: scheduled pool reported without MaxScheduledJobs")
+	}
+
+	teardown(t, done, conn)
+}
+
+func TestLocalToken(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	checkAdmin := `{"FuncName": "CheckAdmin", "CallerID": "0000"}`
+	if status, _ := apiRequest(t, "POST", "/json", "", checkAdmin); status != http.StatusUnauthorized {
+		t.Errorf("request without a token: want status %d, got %d", http.StatusUnauthorized, status)
+	}
+	if status, _ := apiRequest(t, "POST", "/json", "wrong-token", checkAdmin); status != http.StatusUnauthorized {
+		t.Errorf("request with the wrong token: want status %d, got %d", http.StatusUnauthorized, status)
+	}
+	// a valid token gets past authentication to the CallerID check
+	if status, _ := apiRequest(t, "POST", "/json", apiToken, checkAdmin); status != http.StatusBadRequest {
+		t.Errorf("request with the token: want status %d, got %d", http.StatusBadRequest, status)
+	}
+
+	// external plugins send the token, and the caller token for GetTaskConfig
+	tests := []testItem{
+		{aliceID, general, ";echo hello", []testc.TestMessage{{null, general, "hello"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";greeting", []testc.TestMessage{{null, general, "Hello from the config"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestWebhook(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	expectMessage := func(want string) {
+		got, err := conn.GetBotMessage()
+		if err != nil {
+			t.Errorf("FAILED timeout waiting for message from robot; want: \"%s\"", want)
+		} else if !regexp.MustCompile(want).MatchString(got.Message) {
+			t.Errorf("FAILED message regex match; want: \"%s\", got: \"%s\"", want, got.Message)
+		}
+	}
+	trigger := "/trigger/webhook?user=ci&channel=general"
+
+	if status, _ := apiRequest(t, "POST", trigger, "", "build main passed"); status != http.StatusUnauthorized {
+		t.Errorf("webhook without a token: want status %d, got %d", http.StatusUnauthorized, status)
+	}
+	if status, _ := apiRequest(t, "POST", "/trigger/nosuchjob", apiToken, "build main passed"); status != http.StatusNotFound {
+		t.Errorf("webhook for a missing job: want status %d, got %d", http.StatusNotFound, status)
+	}
+	if status, _ := apiRequest(t, "POST", "/trigger/webhook?user=bob&channel=general", apiToken, "build main passed"); status != http.StatusUnprocessableEntity {
+		t.Errorf("webhook not matching a trigger: want status %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+
+	// the test connector doesn't buffer messages, so wait in a goroutine
+	waited := make(chan string)
+	go func() {
+		status, body := apiRequest(t, "POST", trigger+"&wait=true", apiToken, "build main passed")
+		waited <- fmt.Sprintf("%d: %s", status, body)
+	}()
+	expectMessage("Building main")
+	if result := <-waited; result != `200: {"RunID":1,"Job":"webhook","Status":"Normal"}` {
+		t.Errorf("waiting for a webhook job: want status 200 and Normal, got %s", result)
+	}
+
+	status, body := apiRequest(t, "POST", trigger, apiToken, "build broken failed")
+	var run struct {
+		RunID  int
+		Status string
+	}
+	if err := json.Unmarshal([]byte(body), &run); status != http.StatusAccepted || err != nil {
+		t.Fatalf("starting a webhook job: want status %d, got %d: %s", http.StatusAccepted, status, body)
+	}
+	expectMessage("Refusing to build broken")
+	expectMessage(`Job 'webhook', run number \d+ failed`)
+	for i := 0; i < 20 && run.Status != "Fail"; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, body = apiRequest(t, "GET", fmt.Sprintf("/trigger/webhook/%d", run.RunID), apiToken, "")
+		json.Unmarshal([]byte(body), &run)
+	}
+	if run.Status != "Fail" {
+		t.Errorf("polling a failed webhook job: want status Fail, got %s", run.Status)
+	}
+	GetEvents()
+
+	// the failed run is in the dead letter queue, and the first run saved an
+	// artifact
+	tests := []testItem{
+		{aliceID, null, "list dead letters", []testc.TestMessage{{alice, null, "#1: job 'webhook', received .*, status: fail"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";show artifacts webhook 0", []testc.TestMessage{{null, general, `artifacts for job 'webhook', run 0:\nbuild.log \(19 bytes\)`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";show artifact webhook 0 build.log", []testc.TestMessage{{null, general, `ARTIFACT 'BUILD.LOG' FROM JOB 'WEBHOOK', RUN 0:\nBUILD LOG FOR MAIN`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";show artifact webhook 1 build.log", []testc.TestMessage{{null, general, `I don't have an artifact 'build.log' for job 'webhook', run 1`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";history webhook", []testc.TestMessage{{null, general, `^history of job runs for 'webhook':\nrun 0 - .* - normal\nrun 1 - .* - fail$`}, {alice, general, `Which run #\?`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "-", []testc.TestMessage{{null, general, `quitting history command`}}, []Event{}, 0},
+		{aliceID, general, ";show log webhook 0", []testc.TestMessage{{null, general, `(?s)^\*\*\* WEBHOOK MAIN - STARTING TASK 'WEBHOOK'\n.*SAVED ARTIFACT 'BUILD.LOG'`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "show dead letter 1", []testc.TestMessage{{alice, null, `(?s)^DEAD LETTER #1 FOR JOB 'WEBHOOK', STATUS FAIL\n.*PARAMETERS:\n.*  BRANCH=XXXXXX\n.*PAYLOAD:\nBUILD BROKEN FAILED$`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		// the replay gets BRANCH from the stored trigger parameters, so fails
+		// the same way and stays in the queue
+		{aliceID, null, "replay dead letter 1", []testc.TestMessage{{alice, null, "Replaying dead letter #1 for job 'webhook'"}, {null, general, "Refusing to build broken"}, {null, general, `Job 'webhook', run number \d+ failed`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan, JobTaskRan, ExternalTaskRan, ExternalTaskErrExit}, 0},
+		{aliceID, null, "list dead letters", []testc.TestMessage{{alice, null, "#1: job 'webhook', received .*, status: fail"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "drop dead letter 1", []testc.TestMessage{{alice, null, "Removed dead letter #1"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "list dead letters", []testc.TestMessage{{alice, null, "The dead letter queue is empty"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	// signed payloads, GitHub and GitLab style, for a trigger with a Secret
+	signedRequest := func(path, header, value, body string) (int, string) {
+		req, _ := http.NewRequest("POST", "http://127.0.0.1:8889"+path, strings.NewReader(body))
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("sending signed request for %s: %v", path, err)
+		}
+		defer res.Body.Close()
+		rb, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(rb)
+	}
+	sign := func(secret, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	signed := "/trigger/webhook?user=github&channel=general"
+	payload := "build main passed"
+	if status, _ := apiRequest(t, "POST", signed, apiToken, payload); status != http.StatusUnauthorized {
+		t.Errorf("unsigned webhook for a trigger with a Secret: want status %d, got %d", http.StatusUnauthorized, status)
+	}
+	if status, _ := signedRequest(signed, "X-Hub-Signature-256", sign("wrong-secret", payload), payload); status != http.StatusForbidden {
+		t.Errorf("webhook with a bad signature: want status %d, got %d", http.StatusForbidden, status)
+	}
+	if status, _ := signedRequest(signed, "X-Gitlab-Token", "wrong-secret", payload); status != http.StatusForbidden {
+		t.Errorf("webhook with a bad GitLab token: want status %d, got %d", http.StatusForbidden, status)
+	}
+	if status, _ := signedRequest(trigger, "X-Hub-Signature-256", sign("webhook-test-secret", payload), payload); status != http.StatusUnauthorized {
+		t.Errorf("signed webhook for a trigger without a Secret: want status %d, got %d", http.StatusUnauthorized, status)
+	}
+	go func() {
+		status, body := signedRequest(signed+"&wait=true", "X-Hub-Signature-256", sign("webhook-test-secret", payload), payload)
+		waited <- fmt.Sprintf("%d: %s", status, body)
+	}()
+	expectMessage("Building main")
+	if result := <-waited; !strings.HasPrefix(result, "200: ") || !strings.Contains(result, `"Status":"Normal"`) {
+		t.Errorf("signed webhook job: want status 200 and Normal, got %s", result)
+	}
+	go func() {
+		status, body := signedRequest(signed+"&wait=true", "X-Gitlab-Token", "webhook-test-secret", payload)
+		waited <- fmt.Sprintf("%d: %s", status, body)
+	}()
+	expectMessage("Building main")
+	if result := <-waited; !strings.HasPrefix(result, "200: ") || !strings.Contains(result, `"Status":"Normal"`) {
+		t.Errorf("GitLab webhook job: want status 200 and Normal, got %s", result)
+	}
+
+	// JSONParameters set BRANCH from the payload, or leave it empty
+	jsonTrigger := "/trigger/webhook?user=ci-json&channel=general&wait=true"
+	go func() {
+		status, body := apiRequest(t, "POST", jsonTrigger, apiToken, `{"push": {"changes": [{"new branch": "release"}]}}`)
+		waited <- fmt.Sprintf("%d: %s", status, body)
+	}()
+	expectMessage("^Building release$")
+	<-waited
+	go func() {
+		status, body := apiRequest(t, "POST", jsonTrigger, apiToken, `{"push": {"changes": []}}`)
+		waited <- fmt.Sprintf("%d: %s", status, body)
+	}()
+	expectMessage("^Building ?$")
+	<-waited
+	GetEvents()
+
+	teardown(t, done, conn)
+}
+
+func TestMaxHistoryAge(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";run job aging", []testc.TestMessage{{null, general, `Starting job 'aging', run 0`}, {null, general, `aging run 0`}, {null, general, `Finished job 'aging', run 0`}}, []Event{JobTaskRan, ExternalTaskRan}, 1100},
+		{aliceID, general, ";run job aging", []testc.TestMessage{{null, general, `Starting job 'aging', run 1`}, {null, general, `aging run 1`}, {null, general, `Finished job 'aging', run 1`}}, []Event{JobTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";history aging", []testc.TestMessage{{null, general, `^history of job runs for 'aging':\nrun 1 - .* - normal$`}, {alice, general, `Which run #\?`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, "-", []testc.TestMessage{{null, general, `quitting history command`}}, []Event{}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestPipeline(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	// softfail has IgnoreFailure, so the pipeline continues until the 'fail'
+	// task; the failing final task doesn't change the job's exit code
+	tests := []testItem{
+		{aliceID, general, ";run job pipeline", []testc.TestMessage{{null, general, `Starting job 'pipeline', run 0`}, {null, general, `step sees greeting: hello from pipeline`}, {null, general, `after the ignored failure`}, {null, general, `final task added last`}, {null, general, `final task added first`}, {alice, general, `There were errors calling external task 'fail'`}, {null, general, `Job 'pipeline', run number 0 failed in task: 'fail failure that isn't ignored' - Fail the pipeline, exit code: Fail`}}, []Event{JobTaskRan, ExternalTaskRan, ExternalTaskRan, ExternalTaskRan, ExternalTaskErrExit, ExternalTaskRan, ExternalTaskRan, ExternalTaskErrExit, ExternalTaskRan, ExternalTaskRan, ExternalTaskErrExit, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestSecretParameters(t *testing.T) {
+	// membrain uses the 'env' SecretSource with this prefix
+	os.Setenv("GOPHER_TEST_SECRET_API_TOKEN", "sekrit")
+	defer os.Unsetenv("GOPHER_TEST_SECRET_API_TOKEN")
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";run job secrets", []testc.TestMessage{{null, general, `Starting job 'secrets', run 0`}, {null, general, `the token is sekrit`}, {null, general, `Finished job 'secrets', run 0`}}, []Event{JobTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";run job nosecret", []testc.TestMessage{{null, general, `Starting job 'nosecret', run 0`}, {alice, general, `Unable to run task 'nosecret': parameter 'API_TOKEN': secret 'MISSING_TOKEN' not found`}, {null, general, `Job 'nosecret', run number 0 failed`}}, []Event{JobTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestRunJobNow(t *testing.T) {
+	os.Setenv("GOPHER_TEST_SECRET_API_TOKEN", "sekrit")
+	defer os.Unsetenv("GOPHER_TEST_SECRET_API_TOKEN")
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";run job secrets now", []testc.TestMessage{{null, general, `Running job 'secrets' now; status will be posted in channel 'general'`}, {null, general, `Starting scheduled job 'secrets', run 0`}, {null, general, `the token is sekrit`}, {null, general, `Finished job 'secrets', run 0`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed, ScheduledTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";run job secrets now extra", []testc.TestMessage{{null, general, `Wrong number of arguments for job 'secrets', 0 configured but 1 given`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";run job ping now", []testc.TestMessage{{null, general, `'ping' isn't a job`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";run job nonesuch now", []testc.TestMessage{{null, general, `I don't have a job named 'nonesuch'`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		// no MailConfig in the test robot, so NotifyEmail falls back to chat
+		{aliceID, general, ";run job nightly now", []testc.TestMessage{{null, general, `Running job 'nightly' now`}, {null, general, `Starting scheduled job 'nightly', run 0`}, {null, general, `Job 'nightly', run number 0 failed in task: 'nightly', exit code: Fail`}, {alice, null, `Scheduled job 'nightly', run number 0 failed in task 'nightly', exit code: Fail`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed, ScheduledTaskRan, ExternalTaskRan, ExternalTaskErrExit}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestDebugWindow(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";debug task aging for 1s", []testc.TestMessage{{null, general, `Debugging enabled for aging \(verbose: false\) for 1s`}, {alice, null, `Debugging of task 'aging' has expired`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";nodebug", []testc.TestMessage{{null, general, `Debugging disabled`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";debug task aging for 1h", []testc.TestMessage{{null, general, `Debugging enabled for aging \(verbose: false\) for 1h0m0s`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";nodebug", []testc.TestMessage{{null, general, `Debugging of aging disabled`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestIgnoreUser(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";ignore user bob", []testc.TestMessage{{null, general, "Ignoring 'bob' until they're unignored"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		// with no reply to wait for, IgnoredUser is collected with the next test
+		{bobID, general, ";echo anybody home?", []testc.TestMessage{}, []Event{}, 100},
+		{aliceID, general, ";ignore user Bob", []testc.TestMessage{{null, general, "I'm already ignoring 'Bob'"}}, []Event{IgnoredUser, CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";ignore user alice", []testc.TestMessage{{null, general, "Sorry, 'alice' is an administrator, and I never ignore administrators"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";unignore user bob", []testc.TestMessage{{null, general, "No longer ignoring 'bob'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{bobID, general, ";echo anybody home?", []testc.TestMessage{{null, general, "anybody home?"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";unignore user bob", []testc.TestMessage{{null, general, "I'm not ignoring 'bob'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestAuthCache(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{bobID, bottest, ";echo one", []testc.TestMessage{{null, bottest, "one"}}, []Event{GoPluginRan, AdminCheckFailed, AuthRanSuccess, CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, bottest, ";echo two", []testc.TestMessage{{null, bottest, "two"}}, []Event{AuthCacheHit, CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, ";echo three", []testc.TestMessage{{null, bottest, "Sorry, you're not authorized for that command"}}, []Event{GoPluginRan, AdminCheckPassed, AuthRanFail}, 0},
+		{aliceID, bottest, ";echo four", []testc.TestMessage{{null, bottest, "Sorry, you're not authorized for that command"}}, []Event{AuthCacheHit}, 0},
+		{aliceID, general, ";flush auth cache", []testc.TestMessage{{null, general, `Flushed the authorization cache; 2 cached result\(s\) forgotten`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{bobID, bottest, ";echo five", []testc.TestMessage{{null, bottest, "five"}}, []Event{GoPluginRan, AdminCheckFailed, AuthRanSuccess, CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestConnectionStatus(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";connection status", []testc.TestMessage{{null, general, `^test: connector doesn't report it's connection status$`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestCatchAllPriority(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, bottest, ";knock knock", []testc.TestMessage{{null, bottest, "Who's there\\?"}}, []Event{CatchAllsRan, CatchAllTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, ";foo bar", []testc.TestMessage{{alice, bottest, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, ExternalTaskRan, ExternalTaskErrExit, CatchAllTaskRan, GoPluginRan}, 0},
+		{aliceID, bottest, ";ping", []testc.TestMessage{{null, bottest, "Pong from the catchall plugin"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, ";explain ;ping", []testc.TestMessage{{null, bottest, `(?s:.*\nRESULT: WOULD RUN COMMAND 'PING' FOR PLUGIN 'CATCHALL', WHICH HAS A HIGHER PRIORITY \(10\) THAN .*COMMAND 'PING' FOR PLUGIN 'PING'.*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";knock knock", []testc.TestMessage{{alice, general, "Sorry, that didn't match.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestConsumeMessage(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, bottest, "hear this", []testc.TestMessage{{null, bottest, "catchall heard it"}, {null, bottest, "ambient heard it"}}, []Event{AmbientTaskRan, ExternalTaskRan, AmbientTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, "consume this", []testc.TestMessage{{null, bottest, "catchall heard it"}}, []Event{AmbientTaskRan, ExternalTaskRan}, 0},
+		{aliceID, bottest, ";explain hear this", []testc.TestMessage{{null, bottest, `(?s:.*\nRESULT: WOULD RUN, IN PRIORITY ORDER UNTIL ONE CONSUMES THE MESSAGE: COMMAND 'HEARD' FOR PLUGIN 'CATCHALL', COMMAND 'HEARD' FOR PLUGIN 'AMBIENT' \(AMBIENT MATCHES\)$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestStatus(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";status", []testc.TestMessage{{null, general, `(?s)^STATUS: HEALTHY\nUPTIME: .*\nCONNECTOR TEST: RUNNING\nTASKS: \d+ LOADED \(\d+ PLUGINS, \d+ JOBS\), \d+ DISABLED\nPLUGIN PANICS/CRASHES: \d+.*\nSCHEDULED JOBS: RUNNING; \d+ JOB\(S\) SCHEDULED\nBRAIN: REACHABLE\nLAST RELOAD: .*$`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestScheduleAfter(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";remind me in 1s stand up", []testc.TestMessage{{null, general, `Scheduled reminder #1`}, {null, general, `Reminder: stand up`}}, []Event{CommandTaskRan, ExternalTaskRan, ScheduledTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";remind me in 60s never mind", []testc.TestMessage{{null, general, `Scheduled reminder #2`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";cancel reminder 2", []testc.TestMessage{{null, general, `Cancelled reminder #2`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{bobID, general, ";quit in 1s", []testc.TestMessage{{null, general, `Couldn't schedule quit`}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestPauseSchedules(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";schedule status", []testc.TestMessage{{null, general, `Scheduled jobs are running; 0 job\(s\) scheduled`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";pause schedules", []testc.TestMessage{{null, general, `Scheduled jobs paused`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";pause schedules", []testc.TestMessage{{null, general, `Scheduled jobs were already paused`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";reload", []testc.TestMessage{{alice, general, `Configuration reloaded successfully`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";schedule status", []testc.TestMessage{{null, general, `Scheduled jobs are paused`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";resume schedules", []testc.TestMessage{{null, general, `Scheduled jobs resumed`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
 func TestFormatting(t *testing.T) {
 	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
 
@@ -348,6 +1393,8 @@ func TestFormatting(t *testing.T) {
 		{aliceID, general, ";format fixed", []testc.TestMessage{{null, general, "_ITALICS_ <ONE> \\*BOLD\\* `CODE` @PARSLEY"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
 		{aliceID, general, ";format variable", []testc.TestMessage{{null, general, "_italics_ <one> \\*bold\\* `code` @parsley"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
 		{aliceID, general, ";format raw", []testc.TestMessage{{null, general, "_Italics_ <One> \\*Bold\\* `Code` @parsley"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";format markup", []testc.TestMessage{{null, general, "^Italics Bold Struck `\\*\\*Code\\*\\*` Docs \\(https://example.com/docs\\)$"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";format configured", []testc.TestMessage{{null, general, "_ITALICS_ <ONE> \\*BOLD\\* `CODE` @PARSLEY"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
 	}
 	testcases(t, conn, tests)
 
@@ -359,7 +1406,7 @@ func TestHelp(t *testing.T) {
 
 	tests := []testItem{
 		// Took a while to get the regex right; should be # of help msgs * 2 - 1; e.g. 10 lines -> 19
-		{aliceID, deadzone, ";help", []testc.TestMessage{{null, deadzone, `(?s:^Command(?:[^\n]*\n){19}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, deadzone, ";help", []testc.TestMessage{{alice, deadzone, `\(the help output was pretty long, so I sent you a private message\)`}, {alice, null, `(?s:^Command\(s\) available in channel: deadzone\n(?:[^\n]*\n){50}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
 		{aliceID, deadzone, ";help help", []testc.TestMessage{{null, deadzone, `(?s:^Command(?:[^\n]*\n){3}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
 	}
 	testcases(t, conn, tests)
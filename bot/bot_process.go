@@ -5,6 +5,7 @@ package bot
    handler.go has the methods for callbacks from the connector, */
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -32,7 +33,9 @@ var random *rand.Rand
 var connectors = make(map[string]func(Handler, *log.Logger) Connector)
 
 // RegisterConnector should be called in an init function to register a type
-// of connector. Currently only Slack is implemented.
+// of connector; the robot uses the connector whose name matches Protocol in
+// gopherbot.yaml, and any listed in ExtraConnectors. See connector.go for the connector lifecycle and optional
+// capabilities.
 func RegisterConnector(name string, connstarter func(Handler, *log.Logger) Connector) {
 	if stopRegistrations {
 		return
@@ -46,43 +49,74 @@ func RegisterConnector(name string, connstarter func(Handler, *log.Logger) Conne
 // robot holds all the interal data relevant to the Bot. Most of it is populated
 // by loadConfig, other stuff is populated by the connector.
 var botCfg struct {
-	Connector                            // Connector interface, implemented by each specific protocol
-	adminUsers           []string        // List of users with access to administrative commands
-	alias                rune            // single-char alias for addressing the bot
-	botinfo              UserInfo        // robot's name, ID, email, etc.
-	adminContact         string          // who to contact for problems with the bot
-	mailConf             botMailer       // configuration to use when sending email
-	ignoreUsers          []string        // list of users to never listen to, like other bots
-	preRegex             *regexp.Regexp  // regex for matching prefixed commands, e.g. "Gort, drop your weapon"
-	postRegex            *regexp.Regexp  // regex for matching, e.g. "open the pod bay doors, hal"
-	bareRegex            *regexp.Regexp  // regex for matching the robot's bare name, if you forgot it in the previous command
-	joinChannels         []string        // list of channels to join
-	defaultAllowDirect   bool            // whether plugins are available in DM by default
-	defaultMessageFormat MessageFormat   // Raw unless set to Variable or Fixed
-	plugChannels         []string        // list of channels where plugins are available by default
-	protocol             string          // Name of the protocol, e.g. "slack"
-	brainProvider        string          // Type of Brain provider to use
-	brain                SimpleBrain     // Interface for robot to Store and Retrieve data
-	encryptionKey        string          // Key for encrypting data (unlocks "real" key in brain)
-	historyProvider      string          // Name of the history provider to use
-	history              HistoryProvider // Provider for storing and retrieving job / plugin histories
-	workSpace            string          // Read/Write directory where the robot does work
-	defaultElevator      string          // Plugin name for performing elevation
-	defaultAuthorizer    string          // Plugin name for performing authorization
-	externalPlugins      []ExternalTask  // List of external plugins to load
-	externalJobs         []ExternalTask  // List of external jobs to load
-	externalTasks        []ExternalTask  // List of external tasks to load
-	ScheduledJobs        []ScheduledTask // List of scheduled tasks
-	port                 string          // Localhost port to listen on
-	stop                 chan struct{}   // stop channel for stopping the connector
-	done                 chan struct{}   // channel closed when robot finishes shutting down
-	timeZone             *time.Location  // for forcing the TimeZone, Unix only
-	defaultJobChannel    string          // where job statuses will post if not otherwise specified
-	shuttingDown         bool            // to prevent new plugins from starting
-	pluginsRunning       int             // a count of how many plugins are currently running
-	paused               bool            // it's a Windows thing
-	sync.WaitGroup                       // for keeping track of running plugins
-	sync.RWMutex                         // for safe updating of bot data structures
+	Connector                                      // Connector interface, implemented by each specific protocol
+	adminUsers           []string                  // List of users with access to administrative commands
+	alias                rune                      // single-char alias for addressing the bot
+	botinfo              UserInfo                  // robot's name, ID, email, etc.
+	adminContact         string                    // who to contact for problems with the bot
+	mailConf             botMailer                 // configuration to use when sending email
+	ignoreUsers          []string                  // list of users to never listen to, like other bots
+	preRegex             *regexp.Regexp            // regex for matching prefixed commands, e.g. "Gort, drop your weapon"
+	postRegex            *regexp.Regexp            // regex for matching, e.g. "open the pod bay doors, hal"
+	bareRegex            *regexp.Regexp            // regex for matching the robot's bare name, if you forgot it in the previous command
+	channelAliases       map[string]rune           // per-channel alias from ChannelAddressing; 0 requires the robot's name
+	channelPreRegex      map[string]*regexp.Regexp // preRegex for channels in channelAliases
+	commandPrefixes      []string                  // prefixes from CommandPrefixes, longest first
+	joinChannels         []string                  // list of channels to join
+	defaultAllowDirect   bool                      // whether plugins are available in DM by default
+	defaultMessageFormat MessageFormat             // Raw unless set to Variable or Fixed
+	plugChannels         []string                  // list of channels where plugins are available by default
+	protocol             string                    // Name of the protocol, e.g. "slack"
+	brainProvider        string                    // Type of Brain provider to use
+	brain                SimpleBrain               // Interface for robot to Store and Retrieve data
+	brainFallback        bool                      // Whether to degrade to an in-memory brain when the brain fails
+	encryptionKey        string                    // Key for encrypting data (unlocks "real" key in brain)
+	historyProvider      string                    // Name of the history provider to use
+	history              HistoryProvider           // Provider for storing and retrieving job / plugin histories
+	secretSource         SecretSource              // Source for ${secret:NAME} references in Parameters
+	workSpace            string                    // Read/Write directory where the robot does work
+	defaultElevator      string                    // Plugin name for performing elevation
+	defaultAuthorizer    string                    // Plugin name for performing authorization
+	externalPlugins      []ExternalTask            // List of external plugins to load
+	externalJobs         []ExternalTask            // List of external jobs to load
+	externalTasks        []ExternalTask            // List of external tasks to load
+	ScheduledJobs        []ScheduledTask           // List of scheduled tasks
+	port                 string                    // Localhost port to listen on
+	socket               string                    // Unix socket to listen on instead of port
+	httpToken            string                    // Shared secret required for http/JSON api calls, "" for none
+	stop                 chan struct{}             // stop channel for stopping the connector
+	done                 chan struct{}             // channel closed when robot finishes shutting down
+	timeZone             *time.Location            // for forcing the TimeZone, Unix only
+	defaultJobChannel    string                    // where job statuses will post if not otherwise specified
+	deadLetterMax        int                       // how many failed webhook events to keep
+	deadLetterAge        time.Duration             // maximum age of failed webhook events, 0 for no limit
+	maxArgs              int                       // maximum number of arguments for a plugin command
+	maxArgLength         int                       // maximum total length of arguments for a plugin command
+	taskTimeout          time.Duration             // default limit on how long an external task can run, 0 for no limit
+	initConcurrency      int                       // maximum number of plugins initialized at once
+	maxPipelines         int                       // maximum pipelines running at once, 0 for no limit
+	maxQueuedPipelines   int                       // pipelines that can wait for a slot before new ones are rejected
+	maxScheduledJobs     int                       // separate limit for scheduled jobs, 0 to share maxPipelines
+	suggestDistance      int                       // maximum edit distance for command suggestions, 0 to disable
+	threadWindow         time.Duration             // how long the robot stays engaged in a thread, 0 if ThreadAddressing is off
+	businessHours        *hoursCalendar            // default business hours, nil if not configured
+	rateLimit            *rateLimiter              // default per-user command rate limit, nil if not configured
+	auditLog             *AuditLog                 // audit log configuration, nil if not enabled
+	noUnfurl             bool                      // suppress link and media previews for all messages
+	watchConfig          bool                      // reload when configuration files change
+	typingDelay          time.Duration             // show the typing indicator for commands running longer than this; 0 to disable
+	elevateTimeout       time.Duration             // how long a successful elevation counts, unless the elevator sets ElevateTimeout
+	authTTL              time.Duration             // how long a successful authorization is cached; 0 to disable
+	authNegativeTTL      time.Duration             // how long a denial is cached; 0 to disable
+	shuttingDown         bool                      // to prevent new plugins from starting
+	pluginsRunning       int                       // a count of how many plugins are currently running
+	paused               bool                      // it's a Windows thing
+
+	ctx    context.Context    // parent of every pipeline's context, cancelled when the robot stops
+	cancel context.CancelFunc // cancels ctx
+
+	sync.WaitGroup // for keeping track of running plugins
+	sync.RWMutex   // for safe updating of bot data structures
 }
 
 var listening bool // for tests where initBot runs multiple times
@@ -101,6 +135,7 @@ func initBot(cpath, epath string, logger *log.Logger) {
 	botCfg.stop = make(chan struct{})
 	botCfg.done = make(chan struct{})
 	botCfg.shuttingDown = false
+	botCfg.ctx, botCfg.cancel = context.WithCancel(context.Background())
 
 	handle := handler{}
 	c := &botContext{
@@ -115,6 +150,10 @@ func initBot(cpath, epath string, logger *log.Logger) {
 			Log(Fatal, fmt.Sprintf("No provider registered for brain: \"%s\"", botCfg.brainProvider))
 		} else {
 			brain := bprovider(handle, logger)
+			if botCfg.brainFallback {
+				Log(Info, fmt.Sprintf("Brain fallback enabled, '%s' brain will degrade to memory when unavailable", botCfg.brainProvider))
+				brain = newFallbackBrain(brain)
+			}
 			botCfg.brain = brain
 		}
 	} else {
@@ -139,7 +178,14 @@ func initBot(cpath, epath string, logger *log.Logger) {
 		go func() {
 			h := handler{}
 			http.Handle("/json", h)
-			Log(Fatal, http.ListenAndServe(botCfg.port, nil))
+			http.Handle("/trigger/", webhookHandler{})
+			http.Handle("/health", healthHandler{})
+			http.Handle("/metrics", metricsHandler{})
+			if len(botCfg.socket) > 0 {
+				Log(Fatal, serveSocket(botCfg.socket))
+			} else {
+				Log(Fatal, http.ListenAndServe(botCfg.port, nil))
+			}
 		}()
 	}
 }
@@ -148,13 +194,19 @@ func initBot(cpath, epath string, logger *log.Logger) {
 func setConnector(c Connector) {
 	botCfg.Lock()
 	botCfg.Connector = c
+	protocol := botCfg.protocol
 	botCfg.Unlock()
+	activeConnectors.Lock()
+	activeConnectors.c[protocol] = c
+	activeConnectors.Unlock()
+	logConnector(protocol, c)
 }
 
 // run starts all the loops and returns a channel that closes when the robot
 // shuts down. It should return after the connector loop has started and
 // plugins are initialized.
 func run() <-chan struct{} {
+	botStarted = time.Now()
 	// Start the brain loop
 	go runBrain()
 
@@ -164,6 +216,9 @@ func run() <-chan struct{} {
 	c.registerActive(nil)
 	c.loadConfig(false)
 	c.deregister()
+	restoreOneShots()
+	restoreRuntimeIgnores()
+	restoreDebugging()
 
 	var cl []string
 	botCfg.RLock()
@@ -188,8 +243,9 @@ func run() <-chan struct{} {
 			case sig := <-sigs:
 				botCfg.Lock()
 				if botCfg.shuttingDown {
-					Log(Warn, "Received SIGINT/SIGTERM while shutdown in progress")
+					Log(Warn, "Received SIGINT/SIGTERM while shutdown in progress, cancelling running pipelines")
 					botCfg.Unlock()
+					cancelPipelines()
 				} else {
 					botCfg.shuttingDown = true
 					botCfg.Unlock()
@@ -203,17 +259,25 @@ func run() <-chan struct{} {
 		}
 	}()
 
-	// connector loop
+	// connector loops
 	botCfg.RLock()
-	go func(conn Connector, stop <-chan struct{}, done chan<- struct{}) {
-		privThread("connector loop")
-		conn.Run(stop)
-		close(done)
-	}(botCfg.Connector, botCfg.stop, botCfg.done)
+	runConnectors(botCfg.stop, botCfg.done)
+	go runMessageSweeper(botCfg.done)
+	go runConfigWatcher(botCfg.done)
 	botCfg.RUnlock()
 	return botCfg.done
 }
 
+// cancelPipelines cancels the context of every running pipeline, for a
+// second request to shut down while waiting on running plugins. Go plugins
+// watching Robot.Context() can wrap up, and external tasks are killed.
+func cancelPipelines() {
+	botCfg.RLock()
+	cancel := botCfg.cancel
+	botCfg.RUnlock()
+	cancel()
+}
+
 // stop is called whenever the robot needs to shut down gracefully. All callers
 // should lock the bot and check the value of botCfg.shuttingDown; see
 // builtins.go and win_svc_run.go
@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"os/exec"
 	"strconv"
@@ -56,7 +58,15 @@ func (c *botContext) registerActive(parent *botContext) {
 		c.Protocol = setProtocol(c.Incoming.Protocol)
 	}
 	c.Format = botCfg.defaultMessageFormat
-	c.environment["GOPHER_HTTP_POST"] = "http://" + botCfg.port
+	if len(botCfg.socket) > 0 {
+		c.environment["GOPHER_HTTP_POST"] = "http://localhost"
+		c.environment["GOPHER_HTTP_SOCKET"] = botCfg.socket
+	} else {
+		c.environment["GOPHER_HTTP_POST"] = "http://" + botCfg.port
+	}
+	if len(botCfg.httpToken) > 0 {
+		c.environment["GOPHER_HTTP_TOKEN"] = botCfg.httpToken
+	}
 	workSpace := botCfg.workSpace
 	botCfg.RUnlock()
 	cryptKey.RLock()
@@ -82,7 +92,12 @@ func (c *botContext) registerActive(parent *botContext) {
 		if idRegex.MatchString(c.User) {
 			c.ProtocolUser = c.User
 		} else if ui, ok := c.maps.user[c.User]; ok {
-			c.ProtocolUser = bracket(ui.UserID)
+			// a roster ID is only meaningful to the connector for it's protocol
+			if ui.Protocol == c.source {
+				c.ProtocolUser = bracket(ui.UserID)
+			} else {
+				c.ProtocolUser = c.User
+			}
 			c.BotUser = ui.BotUser
 		} else {
 			c.ProtocolUser = c.User
@@ -91,7 +106,7 @@ func (c *botContext) registerActive(parent *botContext) {
 	if len(c.ProtocolChannel) == 0 && len(c.Channel) > 0 {
 		if idRegex.MatchString(c.Channel) {
 			c.ProtocolChannel = c.Channel
-		} else if ci, ok := c.maps.channel[c.Channel]; ok {
+		} else if ci, ok := c.maps.channel[protocolKey{c.source, c.Channel}]; ok {
 			c.ProtocolChannel = bracket(ci.ChannelID)
 		} else {
 			c.ProtocolChannel = c.Channel
@@ -113,6 +128,13 @@ func (c *botContext) registerActive(parent *botContext) {
 	c.environment["GOPHER_CALLER_ID"] = fmt.Sprintf("%d", c.id)
 	botRunID.Unlock()
 
+	// GOPHER_CALLER_ID is easily guessed; external tasks also send the
+	// caller token to read their own configuration.
+	tok := make([]byte, 16)
+	rand.Read(tok)
+	c.callerToken = fmt.Sprintf("%x", tok)
+	c.environment["GOPHER_CALLER_TOKEN"] = c.callerToken
+
 	activeRobots.Lock()
 	if parent != nil {
 		parent.child = c
@@ -142,10 +164,26 @@ func (c *botContext) makeRobot() *Robot {
 		Format:          c.Format,
 		Protocol:        c.Protocol,
 		Incoming:        c.Incoming,
+		source:          c.source,
 		id:              c.id,
 	}
 }
 
+// taskContext returns the context for tasks run by c; tasks run outside a
+// pipeline, like plugin init, are only cancelled when the robot stops.
+func (c *botContext) taskContext() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	botCfg.RLock()
+	ctx := botCfg.ctx
+	botCfg.RUnlock()
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 // clone() is a convenience function to clone the current context before
 // starting a new goroutine for startPipeline. Used by e.g. triggered jobs,
 // SpawnJob(), and runPipeline for sub-jobs.
@@ -156,7 +194,9 @@ func (c *botContext) clone() *botContext {
 		Channel:          c.Channel,
 		ProtocolChannel:  c.ProtocolChannel,
 		Incoming:         c.Incoming,
+		source:           c.source,
 		directMsg:        c.directMsg,
+		thread:           c.thread,
 		BotUser:          c.BotUser,
 		listedUser:       c.listedUser,
 		pipeName:         c.pipeName,
@@ -185,10 +225,12 @@ type botContext struct {
 	ProtocolChannel    string                // the channel name or <channelid> where the message originated
 	Protocol           Protocol              // slack, terminal, test, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
 	Incoming           *ConnectorMessage     // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
+	source             string                // ExtraConnectors protocol the message arrived on, "" for the primary connector
 	Format             MessageFormat         // robot's default message format
 	workingDirectory   string                // directory where tasks run relative to cfgdir or workspace
 	protected          bool                  // protected jobs flip this flag, causing tasks in the pipeline to run in cfgdir
 	id                 int                   // incrementing index of Robot threads
+	callerToken        string                // random token for the pipeline, required by methods that return the task's configuration
 	tasks              taskList              // Pointers to current task configuration at start of pipeline
 	maps               *userChanMaps         // Pointer to current user / channel maps struct
 	repositories       map[string]repository // Set of configured repositories
@@ -196,9 +238,17 @@ type botContext struct {
 	listedUser         bool                  // set for users listed in the UserRoster; ambient messages don't match unlisted users by default
 	isCommand          bool                  // Was the message directed at the robot, dm or by mention
 	directMsg          bool                  // if the message was sent by DM
+	threadEngaged      bool                  // set for unaddressed messages treated as commands in an engaged thread
+	thread             string                // thread the message was posted in, "" for the main channel
 	msg                string                // the message text sent
+	action             *ConnectorAction      // the button click for an action, nil for messages
 	automaticTask      bool                  // set for scheduled & triggers jobs, where user security restrictions don't apply
 	elevated           bool                  // set when required elevation succeeds
+	elevation          string                // result of the elevation check for the audit log, "" if none was required
+	elevateImmediate   bool                  // set while an elevator runs for an ElevateImmediateCommand
+	runbook            bool                  // set for commands run from a runbook
+	dispatched         bool                  // set when a command or job matched the message and its pipeline ran
+	dispatchRet        TaskRetVal            // return value from that pipeline
 	environment        map[string]string     // environment vars set for each job/plugin in the pipeline
 	storedEnv, secrets brainParams           // encrypted parameters and secrets
 	taskenvironment    map[string]string     // per-task environment for Go plugins
@@ -221,13 +271,20 @@ type botContext struct {
 	timeZone *time.Location  // for history timestamping
 	logger   HistoryLogger   // where to send stdout / stderr
 
-	sync.Mutex                     // Protects access to the items below
-	parent, child      *botContext // for sub-job contexts
-	pipeName, pipeDesc string      // name and description of task that started pipeline
-	currentTask        interface{} // pointer to currently executing task
-	taskName           string      // name of current task
-	taskDesc           string      // description for same
-	osCmd              *exec.Cmd   // running Command, for aborting a pipeline
+	ctx    context.Context    // cancelled when the pipeline ends or the robot shuts down
+	cancel context.CancelFunc // cancels ctx
+
+	sync.Mutex                       // Protects access to the items below
+	parent, child      *botContext   // for sub-job contexts
+	pipeName, pipeDesc string        // name and description of task that started pipeline
+	currentTask        interface{}   // pointer to currently executing task
+	taskName           string        // name of current task
+	taskDesc           string        // description for same
+	osCmd              *exec.Cmd     // running Command, for aborting a pipeline
+	lastMessageID      string        // ID of the last message sent with SayWithID
+	typing             chan struct{} // closed to stop the typing indicator; nil when not typing
+	typingEnded        bool          // set when the pipeline ends, so a late timer doesn't start typing
+	consumed           bool          // set by ConsumeMessage, so later plugins don't see the message
 
 	exclusiveTag  string // tasks with the same exclusiveTag never run at the same time
 	exclusive     bool   // indicates task was running exclusively
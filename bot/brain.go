@@ -1,11 +1,14 @@
 package bot
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +28,24 @@ type SimpleBrain interface {
 	Retrieve(key string) (blob *[]byte, exists bool, err error)
 }
 
+// BrainLister is an optional interface for a SimpleBrain that can enumerate
+// it's keys, used by the 'brain list' and 'brain dump' admin commands and
+// ListData. Brains that don't implement it report ListNotSupported.
+type BrainLister interface {
+	// List returns the keys starting with prefix, which may be "".
+	List(prefix string) ([]string, error)
+}
+
+// BrainComparer is an optional interface for a SimpleBrain that can store a
+// memory only if it hasn't changed, atomically with respect to other robots
+// sharing the brain. For brains that don't implement it, the robot compares
+// and stores in the brain loop, which only guards against it's own tasks.
+type BrainComparer interface {
+	// CompareAndStore stores blob if key still holds old, or doesn't exist
+	// when old is nil, and reports whether it was stored.
+	CompareAndStore(key string, old, blob *[]byte) (stored bool, err error)
+}
+
 // Map of registered brains
 var brains = make(map[string]func(Handler, *log.Logger) SimpleBrain)
 
@@ -82,6 +103,7 @@ const (
 	checkOutBytes brainOpType = iota
 	checkInBytes
 	updateBytes
+	listMemories
 	quit
 )
 
@@ -115,6 +137,16 @@ type checkOutReply struct {
 	retval RetVal
 }
 
+type listRequest struct {
+	prefix string
+	reply  chan listReply
+}
+
+type listReply struct {
+	keys   []string
+	retval RetVal
+}
+
 type quitRequest struct {
 	reply chan struct{}
 }
@@ -129,7 +161,8 @@ const (
 
 type memstatus struct {
 	state   memState
-	token   string // whoever has this token owns the lock for this memory
+	token   string  // whoever has this token owns the lock for this memory
+	stored  *[]byte // the datum as stored when checked out, nil if it didn't exist
 	waiters []checkOutRequest
 }
 
@@ -139,12 +172,17 @@ var brainChanEvents = make(chan brainOp)
 // a value of time.Second means a lock will last between 1 and 2 seconds
 const memCycle = time.Second
 
+// how long the robot remembers tokens for locks that expired and were taken
+// over, so a late update gets DatumLockExpired rather than DatumNotFound
+const lapsedLifetime = time.Minute
+
 func replyToWaiter(m *memstatus) {
 	creq := m.waiters[0]
 	m.waiters = m.waiters[1:]
-	lt, d, e, r := getDatum(creq.key, true)
+	lt, d, st, e, r := getDatum(creq.key, true)
 	m.state = newMemory
 	m.token = lt
+	m.stored = st
 	creq.reply <- checkOutReply{lt, d, e, r}
 }
 
@@ -175,7 +213,7 @@ func initializeEncryption(key string) bool {
 	cryptKey.initializing = true
 	cryptKey.Unlock()
 	// retrieve the 'real' key
-	_, rk, exists, ret := getDatum(botEncryptionKey, true)
+	_, rk, _, exists, ret := getDatum(botEncryptionKey, true)
 	if ret != Ok {
 		cryptKey.Lock()
 		cryptKey.initializing = false
@@ -232,19 +270,19 @@ func reKey(newkey string) bool {
 }
 
 // getDatum retrieves a blob of bytes from the brain provider and optionally
-// decrypts it
-func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists bool, ret RetVal) {
+// decrypts it; stored is the blob as stored, for comparing on update.
+func getDatum(dkey string, rw bool) (token string, databytes, stored *[]byte, exists bool, ret RetVal) {
 	var decrypted []byte
 
 	if !keyRe.MatchString(dkey) {
 		err := fmt.Errorf("Invalid memory key, ':' disallowed: %s", dkey)
 		Log(Error, err)
-		return "", nil, false, InvalidDatumKey
+		return "", nil, nil, false, InvalidDatumKey
 	}
 	brain := botCfg.brain
 	if brain == nil {
 		Log(Error, "Brain function called with no brain configured")
-		return "", nil, false, BrainFailed
+		return "", nil, nil, false, BrainFailed
 	}
 	if rw { // checked out read/write, generate a lock token
 		ltb := make([]byte, 8)
@@ -257,11 +295,12 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 	var db *[]byte
 	db, exists, err = botCfg.brain.Retrieve(dkey)
 	if err != nil {
-		return "", nil, false, BrainFailed
+		return "", nil, nil, false, BrainFailed
 	}
 	if !exists {
-		return token, nil, false, Ok
+		return token, nil, nil, false, Ok
 	}
+	stored = db
 	if encryptBrain {
 		cryptKey.RLock()
 		initialized := cryptKey.initialized
@@ -271,31 +310,41 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		if initializing {
 			if dkey != botEncryptionKey {
 				Log(Warn, fmt.Sprintf("Retrieve called with uninitialized brain for '%s'", dkey))
-				return "", nil, false, BrainFailed
+				return "", nil, nil, false, BrainFailed
 			}
 			decrypted, err = decrypt(*db, key)
 			if err != nil {
-				Log(Error, fmt.Sprintf("Failed to decrypt the brain key, bad key provided?: %v", err))
-				return "", nil, false, BrainFailed
+				Log(Error, fmt.Sprintf("Failed to decrypt the brain key - wrong EncryptionKey, or the key was changed after the brain was initialized?: %v", err))
+				return "", nil, nil, false, DatumDecryptFailed
 			}
 			db = &decrypted
-			return token, db, true, Ok
+			return token, db, stored, true, Ok
 		}
 		if initialized {
 			decrypted, err = decrypt(*db, key)
 			if err != nil {
+				// Memories are stored as JSON; anything else is ciphertext
+				// that didn't decrypt, and is never overwritten.
+				if !json.Valid(*db) {
+					Log(Error, fmt.Sprintf("Decryption failed for '%s' - stored with a different key, or corrupted: %v", dkey, err))
+					return "", nil, nil, false, DatumDecryptFailed
+				}
 				Log(Warn, fmt.Sprintf("Decryption failed for '%s', assuming unencrypted and converting to encrypted", dkey))
-				// Calling storeDatum writes to storage without invalidating the lock token
-				storeDatum(dkey, db)
+				// Storing writes to storage without invalidating the lock token
+				if sealed, ret := sealDatum(dkey, db); ret == Ok {
+					if err := brain.Store(dkey, sealed); err == nil {
+						stored = sealed
+					}
+				}
 			} else {
 				db = &decrypted
 			}
-			return token, db, true, Ok
+			return token, db, stored, true, Ok
 		}
 		Log(Warn, fmt.Sprintf("Retrieve called on uninitialized brain for '%s'", dkey))
-		return "", nil, false, BrainFailed
+		return "", nil, nil, false, BrainFailed
 	}
-	return token, db, true, Ok
+	return token, db, stored, true, Ok
 }
 
 // storeDatum takes a blob of bytes and optionally encrypts it before sending it
@@ -306,6 +355,69 @@ func storeDatum(dkey string, datum *[]byte) RetVal {
 		Log(Error, "Brain function called with no brain configured")
 		return BrainFailed
 	}
+	datum, ret := sealDatum(dkey, datum)
+	if ret != Ok {
+		return ret
+	}
+	err := brain.Store(dkey, datum)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
+		return BrainFailed
+	}
+	return Ok
+}
+
+// compareAndStoreDatum is storeDatum for an update, returning DatumChanged
+// if the memory no longer holds old - the datum as stored when checked out.
+func compareAndStoreDatum(dkey string, old, datum *[]byte) RetVal {
+	brain := botCfg.brain
+	if brain == nil {
+		Log(Error, "Brain function called with no brain configured")
+		return BrainFailed
+	}
+	datum, ret := sealDatum(dkey, datum)
+	if ret != Ok {
+		return ret
+	}
+	swapped, err := compareAndStore(brain, dkey, old, datum)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
+		return BrainFailed
+	}
+	if !swapped {
+		Log(Warn, fmt.Sprintf("Not updating datum %s, changed since it was checked out", dkey))
+		return DatumChanged
+	}
+	return Ok
+}
+
+// compareAndStore stores blob if key still holds old, using the brain's
+// CompareAndStore when it has one.
+func compareAndStore(brain SimpleBrain, key string, old, blob *[]byte) (bool, error) {
+	if comparer, ok := brain.(BrainComparer); ok {
+		return comparer.CompareAndStore(key, old, blob)
+	}
+	current, exists, err := brain.Retrieve(key)
+	if err != nil {
+		return false, err
+	}
+	if !sameDatum(old, current, exists) {
+		return false, nil
+	}
+	return true, brain.Store(key, blob)
+}
+
+// sameDatum reports whether a retrieved datum is still old, where a nil old
+// means it didn't exist.
+func sameDatum(old, current *[]byte, exists bool) bool {
+	if old == nil {
+		return !exists
+	}
+	return exists && bytes.Equal(*old, *current)
+}
+
+// sealDatum optionally encrypts a blob of bytes for storing
+func sealDatum(dkey string, datum *[]byte) (*[]byte, RetVal) {
 	if encryptBrain {
 		cryptKey.RLock()
 		initialized := cryptKey.initialized
@@ -315,23 +427,18 @@ func storeDatum(dkey string, datum *[]byte) RetVal {
 		if !initialized {
 			// When re-keying, we store the 'real' key while uninitialized with a new key
 			if !(initializing && dkey == botEncryptionKey) {
-				Log(Error, fmt.Sprintf("storeDatum called for '%s' with encryptBrain true, but brain not initialized", key))
-				return BrainFailed
+				Log(Error, fmt.Sprintf("storeDatum called for '%s' with encryptBrain true, but brain not initialized", dkey))
+				return nil, BrainFailed
 			}
 		}
 		encrypted, err := encrypt(*datum, key)
 		if err != nil {
 			Log(Error, fmt.Sprintf("Failed encrypting '%s': %v", dkey, err))
-			return BrainFailed
+			return nil, BrainFailed
 		}
 		datum = &encrypted
 	}
-	err := botCfg.brain.Store(dkey, datum)
-	if err != nil {
-		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
-		return BrainFailed
-	}
-	return Ok
+	return datum, Ok
 }
 
 var brLock sync.RWMutex
@@ -345,6 +452,8 @@ func runBrain() {
 	shortTermMemories.Unlock()
 	// map key to status
 	memories := make(map[string]*memstatus)
+	// map lapsed tokens to when their lock was taken over
+	lapsed := make(map[string]time.Time)
 	processMemories := time.Tick(memCycle)
 loop:
 	for {
@@ -355,7 +464,7 @@ loop:
 				creq := evt.opData.(checkOutRequest)
 				memStat, exists := memories[creq.key]
 				if !exists {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, st, e, r := getDatum(creq.key, creq.rw)
 					if r != Ok {
 						creq.reply <- checkOutReply{lt, d, e, r}
 						break
@@ -364,6 +473,7 @@ loop:
 						m := &memstatus{
 							newMemory,
 							lt,
+							st,
 							make([]checkOutRequest, 0, 2),
 						}
 						memories[creq.key] = m
@@ -372,15 +482,17 @@ loop:
 					break
 				}
 				if !creq.rw {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, _, e, r := getDatum(creq.key, creq.rw)
 					creq.reply <- checkOutReply{lt, d, e, r}
 					break
 				} // read-write request below
 				// if state is available, there are no waiters
 				if memStat.state == available {
-					lt, d, e, r := getDatum(creq.key, creq.rw)
+					lt, d, st, e, r := getDatum(creq.key, creq.rw)
+					lapsed[memStat.token] = time.Now()
 					memStat.state = newMemory
 					memStat.token = lt // this memory has a new owner now
+					memStat.stored = st
 					memories[creq.key] = memStat
 					creq.reply <- checkOutReply{lt, d, e, r}
 				} else {
@@ -405,20 +517,29 @@ loop:
 			case updateBytes:
 				ur := evt.opData.(updateRequest)
 				m, ok := memories[ur.key]
-				if !ok {
+				if !ok || ur.token != m.token {
+					// the lock expired and another task checked out the
+					// memory; the caller should check out again
+					if _, expired := lapsed[ur.token]; expired {
+						delete(lapsed, ur.token)
+						ur.reply <- DatumLockExpired
+						break
+					}
 					ur.reply <- DatumNotFound
 					break
 				}
-				if ur.token != m.token {
-					ur.reply <- DatumLockExpired
-					break
-				}
-				ur.reply <- storeDatum(ur.key, ur.datum)
+				// the memory may still have been changed by another robot
+				// sharing the brain
+				ur.reply <- compareAndStoreDatum(ur.key, m.stored, ur.datum)
 				if len(m.waiters) > 0 {
 					replyToWaiter(m)
 					break
 				}
 				delete(memories, ur.key)
+			case listMemories:
+				lr := evt.opData.(listRequest)
+				keys, ret := listDatums(lr.prefix)
+				lr.reply <- listReply{keys, ret}
 			case quit:
 				qr := evt.opData.(quitRequest)
 				qr.reply <- struct{}{}
@@ -426,19 +547,26 @@ loop:
 			}
 		case <-processMemories:
 			now := time.Now()
+			window := threadWindow()
 			shortTermMemories.Lock()
 			for k, v := range shortTermMemories.m {
-				if now.Sub(v.timestamp) > shortTermDuration {
+				if now.Sub(v.timestamp) > shortTermLifetime(k.key, window) {
 					delete(shortTermMemories.m, k)
 				}
 			}
 			shortTermMemories.Unlock()
+			for token, t := range lapsed {
+				if now.Sub(t) > lapsedLifetime {
+					delete(lapsed, token)
+				}
+			}
 			for _, m := range memories {
 				switch m.state {
 				case newMemory:
 					m.state = seen
 				case seen:
 					if len(m.waiters) > 0 {
+						lapsed[m.token] = now
 						replyToWaiter(m)
 						break
 					}
@@ -489,6 +617,41 @@ func update(d, lt string, datum *[]byte) (ret RetVal) {
 	return <-reply
 }
 
+// errListNotSupported is returned by wrappers when the wrapped brain isn't a
+// BrainLister
+var errListNotSupported = errors.New("brain doesn't support listing")
+
+// listDatums lists keys from the brain provider, if it supports listing
+func listDatums(prefix string) ([]string, RetVal) {
+	brain := botCfg.brain
+	if brain == nil {
+		Log(Error, "Brain function called with no brain configured")
+		return nil, BrainFailed
+	}
+	lister, ok := brain.(BrainLister)
+	if !ok {
+		return nil, ListNotSupported
+	}
+	keys, err := lister.List(prefix)
+	if err == errListNotSupported {
+		return nil, ListNotSupported
+	}
+	if err != nil {
+		Log(Error, fmt.Sprintf("Listing memories with prefix '%s': %v", prefix, err))
+		return nil, BrainFailed
+	}
+	sort.Strings(keys)
+	return keys, Ok
+}
+
+// listKeys is the internal version of ListData, listing keys as-is
+func listKeys(prefix string) ([]string, RetVal) {
+	reply := make(chan listReply)
+	brainChanEvents <- brainOp{listMemories, listRequest{prefix, reply}}
+	rep := <-reply
+	return rep.keys, rep.retval
+}
+
 // checkinDatum is the internal version of CheckinDatum that uses the key as-is
 func checkinDatum(key, locktoken string) {
 	if locktoken == "" {
@@ -525,6 +688,39 @@ func updateDatum(key, locktoken string, datum interface{}) (ret RetVal) {
 	return update(key, locktoken, &dbytes)
 }
 
+// memoryPrefix is the brain key prefix for the current task's memories.
+// Tasks that share a NameSpace share memories, and a namespace extended with
+// ExtendNamespace gets it's own memories within the task's namespace.
+func (c *botContext) memoryPrefix() string {
+	task, _, _ := getTask(c.currentTask)
+	prefix := task.nameSpace() + ":"
+	if len(c.nsExtension) > 0 {
+		prefix += c.nsExtension + ":"
+	}
+	return prefix
+}
+
+// ListData returns the keys of the memories stored by the current task (or
+// extended namespace), for use with CheckoutDatum. Tasks can only list their
+// own memories; returns ListNotSupported if the brain can't enumerate keys.
+func (r *Robot) ListData() ([]string, RetVal) {
+	c := r.getContext()
+	prefix := c.memoryPrefix()
+	keys, ret := listKeys(prefix)
+	if ret != Ok {
+		return nil, ret
+	}
+	own := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, prefix)
+		// extended namespaces are separate
+		if !strings.ContainsRune(key, ':') {
+			own = append(own, key)
+		}
+	}
+	return own, Ok
+}
+
 // CheckoutDatum gets a datum from the robot's brain and unmarshals it into
 // a struct. If rw is set, the datum is checked out read-write and a non-empty
 // lock token is returned that expires after lockTimeout (250ms). The bool
@@ -537,12 +733,7 @@ func (r *Robot) CheckoutDatum(key string, datum interface{}, rw bool) (locktoken
 		return
 	}
 	c := r.getContext()
-	task, _, _ := getTask(c.currentTask)
-	if len(c.nsExtension) > 0 {
-		key = task.NameSpace + ":" + c.nsExtension + ":" + key
-	} else {
-		key = task.NameSpace + ":" + key
-	}
+	key = c.memoryPrefix() + key
 	return checkoutDatum(key, datum, rw)
 }
 
@@ -555,12 +746,7 @@ func (r *Robot) CheckinDatum(key, locktoken string) {
 		return
 	}
 	c := r.getContext()
-	task, _, _ := getTask(c.currentTask)
-	if len(c.nsExtension) > 0 {
-		key = task.NameSpace + ":" + c.nsExtension + ":" + key
-	} else {
-		key = task.NameSpace + ":" + key
-	}
+	key = c.memoryPrefix() + key
 	checkinDatum(key, locktoken)
 }
 
@@ -574,12 +760,7 @@ func (r *Robot) UpdateDatum(key, locktoken string, datum interface{}) (ret RetVa
 		return InvalidDatumKey
 	}
 	c := r.getContext()
-	task, _, _ := getTask(c.currentTask)
-	if len(c.nsExtension) > 0 {
-		key = task.NameSpace + ":" + c.nsExtension + ":" + key
-	} else {
-		key = task.NameSpace + ":" + key
-	}
+	key = c.memoryPrefix() + key
 	return updateDatum(key, locktoken, datum)
 }
 
@@ -2,9 +2,11 @@ package bot
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -48,6 +50,13 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		botCfg.RLock()
 		admins := strings.Join(botCfg.adminUsers, ", ")
 		aliasCh := botCfg.alias
+		prefixes := botCfg.commandPrefixes
+		if channelAlias, ok := botCfg.channelAliases[r.Channel]; ok {
+			aliasCh = channelAlias
+			if channelAlias == 0 {
+				prefixes = nil
+			}
+		}
 		name := botCfg.botinfo.UserName
 		if len(name) == 0 {
 			name = "(unknown)"
@@ -68,6 +77,9 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		msg = append(msg, "Here's some information about me and my running environment:")
 		msg = append(msg, fmt.Sprintf("The hostname for the server I'm running on is: %s", hostName))
 		msg = append(msg, fmt.Sprintf("My name is '%s', alias '%s', and my %s internal ID is '%s'", name, alias, r.Protocol, ID))
+		if len(prefixes) > 0 {
+			msg = append(msg, fmt.Sprintf("Commands can also start with: %s", strings.Join(prefixes, " ")))
+		}
 		msg = append(msg, fmt.Sprintf("This is channel '%s', %s internal ID: %s", r.Channel, r.Protocol, channelID))
 		if r.CheckAdmin() {
 			msg = append(msg, fmt.Sprintf("My install directory is: %s", installPath))
@@ -101,8 +113,21 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			Log(Trace, "Help requested for term", term)
 		}
 
-		helpLines := make([]string, 0, tooLong)
 		c := r.getContext()
+		// 'help <category>' lists the commands under a category heading
+		category := ""
+		if hasKeyword {
+			if cat, ok := c.helpCategory(term); ok {
+				category = cat
+				hasKeyword = false
+				Log(Trace, "Help requested for category", category)
+			}
+		}
+
+		helpLines := make([]string, 0, tooLong)
+		// help for commands with a Category, listed under headings after
+		// uncategorized commands
+		catLines := make(map[string][]string)
 		for _, t := range c.tasks.t {
 			task, plugin, _ := getTask(t)
 			if plugin == nil {
@@ -114,8 +139,26 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 				continue
 			}
 			Log(Trace, fmt.Sprintf("Checking help for plugin %s (term: %s)", task.name, term))
+			helpLine := func(helptext string) string {
+				return strings.Replace(helptext, botSub, botname, -1) + plugin.aliasNote(helptext)
+			}
 			if !hasKeyword { // if you ask for help without a term, you just get help for whatever commands are available to you
 				for _, phelp := range plugin.Help {
+					pcat := helpCategoryFor(plugin, phelp)
+					if len(category) > 0 {
+						if strings.EqualFold(pcat, category) {
+							for _, helptext := range phelp.Helptext {
+								helpLines = append(helpLines, helpLine(helptext))
+							}
+						}
+						continue
+					}
+					if len(pcat) > 0 {
+						for _, helptext := range phelp.Helptext {
+							catLines[pcat] = append(catLines[pcat], helpLine(helptext))
+						}
+						continue
+					}
 					for _, helptext := range phelp.Helptext {
 						if len(phelp.Keywords) > 0 && phelp.Keywords[0] == "*" {
 							// * signifies help that should be prepended
@@ -124,17 +167,17 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 								newSize += len(helpLines)
 							}
 							prepend := make([]string, 1, newSize)
-							prepend[0] = strings.Replace(helptext, botSub, botname, -1)
+							prepend[0] = helpLine(helptext)
 							helpLines = append(prepend, helpLines...)
 						} else {
-							helpLines = append(helpLines, strings.Replace(helptext, botSub, botname, -1))
+							helpLines = append(helpLines, helpLine(helptext))
 						}
 					}
 				}
 			} else { // when there's a search term, give all help for that term, but add (channels: xxx) at the end
 				for _, phelp := range plugin.Help {
 					for _, keyword := range phelp.Keywords {
-						if term == keyword {
+						if term == keyword || plugin.isAlias(term, keyword) {
 							chantext := ""
 							if task.DirectOnly {
 								// Look: the right paren gets added below
@@ -156,16 +199,37 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 								chantext += ")"
 							}
 							for _, helptext := range phelp.Helptext {
-								helpLines = append(helpLines, strings.Replace(helptext, botSub, botname, -1)+chantext)
+								helpLines = append(helpLines, helpLine(helptext)+chantext)
 							}
 						}
 					}
 				}
 			}
 		}
+		if len(catLines) > 0 {
+			categories := make([]string, 0, len(catLines))
+			for cat := range catLines {
+				categories = append(categories, cat)
+			}
+			sort.Strings(categories)
+			for _, cat := range categories {
+				helpLines = append(helpLines, cat+":")
+				helpLines = append(helpLines, catLines[cat]...)
+			}
+		}
 		if hasKeyword {
 			helpOutput = "Command(s) matching keyword: " + term + "\n" + strings.Join(helpLines, lineSeparator)
 		}
+		// help only lists what the user can run where they asked: commands
+		// for plugins active in the channel, or allowed by direct message
+		where := "in this channel"
+		if c.directMsg {
+			where = "by direct message"
+		}
+		available := "Command(s) available " + where + ":\n"
+		if len(category) > 0 {
+			available = "Command(s) in category " + category + " available " + where + ":\n"
+		}
 		switch {
 		case len(helpLines) == 0:
 			// Unless builtins are disabled or reconfigured, 'ping' is available in all channels
@@ -174,17 +238,21 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			if !c.directMsg {
 				r.Reply("(the help output was pretty long, so I sent you a private message)")
 				if !hasKeyword {
-					helpOutput = "Command(s) available in channel: " + r.Channel + "\n" + strings.Join(helpLines, lineSeparator)
+					if len(category) > 0 {
+						helpOutput = "Command(s) in category " + category + " available in channel: " + r.Channel + "\n" + strings.Join(helpLines, lineSeparator)
+					} else {
+						helpOutput = "Command(s) available in channel: " + r.Channel + "\n" + strings.Join(helpLines, lineSeparator)
+					}
 				}
 			} else {
 				if !hasKeyword {
-					helpOutput = "Command(s) available in this channel:\n" + strings.Join(helpLines, lineSeparator)
+					helpOutput = available + strings.Join(helpLines, lineSeparator)
 				}
 			}
 			r.SendUserMessage(r.User, helpOutput)
 		default:
 			if !hasKeyword {
-				helpOutput = "Command(s) available in this channel:\n" + strings.Join(helpLines, lineSeparator)
+				helpOutput = available + strings.Join(helpLines, lineSeparator)
 			}
 			r.Say(helpOutput)
 		}
@@ -192,6 +260,58 @@ func help(r *Robot, command string, args ...string) (retval TaskRetVal) {
 	return
 }
 
+// helpCategoryFor returns the heading for a plugin's help, if any
+func helpCategoryFor(plugin *BotPlugin, phelp PluginHelp) string {
+	if len(phelp.Category) > 0 {
+		return phelp.Category
+	}
+	return plugin.Category
+}
+
+// helpCategory checks whether a help term names the category of a plugin
+// available in the channel, returning the category as configured.
+func (c *botContext) helpCategory(term string) (string, bool) {
+	for _, t := range c.tasks.t {
+		task, plugin, _ := getTask(t)
+		if plugin == nil || !c.pluginAvailable(task, false, true) {
+			continue
+		}
+		for _, phelp := range plugin.Help {
+			if cat := helpCategoryFor(plugin, phelp); len(cat) > 0 && strings.EqualFold(cat, term) {
+				return cat, true
+			}
+		}
+	}
+	return "", false
+}
+
+// defaultConfig looks up the default configuration for a plugin, for
+// copying to conf/plugins/<plugin>.yaml; that's the DefaultConfig registered
+// by a Go plugin, or the output of an external plugin's 'configure' command.
+// When there's nothing to give, msg says why.
+func (c *botContext) defaultConfig(name string) (cfg, msg string) {
+	if plug, ok := pluginHandlers[name]; ok {
+		return plug.DefaultConfig, ""
+	}
+	for _, t := range c.tasks.t {
+		task, plugin, _ := getTask(t)
+		if name != task.name {
+			continue
+		}
+		if plugin == nil {
+			return "", "No default configuration available for task type 'job'"
+		}
+		if plugin.taskType == taskExternal {
+			defcfg, err := getExtDefCfg(plugin.BotTask)
+			if err != nil {
+				return "", "I had a problem looking that up - somebody should check my logs"
+			}
+			return string(*defcfg), ""
+		}
+	}
+	return "", "Didn't find a plugin named " + name
+}
+
 func dmadmin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 	if command == "init" {
 		return // ignore init
@@ -293,38 +413,48 @@ func dmadmin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			r.Log(Error, fmt.Sprintf("Problem storing parameter: %s", ret))
 			r.Say("There was a problem storing that parameter, check with an administrator")
 		}
+	case "storeglobal":
+		cryptKey.RLock()
+		initialized := cryptKey.initialized
+		cryptKey.RUnlock()
+		if !initialized {
+			r.Say("Sorry, I can't store secrets - encryption isn't initialized, please check with an administrator")
+			return
+		}
+		if storeGlobalSecret(args[0], args[1]) == Ok {
+			r.Say("Stored")
+		} else {
+			r.Say("There was a problem storing that secret, check with an administrator")
+		}
+	case "commandrate":
+		r.Say(shaperStatus())
+	case "concurrency":
+		r.Fixed().Say(concurrencyStatus())
+	case "listtasks":
+		r.Fixed().Say(taskListing())
+	case "taskinfo":
+		r.Fixed().Say(taskInfo(args[0]))
+	case "namespaces":
+		r.Fixed().Say(nameSpaceListing())
 	case "dumprobot":
 		botCfg.RLock()
 		c, _ := yaml.Marshal(config)
 		botCfg.RUnlock()
 		r.Fixed().Say(fmt.Sprintf("Here's how I've been configured, irrespective of interactive changes:\n%s", c))
+	case "exportconfig":
+		cfg, err := effectiveConfig(args[1], strings.EqualFold(args[0], "json"))
+		if err != nil {
+			r.Say(fmt.Sprintf("Sorry, I couldn't export the configuration: %v", err))
+			return
+		}
+		r.Fixed().Say(fmt.Sprintf("Here's my effective configuration, with secrets redacted:\n%s", cfg))
 	case "dumpplugdefault":
-		if plug, ok := pluginHandlers[args[0]]; ok {
-			r.Fixed().Say(fmt.Sprintf("Here's the default configuration for \"%s\":\n%s", args[0], plug.DefaultConfig))
-		} else { // look for an external plugin
-			found := false
-			c := r.getContext()
-			for _, t := range c.tasks.t {
-				task, plugin, _ := getTask(t)
-				if args[0] == task.name {
-					if plugin == nil {
-						r.Say("No default configuration available for task type 'job'")
-						return
-					}
-					if plugin.taskType == taskExternal {
-						found = true
-						if cfg, err := getExtDefCfg(plugin.BotTask); err == nil {
-							r.Fixed().Say(fmt.Sprintf("Here's the default configuration for \"%s\":\n%s", args[0], *cfg))
-						} else {
-							r.Say("I had a problem looking that up - somebody should check my logs")
-						}
-					}
-				}
-			}
-			if !found {
-				r.Say("Didn't find a plugin named " + args[0])
-			}
+		cfg, msg := r.getContext().defaultConfig(args[0])
+		if len(msg) > 0 {
+			r.Say(msg)
+			return
 		}
+		r.Fixed().Say(fmt.Sprintf("Here's the default configuration for \"%s\":\n%s", args[0], cfg))
 	case "dumpplugin":
 		found := false
 		c := r.getContext()
@@ -362,11 +492,16 @@ func dmadmin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			ptext := task.name
 			if wantDisabled {
 				if task.Disabled {
+					if task.runtimeDisabled {
+						ptext += " (at runtime)"
+					}
 					ptext += "; reason: " + task.reason
 					plist = append(plist, ptext)
 				}
 			} else {
-				if task.Disabled {
+				if task.runtimeDisabled {
+					ptext += " (disabled at runtime)"
+				} else if task.Disabled {
 					ptext += " (disabled)"
 				}
 				plist = append(plist, ptext)
@@ -394,6 +529,59 @@ func encryptcfg(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			r.Log(Error, fmt.Sprintf("User '%s' failed to initialize encryption", r.User))
 			r.Say("Failed to initialize encryption - check your passphrase?")
 		}
+	case "list", "dump":
+		ns := args[0]
+		if command == "dump" {
+			// The robot's own memories hold audit logs, dead letters with
+			// trigger parameters, secrets and the like
+			if strings.ToLower(ns) == "bot" {
+				r.Say("Sorry, I won't dump the 'bot' namespace; use 'brain list bot' to see the keys")
+				return
+			}
+			if !r.getContext().directMsg {
+				r.Say("Sorry, brain dump is only available by direct message")
+				return
+			}
+		}
+		keys, ret := listKeys(ns + ":")
+		switch ret {
+		case Ok:
+		case ListNotSupported:
+			r.Say("Sorry, the configured brain doesn't support listing memories")
+			return
+		default:
+			r.Say("I had a problem listing memories, check the log")
+			return
+		}
+		if len(keys) == 0 {
+			r.Say(fmt.Sprintf("I don't have any memories in namespace '%s'", ns))
+			return
+		}
+		if command == "list" {
+			r.Fixed().Say(fmt.Sprintf("Memories in namespace '%s':\n%s", ns, strings.Join(keys, "\n")))
+			return
+		}
+		dump := make(map[string]json.RawMessage)
+		for _, key := range keys {
+			_, db, exists, ret := checkout(key, false)
+			if ret != Ok {
+				r.Log(Error, fmt.Sprintf("Error retrieving '%s' for brain dump: %s", key, ret))
+				r.Say(fmt.Sprintf("I had a problem retrieving '%s', check the log", key))
+				return
+			}
+			if !exists {
+				continue
+			}
+			if json.Valid(*db) {
+				dump[key] = json.RawMessage(*db)
+			} else {
+				// not JSON, dump as a base64 string
+				dump[key], _ = json.Marshal(*db)
+			}
+		}
+		b, _ := json.MarshalIndent(dump, "", "  ")
+		r.Log(Audit, fmt.Sprintf("User '%s' dumped %d memories from namespace '%s'", r.User, len(dump), ns))
+		r.Fixed().Say(string(b))
 	}
 	return
 }
@@ -444,13 +632,13 @@ func admin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 	}
 	switch command {
 	case "reload":
-		err := r.getContext().loadConfig(false)
+		summary, err := r.ReloadConfiguration()
 		if err != nil {
-			r.Reply("Error encountered during reload, check the logs")
+			r.Reply("Error encountered during reload, keeping current configuration; check the logs")
 			Log(Error, fmt.Errorf("Reloading configuration, requested by %s: %v", r.User, err))
 			return
 		}
-		r.Reply("Configuration reloaded successfully")
+		r.Reply(summary)
 		r.Log(Info, "Configuration successfully reloaded by a request from:", r.User)
 	case "abort":
 		buf := make([]byte, 32768)
@@ -479,32 +667,73 @@ func admin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		if len(args[1]) > 0 {
 			verbose = true
 		}
-		Log(Debug, fmt.Sprintf("Enabling debugging for %s (%s), verbose: %v", tname, task.taskID, verbose))
-		pd := &debuggingTask{
-			taskID:  task.taskID,
-			name:    tname,
-			user:    r.User,
-			verbose: verbose,
-		}
-		taskDebug.Lock()
-		taskDebug.p[task.taskID] = pd
-		taskDebug.u[r.User] = pd
-		taskDebug.Unlock()
-		r.Say(fmt.Sprintf("Debugging enabled for %s (verbose: %v)", args[0], verbose))
+		window := defaultDebugWindow
+		if len(args[2]) > 0 {
+			d, err := parseHistoryAge(args[2])
+			if err != nil || d == 0 {
+				r.Say(fmt.Sprintf("Invalid time '%s', try e.g. '30m', '2h' or '1d'", args[2]))
+				return
+			}
+			window = d
+		}
+		Log(Debug, fmt.Sprintf("Enabling debugging for %s (%s), verbose: %v, for %s", tname, task.taskID, verbose, window))
+		startDebugging(r.User, task.name, verbose, window)
+		r.Say(fmt.Sprintf("Debugging enabled for %s (verbose: %v) for %s", args[0], verbose, window))
+	case "audit":
+		showAuditLog(r, args[0])
+	case "artifact":
+		showArtifact(r, args[0], args[1], args[2])
+	case "pauseschedules":
+		if r.PauseSchedules() {
+			r.Say("Scheduled jobs paused; use 'resume schedules' to start them again")
+		} else {
+			r.Say("Scheduled jobs were already paused")
+		}
+	case "resumeschedules":
+		if r.ResumeSchedules() {
+			r.Say("Scheduled jobs resumed")
+		} else {
+			r.Say("Scheduled jobs weren't paused")
+		}
+	case "schedulestatus":
+		scheduleStatus(r)
+	case "runnow":
+		runJobNow(r, args[0], args[1])
+	case "ignoreuser", "unignoreuser":
+		setUserIgnored(r, args[0], command == "ignoreuser")
+	case "flushauth":
+		flushed := flushAuthCache()
+		Log(Audit, fmt.Sprintf("Authorization cache flushed by %s", r.User))
+		r.Say(fmt.Sprintf("Flushed the authorization cache; %d cached result(s) forgotten", flushed))
+	case "connectionstatus":
+		r.Say(connectionStatus())
+	case "status":
+		r.Fixed().Say(checkHealth().String())
+	case "defaultconfig":
+		cfg, msg := r.getContext().defaultConfig(args[0])
+		if len(msg) > 0 {
+			r.Say(msg)
+			return
+		}
+		// default configuration can be long; send it privately
+		if len(r.Channel) > 0 {
+			r.Reply(fmt.Sprintf("(I sent you the default configuration for '%s' in a private message)", args[0]))
+		}
+		r.Fixed().SendUserMessage(r.User, fmt.Sprintf("Here's the default configuration for \"%s\":\n%s", args[0], cfg))
+	case "disable", "enable":
+		setTaskDisabled(r, strings.ToLower(args[0]), args[1], command == "disable", len(args) > 2 && len(args[2]) > 0)
 	case "stop":
-		taskDebug.Lock()
-		pd, ok := taskDebug.u[r.User]
-		if ok {
-			delete(taskDebug.p, pd.taskID)
-			delete(taskDebug.u, r.User)
-		}
-		taskDebug.Unlock()
-		r.Say("Debugging disabled")
+		if pd := stopDebugging(r.User); pd != nil {
+			r.Say(fmt.Sprintf("Debugging of %s disabled", pd.name))
+		} else {
+			r.Say("Debugging disabled")
+		}
 	case "quit":
 		botCfg.Lock()
 		if botCfg.shuttingDown {
 			botCfg.Unlock()
-			Log(Warn, "Received administrator `quit` while shutdown in progress")
+			Log(Warn, "Received administrator `quit` while shutdown in progress, cancelling running pipelines")
+			cancelPipelines()
 			return
 		}
 		botCfg.shuttingDown = true
@@ -514,7 +743,7 @@ func admin(r *Robot, command string, args ...string) (retval TaskRetVal) {
 			runningCount := botCfg.pluginsRunning - 1
 			botCfg.Unlock()
 			if proto != "test" {
-				r.Say(fmt.Sprintf("There are still %d plugins running; I'll exit when they all complete, or you can issue an \"abort\" command", runningCount))
+				r.Say(fmt.Sprintf("There are still %d plugins running; I'll exit when they all complete, or you can \"quit\" again to stop them", runningCount))
 			}
 		} else {
 			botCfg.Unlock()
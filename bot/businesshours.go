@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* businesshours.go - business hours and holiday calendars, for gating
+   commands like deploys. The robot can have a default BusinessHours in
+   gopherbot.yaml, and plugins can supply their own. Commands listed in a
+   plugin's BusinessHoursCommands are only run during business hours; outside
+   of them, administrators can still run the command after elevating. Times
+   are interpreted in the configured TimeZone.
+*/
+
+// BusinessHours defines when business-hours commands are allowed
+type BusinessHours struct {
+	Days     []string // days of the week, e.g. [ "Mon", "Tue", "Wed", "Thu", "Fri" ]; default Monday-Friday
+	Start    string   // start of the business day, e.g. "09:00"
+	End      string   // end of the business day, e.g. "17:00"
+	Holidays []string // dates with no business hours, e.g. "2019-12-25"
+}
+
+// hoursCalendar is the parsed form of BusinessHours
+type hoursCalendar struct {
+	days       [7]bool
+	start, end int // minutes after midnight
+	holidays   map[string]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+const holidayFormat = "2006-01-02"
+
+// parseDayTime returns minutes after midnight for "HH:MM"
+func parseDayTime(ts string) (int, error) {
+	t, err := time.Parse("15:04", ts)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', should be e.g. '09:00'", ts)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// calendar checks and parses the configured BusinessHours
+func (b *BusinessHours) calendar() (*hoursCalendar, error) {
+	bc := &hoursCalendar{holidays: make(map[string]bool)}
+	days := b.Days
+	if len(days) == 0 {
+		days = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+	}
+	for _, d := range days {
+		if len(d) < 3 {
+			return nil, fmt.Errorf("invalid day '%s'", d)
+		}
+		wd, ok := weekdays[strings.ToLower(d[0:3])]
+		if !ok {
+			return nil, fmt.Errorf("invalid day '%s'", d)
+		}
+		bc.days[wd] = true
+	}
+	var err error
+	if bc.start, err = parseDayTime(b.Start); err != nil {
+		return nil, err
+	}
+	if bc.end, err = parseDayTime(b.End); err != nil {
+		return nil, err
+	}
+	if bc.end <= bc.start {
+		return nil, fmt.Errorf("End (%s) must be later than Start (%s)", b.End, b.Start)
+	}
+	for _, h := range b.Holidays {
+		if _, err := time.Parse(holidayFormat, h); err != nil {
+			return nil, fmt.Errorf("invalid holiday '%s', should be e.g. '2019-12-25'", h)
+		}
+		bc.holidays[h] = true
+	}
+	return bc, nil
+}
+
+// businessDay reports whether the day of t has business hours
+func (bc *hoursCalendar) businessDay(t time.Time) bool {
+	return bc.days[t.Weekday()] && !bc.holidays[t.Format(holidayFormat)]
+}
+
+// within reports whether t is during business hours
+func (bc *hoursCalendar) within(t time.Time) bool {
+	if !bc.businessDay(t) {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	return m >= bc.start && m < bc.end
+}
+
+// next returns the start of the next business hours window after t, or the
+// zero time if there isn't one in the next year.
+func (bc *hoursCalendar) next(t time.Time) time.Time {
+	for d := 0; d <= 366; d++ {
+		start := time.Date(t.Year(), t.Month(), t.Day()+d, bc.start/60, bc.start%60, 0, 0, t.Location())
+		if !bc.businessDay(start) {
+			continue
+		}
+		if start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// hoursCalendarFor returns the calendar for a plugin, defaulting to the
+// robot's; nil if neither is configured.
+func hoursCalendarFor(plugin *BotPlugin) *hoursCalendar {
+	if plugin != nil && plugin.calendar != nil {
+		return plugin.calendar
+	}
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	return botCfg.businessHours
+}
+
+// businessTime returns the current time in the configured TimeZone
+func businessTime() time.Time {
+	botCfg.RLock()
+	tz := botCfg.timeZone
+	botCfg.RUnlock()
+	if tz == nil {
+		tz = time.Local
+	}
+	return time.Now().In(tz)
+}
+
+// WithinBusinessHours reports whether it's currently business hours, using
+// the plugin's BusinessHours if configured, or the robot's. It always
+// returns true when no BusinessHours are configured.
+func (r *Robot) WithinBusinessHours() bool {
+	c := r.getContext()
+	var plugin *BotPlugin
+	if c.currentTask != nil {
+		_, plugin, _ = getTask(c.currentTask)
+	}
+	bc := hoursCalendarFor(plugin)
+	if bc == nil {
+		r.Log(Warn, "WithinBusinessHours called, but no BusinessHours configured")
+		return true
+	}
+	return bc.within(businessTime())
+}
+
+// checkBusinessHours gates BusinessHoursCommands, replying with the next
+// allowed window when the command is blocked. Administrators can override
+// with an immediate elevation.
+func (c *botContext) checkBusinessHours(plugin *BotPlugin, command string) bool {
+	gated := false
+	for _, i := range plugin.BusinessHoursCommands {
+		if command == i {
+			gated = true
+			break
+		}
+	}
+	if !gated {
+		return true
+	}
+	r := c.makeRobot()
+	bc := hoursCalendarFor(plugin)
+	if bc == nil {
+		Log(Error, fmt.Sprintf("Plugin '%s' has BusinessHoursCommands, but no BusinessHours are configured", plugin.name))
+		r.Say("Sorry, that command is only available during business hours, but no business hours are configured")
+		return false
+	}
+	now := businessTime()
+	if bc.within(now) {
+		return true
+	}
+	if r.CheckAdmin() {
+		r.Say("That command is normally only available during business hours; elevation is required to override")
+		if c.elevate(plugin.BotTask, true) == Success {
+			Log(Audit, fmt.Sprintf("Business hours overridden by admin user '%s' for command '%s' in plugin '%s'", c.User, command, plugin.name))
+			c.elevated = true
+			return true
+		}
+		return false
+	}
+	next := bc.next(now)
+	if next.IsZero() {
+		r.Say("Sorry, that command is only available during business hours, and none are scheduled in the next year")
+	} else {
+		r.Say(fmt.Sprintf("Sorry, that command is only available during business hours; the next window starts %s", next.Format("Mon Jan 2 15:04 MST")))
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+/* channeladdressing.go - the robot's single-character alias can collide with
+   another robot's in a shared channel. ChannelAddressing in gopherbot.yaml
+   gives a channel its own alias, or requires the robot to be addressed by
+   name there; channels without an override use the global Alias.
+   CommandPrefixes work in every channel that allows an alias.
+*/
+
+// ChannelAddressing overrides how the robot is addressed in a channel
+type ChannelAddressing struct {
+	Channel     string // name of the channel
+	Alias       string // alias to use in this channel instead of the global Alias
+	RequireName bool   // don't respond to any alias in this channel, only the robot's name
+}
+
+// channelAliasMap checks the configured ChannelAddressing, returning the
+// alias to use for each channel; 0 means the robot's name is required.
+func channelAliasMap(cas []ChannelAddressing) map[string]rune {
+	cmap := make(map[string]rune)
+	for _, ca := range cas {
+		if len(ca.Channel) == 0 {
+			Log(Error, "Zero-length Channel in ChannelAddressing, skipping")
+			continue
+		}
+		if ca.RequireName {
+			if len(ca.Alias) > 0 {
+				Log(Warn, fmt.Sprintf("ChannelAddressing for channel '%s' sets both Alias and RequireName; ignoring Alias", ca.Channel))
+			}
+			cmap[ca.Channel] = 0
+			continue
+		}
+		if len(ca.Alias) == 0 {
+			Log(Error, fmt.Sprintf("ChannelAddressing for channel '%s' sets neither Alias nor RequireName, skipping", ca.Channel))
+			continue
+		}
+		alias, _ := utf8.DecodeRuneInString(ca.Alias)
+		if !strings.ContainsRune(string(aliases+escapeAliases), alias) {
+			Log(Error, fmt.Sprintf("Invalid Alias '%s' in ChannelAddressing for channel '%s', skipping. Must be one of: %s%s", ca.Alias, ca.Channel, escapeAliases, aliases))
+			continue
+		}
+		cmap[ca.Channel] = alias
+	}
+	return cmap
+}
+
+// commandPrefixes checks the configured CommandPrefixes, returning them
+// longest first.
+func commandPrefixes(configured []string) []string {
+	prefixes := make([]string, 0, len(configured))
+	for _, prefix := range configured {
+		if len(strings.TrimSpace(prefix)) == 0 {
+			Log(Error, "Empty prefix in CommandPrefixes, skipping")
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return prefixes
+}
+
+// updateChannelRegexes compiles the preRegex for each channel with a
+// ChannelAddressing override; called from updateRegexes. A channel that
+// requires the robot's name doesn't take CommandPrefixes either.
+func updateChannelRegexes(name string, channelAliases map[string]rune, prefixes []string) map[string]*regexp.Regexp {
+	channelPre := make(map[string]*regexp.Regexp)
+	for channel, alias := range channelAliases {
+		channelPrefixes := prefixes
+		if alias == 0 {
+			channelPrefixes = nil
+		}
+		pre, _, _, errpre, _, _ := updateRegexesWrapped(name, alias, channelPrefixes)
+		if errpre != nil {
+			Log(Error, fmt.Sprintf("Error compiling pre regex for channel '%s': %s", channel, errpre))
+		}
+		if pre != nil {
+			Log(Debug, fmt.Sprintf("Setting pre regex for channel '%s' to: %s", channel, pre))
+		}
+		channelPre[channel] = pre
+	}
+	return channelPre
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+/* channeloverrides.go - per-channel plugin configuration. A plugin's
+   ChannelOverrides adjust RequireAdmin, Users and Config for a given
+   channel, e.g. so a plugin can be open to everyone in #ops but restricted
+   to admins in #random, or use a different backend per channel. Overrides
+   are checked and resolved when the configuration is loaded; the effective
+   configuration is picked from the channel the plugin was triggered in.
+   Direct messages always get the plugin's base configuration.
+*/
+
+// Values for ChannelOverride.Merge
+const (
+	mergeOverride = "override"
+	mergeReplace  = "replace"
+)
+
+// ChannelOverride adjusts a plugin's configuration in one channel. With
+// Merge: override (the default), only the settings given replace the
+// plugin's, and the top-level keys of Config are merged over the plugin's
+// Config. With Merge: replace, RequireAdmin, Users and Config are replaced
+// as a whole; settings not given are unset in the channel.
+type ChannelOverride struct {
+	Merge        string          // "override" or "replace"
+	RequireAdmin *bool           // when nil, the plugin's RequireAdmin applies for Merge: override
+	Users        []string        // when nil, the plugin's Users apply for Merge: override
+	Config       json.RawMessage // custom configuration for the channel
+}
+
+// channelConfig is the effective configuration in a channel
+type channelConfig struct {
+	RequireAdmin bool
+	Users        []string
+	Config       json.RawMessage
+	config       interface{} // Config unmarshalled for Go plugins
+}
+
+// parseOverrides reads ChannelOverrides from a plugin's configuration,
+// rejecting unknown keys so a misspelled setting doesn't silently apply
+// to every user.
+func parseOverrides(value json.RawMessage) (map[string]ChannelOverride, error) {
+	var overrides map[string]ChannelOverride
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&overrides); err != nil {
+		return nil, err
+	}
+	for ch, o := range overrides {
+		if len(ch) == 0 {
+			return nil, fmt.Errorf("empty channel name")
+		}
+		switch o.Merge {
+		case "", mergeOverride, mergeReplace:
+		default:
+			return nil, fmt.Errorf("invalid Merge '%s' for channel '%s', should be '%s' or '%s'", o.Merge, ch, mergeOverride, mergeReplace)
+		}
+	}
+	return overrides, nil
+}
+
+// resolveOverrides computes the effective configuration for each channel in
+// ChannelOverrides; called once the rest of the task's configuration is
+// loaded.
+func (task *BotTask) resolveOverrides() error {
+	task.channelConfigs = nil
+	if len(task.ChannelOverrides) == 0 {
+		return nil
+	}
+	configs := make(map[string]*channelConfig)
+	for ch, o := range task.ChannelOverrides {
+		cc := &channelConfig{}
+		if o.Merge == mergeReplace {
+			if o.RequireAdmin != nil {
+				cc.RequireAdmin = *o.RequireAdmin
+			}
+			cc.Users = o.Users
+			cc.Config = o.Config
+		} else {
+			cc.RequireAdmin = task.RequireAdmin
+			if o.RequireAdmin != nil {
+				cc.RequireAdmin = *o.RequireAdmin
+			}
+			cc.Users = task.Users
+			if o.Users != nil {
+				cc.Users = o.Users
+			}
+			cc.Config = task.Config
+			if o.Config != nil {
+				merged, err := mergeConfig(task.Config, o.Config)
+				if err != nil {
+					return fmt.Errorf("channel '%s': %v", ch, err)
+				}
+				cc.Config = merged
+			}
+		}
+		configs[ch] = cc
+	}
+	task.channelConfigs = configs
+	return nil
+}
+
+// mergeConfig merges the top-level keys of override over base
+func mergeConfig(base, override json.RawMessage) (json.RawMessage, error) {
+	var merged, keys map[string]json.RawMessage
+	if base != nil {
+		if err := json.Unmarshal(base, &merged); err != nil {
+			return nil, fmt.Errorf("plugin Config must be a map to merge an override: %v", err)
+		}
+	}
+	if err := json.Unmarshal(override, &keys); err != nil {
+		return nil, fmt.Errorf("Config must be a map for Merge: override: %v", err)
+	}
+	if merged == nil {
+		merged = make(map[string]json.RawMessage)
+	}
+	for k, v := range keys {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
+// forChannel returns the configuration in effect for a channel
+func (task *BotTask) forChannel(channel string) *channelConfig {
+	if cc, ok := task.channelConfigs[channel]; ok {
+		return cc
+	}
+	return &channelConfig{
+		RequireAdmin: task.RequireAdmin,
+		Users:        task.Users,
+		Config:       task.Config,
+		config:       task.config,
+	}
+}
@@ -0,0 +1,266 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* concurrency.go - per-plugin concurrency limits, for plugins that call an
+   API that only tolerates a few concurrent requests. When a plugin sets
+   MaxConcurrent, commands beyond the limit wait for a running command to
+   finish, up to MaxQueued waiting commands; anything beyond that is
+   rejected. Counts are kept by plugin name, so they survive a reload.
+
+   The same applies to all pipelines with MaxPipelines, so a burst of
+   commands can't start an unlimited number of goroutines and processes.
+   Scheduled jobs share the pool with commands, unless
+   MaxScheduledJobs gives them their own. Sub-jobs run in the slot of
+   the pipeline that started them, and builtin-admin is never limited, so
+   an administrator can still check status or quit a busy robot.
+*/
+
+// pluginSlots tracks the running and waiting commands for one plugin, or
+// pipelines for a pipeline pool
+type pluginSlots struct {
+	name     string
+	running  int
+	waiting  int
+	rejected int // commands rejected since start
+	max      int // MaxConcurrent as of the last command
+	queueMax int // MaxQueued as of the last command
+	freed    *sync.Cond
+}
+
+var pluginConcurrency = struct {
+	m map[string]*pluginSlots
+	sync.Mutex
+}{
+	make(map[string]*pluginSlots),
+	sync.Mutex{},
+}
+
+// acquirePluginSlot blocks until the plugin has a free slot, returning
+// false (after telling the user) if the queue is full. The returned
+// pluginSlots is nil for plugins without a limit; otherwise release must be
+// called when the command finishes.
+func (c *botContext) acquirePluginSlot(plugin *BotPlugin) (*pluginSlots, bool) {
+	if plugin.MaxConcurrent <= 0 {
+		return nil, true
+	}
+	name := plugin.name
+	pluginConcurrency.Lock()
+	s, exists := pluginConcurrency.m[name]
+	if !exists {
+		s = &pluginSlots{name: name}
+		s.freed = sync.NewCond(&pluginConcurrency.Mutex)
+		pluginConcurrency.m[name] = s
+	}
+	s.max = plugin.MaxConcurrent
+	s.queueMax = plugin.MaxQueued
+	if s.running < s.max {
+		s.running++
+		pluginConcurrency.Unlock()
+		return s, true
+	}
+	r := c.makeRobot()
+	if s.waiting >= s.queueMax {
+		s.rejected++
+		pluginConcurrency.Unlock()
+		Log(Warn, fmt.Sprintf("Plugin '%s' already running %d commands, rejecting command from user '%s' in channel '%s'", name, s.max, c.User, c.Channel))
+		r.Reply(fmt.Sprintf("Sorry, '%s' is already running as many commands as it can - please try again shortly", name))
+		return nil, false
+	}
+	s.waiting++
+	depth := s.waiting
+	pluginConcurrency.Unlock()
+	Log(Debug, fmt.Sprintf("Queueing command from user '%s' for plugin '%s', queue depth %d", c.User, name, depth))
+	r.Reply(fmt.Sprintf("'%s' is busy; your command is queued and will run shortly", name))
+	pluginConcurrency.Lock()
+	// the limit may have been lowered to 0 by a reload
+	for s.max > 0 && s.running >= s.max {
+		s.freed.Wait()
+	}
+	s.waiting--
+	s.running++
+	pluginConcurrency.Unlock()
+	return s, true
+}
+
+// release frees the slot and wakes any waiting commands
+func (s *pluginSlots) release() {
+	pluginConcurrency.Lock()
+	s.running--
+	s.freed.Broadcast()
+	pluginConcurrency.Unlock()
+}
+
+// concurrencyStatus reports running and queued commands for plugins with a
+// MaxConcurrent.
+func concurrencyStatus() string {
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	limits := make(map[string]*BotPlugin)
+	names := []string{}
+	for _, t := range tasks {
+		_, plugin, _ := getTask(t)
+		if plugin != nil && plugin.MaxConcurrent > 0 {
+			limits[plugin.name] = plugin
+			names = append(names, plugin.name)
+		}
+	}
+	if len(names) == 0 {
+		return "No plugins have a concurrency limit configured"
+	}
+	sort.Strings(names)
+	status := make([]string, 0, len(names)+1)
+	status = append(status, "Plugin concurrency:")
+	pluginConcurrency.Lock()
+	defer pluginConcurrency.Unlock()
+	for _, name := range names {
+		var running, waiting, rejected int
+		if s, ok := pluginConcurrency.m[name]; ok {
+			running, waiting, rejected = s.running, s.waiting, s.rejected
+		}
+		plugin := limits[name]
+		status = append(status, fmt.Sprintf("%s: running %d of %d; queued %d of %d; rejected since start: %d", name, running, plugin.MaxConcurrent, waiting, plugin.MaxQueued, rejected))
+	}
+	return strings.Join(status, "\n")
+}
+
+// pipeline pools
+const (
+	commandPool   = "commands"
+	scheduledPool = "scheduled"
+)
+
+var pipelinePools = struct {
+	m map[string]*pluginSlots
+	sync.Mutex
+}{
+	make(map[string]*pluginSlots),
+	sync.Mutex{},
+}
+
+// pipelinePool returns the pool a new pipeline runs in, with it's limits;
+// max is 0 when the pool is unlimited.
+func pipelinePool(ptype pipelineType) (pool string, max, queueMax int) {
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	if ptype == scheduled && botCfg.maxScheduledJobs > 0 {
+		return scheduledPool, botCfg.maxScheduledJobs, botCfg.maxQueuedPipelines
+	}
+	return commandPool, botCfg.maxPipelines, botCfg.maxQueuedPipelines
+}
+
+// acquirePipelineSlot blocks until the pool for a new pipeline has a free
+// slot, returning false if the queue is full; the user is told the robot
+// is busy, unless the pipeline was started automatically. The returned
+// release func must be called when the pipeline finishes.
+func (c *botContext) acquirePipelineSlot(task *BotTask, ptype pipelineType) (release func(), ok bool) {
+	pool, max, queueMax := pipelinePool(ptype)
+	if max <= 0 || task.name == "builtin-admin" {
+		return func() {}, true
+	}
+	pipelinePools.Lock()
+	s, exists := pipelinePools.m[pool]
+	if !exists {
+		s = &pluginSlots{name: pool}
+		s.freed = sync.NewCond(&pipelinePools.Mutex)
+		pipelinePools.m[pool] = s
+	}
+	s.max = max
+	s.queueMax = queueMax
+	release = func() {
+		pipelinePools.Lock()
+		s.running--
+		s.freed.Broadcast()
+		pipelinePools.Unlock()
+	}
+	if s.running < s.max {
+		s.running++
+		pipelinePools.Unlock()
+		return release, true
+	}
+	interactive := ptype != scheduled && ptype != jobTrigger && !c.automaticTask
+	if s.waiting >= s.queueMax {
+		s.rejected++
+		pipelinePools.Unlock()
+		Log(Warn, fmt.Sprintf("Pipeline pool '%s' already running %d pipelines, rejecting '%s' for user '%s' in channel '%s'", pool, s.max, task.name, c.User, c.Channel))
+		if interactive {
+			c.makeRobot().Reply("Sorry, I'm busy right now - please try again shortly")
+		}
+		return nil, false
+	}
+	s.waiting++
+	depth := s.waiting
+	pipelinePools.Unlock()
+	Log(Debug, fmt.Sprintf("Queueing '%s' in pipeline pool '%s', queue depth %d", task.name, pool, depth))
+	pipelinePools.Lock()
+	// the limit may have been lowered to 0 by a reload
+	for s.max > 0 && s.running >= s.max {
+		s.freed.Wait()
+	}
+	s.waiting--
+	s.running++
+	pipelinePools.Unlock()
+	return release, true
+}
+
+// poolMetric is the state of a pipeline pool or plugin limit, for /metrics
+type poolMetric struct {
+	name                            string
+	running, max, waiting, queueMax int
+	rejected                        int
+}
+
+// pipelineMetrics reports the pipeline pools; the commands pool is always
+// reported, with a max of 0 when unlimited.
+func pipelineMetrics() []poolMetric {
+	botCfg.RLock()
+	max := map[string]int{
+		commandPool:   botCfg.maxPipelines,
+		scheduledPool: botCfg.maxScheduledJobs,
+	}
+	queueMax := botCfg.maxQueuedPipelines
+	botCfg.RUnlock()
+	pipelinePools.Lock()
+	defer pipelinePools.Unlock()
+	var metrics []poolMetric
+	for _, pool := range []string{commandPool, scheduledPool} {
+		if pool == scheduledPool && max[pool] <= 0 {
+			continue
+		}
+		m := poolMetric{name: pool, max: max[pool], queueMax: queueMax}
+		if s, ok := pipelinePools.m[pool]; ok {
+			m.running, m.waiting, m.rejected = s.running, s.waiting, s.rejected
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// pluginMetrics reports plugins with a MaxConcurrent
+func pluginMetrics() []poolMetric {
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	var metrics []poolMetric
+	for _, t := range tasks {
+		_, plugin, _ := getTask(t)
+		if plugin != nil && plugin.MaxConcurrent > 0 {
+			metrics = append(metrics, poolMetric{name: plugin.name, max: plugin.MaxConcurrent, queueMax: plugin.MaxQueued})
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	pluginConcurrency.Lock()
+	defer pluginConcurrency.Unlock()
+	for i := range metrics {
+		if s, ok := pluginConcurrency.m[metrics[i].name]; ok {
+			metrics[i].running, metrics[i].waiting, metrics[i].rejected = s.running, s.waiting, s.rejected
+		}
+	}
+	return metrics
+}
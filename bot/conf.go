@@ -3,6 +3,8 @@ package bot
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -21,15 +23,20 @@ type BotConf struct {
 	MailConfig           botMailer               // configuration for sending email
 	Protocol             string                  // Name of the connector protocol to use, e.g. "slack"
 	ProtocolConfig       json.RawMessage         // Protocol-specific configuration, type for unmarshalling arbitrary config
+	ExtraConnectors      []ExtraConnector        // Additional connectors to start, each with it's own Protocol and ProtocolConfig
 	BotInfo              *UserInfo               // Information about the robot
 	UserRoster           []UserInfo              // List of users and related attributes
 	ChannelRoster        []ChannelInfo           // List of channels mapping names to IDs
 	Brain                string                  // Type of Brain to use
 	BrainConfig          json.RawMessage         // Brain-specific configuration, type for unmarshalling arbitrary config
 	EncryptBrain         bool                    // Whether the brain should be encrypted
+	BrainFallback        bool                    // Fall back to an in-memory brain when the configured brain is unavailable
 	EncryptionKey        string                  // used to decrypt the "real" encryption key
+	EncryptionKeyFile    string                  // file to read the EncryptionKey from, when not given directly
 	HistoryProvider      string                  // Name of provider to use for storing and retrieving job/plugin histories
 	HistoryConfig        json.RawMessage         // History provider specific configuration
+	SecretSource         string                  // Where ${secret:NAME} references in Parameters are looked up; brain (default), env or file
+	SecretSourceConfig   json.RawMessage         // Secret source specific configuration
 	WorkSpace            string                  // Read/Write area the robot uses to do work
 	DefaultElevator      string                  // Elevator plugin to use by default for ElevatedCommands and ElevateImmediateCommands
 	DefaultAuthorizer    string                  // Authorizer plugin to use by default for AuthorizedCommands, or when AuthorizeAllCommands = true
@@ -46,8 +53,37 @@ type BotConf struct {
 	ScheduledJobs        []ScheduledTask         // see tasks.go
 	AdminUsers           []string                // List of users who can access administrative commands
 	Alias                string                  // One-character alias for commands directed at the 'bot, e.g. ';open the pod bay doors'
+	ChannelAddressing    []ChannelAddressing     // Per-channel alternate alias, or requiring the robot's name
+	CommandPrefixes      []string                // Additional prefixes that address a command to the robot like the alias, e.g. "!" or "bot/"
+	ExportAllow          []string                // Parameter names and Config keys that 'export config' shows in clear; all other Parameter values and Config values are redacted
 	LocalPort            int                     // Port number for listening on localhost, for CLI plugins
+	LocalSocket          string                  // Unix socket to listen on instead of LocalPort
+	LocalToken           string                  // Shared secret external tasks send in the X-Gopherbot-Token header; empty disables the check
 	LogLevel             string                  // Initial log level, can be modified by plugins. One of "trace" "debug" "info" "warn" "error"
+	DeadLetterRetention  int                     // How many failed webhook events to keep for replay; default 20
+	DeadLetterMaxAge     string                  // Optional maximum age for dead letters, e.g. "72h"
+	CommandRate          float64                 // Global limit on commands per second; 0 (default) for no limit
+	CommandBurst         int                     // Commands allowed in a burst before pacing starts; default 5
+	CommandQueue         int                     // Commands that can wait for dispatch before new ones are rejected; default 20
+	MaxArgs              int                     // Maximum number of arguments passed to a plugin command; default 64
+	MaxArgLength         int                     // Maximum total length of arguments passed to a plugin command; default 65536
+	DefaultTaskTimeout   int                     // Seconds an external task may run before it's killed, unless the task sets Timeout; default 0 for no limit
+	InitConcurrency      int                     // Maximum number of plugins running "init" at once; default 4
+	MaxPipelines         int                     // Maximum pipelines running at once; 0 (default) for no limit
+	MaxQueuedPipelines   int                     // Pipelines that can wait for MaxPipelines before new ones are rejected; default 0
+	MaxScheduledJobs     int                     // Separate limit for scheduled jobs; 0 (default) to share MaxPipelines
+	SuggestDistance      int                     // Maximum edit distance for "did you mean" suggestions of unmatched commands; default 0 for no suggestions
+	ThreadAddressing     bool                    // Once addressed in a thread, treat further messages in the thread as addressed to the robot
+	ThreadAddressWindow  string                  // How long the robot stays engaged in a quiet thread; default 10m
+	BusinessHours        *BusinessHours          // Default business hours for plugin BusinessHoursCommands
+	RateLimit            *RateLimit              // Default per-user rate limit for plugin commands
+	AuditLog             *AuditLog               // Optional audit log of who ran what
+	LogFile              *LogFile                // Optional log file, rotated by size
+	AuthCache            *AuthCache              // Optional caching of Authorizer results
+	NoUnfurl             bool                    // Suppress link and media previews for all messages, on protocols that support it
+	WatchConfig          bool                    // Reload automatically when yaml files in conf/ change; for development, default off
+	TypingDelay          string                  // Show the typing indicator for commands that run longer than this, e.g. "3s"; default off
+	ElevateTimeout       string                  // How long a successful elevation counts before the user is prompted again, e.g. "30m"; default 2h
 }
 
 type repository struct {
@@ -60,9 +96,12 @@ type repository struct {
 //   - Additional user attributes such as first / last name, email, etc.
 // - Additional information needed by bot internals
 //   - BotUser flag
+// User IDs are only unique to a protocol, so users of ExtraConnectors are
+// listed with their Protocol; UserNames are unique across protocols.
 type UserInfo struct {
 	UserName            string // name that refers to the user in bot config files
 	UserID              string // unique/persistent ID given to the user by the connector
+	Protocol            string // ExtraConnectors protocol the user is on; default the primary connector
 	Email, Phone        string // for Get*Attribute()
 	FullName            string // for Get*Attribute()
 	FirstName, LastName string // for Get*Attribute()
@@ -73,13 +112,20 @@ type UserInfo struct {
 // provide a sensible name for use in configuration files.
 type ChannelInfo struct {
 	ChannelName, ChannelID string // human-readable and protocol-internal channel representations
+	Protocol               string // ExtraConnectors protocol the channel is on; default the primary connector
+}
+
+// protocolKey scopes a user ID, channel ID or channel name to the protocol
+// it's on, "" for the primary connector.
+type protocolKey struct {
+	protocol, key string
 }
 
 type userChanMaps struct {
-	userID    map[string]*UserInfo    // Current map of userID to UserInfo struct
-	user      map[string]*UserInfo    // Current map of username to UserInfo struct
-	channelID map[string]*ChannelInfo // Current map of channel ID to ChannelInfo struct
-	channel   map[string]*ChannelInfo // Current map of channel name to ChannelInfo struct
+	userID    map[protocolKey]*UserInfo    // Current map of protocol and userID to UserInfo struct
+	user      map[string]*UserInfo         // Current map of username to UserInfo struct
+	channelID map[protocolKey]*ChannelInfo // Current map of protocol and channel ID to ChannelInfo struct
+	channel   map[protocolKey]*ChannelInfo // Current map of protocol and channel name to ChannelInfo struct
 }
 
 var currentUCMaps = struct {
@@ -112,6 +158,9 @@ func (c *botContext) loadConfig(preConnect bool) error {
 	if err := c.getConfigFile("gopherbot.yaml", "", true, configload); err != nil {
 		return fmt.Errorf("Loading configuration file: %v", err)
 	}
+	if err := applyConfigEnv(configload); err != nil {
+		return err
+	}
 
 	reporaw := make(map[string]json.RawMessage)
 	c.getConfigFile("repositories.yaml", "", false, reporaw)
@@ -133,36 +182,60 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		var sarrval []string
 		var urval []UserInfo
 		var bival *UserInfo
+		var bhval *BusinessHours
+		var rlval *RateLimit
+		var alval *AuditLog
+		var lfval *LogFile
+		var acval *AuthCache
 		var crval []ChannelInfo
+		var caval []ChannelAddressing
+		var ecval []ExtraConnector
 		var tval map[string]ExternalTask
 		var stval []ScheduledTask
 		var mailval botMailer
 		var boolval bool
 		var intval int
+		var floatval float64
 		var val interface{}
 		skip := false
 		switch key {
-		case "AdminContact", "Email", "Protocol", "Brain", "EncryptionKey", "HistoryProvider", "WorkSpace", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone":
+		case "AdminContact", "Email", "Protocol", "Brain", "EncryptionKey", "EncryptionKeyFile", "HistoryProvider", "SecretSource", "WorkSpace", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone", "DeadLetterMaxAge", "ThreadAddressWindow", "TypingDelay", "ElevateTimeout", "LocalSocket", "LocalToken":
 			val = &strval
-		case "DefaultAllowDirect", "EncryptBrain":
+		case "DefaultAllowDirect", "EncryptBrain", "BrainFallback", "ThreadAddressing", "NoUnfurl", "WatchConfig":
 			val = &boolval
 		case "BotInfo":
 			val = &bival
+		case "BusinessHours":
+			val = &bhval
+		case "RateLimit":
+			val = &rlval
+		case "AuditLog":
+			val = &alval
+		case "LogFile":
+			val = &lfval
+		case "AuthCache":
+			val = &acval
 		case "UserRoster":
 			val = &urval
 		case "ChannelRoster":
 			val = &crval
-		case "LocalPort":
+		case "ChannelAddressing":
+			val = &caval
+		case "ExtraConnectors":
+			val = &ecval
+		case "LocalPort", "DeadLetterRetention", "CommandBurst", "CommandQueue", "MaxArgs", "MaxArgLength", "DefaultTaskTimeout", "InitConcurrency", "MaxPipelines", "MaxQueuedPipelines", "MaxScheduledJobs", "SuggestDistance":
 			val = &intval
+		case "CommandRate":
+			val = &floatval
 		case "ExternalJobs", "ExternalPlugins", "ExternalTasks":
 			val = &tval
 		case "ScheduledJobs":
 			val = &stval
-		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers":
+		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers", "CommandPrefixes", "ExportAllow":
 			val = &sarrval
 		case "MailConfig":
 			val = &mailval
-		case "ProtocolConfig", "BrainConfig", "HistoryConfig":
+		case "ProtocolConfig", "BrainConfig", "HistoryConfig", "SecretSourceConfig":
 			skip = true
 		default:
 			err := fmt.Errorf("Invalid configuration key in gopherbot.yaml: %s", key)
@@ -187,16 +260,24 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			newconfig.Protocol = *(val.(*string))
 		case "ProtocolConfig":
 			newconfig.ProtocolConfig = value
+		case "ExtraConnectors":
+			newconfig.ExtraConnectors = *(val.(*[]ExtraConnector))
 		case "Brain":
 			newconfig.Brain = *(val.(*string))
 		case "EncryptionKey":
 			newconfig.EncryptionKey = *(val.(*string))
+		case "EncryptionKeyFile":
+			newconfig.EncryptionKeyFile = *(val.(*string))
 		case "BrainConfig":
 			newconfig.BrainConfig = value
 		case "HistoryProvider":
 			newconfig.HistoryProvider = *(val.(*string))
 		case "HistoryConfig":
 			newconfig.HistoryConfig = value
+		case "SecretSource":
+			newconfig.SecretSource = *(val.(*string))
+		case "SecretSourceConfig":
+			newconfig.SecretSourceConfig = value
 		case "WorkSpace":
 			newconfig.WorkSpace = *(val.(*string))
 		case "DefaultJobChannel":
@@ -218,10 +299,16 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			newconfig.DefaultChannels = *(val.(*[]string))
 		case "IgnoreUsers":
 			newconfig.IgnoreUsers = *(val.(*[]string))
+		case "CommandPrefixes":
+			newconfig.CommandPrefixes = *(val.(*[]string))
+		case "ExportAllow":
+			newconfig.ExportAllow = *(val.(*[]string))
 		case "JoinChannels":
 			newconfig.JoinChannels = *(val.(*[]string))
 		case "EncryptBrain":
 			newconfig.EncryptBrain = *(val.(*bool))
+		case "BrainFallback":
+			newconfig.BrainFallback = *(val.(*bool))
 		case "ExternalPlugins":
 			newconfig.ExternalPlugins = *(val.(*map[string]ExternalTask))
 		case "ExternalJobs":
@@ -234,17 +321,74 @@ func (c *botContext) loadConfig(preConnect bool) error {
 			newconfig.AdminUsers = *(val.(*[]string))
 		case "Alias":
 			newconfig.Alias = *(val.(*string))
+		case "ChannelAddressing":
+			newconfig.ChannelAddressing = *(val.(*[]ChannelAddressing))
 		case "LocalPort":
 			newconfig.LocalPort = *(val.(*int))
+		case "LocalSocket":
+			newconfig.LocalSocket = *(val.(*string))
+		case "LocalToken":
+			newconfig.LocalToken = *(val.(*string))
 		case "LogLevel":
 			newconfig.LogLevel = *(val.(*string))
 		case "TimeZone":
 			newconfig.TimeZone = *(val.(*string))
+		case "DeadLetterRetention":
+			newconfig.DeadLetterRetention = *(val.(*int))
+		case "DeadLetterMaxAge":
+			newconfig.DeadLetterMaxAge = *(val.(*string))
+		case "CommandRate":
+			newconfig.CommandRate = *(val.(*float64))
+		case "CommandBurst":
+			newconfig.CommandBurst = *(val.(*int))
+		case "CommandQueue":
+			newconfig.CommandQueue = *(val.(*int))
+		case "MaxArgs":
+			newconfig.MaxArgs = *(val.(*int))
+		case "MaxArgLength":
+			newconfig.MaxArgLength = *(val.(*int))
+		case "DefaultTaskTimeout":
+			newconfig.DefaultTaskTimeout = *(val.(*int))
+		case "InitConcurrency":
+			newconfig.InitConcurrency = *(val.(*int))
+		case "MaxPipelines":
+			newconfig.MaxPipelines = *(val.(*int))
+		case "MaxQueuedPipelines":
+			newconfig.MaxQueuedPipelines = *(val.(*int))
+		case "MaxScheduledJobs":
+			newconfig.MaxScheduledJobs = *(val.(*int))
+		case "SuggestDistance":
+			newconfig.SuggestDistance = *(val.(*int))
+		case "ThreadAddressing":
+			newconfig.ThreadAddressing = *(val.(*bool))
+		case "ThreadAddressWindow":
+			newconfig.ThreadAddressWindow = *(val.(*string))
+		case "BusinessHours":
+			newconfig.BusinessHours = *(val.(**BusinessHours))
+		case "RateLimit":
+			newconfig.RateLimit = *(val.(**RateLimit))
+		case "AuditLog":
+			newconfig.AuditLog = *(val.(**AuditLog))
+		case "LogFile":
+			newconfig.LogFile = *(val.(**LogFile))
+		case "AuthCache":
+			newconfig.AuthCache = *(val.(**AuthCache))
+		case "NoUnfurl":
+			newconfig.NoUnfurl = *(val.(*bool))
+		case "WatchConfig":
+			newconfig.WatchConfig = *(val.(*bool))
+		case "TypingDelay":
+			newconfig.TypingDelay = *(val.(*string))
+		case "ElevateTimeout":
+			newconfig.ElevateTimeout = *(val.(*string))
 		}
 	}
 
 	loglevel = logStrToLevel(newconfig.LogLevel)
 	setLogLevel(loglevel)
+	if !validatingConfig {
+		setLogFile(newconfig.LogFile)
+	}
 
 	if !preConnect {
 		botCfg.Lock()
@@ -257,6 +401,8 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		}
 		botCfg.alias = alias
 	}
+	botCfg.channelAliases = channelAliasMap(newconfig.ChannelAddressing)
+	botCfg.commandPrefixes = commandPrefixes(newconfig.CommandPrefixes)
 
 	if len(newconfig.DefaultMessageFormat) == 0 {
 		botCfg.defaultMessageFormat = Raw
@@ -285,6 +431,91 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		}
 	}
 
+	botCfg.deadLetterMax = newconfig.DeadLetterRetention
+	botCfg.deadLetterAge = 0
+	if newconfig.DeadLetterMaxAge != "" {
+		if age, err := time.ParseDuration(newconfig.DeadLetterMaxAge); err == nil {
+			botCfg.deadLetterAge = age
+		} else {
+			Log(Error, fmt.Sprintf("Parsing DeadLetterMaxAge '%s', dead letters will only be limited by count: %v", newconfig.DeadLetterMaxAge, err))
+		}
+	}
+
+	configureShaper(newconfig.CommandRate, newconfig.CommandBurst, newconfig.CommandQueue)
+
+	botCfg.maxArgs = defaultMaxArgs
+	if newconfig.MaxArgs > 0 {
+		botCfg.maxArgs = newconfig.MaxArgs
+	}
+	botCfg.maxArgLength = defaultMaxArgLength
+	if newconfig.MaxArgLength > 0 {
+		botCfg.maxArgLength = newconfig.MaxArgLength
+	}
+	botCfg.taskTimeout = time.Duration(newconfig.DefaultTaskTimeout) * time.Second
+	botCfg.initConcurrency = defaultInitConcurrency
+	if newconfig.InitConcurrency > 0 {
+		botCfg.initConcurrency = newconfig.InitConcurrency
+	}
+	botCfg.maxPipelines = newconfig.MaxPipelines
+	botCfg.maxQueuedPipelines = newconfig.MaxQueuedPipelines
+	botCfg.maxScheduledJobs = newconfig.MaxScheduledJobs
+	botCfg.suggestDistance = newconfig.SuggestDistance
+
+	botCfg.threadWindow = 0
+	if newconfig.ThreadAddressing {
+		botCfg.threadWindow = defaultThreadWindow
+		if newconfig.ThreadAddressWindow != "" {
+			if window, err := time.ParseDuration(newconfig.ThreadAddressWindow); err == nil && window > 0 {
+				botCfg.threadWindow = window
+			} else {
+				Log(Error, fmt.Sprintf("Parsing ThreadAddressWindow '%s', using default of %s", newconfig.ThreadAddressWindow, defaultThreadWindow))
+			}
+		}
+	}
+
+	botCfg.noUnfurl = newconfig.NoUnfurl
+	botCfg.watchConfig = newconfig.WatchConfig
+
+	botCfg.typingDelay = 0
+	if newconfig.TypingDelay != "" {
+		if delay, err := time.ParseDuration(newconfig.TypingDelay); err == nil && delay > 0 {
+			botCfg.typingDelay = delay
+		} else {
+			Log(Error, fmt.Sprintf("Parsing TypingDelay '%s', the typing indicator won't be shown automatically", newconfig.TypingDelay))
+		}
+	}
+
+	botCfg.authTTL, botCfg.authNegativeTTL = newconfig.AuthCache.authTTLs()
+
+	botCfg.elevateTimeout = defaultElevateTimeout
+	if newconfig.ElevateTimeout != "" {
+		if timeout, err := time.ParseDuration(newconfig.ElevateTimeout); err == nil && timeout >= 0 {
+			botCfg.elevateTimeout = timeout
+		} else {
+			Log(Error, fmt.Sprintf("Parsing ElevateTimeout '%s', using default of %s", newconfig.ElevateTimeout, defaultElevateTimeout))
+		}
+	}
+
+	botCfg.businessHours = nil
+	if newconfig.BusinessHours != nil {
+		if bc, err := newconfig.BusinessHours.calendar(); err == nil {
+			botCfg.businessHours = bc
+		} else {
+			Log(Error, fmt.Sprintf("Invalid BusinessHours, ignoring: %v", err))
+		}
+	}
+
+	botCfg.rateLimit = nil
+	if newconfig.RateLimit != nil {
+		if rl, err := newconfig.RateLimit.limiter(); err == nil {
+			botCfg.rateLimit = rl
+		} else {
+			Log(Error, fmt.Sprintf("Invalid RateLimit, ignoring: %v", err))
+		}
+	}
+
+	botCfg.auditLog = newconfig.AuditLog
+
 	if newconfig.BotInfo != nil {
 		botID := botCfg.botinfo.UserID
 		botCfg.botinfo = *newconfig.BotInfo
@@ -349,6 +580,12 @@ func (c *botContext) loadConfig(preConnect bool) error {
 	for _, s := range newconfig.ScheduledJobs {
 		if len(s.Name) == 0 || len(s.Schedule) == 0 {
 			Log(Error, fmt.Sprintf("Zero-length Name (%s) or Schedule (%s) in ScheduledTask, skipping", s.Name, s.Schedule))
+		} else if _, err := parseSchedule(s.Schedule); err != nil {
+			Log(Error, fmt.Sprintf("Skipping ScheduledJob '%s': %v", s.Name, err))
+		} else if _, err := parseJitter(s.Jitter); err != nil {
+			Log(Error, fmt.Sprintf("Skipping ScheduledJob '%s': %v", s.Name, err))
+		} else if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			Log(Error, fmt.Sprintf("Skipping ScheduledJob '%s': invalid TimeZone '%s': %v", s.Name, s.TimeZone, err))
 		} else {
 			st = append(st, s)
 		}
@@ -362,21 +599,29 @@ func (c *botContext) loadConfig(preConnect bool) error {
 	}
 
 	ucmaps := userChanMaps{
+		make(map[protocolKey]*UserInfo),
 		make(map[string]*UserInfo),
-		make(map[string]*UserInfo),
-		make(map[string]*ChannelInfo),
-		make(map[string]*ChannelInfo),
+		make(map[protocolKey]*ChannelInfo),
+		make(map[protocolKey]*ChannelInfo),
 	}
 	usermap := make(map[string]string)
 	if len(newconfig.UserRoster) > 0 {
 		for i, user := range newconfig.UserRoster {
 			if len(user.UserName) == 0 || len(user.UserID) == 0 {
 				Log(Error, fmt.Sprintf("one of Username/UserID empty (%s/%s), ignoring", user.UserName, user.UserID))
+			} else if _, dup := ucmaps.user[user.UserName]; dup {
+				Log(Error, fmt.Sprintf("UserName '%s' listed more than once in the UserRoster, ignoring UserID '%s'; users on other protocols need their own UserName", user.UserName, user.UserID))
 			} else {
 				u := &newconfig.UserRoster[i]
+				if u.Protocol == newconfig.Protocol {
+					u.Protocol = ""
+				}
 				ucmaps.user[u.UserName] = u
-				ucmaps.userID[u.UserID] = u
-				usermap[u.UserName] = u.UserID
+				ucmaps.userID[protocolKey{u.Protocol, u.UserID}] = u
+				// the primary connector only gets it's own users
+				if len(u.Protocol) == 0 {
+					usermap[u.UserName] = u.UserID
+				}
 			}
 		}
 		if len(botCfg.botinfo.UserName) > 0 && len(botCfg.botinfo.UserID) > 0 {
@@ -389,8 +634,11 @@ func (c *botContext) loadConfig(preConnect bool) error {
 				Log(Error, fmt.Sprintf("one of ChannelName/ChannelID empty (%s/%s), ignoring", ch.ChannelName, ch.ChannelID))
 			} else {
 				c := &newconfig.ChannelRoster[i]
-				ucmaps.channel[c.ChannelName] = c
-				ucmaps.channelID[c.ChannelID] = c
+				if c.Protocol == newconfig.Protocol {
+					c.Protocol = ""
+				}
+				ucmaps.channel[protocolKey{c.Protocol, c.ChannelName}] = c
+				ucmaps.channelID[protocolKey{c.Protocol, c.ChannelID}] = c
 			}
 		}
 	}
@@ -427,10 +675,22 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		if newconfig.ProtocolConfig != nil {
 			protocolConfig = newconfig.ProtocolConfig
 		}
+		extraConnectors = newconfig.ExtraConnectors
 
 		if newconfig.EncryptBrain {
 			encryptBrain = true
 		}
+		if newconfig.EncryptionKey == "" && newconfig.EncryptionKeyFile != "" {
+			kf := newconfig.EncryptionKeyFile
+			if !filepath.IsAbs(kf) {
+				kf = filepath.Join(configPath, kf)
+			}
+			if kb, err := ioutil.ReadFile(kf); err != nil {
+				Log(Error, fmt.Sprintf("Reading EncryptionKeyFile: %v", err))
+			} else {
+				newconfig.EncryptionKey = strings.TrimSpace(string(kb))
+			}
+		}
 		if newconfig.EncryptionKey != "" {
 			botCfg.encryptionKey = newconfig.EncryptionKey
 			newconfig.EncryptionKey = "XXXXXX" // too short to be valid anyway
@@ -441,11 +701,18 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		if newconfig.BrainConfig != nil {
 			brainConfig = newconfig.BrainConfig
 		}
-		if newconfig.LocalPort != 0 {
+		botCfg.brainFallback = newconfig.BrainFallback
+		if len(newconfig.LocalSocket) > 0 {
+			botCfg.socket = newconfig.LocalSocket
+		} else if newconfig.LocalPort != 0 {
 			botCfg.port = fmt.Sprintf("127.0.0.1:%d", newconfig.LocalPort)
 		} else {
 			Log(Error, "LocalPort not defined, not exporting GOPHER_HTTP_POST and external tasks will be broken")
 		}
+		botCfg.httpToken = newconfig.LocalToken
+		if len(botCfg.httpToken) == 0 {
+			Log(Warn, "LocalToken not set, the http/JSON api will accept unauthenticated requests")
+		}
 	} else {
 		if len(usermap) > 0 {
 			botCfg.SetUserMap(usermap)
@@ -467,6 +734,8 @@ func (c *botContext) loadConfig(preConnect bool) error {
 		}
 	}
 
+	configureSecretSource(newconfig.SecretSource, newconfig.SecretSourceConfig)
+
 	confLock.Lock()
 	config = newconfig
 	repositories = repolist
@@ -482,6 +751,9 @@ func (c *botContext) loadConfig(preConnect bool) error {
 	if !preConnect {
 		updateRegexes()
 		scheduleTasks()
+		// group membership may have changed with the configuration
+		flushAuthCache()
+		setReloaded()
 	}
 
 	return nil
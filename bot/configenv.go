@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* configenv.go - configuration from the environment, for twelve-factor
+   deployments. Top-level settings with a single value can be overridden
+   with GOPHERBOT_<SETTING>, the setting name in upper snake case, e.g.
+   GOPHERBOT_LOCAL_PORT or GOPHERBOT_BRAIN; the environment takes precedence
+   over the file. Other values come from the environment with the
+   {{ env "NAME" }} template function. Settings taken from the environment
+   are logged by name, never with their values.
+*/
+
+const envSettingPrefix = "GOPHERBOT_"
+
+// envSettingName returns the environment variable overriding a top-level
+// setting, e.g. GOPHERBOT_DEFAULT_MESSAGE_FORMAT for DefaultMessageFormat.
+func envSettingName(setting string) string {
+	r := []rune(setting)
+	var name strings.Builder
+	name.WriteString(envSettingPrefix)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) && (!unicode.IsUpper(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToUpper(c))
+	}
+	return name.String()
+}
+
+// applyConfigEnv applies GOPHERBOT_* overrides for top-level settings;
+// called from loadConfig.
+func applyConfigEnv(configload map[string]json.RawMessage) error {
+	var sourced []string
+	conf := reflect.TypeOf(BotConf{})
+	for i := 0; i < conf.NumField(); i++ {
+		field := conf.Field(i)
+		name := envSettingName(field.Name)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var val interface{}
+		var err error
+		switch field.Type.Kind() {
+		case reflect.String:
+			val = env
+		case reflect.Bool:
+			val, err = strconv.ParseBool(env)
+		case reflect.Int:
+			val, err = strconv.Atoi(env)
+		case reflect.Float64:
+			val, err = strconv.ParseFloat(env, 64)
+		default:
+			Log(Warn, fmt.Sprintf("Ignoring '%s', only settings with a single value can be set from the environment", name))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid value for '%s' in environment variable '%s': %v", field.Name, name, err)
+		}
+		configload[field.Name], _ = json.Marshal(val)
+		sourced = append(sourced, field.Name)
+	}
+	if len(sourced) > 0 {
+		sort.Strings(sourced)
+		Log(Info, fmt.Sprintf("Configuration settings from the environment: %s", strings.Join(sourced, ", ")))
+	}
+	return nil
+}
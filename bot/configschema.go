@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+/* configschema.go - checking a plugin's custom Config at load. Plugins can
+   provide a schema for their Config: Go plugins with ConfigSchema in the
+   PluginHandler, external plugins with ConfigSchema in the default
+   configuration they return for 'configure'. A misspelled or mistyped key
+   then disables the plugin with an error naming the offending path, rather
+   than silently unmarshalling to a zero value. Go plugins without a schema
+   can instead tag fields of their Config struct with `gopherbot:"required"`.
+
+   Only a subset of JSON Schema is supported: type, properties, required,
+   additionalProperties (true / false), items and enum; other keywords are
+   ignored.
+*/
+
+// configSchema is the supported subset of JSON Schema
+type configSchema struct {
+	Type                 string                   `json:"type"`
+	Properties           map[string]*configSchema `json:"properties"`
+	Required             []string                 `json:"required"`
+	AdditionalProperties *bool                    `json:"additionalProperties"`
+	Items                *configSchema            `json:"items"`
+	Enum                 []interface{}            `json:"enum"`
+	foldCase             bool                     // derived from a Go struct, which unmarshals keys case-insensitively
+}
+
+// parseSchema reads a schema in yaml or json
+func parseSchema(data []byte) (*configSchema, error) {
+	var s configSchema
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// check catches mistakes in the schema itself
+func (s *configSchema) check() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("invalid type '%s'", s.Type)
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("empty schema for property '%s'", name)
+		}
+		if err := p.check(); err != nil {
+			return fmt.Errorf("property '%s': %v", name, err)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.check(); err != nil {
+			return fmt.Errorf("items: %v", err)
+		}
+	}
+	return nil
+}
+
+// deriveSchema builds a schema from a Go plugin's Config struct, with the
+// fields tagged `gopherbot:"required"`; nil if no fields are required.
+func deriveSchema(t reflect.Type) *configSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &configSchema{foldCase: true}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if len(f.PkgPath) > 0 { // unexported
+				continue
+			}
+			name := f.Name
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				name = tag
+			}
+			if f.Tag.Get("gopherbot") == "required" {
+				s.Required = append(s.Required, name)
+			}
+			if p := deriveSchema(f.Type); p != nil {
+				if s.Properties == nil {
+					s.Properties = make(map[string]*configSchema)
+				}
+				s.Properties[name] = p
+			}
+		}
+		if len(s.Required) == 0 && len(s.Properties) == 0 {
+			return nil
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		if items := deriveSchema(t.Elem()); items != nil {
+			return &configSchema{Items: items}
+		}
+	}
+	return nil
+}
+
+// validate checks config against the schema, returning a sorted list of
+// problems, each starting with the path of the offending value.
+func (s *configSchema) validate(path string, config json.RawMessage) []string {
+	if config == nil {
+		if len(s.Required) > 0 {
+			return []string{fmt.Sprintf("%s: missing, required: %s", path, strings.Join(s.Required, ", "))}
+		}
+		return nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	if err := dec.Decode(&v); err != nil {
+		return []string{fmt.Sprintf("%s: %v", path, err)}
+	}
+	var errs []string
+	s.validateValue(path, v, &errs)
+	sort.Strings(errs)
+	return errs
+}
+
+func (s *configSchema) validateValue(path string, v interface{}, errs *[]string) {
+	if len(s.Type) > 0 && jsonType(v, s.Type == "integer") != s.Type {
+		*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, s.Type, jsonType(v, false)))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allowed := make([]string, len(s.Enum))
+			for i, e := range s.Enum {
+				allowed[i] = fmt.Sprintf("%v", e)
+			}
+			*errs = append(*errs, fmt.Sprintf("%s: value '%v' not one of: %s", path, v, strings.Join(allowed, ", ")))
+		}
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, req := range s.Required {
+			if _, ok := s.lookup(val, req); !ok {
+				*errs = append(*errs, fmt.Sprintf("%s.%s: required", path, req))
+			}
+		}
+		for key, item := range val {
+			p, ok := s.property(key)
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, fmt.Sprintf("%s.%s: unknown key", path, key))
+				}
+				continue
+			}
+			p.validateValue(path+"."+key, item, errs)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validateValue(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	}
+}
+
+// lookup finds a key in a config map
+func (s *configSchema) lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	if s.foldCase {
+		for k, v := range m {
+			if strings.EqualFold(k, key) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// property finds the schema for a config key
+func (s *configSchema) property(key string) (*configSchema, bool) {
+	if p, ok := s.Properties[key]; ok {
+		return p, true
+	}
+	if s.foldCase {
+		for k, p := range s.Properties {
+			if strings.EqualFold(k, key) {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// jsonType returns the JSON Schema type of an unmarshalled value; whole
+// numbers are "integer" when wantInt is set.
+func jsonType(v interface{}, wantInt bool) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if wantInt && val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// checkConfig validates the plugin's Config, and the Config for any
+// ChannelOverrides, against the plugin's schema.
+func (p *BotPlugin) checkConfig() error {
+	schema := p.schema
+	if schema == nil && p.taskType == taskGo {
+		h := pluginHandlers[p.name]
+		if len(h.ConfigSchema) > 0 {
+			s, err := parseSchema([]byte(h.ConfigSchema))
+			if err != nil {
+				return fmt.Errorf("invalid ConfigSchema: %v", err)
+			}
+			schema = s
+		} else if h.Config != nil {
+			schema = deriveSchema(reflect.TypeOf(h.Config))
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+	errs := schema.validate("Config", p.Config)
+	channels := make([]string, 0, len(p.channelConfigs))
+	for ch := range p.channelConfigs {
+		// without a Config of it's own, the channel has the plugin's Config
+		if p.ChannelOverrides[ch].Config != nil {
+			channels = append(channels, ch)
+		}
+	}
+	sort.Strings(channels)
+	for _, ch := range channels {
+		errs = append(errs, schema.validate("ChannelOverrides."+ch+".Config", p.channelConfigs[ch].Config)...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
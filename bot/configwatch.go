@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* configwatch.go - with WatchConfig, the robot reloads it's configuration
+   when yaml files under conf/ change, so plugin and job authors don't need
+   to 'reload' after every edit. The directory is polled rather than watched
+   with inotify and friends, which works the same on every platform and on
+   network filesystems. A reload waits until files have stopped changing for
+   watchSettle, so saving several files, or an editor's write-and-rename,
+   gives a single reload. As with the 'reload' command, a reload that fails
+   keeps the previous configuration.
+*/
+
+const (
+	watchInterval = 2 * time.Second // how often to check for changes
+	watchSettle   = 3 * time.Second // how long files must be unchanged before reloading
+)
+
+// confStamp is how a configuration file is checked for changes
+type confStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// confStamps returns the stamps for all the yaml files under conf/
+func confStamps() map[string]confStamp {
+	stamps := make(map[string]confStamp)
+	filepath.Walk(filepath.Join(configPath, "conf"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return nil
+		}
+		stamps[path] = confStamp{info.ModTime(), info.Size()}
+		return nil
+	})
+	return stamps
+}
+
+func sameStamps(a, b map[string]confStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if bs, ok := b[path]; !ok || !bs.modTime.Equal(stamp.modTime) || bs.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+// runConfigWatcher polls for configuration changes while WatchConfig is
+// set, until the robot stops.
+func runConfigWatcher(done <-chan struct{}) {
+	poll := time.NewTicker(watchInterval)
+	defer poll.Stop()
+	var last map[string]confStamp
+	var changed time.Time // when the last change was seen; zero if none is pending
+	for {
+		select {
+		case <-poll.C:
+		case <-done:
+			return
+		}
+		botCfg.RLock()
+		watch := botCfg.watchConfig
+		botCfg.RUnlock()
+		if !watch {
+			last = nil
+			changed = time.Time{}
+			continue
+		}
+		current := confStamps()
+		if last == nil {
+			Log(Info, fmt.Sprintf("WatchConfig set, watching %d configuration file(s) for changes", len(current)))
+			last = current
+			continue
+		}
+		if !sameStamps(last, current) {
+			last = current
+			changed = time.Now()
+			continue
+		}
+		if !changed.IsZero() && time.Since(changed) >= watchSettle {
+			changed = time.Time{}
+			watchReload()
+		}
+	}
+}
+
+// watchReload reloads the configuration after files changed
+func watchReload() {
+	Log(Info, "Configuration files changed, reloading")
+	c := &botContext{
+		environment: make(map[string]string),
+	}
+	c.registerActive(nil)
+	defer c.deregister()
+	summary, err := c.makeRobot().ReloadConfiguration()
+	if err != nil {
+		Log(Error, fmt.Sprintf("Reloading changed configuration, keeping the current configuration: %v", err))
+		return
+	}
+	Log(Info, strings.Replace(summary, "\n", "; ", -1))
+}
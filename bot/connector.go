@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+/* connector.go - optional connector capabilities. Connectors are registered
+   by name with RegisterConnector (see bot_process.go) from an init()
+   function, so any package imported by main - including one maintained
+   outside this repository - can supply a connector. The robot selects the
+   connector named by the Protocol setting in gopherbot.yaml, and starts any
+   listed in ExtraConnectors; see activeconnectors.go.
+
+   Connector lifecycle:
+   - init(): RegisterConnector(name, initializer)
+   - start-up: the initializer for each configured protocol is called once
+     with a Handler and logger, and returns the Connector
+   - Run(stop) is called in it's own goroutine and should connect to the
+     protocol and deliver messages with Handler.IncomingMessage
+   - at shutdown, the stop channel is closed; Run should disconnect and return
+*/
+
+// ConnectorCapability names an optional feature of a connector protocol.
+type ConnectorCapability string
+
+// Capabilities a connector may declare
+const (
+	// JoinChannel actually joins a channel, instead of being a no-op
+	CapJoinChannel ConnectorCapability = "joinchannel"
+	// TypingIndicator shows the user an indication (e.g. typing) that the
+	// robot heard the message and is working on it
+	CapTypingIndicator ConnectorCapability = "typing"
+	// Threads posts messages with MessageOptions.Thread as replies in the
+	// thread, instead of in the main channel
+	CapThreads ConnectorCapability = "threads"
+	// Ephemeral posts messages in a channel that only one user sees; see
+	// EphemeralSender
+	CapEphemeral ConnectorCapability = "ephemeral"
+	// ReactionEvents delivers users' reactions to messages sent with a
+	// MessageEditor with Handler.IncomingReaction; see WaitForReaction
+	CapReactionEvents ConnectorCapability = "reactionevents"
+	// Blocks sends Block Kit messages, see BlockSender, and delivers button
+	// clicks with Handler.IncomingAction
+	CapBlocks ConnectorCapability = "blocks"
+)
+
+// CapabilityProvider is an optional interface for Connectors to declare
+// the optional features they support. Connectors that don't implement it
+// are assumed to support only the base Connector interface.
+type CapabilityProvider interface {
+	Capabilities() []ConnectorCapability
+}
+
+// MessageOptions are per-message options that only some protocols support;
+// the zero value means no options.
+type MessageOptions struct {
+	NoUnfurl bool   // suppress link and media previews
+	Thread   string // post in the thread with this ID; see CapThreads
+}
+
+// OptionSender is an optional interface for Connectors that support
+// MessageOptions. The bot calls these methods instead of the corresponding
+// Connector methods when a message has options; connectors that don't
+// implement it get the message without options.
+type OptionSender interface {
+	SendProtocolChannelMessageOpts(channelname, msg string, format MessageFormat, opts MessageOptions) RetVal
+	SendProtocolUserChannelMessageOpts(userid, username, channelname, msg string, format MessageFormat, opts MessageOptions) RetVal
+	SendProtocolUserMessageOpts(user, msg string, format MessageFormat, opts MessageOptions) RetVal
+}
+
+// ChannelCreator is an optional interface for Connectors that can create
+// channels, e.g. for incidents.
+type ChannelCreator interface {
+	// CreateChannel creates a channel with the given name and invites the
+	// given users (names or bracketed protocol IDs), returning the name of
+	// the new channel.
+	CreateChannel(name string, users []string) (string, RetVal)
+}
+
+// FileSender is an optional interface for Connectors that can upload files,
+// e.g. reports and graphs. The channel and user arguments are the same as for
+// the corresponding SendProtocol*Message methods.
+type FileSender interface {
+	SendProtocolChannelFile(channelname, name string, content io.Reader, comment string, opts MessageOptions) RetVal
+	SendProtocolUserFile(user, name string, content io.Reader, comment string, opts MessageOptions) RetVal
+}
+
+// Reactor is an optional interface for Connectors that can add emoji
+// reactions to messages. The channel is a name or bracketed protocol ID, and
+// messageID is the MessageID of a ConnectorMessage.
+type Reactor interface {
+	AddReaction(channelname, messageID, emoji string) RetVal
+	RemoveReaction(channelname, messageID, emoji string) RetVal
+}
+
+// EphemeralSender is an optional interface for Connectors that declare
+// CapEphemeral, for messages in a channel that only the given user sees.
+type EphemeralSender interface {
+	SendProtocolEphemeralMessage(userid, username, channelname, msg string, format MessageFormat, opts MessageOptions) RetVal
+}
+
+// BlockSender is an optional interface for Connectors that declare
+// CapBlocks, for sending Block Kit messages. The fallback is a plain text
+// version of the blocks, for notifications.
+type BlockSender interface {
+	SendProtocolChannelBlocks(channelname string, blocks json.RawMessage, fallback string, opts MessageOptions) RetVal
+	SendProtocolUserBlocks(user string, blocks json.RawMessage, fallback string, opts MessageOptions) RetVal
+}
+
+// ConnectionReporter is an optional interface for Connectors that can
+// report the state of their connection to the platform, e.g. while
+// reconnecting after a dropped connection; see the 'connection status'
+// admin command. connected is false while the connector can't reach the
+// platform, which marks the robot unhealthy for the /health endpoint.
+type ConnectionReporter interface {
+	ConnectionStatus() (connected bool, status string)
+}
+
+// MessageEditor is an optional interface for Connectors that can update and
+// delete messages they've sent, e.g. for progress messages. Message IDs are
+// opaque to the robot; the connector encodes whatever it needs to find the
+// message again.
+type MessageEditor interface {
+	SendProtocolChannelMessageID(channelname, msg string, format MessageFormat, opts MessageOptions) (string, RetVal)
+	SendProtocolUserMessageID(user, msg string, format MessageFormat, opts MessageOptions) (string, RetVal)
+	UpdateMessage(id, msg string, format MessageFormat) RetVal
+	DeleteMessage(id string) RetVal
+}
+
+// The helpers below take the connector to send with, normally the one the
+// triggering message arrived on; see Robot.connector(). Markup format
+// messages are rendered for the connector before sending.
+
+// optionSender returns the connector as an OptionSender when the message
+// has options and the connector supports them.
+func optionSender(conn Connector, opts MessageOptions) (OptionSender, bool) {
+	if opts == (MessageOptions{}) {
+		return nil, false
+	}
+	sender, ok := conn.(OptionSender)
+	return sender, ok
+}
+
+func sendProtocolChannelMessage(conn Connector, channel, msg string, f MessageFormat, opts MessageOptions) RetVal {
+	msg, f = renderMarkup(conn, msg, f)
+	if sender, ok := optionSender(conn, opts); ok {
+		return sender.SendProtocolChannelMessageOpts(channel, msg, f, opts)
+	}
+	return conn.SendProtocolChannelMessage(channel, msg, f)
+}
+
+func sendProtocolUserChannelMessage(conn Connector, userid, username, channel, msg string, f MessageFormat, opts MessageOptions) RetVal {
+	msg, f = renderMarkup(conn, msg, f)
+	if sender, ok := optionSender(conn, opts); ok {
+		return sender.SendProtocolUserChannelMessageOpts(userid, username, channel, msg, f, opts)
+	}
+	return conn.SendProtocolUserChannelMessage(userid, username, channel, msg, f)
+}
+
+func sendProtocolUserMessage(conn Connector, user, msg string, f MessageFormat, opts MessageOptions) RetVal {
+	msg, f = renderMarkup(conn, msg, f)
+	if sender, ok := optionSender(conn, opts); ok {
+		return sender.SendProtocolUserMessageOpts(user, msg, f, opts)
+	}
+	return conn.SendProtocolUserMessage(user, msg, f)
+}
+
+// sendProtocolEphemeralMessage falls back to a DM for connectors without
+// ephemeral messages.
+func sendProtocolEphemeralMessage(conn Connector, userid, username, channel, msg string, f MessageFormat, opts MessageOptions) RetVal {
+	msg, f = renderMarkup(conn, msg, f)
+	if connectorSupports(conn, CapEphemeral) {
+		if sender, ok := conn.(EphemeralSender); ok {
+			return sender.SendProtocolEphemeralMessage(userid, username, channel, msg, f, opts)
+		}
+	}
+	// a channel thread doesn't apply to the DM
+	opts.Thread = ""
+	return sendProtocolUserMessage(conn, userid, msg, f, opts)
+}
+
+func sendProtocolChannelFile(conn Connector, channel, name string, content io.Reader, comment string, opts MessageOptions) RetVal {
+	sender, ok := conn.(FileSender)
+	if !ok {
+		return FileSendNotSupported
+	}
+	return sender.SendProtocolChannelFile(channel, name, content, comment, opts)
+}
+
+func sendProtocolUserFile(conn Connector, user, name string, content io.Reader, comment string, opts MessageOptions) RetVal {
+	sender, ok := conn.(FileSender)
+	if !ok {
+		return FileSendNotSupported
+	}
+	return sender.SendProtocolUserFile(user, name, content, comment, opts)
+}
+
+// react adds or removes a reaction; connectors without reactions only log
+// it.
+func react(conn Connector, channel, messageID, emoji string, add bool) RetVal {
+	reactor, ok := conn.(Reactor)
+	if !ok {
+		Log(Debug, fmt.Sprintf("Connector doesn't support reactions, ignoring reaction '%s'", emoji))
+		return Ok
+	}
+	if add {
+		return reactor.AddReaction(channel, messageID, emoji)
+	}
+	return reactor.RemoveReaction(channel, messageID, emoji)
+}
+
+// connectorSupports checks whether a running connector declares a
+// capability.
+func connectorSupports(conn Connector, capability ConnectorCapability) bool {
+	cp, ok := conn.(CapabilityProvider)
+	if !ok {
+		return false
+	}
+	for _, c := range cp.Capabilities() {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// createChannel creates a channel when the connector supports it; ok is
+// false when it doesn't.
+func createChannel(conn Connector, name string, users []string) (channel string, ret RetVal, ok bool) {
+	cc, ok := conn.(ChannelCreator)
+	if !ok {
+		return "", Ok, false
+	}
+	channel, ret = cc.CreateChannel(name, users)
+	return channel, ret, true
+}
+
+// logConnector logs the selected protocol and available connectors at
+// start-up.
+func logConnector(protocol string, conn Connector) {
+	names := make([]string, 0, len(connectors))
+	for name := range connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var caps []ConnectorCapability
+	if cp, ok := conn.(CapabilityProvider); ok {
+		caps = cp.Capabilities()
+	}
+	Log(Info, fmt.Sprintf("Initialized connector for protocol '%s' (registered: %v), capabilities: %v", protocol, names, caps))
+}
@@ -0,0 +1,105 @@
+// +build integration
+
+package bot_test
+
+// connector_integration_test.go - verify that an additionally registered
+// connector is selected by the Protocol setting, or started as an
+// ExtraConnector, and the test connector's helpers for driving the robot.
+
+import (
+	"log"
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+var altConnectorSelected bool
+
+// the 'testextra' connector, when started as an ExtraConnector
+var extraConn *testc.TestConnector
+
+func init() {
+	RegisterConnector("testalt", func(h Handler, l *log.Logger) Connector {
+		altConnectorSelected = true
+		return testc.Initialize(h, l)
+	})
+	RegisterConnector("testextra", func(h Handler, l *log.Logger) Connector {
+		conn := testc.Initialize(h, l)
+		extraConn = conn.(*testc.TestConnector)
+		return conn
+	})
+}
+
+func TestAltConnector(t *testing.T) {
+	altConnectorSelected = false
+	done, conn := setup("resources/cfg/altconnector", "/tmp/bottest.log", t)
+
+	if !altConnectorSelected {
+		t.Errorf("FAILED: Protocol 'testalt' didn't select the 'testalt' connector")
+	}
+
+	tests := []testItem{
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestExtraConnector(t *testing.T) {
+	extraConn = nil
+	done, conn := setup("resources/cfg/extraconnector", "/tmp/bottest.log", t)
+
+	if extraConn == nil {
+		t.Fatalf("FAILED: ExtraConnectors didn't start the 'testextra' connector")
+	}
+
+	tests := []testItem{
+		{aliceID, general, ";ping", []testc.TestMessage{{alice, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	conn.ClearSentMessages()
+	extraTests := []testItem{
+		// replies go back out the connector the message arrived on
+		{"x0003", general, ";ping", []testc.TestMessage{{carol, general, "PONG"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		// users of an ExtraConnector must be listed for it's protocol, by
+		// name or by ID; with no reply to wait for, IgnoredUser is
+		// collected with the next test
+		{"x0009", general, ";ping", []testc.TestMessage{}, []Event{}, 100},
+		{aliceID, general, ";ping", []testc.TestMessage{}, []Event{IgnoredUser}, 100},
+		{"x0003", general, ";ping", []testc.TestMessage{{carol, general, "PONG"}}, []Event{IgnoredUser, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, extraConn, extraTests)
+	if sent := conn.SentMessages(); len(sent) != 0 {
+		t.Errorf("FAILED: messages from the ExtraConnector were answered on the primary connector: %v", sent)
+	}
+
+	teardown(t, done, conn)
+}
+
+func TestSendTestMessage(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	conn.ClearSentMessages()
+	replies := conn.SendTestMessage(alice, general, ";ping")
+	if len(replies) != 1 || replies[0] != (testc.TestMessage{alice, general, "PONG"}) {
+		t.Errorf("FAILED SendTestMessage replies; want: [{alice general PONG}], got: %v", replies)
+	}
+	replies = conn.SendTestMessage(aliceID, null, "ping")
+	if len(replies) != 1 || replies[0] != (testc.TestMessage{alice, null, "PONG"}) {
+		t.Errorf("FAILED SendTestMessage direct message with user ID; want: [{alice  PONG}], got: %v", replies)
+	}
+	sent := conn.SentMessages()
+	if len(sent) != 2 {
+		t.Errorf("FAILED SentMessages; want 2 messages, got: %v", sent)
+	}
+	conn.ClearSentMessages()
+	if sent := conn.SentMessages(); len(sent) != 0 {
+		t.Errorf("FAILED ClearSentMessages; got: %v", sent)
+	}
+	GetEvents()
+
+	teardown(t, done, conn)
+}
@@ -12,6 +12,10 @@ const (
 	Terminal
 	// Test connector for automated test suites
 	Test
+	// Mattermost connector
+	Mattermost
+	// Telegram connector
+	Telegram
 )
 
 type pipeAddFlavor int
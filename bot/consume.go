@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+)
+
+/* consume.go - by default every plugin with a matching MessageMatcher sees
+   an ambient message, highest Priority first and ties by plugin name. A
+   plugin that handles a message completely can call ConsumeMessage, and
+   plugins after it with matching MessageMatchers, and any CatchAll plugins,
+   won't see the message. A matched command is always the only plugin to see
+   a message.
+*/
+
+// pluginMatch is a plugin matcher that matched the message, with the
+// arguments from it's regex
+type pluginMatch struct {
+	t       interface{}
+	matcher InputMatcher
+	args    []string
+}
+
+// ConsumeMessage stops plugins after this one from seeing the message; lower
+// Priority plugins with matching MessageMatchers aren't run, and neither are
+// further CatchAll plugins.
+func (r *Robot) ConsumeMessage() {
+	c := r.getContext()
+	c.Lock()
+	c.consumed = true
+	c.Unlock()
+	r.Log(Debug, fmt.Sprintf("Message consumed by task '%s'", c.taskName))
+}
+
+// messageConsumed reports whether a plugin in the pipeline called
+// ConsumeMessage
+func (c *botContext) messageConsumed() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.consumed
+}
+
+// runAmbient runs the plugins with MessageMatchers matching an ambient
+// message, in Priority order, until one consumes the message.
+func (c *botContext) runAmbient(matches []pluginMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		ti, pi, _ := getTask(matches[i].t)
+		tj, pj, _ := getTask(matches[j].t)
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		return ti.name < tj.name
+	})
+	for i, m := range matches {
+		mc := c
+		if i > 0 {
+			mc = c.clone()
+			mc.isCommand = c.isCommand
+		}
+		mc.runMatch(m.t, m.matcher, m.args, plugMessage)
+		if mc.messageConsumed() {
+			if i < len(matches)-1 {
+				task, _, _ := getTask(m.t)
+				Log(Debug, fmt.Sprintf("Ambient message consumed by '%s', skipping %d more matching plugin(s)", task.name, len(matches)-1-i))
+			}
+			return
+		}
+	}
+}
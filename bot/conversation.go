@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+)
+
+/* conversation.go - a helper for plugins that ask several questions in a
+   row, e.g. a wizard for filing a ticket. The plugin lists the questions in
+   order; Converse prompts for each one with PromptForReply, asks again when
+   an answer doesn't match or fails validation, and returns the answers by
+   name. Only one conversation at a time can run for a user in a given
+   channel (and thread), but the same user can carry on conversations in
+   other channels at the same time.
+*/
+
+// Question is one step in a conversation
+type Question struct {
+	Name     string                    // key for the answer in the map returned by Converse
+	Prompt   string                    // the prompt, as for PromptForReply
+	RegexID  string                    // a ReplyMatchers Label or stock reply like SimpleString, as for PromptForReply
+	Default  string                    // the answer when the user replies '=', if not ""
+	Validate func(answer string) error // optional further check; the error is shown to the user before asking again
+}
+
+// maxQuestionTries is how many times a question is asked before giving up
+const maxQuestionTries = 3
+
+// conversations in progress, with the name of the task holding each one
+var conversations = struct {
+	m map[replyMatcher]string
+	sync.Mutex
+}{
+	make(map[replyMatcher]string),
+	sync.Mutex{},
+}
+
+// Converse asks each question in turn, returning the answers by Name with
+// Ok once all of them are answered. A question is asked again, up to 3
+// times, when the reply doesn't match it's RegexID, fails Validate, or is
+// '=' with no Default. Otherwise Converse stops and returns the answers so
+// far with one of the following RetVals:
+//  TimeoutExpired - the user didn't answer in time, abandoning the conversation
+//  Interrupted - the user cancelled with '-' or issued another command
+//  ReplyNotMatched - the user didn't give a valid answer after 3 tries
+//  ConversationInProgress - the user is already in a conversation in this channel
+//  MatcherNotFound - a RegexID didn't correspond to a valid regex
+func (r *Robot) Converse(questions []Question) (map[string]string, RetVal) {
+	c := r.getContext()
+	// the same thread promptInternal uses
+	thread := r.thread
+	if len(thread) == 0 && r.Channel == c.Channel {
+		thread = c.thread
+	}
+	key := replyMatcher{
+		user:    r.User,
+		channel: r.Channel,
+		thread:  thread,
+	}
+	task, _, _ := getTask(c.currentTask)
+	conversations.Lock()
+	if holder, exists := conversations.m[key]; exists {
+		conversations.Unlock()
+		Log(Debug, fmt.Sprintf("Not starting conversation for '%s' with user '%s' in channel '%s', '%s' already in progress", task.name, r.User, r.Channel, holder))
+		return nil, ConversationInProgress
+	}
+	conversations.m[key] = task.name
+	conversations.Unlock()
+	defer func() {
+		conversations.Lock()
+		delete(conversations.m, key)
+		conversations.Unlock()
+	}()
+
+	answers := make(map[string]string)
+	for _, q := range questions {
+		answer, ret := r.ask(q)
+		if ret != Ok {
+			if ret == TimeoutExpired {
+				Log(Debug, fmt.Sprintf("User '%s' abandoned conversation for '%s' in channel '%s' at question '%s'", r.User, task.name, r.Channel, q.Name))
+			}
+			return answers, ret
+		}
+		answers[q.Name] = answer
+	}
+	return answers, Ok
+}
+
+// ask asks a single question until it gets a valid answer
+func (r *Robot) ask(q Question) (string, RetVal) {
+	prompt := q.Prompt
+	for i := 0; i < maxQuestionTries; i++ {
+		answer, ret := r.PromptForReply(q.RegexID, prompt)
+		switch ret {
+		case Ok:
+		case UseDefaultValue:
+			if len(q.Default) == 0 {
+				prompt = "There's no default; " + q.Prompt
+				continue
+			}
+			answer = q.Default
+		case ReplyNotMatched:
+			prompt = "Sorry, I didn't understand that; " + q.Prompt
+			continue
+		default:
+			return "", ret
+		}
+		if q.Validate != nil {
+			if err := q.Validate(answer); err != nil {
+				prompt = fmt.Sprintf("%v; %s", err, q.Prompt)
+				continue
+			}
+		}
+		return answer, Ok
+	}
+	return "", ReplyNotMatched
+}
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* deadletter.go - storage and admin commands for webhook events whose
+   triggered job failed. Events are kept in the brain so an administrator can
+   inspect and replay them after fixing a downstream problem, without needing
+   the upstream service to re-send.
+*/
+
+const deadLetterKey = "bot:deadletters"
+
+// defaultDeadLetters is how many dead letters are kept when
+// DeadLetterRetention isn't set.
+const defaultDeadLetters = 20
+
+// header names containing any of these are redacted before storing
+var deadLetterRedact = []string{
+	"authorization",
+	"cookie",
+	"key",
+	"password",
+	"secret",
+	"signature",
+	"token",
+}
+
+// deadLetter is a single failed webhook event
+type deadLetter struct {
+	ID         int               // incrementing identifier for admin commands
+	Job        string            // job the webhook triggered
+	User       string            // user from the webhook query
+	Channel    string            // channel from the webhook query
+	Received   time.Time         // when the original event arrived
+	Status     string            // TaskRetVal of the failed run
+	Arguments  []string          // arguments the job was started with
+	Parameters map[string]string // parameters set from the trigger, restored on replay
+	Headers    map[string]string // request headers, with secrets redacted
+	Payload    string            // raw request body
+}
+
+// deadLetterQueue is the datum stored in the brain
+type deadLetterQueue struct {
+	NextID  int
+	Letters []deadLetter
+}
+
+func init() {
+	RegisterPlugin("builtin-deadletter", PluginHandler{Handler: deadletters})
+}
+
+// redactHeaders copies the headers, replacing values for anything that looks
+// like a credential.
+func redactHeaders(headers map[string]string) map[string]string {
+	rh := make(map[string]string, len(headers))
+	for name, value := range headers {
+		lname := strings.ToLower(name)
+		for _, r := range deadLetterRedact {
+			if strings.Contains(lname, r) {
+				value = "XXXXXX"
+				break
+			}
+		}
+		rh[name] = value
+	}
+	return rh
+}
+
+// pruneDeadLetters enforces the configured retention on the queue.
+func pruneDeadLetters(dq *deadLetterQueue) {
+	botCfg.RLock()
+	max := botCfg.deadLetterMax
+	maxAge := botCfg.deadLetterAge
+	botCfg.RUnlock()
+	if max == 0 {
+		max = defaultDeadLetters
+	}
+	if maxAge > 0 {
+		cutoff := time.Now().Add(-maxAge)
+		kept := make([]deadLetter, 0, len(dq.Letters))
+		for _, dl := range dq.Letters {
+			if dl.Received.After(cutoff) {
+				kept = append(kept, dl)
+			}
+		}
+		dq.Letters = kept
+	}
+	if l := len(dq.Letters); l > max {
+		dq.Letters = dq.Letters[l-max:]
+	}
+}
+
+// recordDeadLetter stores a webhook event whose triggered job failed.
+func recordDeadLetter(job, user, channel string, args []string, params, headers map[string]string, payload []byte, status TaskRetVal) {
+	var dq deadLetterQueue
+	tok, _, ret := checkoutDatum(deadLetterKey, &dq, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to store dead letter for job '%s'", deadLetterKey, job))
+		return
+	}
+	if dq.NextID == 0 {
+		dq.NextID = 1
+	}
+	dl := deadLetter{
+		ID:         dq.NextID,
+		Job:        job,
+		User:       user,
+		Channel:    channel,
+		Received:   time.Now(),
+		Status:     status.String(),
+		Arguments:  args,
+		Parameters: params,
+		Headers:    redactHeaders(headers),
+		Payload:    string(payload),
+	}
+	dq.NextID++
+	dq.Letters = append(dq.Letters, dl)
+	pruneDeadLetters(&dq)
+	if ret := updateDatum(deadLetterKey, tok, dq); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to store dead letter for job '%s'", deadLetterKey, job))
+		return
+	}
+	Log(Warn, fmt.Sprintf("Stored dead letter #%d for failed webhook-triggered job '%s'", dl.ID, job))
+}
+
+// removeDeadLetter drops a dead letter from the queue, returning false if
+// not found.
+func removeDeadLetter(id int) bool {
+	var dq deadLetterQueue
+	tok, _, ret := checkoutDatum(deadLetterKey, &dq, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to remove dead letter #%d", deadLetterKey, id))
+		return false
+	}
+	found := false
+	for i, dl := range dq.Letters {
+		if dl.ID == id {
+			dq.Letters = append(dq.Letters[:i], dq.Letters[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		checkinDatum(deadLetterKey, tok)
+		return false
+	}
+	if ret := updateDatum(deadLetterKey, tok, dq); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to remove dead letter #%d", deadLetterKey, id))
+		return false
+	}
+	return true
+}
+
+func deadletters(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	var dq deadLetterQueue
+	_, _, ret := checkoutDatum(deadLetterKey, &dq, false)
+	if ret != Ok {
+		r.Say("I had a problem retrieving the dead letter queue, check the log")
+		return
+	}
+	pruneDeadLetters(&dq)
+	var dl *deadLetter
+	if len(args) > 0 {
+		id, _ := strconv.Atoi(args[0])
+		for i := range dq.Letters {
+			if dq.Letters[i].ID == id {
+				dl = &dq.Letters[i]
+				break
+			}
+		}
+		if dl == nil {
+			r.Say(fmt.Sprintf("I don't have a dead letter #%s", args[0]))
+			return
+		}
+	}
+	switch command {
+	case "list":
+		if len(dq.Letters) == 0 {
+			r.Say("The dead letter queue is empty")
+			return
+		}
+		dll := make([]string, 0, len(dq.Letters)+1)
+		dll = append(dll, "Here are the webhook events whose jobs failed:")
+		for _, dl := range dq.Letters {
+			dll = append(dll, fmt.Sprintf("#%d: job '%s', received %s, status: %s", dl.ID, dl.Job, dl.Received.Format("Mon Jan 2 15:04:05 MST 2006"), dl.Status))
+		}
+		r.MessageFormat(Variable).Say(strings.Join(dll, "\n"))
+	case "show":
+		dls := make([]string, 0, len(dl.Headers)+6)
+		dls = append(dls, fmt.Sprintf("Dead letter #%d for job '%s', status %s", dl.ID, dl.Job, dl.Status))
+		dls = append(dls, fmt.Sprintf("Received: %s", dl.Received.Format("Mon Jan 2 15:04:05 MST 2006")))
+		if len(dl.Arguments) > 0 {
+			dls = append(dls, fmt.Sprintf("Arguments: %s", strings.Join(dl.Arguments, " ")))
+		}
+		if len(dl.Parameters) > 1 {
+			// Parameters can carry secrets from the trigger, so only the
+			// names are shown
+			dls = append(dls, "Parameters:")
+			for name := range dl.Parameters {
+				// the payload is shown below
+				if name != "GOPHER_WEBHOOK_PAYLOAD" {
+					dls = append(dls, fmt.Sprintf("  %s=%s", name, redactedValue))
+				}
+			}
+		}
+		dls = append(dls, "Headers:")
+		for name, value := range dl.Headers {
+			dls = append(dls, fmt.Sprintf("  %s: %s", name, value))
+		}
+		dls = append(dls, "Payload:", dl.Payload)
+		r.Fixed().Say(strings.Join(dls, "\n"))
+	case "replay":
+		c := r.getContext()
+		t := c.tasks.getTaskByName(dl.Job)
+		if t == nil {
+			r.Say(fmt.Sprintf("Sorry, I don't have a job named '%s' configured anymore", dl.Job))
+			return
+		}
+		task, _, job := getTask(t)
+		if job == nil {
+			r.Say(fmt.Sprintf("Sorry, '%s' isn't a job", dl.Job))
+			return
+		}
+		if task.Disabled {
+			r.Say(fmt.Sprintf("Job '%s' is disabled: %s", dl.Job, task.reason))
+			return
+		}
+		id := dl.ID
+		// the same context the webhook started the job with
+		rb := &botContext{
+			User:          dl.User,
+			Channel:       dl.Channel,
+			tasks:         c.tasks,
+			repositories:  c.repositories,
+			msg:           dl.Payload,
+			automaticTask: true,
+			environment:   make(map[string]string),
+		}
+		for name, value := range dl.Parameters {
+			rb.environment[name] = value
+		}
+		rb.environment["GOPHER_WEBHOOK_PAYLOAD"] = dl.Payload
+		r.Say(fmt.Sprintf("Replaying dead letter #%d for job '%s'", id, dl.Job))
+		go func() {
+			if rb.startPipeline(nil, t, jobCmd, "run", dl.Arguments...) == Normal {
+				removeDeadLetter(id)
+			}
+		}()
+	case "drop":
+		if removeDeadLetter(dl.ID) {
+			r.Say(fmt.Sprintf("Removed dead letter #%d", dl.ID))
+		} else {
+			r.Say(fmt.Sprintf("I wasn't able to remove dead letter #%d, check the log", dl.ID))
+		}
+	}
+	return
+}
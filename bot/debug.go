@@ -3,7 +3,8 @@ package bot
 /* debug.go - Provide support for plugin debugging. Admin users can use the
 'debug' built-in to debug a plugin and get verbose messages sent to them as
 a private message detailing everything going on with a plugin. Works well with
-the 'terminal' connector.
+the 'terminal' connector. Debugging lasts for a limited time, an hour by
+default, and is remembered in the brain so it survives a restart.
 */
 
 import (
@@ -12,9 +13,23 @@ import (
 	"time"
 )
 
+const debuggingKey = "bot:debugging"
+
+// how long debugging lasts when the admin doesn't give a time
+const defaultDebugWindow = time.Hour
+
 type debuggingTask struct {
-	taskID, name, user string // the ID and name of the plugin being debugged, user requesting
-	verbose            bool   // do we want feedback for every message the user types?
+	taskID, name, user string    // the ID and name of the plugin being debugged, user requesting
+	verbose            bool      // do we want feedback for every message the user types?
+	expires            time.Time // when debugging stops
+}
+
+// debugSubscription is a user's debugging of a task, remembered in the
+// brain, keyed by user
+type debugSubscription struct {
+	Task    string
+	Verbose bool
+	Expires time.Time
 }
 
 var taskDebug = struct {
@@ -48,6 +63,15 @@ func (c *botContext) debugTask(task *BotTask, msg string, verboseonly bool) {
 		taskID = ""
 	} else {
 		taskID = task.taskID
+		// A task with a LogLevel of debug (or trace, for verboseonly) also
+		// logs it's debugging output.
+		level := Debug
+		if verboseonly {
+			level = Trace
+		}
+		if tl, ok := task.logLevel(); ok && tl <= level {
+			writeLog(level, fmt.Sprintf("Debugging task '%s': %s", task.name, msg))
+		}
 	}
 	if len(taskID) == 0 && len(c.User) == 0 {
 		return
@@ -107,10 +131,130 @@ func (c *botContext) debugTask(task *BotTask, msg string, verboseonly bool) {
 	}
 	ts := time.Now().Format("2006/01/02 03:04:05")
 	debugLog := fmt.Sprintf("%s DEBUG %s: %s", ts, plugName, msg)
-	// Since Format isn't set right away, we always debug with the configured default
-	r := c.makeRobot()
+	sendDebugMessage(targetUser, debugLog)
+}
+
+// sendDebugMessage sends a debugging message to a user. It's called while
+// messages are dispatched, before there's a Robot to send with; since Format
+// isn't set right away, we always debug with the configured default.
+func sendDebugMessage(user, msg string) {
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	conn, _, user := userConnector(maps, "", user)
 	botCfg.RLock()
-	r.Format = botCfg.defaultMessageFormat
+	format := botCfg.defaultMessageFormat
 	botCfg.RUnlock()
-	r.SendUserMessage(targetUser, debugLog)
+	sendProtocolUserMessage(conn, user, msg, format, MessageOptions{})
+}
+
+// startDebugging starts debugging a task for a user, replacing any
+// debugging they already had.
+func startDebugging(user, name string, verbose bool, window time.Duration) *debuggingTask {
+	pd := &debuggingTask{
+		taskID:  getTaskID(name),
+		name:    name,
+		user:    user,
+		verbose: verbose,
+		expires: time.Now().Add(window),
+	}
+	taskDebug.Lock()
+	if old, ok := taskDebug.u[user]; ok {
+		delete(taskDebug.p, old.taskID)
+	}
+	taskDebug.p[pd.taskID] = pd
+	taskDebug.u[user] = pd
+	taskDebug.Unlock()
+	time.AfterFunc(window, func() { expireDebugging(pd) })
+	saveDebugging(user, &debugSubscription{name, verbose, pd.expires})
+	return pd
+}
+
+// stopDebugging stops any debugging for a user, returning what they were
+// debugging, or nil.
+func stopDebugging(user string) *debuggingTask {
+	taskDebug.Lock()
+	pd, ok := taskDebug.u[user]
+	if ok {
+		delete(taskDebug.p, pd.taskID)
+		delete(taskDebug.u, user)
+	}
+	taskDebug.Unlock()
+	if ok {
+		saveDebugging(user, nil)
+	}
+	return pd
+}
+
+// expireDebugging stops debugging when it's time runs out, unless the user
+// has since stopped or started debugging again.
+func expireDebugging(pd *debuggingTask) {
+	taskDebug.RLock()
+	current := taskDebug.u[pd.user]
+	taskDebug.RUnlock()
+	if current != pd {
+		return
+	}
+	stopDebugging(pd.user)
+	Log(Debug, fmt.Sprintf("Debugging of task '%s' for user '%s' expired", pd.name, pd.user))
+	sendDebugMessage(pd.user, fmt.Sprintf("Debugging of task '%s' has expired", pd.name))
+}
+
+// saveDebugging remembers or, with a nil subscription, forgets a user's
+// debugging in the brain.
+func saveDebugging(user string, sub *debugSubscription) {
+	subs := make(map[string]debugSubscription)
+	tok, _, ret := checkoutDatum(debuggingKey, &subs, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', debugging for '%s' won't be remembered: %s", debuggingKey, user, ret))
+		return
+	}
+	if sub == nil {
+		delete(subs, user)
+	} else {
+		subs[user] = *sub
+	}
+	if ret := updateDatum(debuggingKey, tok, subs); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s' for user '%s': %s", debuggingKey, user, ret))
+	}
+}
+
+// restoreDebugging restarts debugging remembered in the brain that hasn't
+// expired; called from run() at startup.
+func restoreDebugging() {
+	subs := make(map[string]debugSubscription)
+	tok, _, ret := checkoutDatum(debuggingKey, &subs, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', debugging won't be restored: %s", debuggingKey, ret))
+		return
+	}
+	now := time.Now()
+	expired := 0
+	taskDebug.Lock()
+	for user, sub := range subs {
+		if !sub.Expires.After(now) {
+			delete(subs, user)
+			expired++
+			continue
+		}
+		pd := &debuggingTask{
+			taskID:  getTaskID(sub.Task),
+			name:    sub.Task,
+			user:    user,
+			verbose: sub.Verbose,
+			expires: sub.Expires,
+		}
+		taskDebug.p[pd.taskID] = pd
+		taskDebug.u[user] = pd
+		time.AfterFunc(sub.Expires.Sub(now), func() { expireDebugging(pd) })
+		Log(Info, fmt.Sprintf("Restored debugging of task '%s' for user '%s' until %s", sub.Task, user, sub.Expires.Format(time.RFC1123)))
+	}
+	taskDebug.Unlock()
+	if expired == 0 {
+		checkinDatum(debuggingKey, tok)
+		return
+	}
+	if ret := updateDatum(debuggingKey, tok, subs); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s': %s", debuggingKey, ret))
+	}
 }
@@ -3,18 +3,25 @@ package bot
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 const keepListeningDuration = 77 * time.Second
 
+// Generous defaults for MaxArgs and MaxArgLength
+const defaultMaxArgs = 64
+const defaultMaxArgLength = 65536
+
 var spaceRe = regexp.MustCompile(" +")
 
 // checkPluginMatchersAndRun checks either command matchers (for messages directed at
 // the robot), or message matchers (for ambient commands that need not be
-// directed at the robot), and calls the plugin if it matches. Note: this
-// function is called under a read lock on the 'b' struct.
+// directed at the robot), and calls the plugin if it matches. Every plugin
+// with a matching MessageMatcher sees an ambient message, unless one calls
+// ConsumeMessage; see runAmbient. Note: this function is called under a read
+// lock on the 'b' struct.
 func (c *botContext) checkPluginMatchersAndRun(pipelineType pipelineType) (messageMatched bool) {
 	r := c.makeRobot()
 	// un-needed, but more clear
@@ -28,6 +35,8 @@ func (c *botContext) checkPluginMatchersAndRun(pipelineType pipelineType) (messa
 	var runTask interface{}
 	var matchedMatcher InputMatcher
 	var cmdArgs []string
+	var ambient []pluginMatch
+	var tiedWith string // another plugin matching with the same Priority as runTask
 	for _, t := range c.tasks.t {
 		task, plugin, _ := getTask(t)
 		if plugin == nil {
@@ -68,25 +77,63 @@ func (c *botContext) checkPluginMatchersAndRun(pipelineType pipelineType) (messa
 			}
 			matchers = plugin.MessageMatchers
 			ctype = "message"
+		case plugAction:
+			if len(plugin.ActionMatchers) == 0 {
+				continue
+			}
+			matchers = plugin.ActionMatchers
+			ctype = "action"
 		}
 		Log(Trace, fmt.Sprintf("Task '%s' is active, will check for matches", task.name))
 		cmsg := spaceRe.ReplaceAllString(c.msg, " ")
+		if pipelineType == plugAction {
+			cmsg = c.action.ActionID
+		}
+		if pipelineType == plugCommand {
+			if resolved := plugin.resolveAlias(cmsg); resolved != cmsg {
+				c.debugT(t, fmt.Sprintf("Resolved alias in '%s' to '%s'", cmsg, resolved), false)
+				cmsg = resolved
+			}
+		}
 		c.debugT(t, fmt.Sprintf("Checking %d %s matchers against message: '%s'", len(matchers), ctype, cmsg), verboseOnly)
 		for _, matcher := range matchers {
 			Log(Trace, fmt.Sprintf("Checking '%s' against '%s'", cmsg, matcher.Regex))
 			matches := matcher.re.FindAllStringSubmatch(cmsg, -1)
 			matched := false
+			if matches != nil && messageMatched && pipelineType != plugMessage {
+				// More than one plugin matched; the higher Priority wins
+				prevTask, prevPlugin, _ := getTask(runTask)
+				if prevPlugin.Priority == plugin.Priority {
+					tiedWith = task.name
+					break
+				}
+				if prevPlugin.Priority > plugin.Priority {
+					c.debugT(t, fmt.Sprintf("Matched %s regex '%s', but plugin '%s' has higher Priority", ctype, matcher.Regex, prevTask.name), false)
+					Log(Debug, fmt.Sprintf("Message '%s' matched tasks %s and %s; running %s with higher Priority %d", cmsg, prevTask.name, task.name, prevTask.name, prevPlugin.Priority))
+					break
+				}
+				Log(Debug, fmt.Sprintf("Message '%s' matched tasks %s and %s; running %s with higher Priority %d", cmsg, prevTask.name, task.name, task.name, plugin.Priority))
+				tiedWith = ""
+			}
 			if matches != nil {
 				c.debugT(t, fmt.Sprintf("Matched %s regex '%s', command: %s", ctype, matcher.Regex, matcher.Command), false)
 				matched = true
 				Log(Trace, fmt.Sprintf("Message '%s' matches command '%s'", cmsg, matcher.Command))
 				cmdArgs = matches[0][1:]
+				if pipelineType == plugAction {
+					// The button's value is the last argument
+					cmdArgs = append(cmdArgs, c.action.Value)
+				}
 				if len(matcher.Contexts) > 0 {
 					// Resolve & store "it" with short-term memories
 					ts := time.Now()
 					shortTermMemories.Lock()
 					for i, contextLabel := range matcher.Contexts {
 						if contextLabel != "" {
+							// with named groups, a context applies to the group of the same name
+							if matcher.groups != nil {
+								i = matcher.groups[strings.Split(contextLabel, ":")[0]]
+							}
 							if len(cmdArgs) > i {
 								ctxargs := strings.Split(contextLabel, ":")
 								contextName := ctxargs[0]
@@ -133,61 +180,151 @@ func (c *botContext) checkPluginMatchersAndRun(pipelineType pipelineType) (messa
 				c.debugT(t, fmt.Sprintf("Not matched: %s", matcher.Regex), verboseOnly)
 			}
 			if matched {
-				if messageMatched {
-					prevTask, _, _ := getTask(runTask)
-					Log(Error, fmt.Sprintf("Message '%s' matched multiple tasks: %s and %s", cmsg, prevTask.name, task.name))
-					r.Say("Yikes! Your command matched multiple plugins, so I'm not doing ANYTHING")
-					emit(MultipleMatchesNoAction)
-					return
-				}
 				messageMatched = true
+				if pipelineType == plugMessage {
+					ambient = append(ambient, pluginMatch{t, matcher, cmdArgs})
+					break
+				}
 				runTask = t
 				matchedMatcher = matcher
 				break
 			}
 		} // end of matcher checking
 	} // end of plugin checking
-	if messageMatched {
+	if !messageMatched {
+		return
+	}
+	if len(tiedWith) > 0 {
 		task, _, _ := getTask(runTask)
-		c.messageHeard()
-		matcher := matchedMatcher
-		abort := false
-		if task.name == "builtin-admin" && matcher.Command == "abort" {
-			abort = true
-		}
-		botCfg.RLock()
-		if botCfg.shuttingDown && !abort {
-			r.Say("Sorry, I'm shutting down and can't start any new tasks")
-			botCfg.RUnlock()
-			return
-		} else if botCfg.paused && !abort {
-			r.Say("Sorry, I've been paused and can't start any new tasks")
-			botCfg.RUnlock()
-			return
-		}
+		Log(Error, fmt.Sprintf("Message '%s' matched multiple tasks: %s and %s", spaceRe.ReplaceAllString(c.msg, " "), task.name, tiedWith))
+		r.Say("Yikes! Your command matched multiple plugins, so I'm not doing ANYTHING")
+		emit(MultipleMatchesNoAction)
+		return
+	}
+	c.messageHeard()
+	if pipelineType == plugMessage {
+		c.runAmbient(ambient)
+		return
+	}
+	c.runMatch(runTask, matchedMatcher, cmdArgs, pipelineType)
+	return
+}
+
+// runMatch starts the pipeline for a plugin whose matcher matched the
+// message, unless the robot is shutting down or the command is limited.
+func (c *botContext) runMatch(runTask interface{}, matcher InputMatcher, cmdArgs []string, pipelineType pipelineType) {
+	r := c.makeRobot()
+	task, plugin, _ := getTask(runTask)
+	abort := false
+	if task.name == "builtin-admin" && matcher.Command == "abort" {
+		abort = true
+	}
+	// a second quit cancels the plugins shutdown is waiting on
+	quit := task.name == "builtin-admin" && matcher.Command == "quit"
+	botCfg.RLock()
+	if botCfg.shuttingDown && !abort && !quit {
+		r.Say("Sorry, I'm shutting down and can't start any new tasks")
 		botCfg.RUnlock()
-		// Check to see if user issued a new command when a reply was being
-		// waited on
-		replyMatcher := replyMatcher{c.User, c.Channel}
-		replies.Lock()
-		waiters, waitingForReply := replies.m[replyMatcher]
-		if waitingForReply {
-			delete(replies.m, replyMatcher)
-			replies.Unlock()
-			for i, rep := range waiters {
-				if i == 0 {
-					rep.replyChannel <- reply{false, replyInterrupted, ""}
-				} else {
-					rep.replyChannel <- reply{false, retryPrompt, ""}
-				}
+		return
+	} else if botCfg.paused && !abort {
+		r.Say("Sorry, I've been paused and can't start any new tasks")
+		botCfg.RUnlock()
+		return
+	}
+	botCfg.RUnlock()
+	if !c.argsWithinLimits(runTask, cmdArgs) {
+		return
+	}
+	if pipelineType != plugMessage && !c.checkRateLimit(plugin, matcher.Command) {
+		return
+	}
+	releaseSingleton, ok := c.claimSingleton(plugin, matcher.Command, cmdArgs)
+	if !ok {
+		return
+	}
+	defer releaseSingleton()
+	slot, ok := c.acquirePluginSlot(plugin)
+	if !ok {
+		return
+	}
+	if slot != nil {
+		defer slot.release()
+	}
+	// Check to see if user issued a new command when a reply was being
+	// waited on
+	replyMatcher := replyMatcher{c.User, c.Channel, c.thread}
+	replies.Lock()
+	waiters, waitingForReply := replies.m[replyMatcher]
+	if waitingForReply {
+		delete(replies.m, replyMatcher)
+		replies.Unlock()
+		for i, rep := range waiters {
+			if i == 0 {
+				rep.replyChannel <- reply{false, replyInterrupted, ""}
+			} else {
+				rep.replyChannel <- reply{false, retryPrompt, ""}
 			}
-			Log(Debug, fmt.Sprintf("User '%s' matched a new command while the robot was waiting for a reply in channel '%s'", c.User, c.Channel))
-		} else {
-			replies.Unlock()
 		}
-		c.startPipeline(nil, runTask, pipelineType, matcher.Command, cmdArgs...)
+		Log(Debug, fmt.Sprintf("User '%s' matched a new command while the robot was waiting for a reply in channel '%s'", c.User, c.Channel))
+	} else {
+		replies.Unlock()
 	}
-	return
+	c.setGroupParameters(matcher.groups, cmdArgs)
+	c.dispatchRet = c.startPipeline(nil, runTask, pipelineType, matcher.Command, cmdArgs...)
+	c.dispatched = true
+}
+
+// argsWithinLimits checks the number and total length of arguments for a
+// plugin command against MaxArgs and MaxArgLength, telling the user when
+// they're exceeded; the plugin's own settings take precedence.
+func (c *botContext) argsWithinLimits(t interface{}, args []string) bool {
+	task, plugin, _ := getTask(t)
+	botCfg.RLock()
+	maxArgs := botCfg.maxArgs
+	maxLength := botCfg.maxArgLength
+	botCfg.RUnlock()
+	if plugin.MaxArgs > 0 {
+		maxArgs = plugin.MaxArgs
+	}
+	if plugin.MaxArgLength > 0 {
+		maxLength = plugin.MaxArgLength
+	}
+	length := 0
+	for _, arg := range args {
+		length += len(arg)
+	}
+	r := c.makeRobot()
+	if maxArgs > 0 && len(args) > maxArgs {
+		Log(Warn, fmt.Sprintf("Rejecting command for plugin '%s' from user '%s' in channel '%s': %d arguments exceeds maximum of %d", task.name, c.User, c.Channel, len(args), maxArgs))
+		r.Reply(fmt.Sprintf("Sorry, that command has too many arguments (%d, the maximum is %d)", len(args), maxArgs))
+		return false
+	}
+	if maxLength > 0 && length > maxLength {
+		Log(Warn, fmt.Sprintf("Rejecting command for plugin '%s' from user '%s' in channel '%s': argument length %d exceeds maximum of %d", task.name, c.User, c.Channel, length, maxLength))
+		r.Reply(fmt.Sprintf("Sorry, the arguments for that command are too long (%d characters, the maximum is %d)", length, maxLength))
+		return false
+	}
+	return true
+}
+
+// catchAlls returns the enabled CatchAll plugins in the order they're
+// called: highest Priority first, ties by plugin name.
+func (c *botContext) catchAlls() []interface{} {
+	var catchAllPlugins []interface{}
+	for _, t := range c.tasks.t {
+		if plugin, ok := t.(*BotPlugin); ok && plugin.CatchAll && !plugin.Disabled {
+			catchAllPlugins = append(catchAllPlugins, t)
+		}
+	}
+	sort.SliceStable(catchAllPlugins, func(i, j int) bool {
+		_, pi, _ := getTask(catchAllPlugins[i])
+		_, pj, _ := getTask(catchAllPlugins[j])
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		return pi.name < pj.name
+	})
+	return catchAllPlugins
 }
 
 // handleMessage checks the message against plugin commands and full-message
@@ -199,10 +336,19 @@ func (c *botContext) handleMessage() {
 	r := c.makeRobot()
 	defer checkPanic(r, c.msg)
 
+	// Commands are paced when a global CommandRate is configured
+	if c.isCommand && !c.shapeCommand() {
+		return
+	}
+
 	if c.directMsg {
 		emit(BotDirectMessage)
 		Log(Trace, fmt.Sprintf("Bot received a direct message from %s: %s", c.User, c.msg))
 	}
+	// Button clicks check ActionMatchers first; see handleAction
+	if c.action != nil && c.handleAction() {
+		return
+	}
 	messageMatched := false
 	ts := time.Now()
 	lastMsgContext := memoryContext{"lastMsg", c.User, c.Channel}
@@ -225,12 +371,15 @@ func (c *botContext) handleMessage() {
 	if !messageMatched && c.isCommand {
 		// See if a command matches (and runs)
 		messageMatched = c.checkPluginMatchersAndRun(plugCommand)
+		if messageMatched && c.threadEngaged {
+			extendThreadEngagement(c.Channel, c.thread)
+		}
 	}
 	// See if the robot was waiting on a reply
 	var waiters []replyWaiter
 	waitingForReply := false
 	if !messageMatched {
-		matcher := replyMatcher{c.User, c.Channel}
+		matcher := replyMatcher{c.User, c.Channel, c.thread}
 		Log(Trace, fmt.Sprintf("Checking replies for matcher: %q", matcher))
 		replies.Lock()
 		waiters, waitingForReply = replies.m[matcher]
@@ -266,30 +415,43 @@ func (c *botContext) handleMessage() {
 	if !messageMatched {
 		messageMatched = c.checkJobMatchersAndRun()
 	}
-	if c.isCommand && !messageMatched && !c.BotUser { // the robot was spoken to, but nothing matched - call catchAlls
+	// In an engaged thread the robot wasn't necessarily spoken to, so
+	// unmatched messages are just ignored
+	if c.isCommand && !messageMatched && !c.BotUser && !c.threadEngaged { // the robot was spoken to, but nothing matched - call catchAlls
 		botCfg.RLock()
 		if !botCfg.shuttingDown {
 			botCfg.RUnlock()
 			c.messageHeard()
-			Log(Debug, fmt.Sprintf("Unmatched command sent to robot, calling catchalls: %s", c.msg))
-			emit(CatchAllsRan) // for testing, otherwise noop
-			// TODO: should we allow more than 1 catchall?
-			catchAllPlugins := make([]interface{}, 0, 0)
-			for _, t := range c.tasks.t {
-				if plugin, ok := t.(*BotPlugin); ok && plugin.CatchAll {
-					catchAllPlugins = append(catchAllPlugins, t)
-				}
-			}
-			if len(catchAllPlugins) > 1 {
-				Log(Error, "More than one catch all registered, none will be called")
-			} else {
-				// Note: if the catchall plugin has configured security, it
-				// should still apply.
-				if len(catchAllPlugins) != 0 {
-					c.startPipeline(nil, catchAllPlugins[0], catchAll, "catchall", spaceRe.ReplaceAllString(c.msg, " "))
-				} else {
+			// A close match to a command gets a suggestion instead of the catchall
+			if !c.suggestCommand() {
+				Log(Debug, fmt.Sprintf("Unmatched command sent to robot, calling catchalls: %s", c.msg))
+				emit(CatchAllsRan) // for testing, otherwise noop
+				catchAllPlugins := c.catchAlls()
+				if len(catchAllPlugins) == 0 {
 					Log(Debug, "Unmatched command to robot and no catchall defined")
 				}
+				cmsg := spaceRe.ReplaceAllString(c.msg, " ")
+				for i, t := range catchAllPlugins {
+					// Note: if the catchall plugin has configured security, it
+					// should still apply.
+					task, _, _ := getTask(t)
+					if !c.pluginAvailable(task, false, true) {
+						continue
+					}
+					if !c.argsWithinLimits(t, []string{cmsg}) {
+						break
+					}
+					cc := c
+					if i > 0 {
+						cc = c.clone()
+					}
+					// A catchall that exits Normal or calls ConsumeMessage
+					// consumes the message; Fail passes it on
+					if cc.startPipeline(nil, t, catchAll, "catchall", cmsg) != Fail || cc.messageConsumed() {
+						break
+					}
+					Log(Debug, fmt.Sprintf("Catchall plugin '%s' didn't handle the message, trying the next catchall", task.name))
+				}
 			}
 		} else {
 			// If the robot is shutting down, just ignore catch-all plugins
@@ -299,7 +461,7 @@ func (c *botContext) handleMessage() {
 	if c.BotUser {
 		return
 	}
-	if messageMatched || c.isCommand {
+	if messageMatched || (c.isCommand && !c.threadEngaged) {
 		shortTermMemories.Lock()
 		delete(shortTermMemories.m, lastMsgContext)
 		shortTermMemories.Unlock()
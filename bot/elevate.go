@@ -1,10 +1,22 @@
 package bot
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 const technicalElevError = "Sorry, elevation failed due to a problem with the elevation service"
 const configElevError = "Sorry, elevation failed due to a configuration error"
 
+// Successful elevations are remembered in the brain, keyed by user and
+// elevator, so elevators can tell if a prior elevation still counts.
+const elevationsKey = "bot:elevations"
+
+// defaultElevateTimeout is how long a successful elevation counts when
+// ElevateTimeout isn't configured
+const defaultElevateTimeout = 2 * time.Hour
+
 // Elevator plugins provide an elevate method for checking if the user
 // can run a privileged command.
 
@@ -29,7 +41,9 @@ func (c *botContext) elevate(task *BotTask, immediate bool) (retval TaskRetVal)
 		if !immediate {
 			immedString = "false"
 		}
+		c.elevateImmediate = immediate
 		_, elevRet := c.callTask(ePlug, "elevate", immedString)
+		c.elevateImmediate = false
 		if elevRet == Success {
 			Log(Audit, fmt.Sprintf("Elevation succeeded by elevator '%s', user '%s', task '%s' in channel '%s'", ePlug.name, c.User, task.name, c.Channel))
 			emit(ElevRanSuccess)
@@ -64,12 +78,11 @@ func (c *botContext) elevate(task *BotTask, immediate bool) (retval TaskRetVal)
 	return ConfigurationError
 }
 
-// Check for a configured Elevator and check elevation
-func (c *botContext) checkElevation(t interface{}, command string) (retval TaskRetVal, required bool) {
+// elevationRequired reports whether a task / command requires elevation, and
+// whether it's immediate
+func elevationRequired(t interface{}, command string) (elevationRequired, immediate bool) {
 	task, plugin, _ := getTask(t)
 	isPlugin := plugin != nil
-	immediate := false
-	elevationRequired := false
 	if isPlugin && len(plugin.ElevateImmediateCommands) > 0 {
 		for _, i := range plugin.ElevateImmediateCommands {
 			if command == i {
@@ -92,7 +105,14 @@ func (c *botContext) checkElevation(t interface{}, command string) (retval TaskR
 			elevationRequired = true
 		}
 	}
-	if !elevationRequired {
+	return
+}
+
+// Check for a configured Elevator and check elevation
+func (c *botContext) checkElevation(t interface{}, command string) (retval TaskRetVal, required bool) {
+	task, _, _ := getTask(t)
+	needed, immediate := elevationRequired(t, command)
+	if !needed {
 		return Success, false
 	}
 	retval = c.elevate(task, immediate)
@@ -102,3 +122,96 @@ func (c *botContext) checkElevation(t interface{}, command string) (retval TaskR
 	Log(Error, fmt.Sprintf("Elevation failed for task '%s', command: '%s'", task.name, command))
 	return Fail, true
 }
+
+// elevateTimeout is how long a successful elevation with the elevator
+// counts; the elevator's ElevateTimeout, or the robot's.
+func elevateTimeout(elevator *BotPlugin) time.Duration {
+	if elevator != nil && len(elevator.ElevateTimeout) > 0 {
+		if timeout, err := time.ParseDuration(elevator.ElevateTimeout); err == nil {
+			return timeout
+		}
+	}
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	return botCfg.elevateTimeout
+}
+
+// elevationKey identifies a user's elevations with an elevator
+func elevationKey(user, elevator string) string {
+	return user + ":" + elevator
+}
+
+// windowString formats an elevation window for users, e.g. "4m"
+func windowString(d time.Duration) string {
+	if d >= time.Minute {
+		d = d.Round(time.Minute)
+	} else {
+		d = d.Round(time.Second)
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// ElevationWindow is for elevator plugins; it returns how much longer the
+// user's last successful elevation with the elevator counts, according to
+// ElevateTimeout. Zero means the user needs to elevate, and it's always zero
+// for ElevateImmediateCommands.
+func (r *Robot) ElevationWindow() time.Duration {
+	c := r.getContext()
+	if c.elevateImmediate {
+		return 0
+	}
+	task, plugin, _ := getTask(c.currentTask)
+	timeout := elevateTimeout(plugin)
+	if timeout == 0 {
+		return 0
+	}
+	elevations := make(map[string]time.Time)
+	_, _, ret := checkoutDatum(elevationsKey, &elevations, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', user '%s' will need to elevate: %s", elevationsKey, r.User, ret))
+		return 0
+	}
+	last, ok := elevations[elevationKey(r.User, task.name)]
+	if !ok {
+		return 0
+	}
+	if remaining := timeout - time.Since(last); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// ElevationSucceeded is for elevator plugins, recording a successful
+// elevation for the user that counts for ElevateTimeout. When prompted is
+// true, the user is told how long the elevation is valid. Elevators with an
+// idle timeout also call it with prompted false when ElevationWindow was
+// non-zero, to restart the window.
+func (r *Robot) ElevationSucceeded(prompted bool) {
+	c := r.getContext()
+	task, plugin, _ := getTask(c.currentTask)
+	timeout := elevateTimeout(plugin)
+	if timeout == 0 {
+		return
+	}
+	elevations := make(map[string]time.Time)
+	tok, _, ret := checkoutDatum(elevationsKey, &elevations, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', not remembering elevation for user '%s': %s", elevationsKey, r.User, ret))
+		return
+	}
+	elevations[elevationKey(r.User, task.name)] = time.Now()
+	if ret := updateDatum(elevationsKey, tok, elevations); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', not remembering elevation for user '%s': %s", elevationsKey, r.User, ret))
+		return
+	}
+	if prompted {
+		r.Say(fmt.Sprintf("Elevation valid for %s", windowString(timeout)))
+	}
+}
@@ -3,7 +3,10 @@ package bot
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"mime"
 	"net/smtp"
+	"path/filepath"
 	"strings"
 
 	"github.com/jordan-wright/email"
@@ -16,6 +19,13 @@ type botMailer struct {
 	Password string // optional password for authenticated email
 }
 
+// EmailAttachment is a file to attach to an email sent with SendEmail; as
+// with SendFile, the content is read from an io.Reader.
+type EmailAttachment struct {
+	Name    string
+	Content io.Reader
+}
+
 // Email provides a simple interface for sending the user an email from the
 // bot. It relies on both the robot and the user having an email address.
 // For the robot, this can be conifigured in gopherbot.conf, Email attribute.
@@ -44,9 +54,38 @@ func (r *Robot) EmailAddress(address, subject string, messageBody *bytes.Buffer,
 	return r.realEmail(subject, address, messageBody, html...)
 }
 
+// SendEmail sends a plain text email through the robot's MailConfig, with
+// optional attachments, so plugins don't need their own SMTP credentials. to
+// can be a user known to the robot, or an email address. Returns
+// NoMailConfig when the robot has no MailConfig, NoUserEmail when the user
+// has no email address, and MailError if sending fails.
+func (r *Robot) SendEmail(to, subject, body string, attachments ...EmailAttachment) (ret RetVal) {
+	mailTo := to
+	if !strings.Contains(to, "@") {
+		mailAttr := r.GetUserAttribute(to, "email")
+		if mailAttr.RetVal != Ok {
+			return NoUserEmail
+		}
+		mailTo = mailAttr.Attribute
+	}
+	return r.sendMail(subject, mailTo, []byte(body), false, attachments)
+}
+
 func (r *Robot) realEmail(subject, mailTo string, messageBody *bytes.Buffer, html ...bool) (ret RetVal) {
+	return r.sendMail(subject, mailTo, messageBody.Bytes(), len(html) > 0 && html[0], nil)
+}
+
+func (r *Robot) sendMail(subject, mailTo string, messageBody []byte, html bool, attachments []EmailAttachment) (ret RetVal) {
 	var mailFrom, botName string
 
+	botCfg.RLock()
+	mailConf := botCfg.mailConf
+	botCfg.RUnlock()
+	if len(mailConf.Mailhost) == 0 {
+		Log(Error, "Email send requested but robot has no MailConfig")
+		return NoMailConfig
+	}
+
 	mailAttr := r.GetBotAttribute("email")
 	if mailAttr.RetVal != Ok || mailAttr.Attribute == "" {
 		Log(Error, "Email send requested but robot has no Email set in config")
@@ -66,32 +105,38 @@ func (r *Robot) realEmail(subject, mailTo string, messageBody *bytes.Buffer, htm
 	e.From = from
 	e.To = []string{mailTo}
 	e.Subject = subject
-	if len(html) > 0 && html[0] {
-		e.HTML = messageBody.Bytes()
+	if html {
+		e.HTML = messageBody
 	} else {
-		e.Text = messageBody.Bytes()
+		e.Text = messageBody
+	}
+	for _, a := range attachments {
+		if _, err := e.Attach(a.Content, a.Name, mime.TypeByExtension(filepath.Ext(a.Name))); err != nil {
+			Log(Error, fmt.Sprintf("Reading email attachment '%s': %v", a.Name, err))
+			return MailError
+		}
 	}
 
 	var a smtp.Auth
-	if botCfg.mailConf.Authtype == "plain" {
-		host := strings.Split(botCfg.mailConf.Mailhost, ":")[0]
-		a = smtp.PlainAuth("", botCfg.mailConf.User, botCfg.mailConf.Password, host)
+	if mailConf.Authtype == "plain" {
+		host := strings.Split(mailConf.Mailhost, ":")[0]
+		a = smtp.PlainAuth("", mailConf.User, mailConf.Password, host)
 		Log(Debug, fmt.Sprintf("Sending authenticated email to \"%s\" from \"%s\" via \"%s\" with user: %s, password: xxxx, and host: %s",
 			mailTo,
 			from,
-			botCfg.mailConf.Mailhost,
-			botCfg.mailConf.User,
+			mailConf.Mailhost,
+			mailConf.User,
 			host,
 		))
 	} else {
 		Log(Debug, fmt.Sprintf("Sending unauthenticated email to \"%s\" from \"%s\" via \"%s\"",
 			mailTo,
 			from,
-			botCfg.mailConf.Mailhost,
+			mailConf.Mailhost,
 		))
 	}
 
-	err := e.Send(botCfg.mailConf.Mailhost, a)
+	err := e.Send(mailConf.Mailhost, a)
 	if err != nil {
 		err = fmt.Errorf("Sending email: %v", err)
 		Log(Error, err)
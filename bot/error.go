@@ -25,6 +25,10 @@ const (
 	ConfigurationError
 	// Failed Exclusive w/o queueTask
 	PipelineAborted
+	// TaskTimedOut indicates an external task was killed for running past it's Timeout
+	TaskTimedOut
+	// TaskCancelled indicates an external task was killed when it's pipeline was cancelled, e.g. on shutdown
+	TaskCancelled
 	// Success indicates successful authorization or elevation; using '7' (three bits set)
 	// reduces the likelihood of an authorization plugin mistakenly exiting with a success
 	// value
@@ -108,4 +112,36 @@ const (
 	CommandNotMatched
 	// TaskDisabled - a method call attempted to add a disabled task to a pipeline
 	TaskDisabled
+
+	/* More connector issues */
+
+	// FailedChannelCreate - the connector couldn't create a channel
+	FailedChannelCreate
+	// FileSendNotSupported - the connector can't upload files
+	FileSendNotSupported
+	// FailedFileSend - the connector failed to upload a file
+	FailedFileSend
+	// FailedReaction - the connector couldn't add or remove a reaction, or
+	// there was no message to react to
+	FailedReaction
+	// MessageEditNotSupported - the connector can't update or delete sent messages
+	MessageEditNotSupported
+	// FailedMessageEdit - the connector couldn't update or delete a message
+	FailedMessageEdit
+	// FailedArtifactSave - a job artifact couldn't be stored
+	FailedArtifactSave
+	// DatumDecryptFailed - an encrypted memory couldn't be decrypted; wrong EncryptionKey, or corrupted data
+	DatumDecryptFailed
+	// ListNotSupported - the configured brain can't enumerate memories
+	ListNotSupported
+	// ReactionsNotSupported - the connector doesn't deliver users' reactions
+	ReactionsNotSupported
+	// ConversationInProgress - the user is already in a conversation with the robot in the channel
+	ConversationInProgress
+	// FailedMessageSend - the connector couldn't post a message
+	FailedMessageSend
+	// NoMailConfig - the robot has no MailConfig for sending email
+	NoMailConfig
+	// DatumChanged - a memory was changed by somebody else after it was checked out
+	DatumChanged
 )
@@ -49,4 +49,8 @@ const (
 	ExternalTaskRan
 	ExternalTaskStderrOutput
 	ExternalTaskErrExit
+	ExternalTaskTimedOut
+	ExternalTaskCancelled
+	ActionTaskRan
+	AuthCacheHit
 )
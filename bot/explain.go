@@ -0,0 +1,200 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* explain.go - the explain builtin, for answering "why didn't the robot
+   respond?". The message text is run through addressing detection, plugin
+   availability and the plugin matchers the same way as an incoming message,
+   but nothing is executed; the result is a report of what happened at each
+   step.
+*/
+
+func init() {
+	RegisterPlugin("builtin-explain", PluginHandler{Handler: explain})
+}
+
+// explainMatch reports how a message would be dispatched for the user and
+// channel in the context, in the same order as handleMessage.
+func (c *botContext) explainMatch(text string) []string {
+	report := make([]string, 0)
+	where := "channel '" + c.Channel + "'"
+	if c.directMsg {
+		where = "a direct message"
+	}
+	report = append(report, fmt.Sprintf("Explaining message from user '%s' in %s: %s", c.User, where, text))
+	msg, isCommand := checkAddressed(text, c.Channel)
+	if c.directMsg {
+		isCommand = true
+	}
+	if isCommand {
+		report = append(report, fmt.Sprintf("Addressed to the robot: yes; command text: '%s'", msg))
+	} else {
+		report = append(report, "Addressed to the robot: no; only ambient message matchers will be checked")
+	}
+	if isCommand && len(msg) == 0 {
+		report = append(report, "Result: the message is only the robot's name, so the robot would reply 'Yes?' (or re-check your last message)")
+		return report
+	}
+	cmsg := spaceRe.ReplaceAllString(msg, " ")
+	var commandMatches, messageMatches []explainedMatch
+	for _, t := range c.tasks.t {
+		task, plugin, _ := getTask(t)
+		if plugin == nil {
+			continue
+		}
+		if task.Disabled {
+			report = append(report, fmt.Sprintf("Plugin '%s': skipped - disabled: %s", task.name, task.reason))
+			continue
+		}
+		if available, reason := c.taskAvailability(task, false); !available {
+			report = append(report, fmt.Sprintf("Plugin '%s': skipped - %s", task.name, reason))
+			continue
+		}
+		var results []string
+		if isCommand && len(plugin.CommandMatchers) > 0 {
+			pmsg := plugin.resolveAlias(cmsg)
+			if pmsg != cmsg {
+				results = append(results, fmt.Sprintf("resolved alias to '%s'", pmsg))
+			}
+			matched := matchCommands(plugin.CommandMatchers, pmsg)
+			results = append(results, fmt.Sprintf("tried %d command matchers, matched: %s", len(plugin.CommandMatchers), matchList(matched)))
+			if len(matched) > 0 {
+				commandMatches = append(commandMatches, explainedMatch{task.name, matched[0], plugin.Priority})
+			}
+		}
+		if len(plugin.MessageMatchers) > 0 {
+			if !c.listedUser && !plugin.MatchUnlisted && !isCommand {
+				results = append(results, "message matchers skipped for unlisted user (MatchUnlisted is false)")
+			} else {
+				matched := matchCommands(plugin.MessageMatchers, cmsg)
+				results = append(results, fmt.Sprintf("tried %d message matchers, matched: %s", len(plugin.MessageMatchers), matchList(matched)))
+				if len(matched) > 0 {
+					messageMatches = append(messageMatches, explainedMatch{task.name, matched[0], plugin.Priority})
+				}
+			}
+		}
+		if len(results) == 0 {
+			// nothing to check for this kind of message
+			continue
+		}
+		report = append(report, fmt.Sprintf("Plugin '%s': %s", task.name, strings.Join(results, "; ")))
+	}
+	winners, outranked := byPriority(commandMatches)
+	switch {
+	case len(winners) > 1:
+		report = append(report, fmt.Sprintf("Result: matched multiple plugins with Priority %d, so nothing would run: %s", winners[0].priority, describeMatches(winners)))
+	case len(winners) == 1 && len(outranked) > 0:
+		report = append(report, fmt.Sprintf("Result: would run %s, which has a higher Priority (%d) than %s", winners[0], winners[0].priority, describeMatches(outranked)))
+	case len(winners) == 1:
+		report = append(report, fmt.Sprintf("Result: would run %s", winners[0]))
+	case c.BotUser:
+		report = append(report, "Result: no command matched; ambient messages and catch-alls are ignored for bot users")
+	case len(messageMatches) > 1:
+		// the same order as runAmbient
+		sort.SliceStable(messageMatches, func(i, j int) bool {
+			if messageMatches[i].priority != messageMatches[j].priority {
+				return messageMatches[i].priority > messageMatches[j].priority
+			}
+			return messageMatches[i].plugin < messageMatches[j].plugin
+		})
+		report = append(report, fmt.Sprintf("Result: would run, in Priority order until one consumes the message: %s (ambient matches)", describeMatches(messageMatches)))
+	case len(messageMatches) == 1:
+		report = append(report, fmt.Sprintf("Result: would run %s (ambient match)", messageMatches[0]))
+	case isCommand:
+		report = append(report, "Result: nothing matched; job commands would be checked, then any catch-all plugin called")
+	default:
+		report = append(report, "Result: nothing matched; job commands would be checked, otherwise the message is ignored")
+	}
+	return report
+}
+
+// explainedMatch is a plugin command that matched the explained message
+type explainedMatch struct {
+	plugin, command string
+	priority        int
+}
+
+func (m explainedMatch) String() string {
+	return fmt.Sprintf("command '%s' for plugin '%s'", m.command, m.plugin)
+}
+
+// byPriority splits command matches the way dispatch resolves them: the
+// plugin(s) with the highest Priority, and the rest; more than one
+// winner is a tie, and nothing runs.
+func byPriority(matches []explainedMatch) (winners, outranked []explainedMatch) {
+	for _, m := range matches {
+		switch {
+		case len(winners) == 0 || m.priority == winners[0].priority:
+			winners = append(winners, m)
+		case m.priority > winners[0].priority:
+			outranked = append(outranked, winners...)
+			winners = []explainedMatch{m}
+		default:
+			outranked = append(outranked, m)
+		}
+	}
+	return
+}
+
+func describeMatches(matches []explainedMatch) string {
+	described := make([]string, len(matches))
+	for i, m := range matches {
+		described[i] = m.String()
+	}
+	return strings.Join(described, ", ")
+}
+
+// matchCommands returns the commands for every matcher matching the message;
+// dispatch only uses the first, so this shows overlapping matchers.
+func matchCommands(matchers []InputMatcher, msg string) []string {
+	var matched []string
+	for _, matcher := range matchers {
+		if matcher.re != nil && matcher.re.MatchString(msg) {
+			matched = append(matched, matcher.Command)
+		}
+	}
+	return matched
+}
+
+func matchList(commands []string) string {
+	if len(commands) == 0 {
+		return "(none)"
+	}
+	return strings.Join(commands, ", ")
+}
+
+func explain(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	c := r.getContext()
+	ec := c.clone()
+	var text string
+	switch command {
+	case "explain":
+		text = args[0]
+	case "explainfor":
+		user, channel := args[0], args[1]
+		text = args[2]
+		ec.User = user
+		ec.listedUser = false
+		ec.BotUser = false
+		if ui, ok := c.maps.user[user]; ok {
+			ec.listedUser = true
+			ec.BotUser = ui.BotUser
+		}
+		if strings.EqualFold(channel, "direct") {
+			ec.directMsg = true
+			ec.Channel = ""
+		} else {
+			ec.directMsg = false
+			ec.Channel = channel
+		}
+	}
+	r.Fixed().Say(strings.Join(ec.explainMatch(text), "\n"))
+	return
+}
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+/* exportconfig.go - export of the effective configuration, after all the
+   default and custom yaml has been merged and expanded, for debugging
+   overlay precedence or saving a snapshot. The document is built from the
+   robot configuration and the tasks in currentTasks, exactly as loadConfig
+   and loadTaskConfig left them, with secrets redacted. Parameter values and
+   the contents of Config sections (ProtocolConfig, BrainConfig, a task's
+   Config, etc.) can hold credentials under any name, e.g. a DB_URL, so
+   they're all redacted except for names listed in ExportAllow; elsewhere
+   only secret-looking keys are.
+*/
+
+// value substituted for redacted secrets, same as for the EncryptionKey
+const redactedValue = "XXXXXX"
+
+// keys whose values are redacted outside Parameters and Config sections
+var secretKeyRe = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|encryptionkey|apikey|privatekey)`)
+
+// exportedConfig is the effective configuration document
+type exportedConfig struct {
+	Robot   *BotConf              `json:",omitempty"`
+	Plugins map[string]*BotPlugin `json:",omitempty"`
+	Jobs    map[string]*BotJob    `json:",omitempty"`
+	Tasks   map[string]*BotTask   `json:",omitempty"`
+}
+
+// redact replaces secrets in a decoded json document. Parameters are lists
+// of Name/Value pairs, and the Value is redacted unless the Name is allowed;
+// every value in a Config section is redacted unless it's key is allowed.
+// Other values are redacted when their key looks secret.
+func redact(v interface{}, allow map[string]bool) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			switch {
+			case k == "Parameters":
+				redactParameters(item, allow)
+			case strings.HasSuffix(k, "Config"):
+				val[k] = redactAll(item, allow)
+			case secretKeyRe.MatchString(k):
+				if item != nil && item != "" {
+					val[k] = redactedValue
+				}
+			default:
+				val[k] = redact(item, allow)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redact(item, allow)
+		}
+	}
+	return v
+}
+
+// redactParameters redacts the Values in a list of Parameters
+func redactParameters(v interface{}, allow map[string]bool) {
+	params, _ := v.([]interface{})
+	for _, p := range params {
+		param, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := param["Name"].(string)
+		if value, ok := param["Value"]; ok && value != "" && !allow[name] {
+			param["Value"] = redactedValue
+		}
+	}
+}
+
+// redactAll redacts every string and number in a Config section, except the
+// values of allowed keys; booleans are left as-is.
+func redactAll(v interface{}, allow map[string]bool) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if !allow[k] {
+				val[k] = redactAll(item, allow)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redactAll(item, allow)
+		}
+	case string:
+		if len(val) > 0 {
+			return redactedValue
+		}
+	case float64:
+		return redactedValue
+	}
+	return v
+}
+
+// effectiveConfig returns the effective configuration for the robot and all
+// tasks, or only the named task if taskName isn't "", as yaml or json.
+func effectiveConfig(taskName string, asJSON bool) ([]byte, error) {
+	ec := exportedConfig{
+		Plugins: make(map[string]*BotPlugin),
+		Jobs:    make(map[string]*BotJob),
+		Tasks:   make(map[string]*BotTask),
+	}
+	allow := make(map[string]bool)
+	confLock.RLock()
+	if len(taskName) == 0 {
+		ec.Robot = config
+	}
+	for _, name := range config.ExportAllow {
+		allow[name] = true
+	}
+	confLock.RUnlock()
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	found := false
+	for _, t := range tasks {
+		task, plugin, job := getTask(t)
+		if len(taskName) > 0 && task.name != taskName {
+			continue
+		}
+		found = true
+		switch {
+		case plugin != nil:
+			ec.Plugins[task.name] = plugin
+		case job != nil:
+			ec.Jobs[task.name] = job
+		default:
+			ec.Tasks[task.name] = task
+		}
+	}
+	if len(taskName) > 0 && !found {
+		return nil, fmt.Errorf("no task named '%s'", taskName)
+	}
+	raw, err := json.Marshal(ec)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	doc = redact(doc, allow)
+	if asJSON {
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return yaml.Marshal(doc)
+}
+
+// exportConfig loads the full configuration without starting the connector,
+// writes the effective configuration to stdout and exits; used for the
+// -export-config command-line flag.
+func exportConfig(cpath, epath, format string, logger *log.Logger) {
+	if format != "yaml" && format != "json" {
+		logger.Fatalf("Invalid format for -export-config: '%s', should be 'yaml' or 'json'", format)
+	}
+	// the http listener isn't needed, and the port may be in use by a
+	// running robot
+	listening = true
+	initBot(cpath, epath, logger)
+	go runBrain()
+	c := &botContext{
+		environment: make(map[string]string),
+	}
+	c.registerActive(nil)
+	c.loadConfig(false)
+	c.deregister()
+	cfg, err := effectiveConfig("", format == "json")
+	if err != nil {
+		logger.Fatalf("Error exporting configuration: %v", err)
+	}
+	os.Stdout.Write(cfg)
+	os.Exit(0)
+}
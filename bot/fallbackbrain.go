@@ -0,0 +1,234 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* fallbackbrain.go - an optional wrapper for the configured SimpleBrain that
+   degrades to an in-memory copy of memories when the backend is unreachable,
+   and writes changes back when it recovers. Enabled with BrainFallback: true;
+   off by default, since some uses need strict consistency.
+
+   While degraded, only memories whose backend state is known - read or
+   written while the backend was reachable - are served and stored; anything
+   else fails, so a write-back never replaces a value the robot never saw.
+   The robot's own "bot:" memories are never created while degraded, so e.g.
+   a new encryption key can't overwrite the real one on recovery.
+*/
+
+// how long to wait between attempts to reach a failed brain
+const fallbackRetry = 30 * time.Second
+
+// prefix for the robot's own memories, never created while degraded
+const fallbackBotPrefix = "bot:"
+
+type fallbackBrain struct {
+	backend    SimpleBrain
+	memories   map[string]*[]byte  // everything read from or written to the backend
+	absent     map[string]struct{} // keys the backend reported as not existing
+	dirty      map[string]uint64   // keys stored while degraded, with the generation of the store
+	gen        uint64              // incremented for each store while degraded
+	degraded   bool                // whether the backend is currently failing
+	recovering bool                // set while dirty memories are being written back
+	since      time.Time           // when the backend started failing
+	lastRetry  time.Time           // last attempt to sync back to the backend
+	served     int                 // operations served from memory while degraded
+	sync.Mutex
+}
+
+func newFallbackBrain(backend SimpleBrain) SimpleBrain {
+	return &fallbackBrain{
+		backend:  backend,
+		memories: make(map[string]*[]byte),
+		absent:   make(map[string]struct{}),
+		dirty:    make(map[string]uint64),
+	}
+}
+
+// degrade switches to in-memory operation; called with the lock held.
+func (fb *fallbackBrain) degrade(op, key string, err error) {
+	fb.served++
+	if fb.degraded {
+		return
+	}
+	fb.degraded = true
+	fb.since = time.Now()
+	fb.lastRetry = fb.since
+	Log(Error, fmt.Sprintf("BRAIN UNAVAILABLE: %s for '%s' failed (%v); falling back to in-memory brain, changes will be synced when the brain recovers", op, key, err))
+}
+
+// recover tries to write back any memories stored while degraded. The
+// backend is written without holding the lock, so other operations are
+// still served from memory in the meantime; a memory stored again during
+// the write-back stays dirty for the next attempt.
+func (fb *fallbackBrain) recover() {
+	fb.Lock()
+	if !fb.degraded || fb.recovering || time.Since(fb.lastRetry) < fallbackRetry {
+		fb.Unlock()
+		return
+	}
+	fb.lastRetry = time.Now()
+	fb.recovering = true
+	pending := make(map[string]uint64, len(fb.dirty))
+	blobs := make(map[string]*[]byte, len(fb.dirty))
+	for key, gen := range fb.dirty {
+		pending[key] = gen
+		blobs[key] = fb.memories[key]
+	}
+	fb.Unlock()
+
+	synced := make([]string, 0, len(pending))
+	var err error
+	for key, blob := range blobs {
+		if err = fb.backend.Store(key, blob); err != nil {
+			break
+		}
+		synced = append(synced, key)
+	}
+
+	fb.Lock()
+	defer fb.Unlock()
+	fb.recovering = false
+	for _, key := range synced {
+		if fb.dirty[key] == pending[key] {
+			delete(fb.dirty, key)
+		}
+	}
+	if err != nil {
+		Log(Warn, fmt.Sprintf("Brain still unavailable after %s, %d memories pending sync: %v", time.Since(fb.since), len(fb.dirty), err))
+		return
+	}
+	if len(fb.dirty) > 0 {
+		// stored while writing back; try again with the next operation
+		fb.lastRetry = time.Time{}
+		return
+	}
+	Log(Warn, fmt.Sprintf("Brain recovered after %s; %d operations were served from memory", time.Since(fb.since), fb.served))
+	fb.degraded = false
+	fb.served = 0
+}
+
+// storeDegraded stores a memory while the backend is failing, refusing
+// memories the backend state isn't known for; called with the lock held.
+func (fb *fallbackBrain) storeDegraded(key string, blob *[]byte) error {
+	_, exists := fb.memories[key]
+	_, absent := fb.absent[key]
+	switch {
+	case !exists && !absent:
+		return fmt.Errorf("brain unavailable, and the current value of '%s' is unknown", key)
+	case !exists && strings.HasPrefix(key, fallbackBotPrefix):
+		return fmt.Errorf("brain unavailable, not creating robot memory '%s'", key)
+	}
+	fb.served++
+	fb.gen++
+	fb.memories[key] = blob
+	fb.dirty[key] = fb.gen
+	delete(fb.absent, key)
+	return nil
+}
+
+func (fb *fallbackBrain) Store(key string, blob *[]byte) error {
+	fb.recover()
+	fb.Lock()
+	defer fb.Unlock()
+	if fb.degraded {
+		return fb.storeDegraded(key, blob)
+	}
+	if err := fb.backend.Store(key, blob); err != nil {
+		fb.degrade("Store", key, err)
+		return fb.storeDegraded(key, blob)
+	}
+	fb.memories[key] = blob
+	delete(fb.absent, key)
+	return nil
+}
+
+// CompareAndStore compares and stores with the backend when it can, or with
+// the in-memory copy while degraded.
+func (fb *fallbackBrain) CompareAndStore(key string, old, blob *[]byte) (bool, error) {
+	fb.recover()
+	fb.Lock()
+	defer fb.Unlock()
+	if !fb.degraded {
+		swapped, err := compareAndStore(fb.backend, key, old, blob)
+		if err == nil {
+			if swapped {
+				fb.memories[key] = blob
+				delete(fb.absent, key)
+			} else {
+				// changed by somebody else; the current value is unknown
+				delete(fb.memories, key)
+				delete(fb.absent, key)
+			}
+			return swapped, nil
+		}
+		fb.degrade("CompareAndStore", key, err)
+	}
+	current, exists := fb.memories[key]
+	if _, absent := fb.absent[key]; (exists || absent) && !sameDatum(old, current, exists) {
+		return false, nil
+	}
+	if err := fb.storeDegraded(key, blob); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// List lists from the backend when it can, or from memory while degraded.
+func (fb *fallbackBrain) List(prefix string) ([]string, error) {
+	lister, ok := fb.backend.(BrainLister)
+	if !ok {
+		return nil, errListNotSupported
+	}
+	fb.recover()
+	fb.Lock()
+	defer fb.Unlock()
+	if !fb.degraded {
+		keys, err := lister.List(prefix)
+		if err == nil {
+			return keys, nil
+		}
+		fb.degrade("List", prefix, err)
+	} else {
+		fb.served++
+	}
+	keys := make([]string, 0)
+	for key := range fb.memories {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (fb *fallbackBrain) Retrieve(key string) (*[]byte, bool, error) {
+	fb.recover()
+	fb.Lock()
+	defer fb.Unlock()
+	if !fb.degraded {
+		blob, exists, err := fb.backend.Retrieve(key)
+		if err == nil {
+			if exists {
+				fb.memories[key] = blob
+				delete(fb.absent, key)
+			} else {
+				delete(fb.memories, key)
+				fb.absent[key] = struct{}{}
+			}
+			return blob, exists, nil
+		}
+		fb.degrade("Retrieve", key, err)
+	} else {
+		fb.served++
+	}
+	if blob, exists := fb.memories[key]; exists {
+		return blob, true, nil
+	}
+	if _, absent := fb.absent[key]; absent {
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("brain unavailable, and '%s' was never retrieved", key)
+}
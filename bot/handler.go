@@ -3,11 +3,13 @@ package bot
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 )
 
-// an empty object type for passing a Handler to the connector.
-type handler struct{}
+// handler is passed to each connector as it's Handler; the protocol tags
+// messages from ExtraConnectors.
+type handler struct {
+	protocol string // set for ExtraConnectors, "" for the primary connector
+}
 
 /* Handle incoming messages and other callbacks from the connector. */
 
@@ -39,6 +41,19 @@ func (h handler) GetConfigPath() string {
 	return installPath
 }
 
+// GetWorkSpace gets the path to the robot's read/write WorkSpace directory,
+// where it does work and keeps local state. This defaults to the config
+// directory, or the install directory if no config directory is set.
+func (h handler) GetWorkSpace() string {
+	botCfg.RLock()
+	workSpace := botCfg.workSpace
+	botCfg.RUnlock()
+	if len(workSpace) > 0 {
+		return workSpace
+	}
+	return h.GetConfigPath()
+}
+
 // ConnectorMessage is passed in to the robot for every incoming message seen.
 // The *ID fields are required invariant internal representations that the
 // protocol accepts in it's interface methods.
@@ -53,6 +68,12 @@ type ConnectorMessage struct {
 	DirectMessage bool
 	// MessageText - sanitized message text, with all protocol-added junk removed
 	MessageText string
+	// ThreadID - optional identifier of the thread the message was posted in,
+	// for protocols that support threads; "" for the main channel
+	ThreadID string
+	// MessageID - optional identifier of the message itself, for protocols
+	// where a reply thread can be started on a message (e.g. Slack timestamp)
+	MessageID string
 	// MessageObject, Client - interfaces for the raw
 	MessageObject, Client interface{}
 }
@@ -60,6 +81,12 @@ type ConnectorMessage struct {
 // ChannelMessage accepts an incoming channel message from the connector.
 //func (h handler) IncomingMessage(channelName, userName, messageFull string, raw interface{}) {
 func (h handler) IncomingMessage(inc *ConnectorMessage) {
+	h.incomingMessage(inc, nil)
+}
+
+// incomingMessage handles a message from the connector; a synthetic message
+// for a button click (act != nil) is always a command to the robot.
+func (h handler) incomingMessage(inc *ConnectorMessage, act *ConnectorAction) {
 	// Note: zero-len channel name and ID is valid; true of direct messages for some connectors
 	if len(inc.UserName) == 0 && len(inc.UserID) == 0 {
 		Log(Error, "incoming message with no username or user ID")
@@ -75,7 +102,7 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 	 */
 	ProtocolChannel = bracket(inc.ChannelID)
 	if !inc.DirectMessage {
-		if cn, ok := maps.channelID[inc.ChannelID]; ok {
+		if cn, ok := maps.channelID[protocolKey{h.protocol, inc.ChannelID}]; ok {
 			channelName = cn.ChannelName
 		} else if len(inc.ChannelName) > 0 {
 			channelName = inc.ChannelName
@@ -85,7 +112,7 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 	} // ProtocolChannel / channelName should be "" for DM
 	ProtocolUser = bracket(inc.UserID)
 	listedUser := false
-	if un, ok := maps.userID[inc.UserID]; ok {
+	if un, ok := maps.userID[protocolKey{h.protocol, inc.UserID}]; ok {
 		userName = un.UserName
 		BotUser = un.BotUser
 		listedUser = true
@@ -98,52 +125,35 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 	messageFull := inc.MessageText
 
 	Log(Trace, fmt.Sprintf("Incoming message in channel '%s/%s' from user '%s/%s': %s", channelName, ProtocolChannel, userName, ProtocolUser, messageFull))
-	// When command == true, the message was directed at the bot
-	isCommand := false
 	logChannel := channelName
-	var message string
 
-	botCfg.RLock()
-	for _, user := range botCfg.ignoreUsers {
-		if strings.EqualFold(userName, user) {
-			Log(Debug, "Ignoring user", userName)
-			c := &botContext{User: userName}
-			c.debug("robot is configured to ignore this user", true)
-			emit(IgnoredUser)
-			botCfg.RUnlock()
-			return
-		}
-	}
-	preRegex := botCfg.preRegex
-	postRegex := botCfg.postRegex
-	bareRegex := botCfg.bareRegex
-	botCfg.RUnlock()
-	if preRegex != nil {
-		matches := preRegex.FindAllStringSubmatch(messageFull, -1)
-		if matches != nil && len(matches[0]) == 2 {
-			isCommand = true
-			message = matches[0][1]
-		}
-	}
-	if !isCommand && postRegex != nil {
-		matches := postRegex.FindAllStringSubmatch(messageFull, -1)
-		if matches != nil && len(matches[0]) == 3 {
-			isCommand = true
-			message = matches[0][1] + matches[0][2]
-		}
+	// A name from another platform could be anyone, including one of the
+	// robot's administrators; users of ExtraConnectors must be listed.
+	if len(h.protocol) > 0 && !listedUser {
+		Log(Debug, fmt.Sprintf("Ignoring user '%s' on protocol '%s', not listed in the UserRoster for it", userName, h.protocol))
+		emit(IgnoredUser)
+		return
 	}
-	if !isCommand && bareRegex != nil {
-		if bareRegex.MatchString(messageFull) {
-			isCommand = true
-		}
+	if userIgnored(userName) {
+		Log(Debug, "Ignoring user", userName)
+		c := &botContext{User: userName}
+		c.debug("robot is configured to ignore this user", true)
+		emit(IgnoredUser)
+		return
 	}
-	if !isCommand {
-		message = messageFull
+	// When isCommand == true, the message was directed at the bot
+	message, isCommand := checkAddressed(messageFull, channelName)
+	if act != nil {
+		message, isCommand = messageFull, true
 	}
 
+	threadEngaged := false
 	if inc.DirectMessage {
 		isCommand = true
 		logChannel = "(direct message)"
+	} else if checkThreadEngaged(channelName, inc.ThreadID, isCommand) {
+		isCommand = true
+		threadEngaged = true
 	}
 
 	currentTasks.Lock()
@@ -164,20 +174,24 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 		ProtocolUser:    ProtocolUser,
 		ProtocolChannel: ProtocolChannel,
 		Incoming:        inc,
+		source:          h.protocol,
 		tasks: taskList{
 			t:          t,
 			nameMap:    nameMap,
 			idMap:      idMap,
 			nameSpaces: nameSpaces,
 		},
-		maps:         maps,
-		BotUser:      BotUser,
-		listedUser:   listedUser,
-		repositories: repolist,
-		isCommand:    isCommand,
-		directMsg:    inc.DirectMessage,
-		msg:          message,
-		environment:  make(map[string]string),
+		maps:          maps,
+		BotUser:       BotUser,
+		listedUser:    listedUser,
+		repositories:  repolist,
+		isCommand:     isCommand,
+		directMsg:     inc.DirectMessage,
+		threadEngaged: threadEngaged,
+		thread:        inc.ThreadID,
+		msg:           message,
+		action:        act,
+		environment:   make(map[string]string),
 	}
 	if c.directMsg {
 		Log(Debug, fmt.Sprintf("Received private message from user '%s'", userName))
@@ -188,10 +202,50 @@ func (h handler) IncomingMessage(inc *ConnectorMessage) {
 	go c.handleMessage()
 }
 
+// checkAddressed checks whether a message in a channel is addressed to the
+// robot by name or alias, returning the message with the name/alias removed.
+// The channel's ChannelAddressing, if any, determines the alias.
+func checkAddressed(messageFull, channel string) (message string, isCommand bool) {
+	botCfg.RLock()
+	preRegex := botCfg.preRegex
+	if channelPre, ok := botCfg.channelPreRegex[channel]; ok {
+		preRegex = channelPre
+	}
+	postRegex := botCfg.postRegex
+	bareRegex := botCfg.bareRegex
+	botCfg.RUnlock()
+	if preRegex != nil {
+		matches := preRegex.FindAllStringSubmatch(messageFull, -1)
+		if matches != nil && len(matches[0]) == 2 {
+			return matches[0][1], true
+		}
+	}
+	if postRegex != nil {
+		matches := postRegex.FindAllStringSubmatch(messageFull, -1)
+		if matches != nil && len(matches[0]) == 3 {
+			return matches[0][1] + matches[0][2], true
+		}
+	}
+	if bareRegex != nil {
+		if bareRegex.MatchString(messageFull) {
+			return "", true
+		}
+	}
+	return messageFull, false
+}
+
 // GetProtocolConfig unmarshals the connector's configuration data into a provided struct
 func (h handler) GetProtocolConfig(v interface{}) error {
 	botCfg.RLock()
-	err := json.Unmarshal(protocolConfig, v)
+	cfg := protocolConfig
+	if len(h.protocol) > 0 {
+		for _, ec := range extraConnectors {
+			if ec.Protocol == h.protocol {
+				cfg = ec.ProtocolConfig
+			}
+		}
+	}
+	err := json.Unmarshal(cfg, v)
 	botCfg.RUnlock()
 	return err
 }
@@ -217,8 +271,13 @@ func (h handler) Log(l LogLevel, v ...interface{}) {
 	Log(l, v...)
 }
 
-// SetID let's the connector set the bot's internal ID
+// SetID let's the connector set the bot's internal ID; only the primary
+// connector's ID is used.
 func (h handler) SetID(id string) {
+	if len(h.protocol) > 0 {
+		Log(Debug, fmt.Sprintf("Ignoring ID '%s' from extra connector '%s'", id, h.protocol))
+		return
+	}
 	botCfg.Lock()
 	botCfg.botinfo.UserID = id
 	botCfg.Unlock()
@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* health.go - the 'status' admin command and the /health endpoint on the
+   robot's http listener, for administrators and for container liveness
+   probes. /health returns the same report as JSON, with status 200 when the
+   robot is healthy and 503 when a connector reports it's disconnected or the
+   brain can't be reached.
+*/
+
+// how long to wait for the brain to answer a health check
+const brainHealthTimeout = 5 * time.Second
+
+// brain key read for health checks; it never exists
+const healthKey = "bot:healthcheck"
+
+// botStarted records when run() started the robot
+var botStarted time.Time
+
+// lastReload records when the configuration was last (re)loaded
+var lastReload = struct {
+	t time.Time
+	sync.Mutex
+}{}
+
+// connectorHealth is the state of one active connector
+type connectorHealth struct {
+	Protocol  string
+	Connected bool
+	Status    string
+}
+
+// healthReport gathers the robot's status
+type healthReport struct {
+	Healthy       bool
+	Uptime        string
+	Connectors    []connectorHealth
+	Tasks         int
+	Plugins       int
+	Jobs          int
+	Disabled      int
+	Panics        int      // Go plugin panics and external plugin crashes
+	PanicsByTask  []string `json:",omitempty"`
+	Schedules     string   // running or paused
+	ScheduledJobs int
+	BrainOk       bool
+	Brain         string
+	LastReload    time.Time
+}
+
+// setReloaded records the time of a configuration load; called from
+// loadConfig.
+func setReloaded() {
+	lastReload.Lock()
+	lastReload.t = time.Now()
+	lastReload.Unlock()
+}
+
+// connectorHealthList reports the connection state of each active
+// connector; connectors that don't implement ConnectionReporter are assumed
+// to be connected.
+func connectorHealthList() []connectorHealth {
+	activeConnectors.RLock()
+	defer activeConnectors.RUnlock()
+	list := make([]connectorHealth, 0, len(activeConnectors.c))
+	for protocol, conn := range activeConnectors.c {
+		ch := connectorHealth{Protocol: protocol, Connected: true, Status: "running"}
+		if cr, ok := conn.(ConnectionReporter); ok {
+			ch.Connected, ch.Status = cr.ConnectionStatus()
+		}
+		list = append(list, ch)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Protocol < list[j].Protocol })
+	return list
+}
+
+// brainHealth checks that the brain answers a read within
+// brainHealthTimeout.
+func brainHealth() (bool, string) {
+	botCfg.RLock()
+	brain := botCfg.brain
+	botCfg.RUnlock()
+	if brain == nil {
+		return false, "no brain configured"
+	}
+	if fb, ok := brain.(*fallbackBrain); ok {
+		fb.Lock()
+		degraded, since, pending := fb.degraded, fb.since, len(fb.dirty)
+		fb.Unlock()
+		if degraded {
+			return false, fmt.Sprintf("unreachable since %s, serving from memory; %d memories pending sync", since.Format(time.RFC1123), pending)
+		}
+	}
+	reply := make(chan RetVal, 1)
+	go func() {
+		_, _, _, ret := checkout(healthKey, false)
+		reply <- ret
+	}()
+	select {
+	case ret := <-reply:
+		if ret != Ok {
+			return false, fmt.Sprintf("unreachable: %s", ret)
+		}
+		return true, "reachable"
+	case <-time.After(brainHealthTimeout):
+		return false, fmt.Sprintf("no response after %s", brainHealthTimeout)
+	}
+}
+
+// checkHealth gathers a healthReport
+func checkHealth() healthReport {
+	h := healthReport{
+		Uptime:     time.Since(botStarted).Round(time.Second).String(),
+		Connectors: connectorHealthList(),
+	}
+
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	for _, t := range tasks {
+		task, plugin, job := getTask(t)
+		switch {
+		case plugin != nil:
+			h.Plugins++
+		case job != nil:
+			h.Jobs++
+		}
+		h.Tasks++
+		if task.Disabled {
+			h.Disabled++
+		}
+	}
+
+	h.Panics, h.PanicsByTask = panicCounts()
+
+	schedMutex.Lock()
+	h.Schedules = "running"
+	if schedulesPaused {
+		h.Schedules = "paused"
+	}
+	if taskRunner != nil {
+		h.ScheduledJobs = len(taskRunner.Entries())
+	}
+	schedMutex.Unlock()
+
+	lastReload.Lock()
+	h.LastReload = lastReload.t
+	lastReload.Unlock()
+
+	h.BrainOk, h.Brain = brainHealth()
+	h.Healthy = h.BrainOk
+	for _, c := range h.Connectors {
+		if !c.Connected {
+			h.Healthy = false
+		}
+	}
+	return h
+}
+
+// String formats the report for the 'status' admin command
+func (h healthReport) String() string {
+	var s strings.Builder
+	state := "healthy"
+	if !h.Healthy {
+		state = "UNHEALTHY"
+	}
+	fmt.Fprintf(&s, "Status: %s\nUptime: %s\n", state, h.Uptime)
+	for _, c := range h.Connectors {
+		fmt.Fprintf(&s, "Connector %s: %s\n", c.Protocol, c.Status)
+	}
+	fmt.Fprintf(&s, "Tasks: %d loaded (%d plugins, %d jobs), %d disabled\n", h.Tasks, h.Plugins, h.Jobs, h.Disabled)
+	if h.Panics > 0 {
+		fmt.Fprintf(&s, "Plugin panics/crashes: %d (%s)\n", h.Panics, strings.Join(h.PanicsByTask, ", "))
+	} else {
+		fmt.Fprintf(&s, "Plugin panics/crashes: 0\n")
+	}
+	fmt.Fprintf(&s, "Scheduled jobs: %s; %d job(s) scheduled\n", h.Schedules, h.ScheduledJobs)
+	fmt.Fprintf(&s, "Brain: %s\n", h.Brain)
+	fmt.Fprintf(&s, "Last reload: %s", h.LastReload.Format(time.RFC1123))
+	return s.String()
+}
+
+// healthHandler serves /health
+type healthHandler struct{}
+
+func (healthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h := checkHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(h)
+}
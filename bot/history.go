@@ -4,19 +4,24 @@ package bot
 	history.go provides the mechanism and methods for storing and retrieving
 	job / plugin run histories of stdout/stderr for a given run. Each time
 	a job / plugin is initiated by a trigger, scheduled job, or user command,
-	a new history file is started if HistoryLogs is != 0 for the job/plugin.
+	a new history file is started if HistoryLogs is != 0 for the job/plugin,
+	or the job has a MaxHistoryAge.
 	The history provider will store histories up to some maximum, and return
 	that history based on the index.
 */
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"time"
 )
 
 type historyLog struct {
 	LogIndex   int
 	CreateTime string
+	Status     string    // TaskRetVal of the finished run, "" while running
+	Started    time.Time // when the run started, for pruning by MaxHistoryAge
 }
 
 type jobHistory struct {
@@ -49,6 +54,29 @@ type HistoryProvider interface {
 	MakeHistoryURL(tag string, index int) (URL string, exists bool)
 }
 
+// recordRunStatus stores the final status of a job run in the job's history,
+// for listing with the 'history' command.
+func recordRunStatus(histSpec string, index int, status TaskRetVal) {
+	var jh jobHistory
+	key := histPrefix + histSpec
+	tok, _, ret := checkoutDatum(key, &jh, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to record status for run %d", key, index))
+		return
+	}
+	for i := range jh.Histories {
+		if jh.Histories[i].LogIndex == index {
+			jh.Histories[i].Status = status.String()
+			if ret := updateDatum(key, tok, jh); ret != Ok {
+				Log(Error, fmt.Sprintf("Error updating '%s', unable to record status for run %d", key, index))
+			}
+			return
+		}
+	}
+	// pruned by newer runs
+	checkinDatum(key, tok)
+}
+
 // Map of registered history providers
 var historyProviders = make(map[string]func(Handler) HistoryProvider)
 
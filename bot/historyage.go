@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* historyage.go - HistoryLogs keeps a count of runs, which isn't much use for
+   a job that runs hourly. MaxHistoryAge keeps runs by age instead, e.g.
+   "30d"; with both set, a run is kept only while it's within HistoryLogs and
+   younger than MaxHistoryAge. Runs are pruned by age after each job run,
+   removing their history entries, history logs and artifacts.
+*/
+
+// HistoryRemover is an optional interface for HistoryProviders that can
+// remove the log for a single run, used for pruning runs by MaxHistoryAge.
+// Providers that don't implement it keep logs until they're pruned by count.
+type HistoryRemover interface {
+	RemoveHistory(tag string, index int) error
+}
+
+// parseHistoryAge parses a MaxHistoryAge; along with Go durations like
+// "72h", it takes a number of days, e.g. "30d".
+func parseHistoryAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid number of days: %s", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative duration: %s", age)
+	}
+	return d, err
+}
+
+// historyRetention returns how many runs of a job to keep, 0 for no limit,
+// and whether history logs are kept. With only MaxHistoryAge set, runs are
+// limited by age alone.
+func (job *BotJob) historyRetention() (keep int, logged bool) {
+	if job.HistoryLogs > 0 {
+		return job.HistoryLogs, true
+	}
+	if job.maxHistoryAge > 0 {
+		return 0, true
+	}
+	return 1, false
+}
+
+// keepLogs returns the maxHistories to give a HistoryProvider for a run; with
+// no count limit it's enough to keep every run.
+func keepLogs(keep, index int) int {
+	if keep == 0 {
+		return index + 1
+	}
+	return keep
+}
+
+// started returns when a run started; runs recorded before Started was
+// added fall back to CreateTime.
+func (h historyLog) started() (time.Time, bool) {
+	if !h.Started.IsZero() {
+		return h.Started, true
+	}
+	t, err := time.Parse("Mon Jan 2 15:04:05 MST 2006", h.CreateTime)
+	return t, err == nil
+}
+
+// pruneHistoryAge removes runs of a job older than it's MaxHistoryAge,
+// other than the run that just finished; called when a job run completes.
+func pruneHistoryAge(histSpec string, current int, job *BotJob, history HistoryProvider) {
+	var jh jobHistory
+	key := histPrefix + histSpec
+	tok, _, ret := checkoutDatum(key, &jh, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to prune runs older than MaxHistoryAge", key))
+		return
+	}
+	cutoff := time.Now().Add(-job.maxHistoryAge)
+	kept := make([]historyLog, 0, len(jh.Histories))
+	var expired []string
+	var expiredRuns []int
+	for _, h := range jh.Histories {
+		if started, ok := h.started(); ok && h.LogIndex != current && started.Before(cutoff) {
+			expired = append(expired, strconv.Itoa(h.LogIndex))
+			expiredRuns = append(expiredRuns, h.LogIndex)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	if len(expiredRuns) == 0 {
+		checkinDatum(key, tok)
+		return
+	}
+	jh.Histories = kept
+	if ret := updateDatum(key, tok, jh); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to prune runs older than MaxHistoryAge", key))
+		return
+	}
+	limits := fmt.Sprintf("MaxHistoryAge %s", job.MaxHistoryAge)
+	if job.HistoryLogs > 0 {
+		limits += fmt.Sprintf(" and HistoryLogs %d", job.HistoryLogs)
+	}
+	Log(Info, fmt.Sprintf("Pruned run(s) %s of job '%s' older than MaxHistoryAge; keeping %d run(s) within %s", strings.Join(expired, ", "), histSpec, len(kept), limits))
+	hr, canRemove := history.(HistoryRemover)
+	if history != nil && !canRemove {
+		Log(Warn, fmt.Sprintf("History provider can't remove logs by age; logs for pruned runs of job '%s' are only removed by HistoryLogs", histSpec))
+	}
+	dir := artifactDir(histSpec)
+	for _, index := range expiredRuns {
+		if canRemove {
+			if err := hr.RemoveHistory(histSpec, index); err != nil {
+				Log(Error, fmt.Sprintf("Error removing history for job '%s', run %d: %v", histSpec, index, err))
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(dir, fmt.Sprintf("run-%d", index))); err != nil {
+			Log(Error, fmt.Sprintf("Error removing artifacts for job '%s', run %d: %v", histSpec, index, err))
+		}
+	}
+}
@@ -5,13 +5,25 @@ package bot
 */
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
+// header external tasks use to send the LocalToken
+const tokenHeader = "X-Gopherbot-Token"
+
+// header external tasks use to send their GOPHER_CALLER_TOKEN
+const callerTokenHeader = "X-Gopherbot-Caller-Token"
+
 type jsonFunction struct {
 	FuncName string
 	User     string
@@ -53,11 +65,27 @@ type channelmessage struct {
 	Base64  bool
 }
 
+type saymessage struct {
+	Time    int64 // Unix time to send the message
+	Message string
+	Base64  bool
+}
+
 type taskcall struct {
 	Name    string
 	CmdArgs []string
 }
 
+type schedulecall struct {
+	Seconds int64 // delay before running the task
+	Name    string
+	CmdArgs []string
+}
+
+type cancelcall struct {
+	ID int
+}
+
 type cmdcall struct {
 	Plugin  string
 	Command string
@@ -128,6 +156,19 @@ type extns struct {
 	Histories int
 }
 
+type artifact struct {
+	Name    string
+	Content string // base64 encoded
+}
+
+type emailmessage struct {
+	To          string
+	Subject     string
+	Body        string
+	Base64      bool
+	Attachments []artifact
+}
+
 // Types for returning values
 
 // AttrRet implements Stringer so it can be interpolated with fmt if
@@ -171,6 +212,11 @@ type replyresponse struct {
 	RetVal int
 }
 
+type scheduleresponse struct {
+	ID     int
+	RetVal int
+}
+
 // decode decodes a base64 string, primarily for the bash library
 func decode(msg string) string {
 	decoded, err := base64.StdEncoding.DecodeString(msg)
@@ -200,7 +246,40 @@ func sendReturn(rw http.ResponseWriter, ret interface{}) {
 	rw.Write(d)
 }
 
+// serveSocket serves the http/JSON api on a unix socket, accessible only to
+// the robot's user.
+func serveSocket(path string) error {
+	// remove a stale socket from a previous run
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	return http.Serve(l, nil)
+}
+
+// authorized checks the LocalToken for a request, replying with 401 and
+// returning false if it's missing or wrong.
+func authorized(rw http.ResponseWriter, req *http.Request) bool {
+	botCfg.RLock()
+	token := botCfg.httpToken
+	botCfg.RUnlock()
+	if len(token) > 0 && subtle.ConstantTimeCompare([]byte(req.Header.Get(tokenHeader)), []byte(token)) != 1 {
+		rw.WriteHeader(http.StatusUnauthorized)
+		Log(Warn, fmt.Sprintf("Rejected unauthenticated request for '%s' from '%s'", req.URL.Path, req.RemoteAddr))
+		return false
+	}
+	return true
+}
+
 func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !authorized(rw, req) {
+		return
+	}
 	data, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		Log(Fatal, err)
@@ -236,26 +315,17 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		Channel:  f.Channel,
 		Protocol: setProtocol(f.Protocol),
 		Incoming: c.Incoming,
+		source:   c.source,
 		id:       c.id,
 	}
 	if len(f.Format) > 0 {
 		r.Format = setFormat(f.Format)
 	} else {
-		botCfg.RLock()
-		r.Format = botCfg.defaultMessageFormat
-		botCfg.RUnlock()
+		r.Format = c.Format
 	}
 	task, _, _ := getTask(c.currentTask)
 	Log(Trace, fmt.Sprintf("Task '%s' calling function '%s' in channel '%s' for user '%s'", task.name, f.FuncName, f.Channel, f.User))
 
-	if len(f.Format) > 0 {
-		r.Format = setFormat(f.Format)
-	} else {
-		botCfg.RLock()
-		r.Format = botCfg.defaultMessageFormat
-		botCfg.RUnlock()
-	}
-
 	var (
 		attr  *AttrRet
 		reply string
@@ -266,6 +336,22 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		bret := r.CheckAdmin()
 		sendReturn(rw, boolresponse{Boolean: bret})
 		return
+	case "WithinBusinessHours":
+		bret := r.WithinBusinessHours()
+		sendReturn(rw, boolresponse{Boolean: bret})
+		return
+	case "StartTyping":
+		r.StartTyping()
+		sendReturn(rw, &botretvalresponse{int(Ok)})
+		return
+	case "StopTyping":
+		r.StopTyping()
+		sendReturn(rw, &botretvalresponse{int(Ok)})
+		return
+	case "ConsumeMessage":
+		r.ConsumeMessage()
+		sendReturn(rw, &botretvalresponse{int(Ok)})
+		return
 	case "GetRepoData":
 		sendReturn(rw, r.GetRepoData())
 		return
@@ -291,6 +377,21 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 		sendReturn(rw, &botretvalresponse{int(ret)})
 		return
+	case "ScheduleAfter":
+		var sc schedulecall
+		if !getArgs(rw, &f.FuncArgs, &sc) {
+			return
+		}
+		id, ret := r.ScheduleAfter(time.Duration(sc.Seconds)*time.Second, sc.Name, sc.CmdArgs...)
+		sendReturn(rw, &scheduleresponse{id, int(ret)})
+		return
+	case "CancelScheduled":
+		var cc cancelcall
+		if !getArgs(rw, &f.FuncArgs, &cc) {
+			return
+		}
+		sendReturn(rw, &botretvalresponse{int(r.CancelScheduled(cc.ID))})
+		return
 	case "AddCommand", "FinalCommand", "FailCommand":
 		var cc cmdcall
 		if !getArgs(rw, &f.FuncArgs, &cc) {
@@ -330,6 +431,20 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 		success := r.ExtendNamespace(en.Extend, en.Histories)
 		sendReturn(rw, boolresponse{Boolean: success})
+	case "SaveArtifact":
+		var a artifact
+		if !getArgs(rw, &f.FuncArgs, &a) {
+			return
+		}
+		content, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Unable to decode base64 content for artifact '%s': %v", a.Name, err))
+			sendReturn(rw, &botretvalresponse{int(FailedArtifactSave)})
+			return
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(r.SaveArtifact(a.Name, bytes.NewReader(content))),
+		})
 	case "Exclusive":
 		var e exclusive
 		if !getArgs(rw, &f.FuncArgs, &e) {
@@ -372,9 +487,11 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		if !getArgs(rw, &f.FuncArgs, &m) {
 			return
 		}
-		var key string
-		task, _, _ := getTask(c.currentTask)
-		key = task.NameSpace + ":" + m.Key
+		if strings.ContainsRune(m.Key, ':') {
+			sendReturn(rw, &botretvalresponse{int(InvalidDatumKey)})
+			return
+		}
+		key := c.memoryPrefix() + m.Key
 		// Since we're getting raw JSON (=[]byte), we call update directly.
 		// See brain.go
 		ret = update(key, m.Token, (*[]byte)(&m.Datum))
@@ -412,12 +529,19 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		sendReturn(rw, &stringresponse{s})
 		return
 	case "GetTaskConfig":
-		if task.Config == nil {
+		// Config often has credentials; only return it to the task itself
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get(callerTokenHeader)), []byte(c.callerToken)) != 1 {
+			rw.WriteHeader(http.StatusForbidden)
+			Log(Warn, fmt.Sprintf("Rejected GetTaskConfig for task '%s' without a valid caller token", task.name))
+			return
+		}
+		cfg := task.forChannel(c.Channel)
+		if cfg.Config == nil {
 			Log(Error, fmt.Sprintf("GetTaskConfig called by external script '%s', but no config found.", task.name))
 			sendReturn(rw, handler{})
 			return
 		}
-		sendReturn(rw, task.Config)
+		sendReturn(rw, cfg.Config)
 		return
 	case "GetSenderAttribute", "GetBotAttribute":
 		var a attribute
@@ -463,6 +587,18 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			int(r.SendChannelMessage(cm.Channel, cm.Message)),
 		})
 		return
+	case "SayAt":
+		var sm saymessage
+		if !getArgs(rw, &f.FuncArgs, &sm) {
+			return
+		}
+		if sm.Base64 {
+			sm.Message = decode(sm.Message)
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(r.SayAt(time.Unix(sm.Time, 0), sm.Message)),
+		})
+		return
 	case "SendUserChannelMessage":
 		var ucm userchannelmessage
 		if !getArgs(rw, &f.FuncArgs, &ucm) {
@@ -487,6 +623,28 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			int(r.SendUserMessage(um.User, um.Message)),
 		})
 		return
+	case "SendEmail":
+		var em emailmessage
+		if !getArgs(rw, &f.FuncArgs, &em) {
+			return
+		}
+		if em.Base64 {
+			em.Body = decode(em.Body)
+		}
+		attachments := make([]EmailAttachment, 0, len(em.Attachments))
+		for _, a := range em.Attachments {
+			content, err := base64.StdEncoding.DecodeString(a.Content)
+			if err != nil {
+				Log(Error, fmt.Sprintf("Unable to decode base64 content for email attachment '%s': %v", a.Name, err))
+				sendReturn(rw, &botretvalresponse{int(MailError)})
+				return
+			}
+			attachments = append(attachments, EmailAttachment{a.Name, bytes.NewReader(content)})
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(r.SendEmail(em.To, em.Subject, em.Body, attachments...)),
+		})
+		return
 	case "PromptUserChannelForReply":
 		var rr replyrequest
 		if !getArgs(rw, &f.FuncArgs, &rr) {
@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ignoreusers.go - IgnoreUsers in gopherbot.yaml is read at load time; the
+   'ignore user' and 'unignore user' admin commands add to it at runtime, e.g.
+   to mute a spamming integration without editing configuration and
+   reloading. Runtime ignores are remembered in the brain and cached here.
+*/
+
+const ignoredUsersKey = "bot:ignoredUsers"
+
+// runtimeIgnore records a user ignored at runtime
+type runtimeIgnore struct {
+	User string // administrator that ignored the user
+	Time string
+}
+
+// runtimeIgnores caches the brain's ignoredUsersKey, keyed by lowercased
+// user name, so incoming messages don't each need a trip to the brain.
+var runtimeIgnores = struct {
+	sync.Mutex
+	u map[string]runtimeIgnore
+}{
+	u: make(map[string]runtimeIgnore),
+}
+
+// restoreRuntimeIgnores loads users ignored at runtime from the brain; called
+// from run() at startup.
+func restoreRuntimeIgnores() {
+	ignored := make(map[string]runtimeIgnore)
+	_, _, ret := checkoutDatum(ignoredUsersKey, &ignored, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', users ignored at runtime won't be ignored: %s", ignoredUsersKey, ret))
+	}
+	runtimeIgnores.Lock()
+	runtimeIgnores.u = ignored
+	runtimeIgnores.Unlock()
+}
+
+// isAdmin reports whether user is listed in AdminUsers
+func isAdmin(user string) bool {
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	for _, adminUser := range botCfg.adminUsers {
+		if user == adminUser {
+			return true
+		}
+	}
+	return false
+}
+
+// staticIgnored reports whether user is listed in IgnoreUsers
+func staticIgnored(user string) bool {
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	for _, ignored := range botCfg.ignoreUsers {
+		if strings.EqualFold(user, ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+// userIgnored reports whether the robot should ignore messages from user,
+// checking both IgnoreUsers and users ignored at runtime. Admin users are
+// never ignored at runtime, so an admin can always 'unignore' again.
+func userIgnored(user string) bool {
+	if staticIgnored(user) {
+		return true
+	}
+	runtimeIgnores.Lock()
+	_, ignored := runtimeIgnores.u[strings.ToLower(user)]
+	runtimeIgnores.Unlock()
+	return ignored && !isAdmin(user)
+}
+
+// setUserIgnored ignores or stops ignoring a user at runtime, replying to
+// the administrator with the result.
+func setUserIgnored(r *Robot, user string, ignore bool) {
+	if ignore && isAdmin(user) {
+		r.Say(fmt.Sprintf("Sorry, '%s' is an administrator, and I never ignore administrators", user))
+		return
+	}
+	if !ignore && staticIgnored(user) {
+		r.Say(fmt.Sprintf("The user '%s' is listed in IgnoreUsers; remove them from the configuration and reload", user))
+		return
+	}
+	key := strings.ToLower(user)
+	runtimeIgnores.Lock()
+	defer runtimeIgnores.Unlock()
+	ignored := make(map[string]runtimeIgnore)
+	tok, _, ret := checkoutDatum(ignoredUsersKey, &ignored, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s': %s", ignoredUsersKey, ret))
+		r.Say(fmt.Sprintf("Sorry, I had a problem retrieving the list of ignored users: %s", ret))
+		return
+	}
+	_, already := ignored[key]
+	if ignore == already {
+		checkinDatum(ignoredUsersKey, tok)
+		if ignore {
+			r.Say(fmt.Sprintf("I'm already ignoring '%s'", user))
+		} else {
+			r.Say(fmt.Sprintf("I'm not ignoring '%s'", user))
+		}
+		return
+	}
+	if ignore {
+		ignored[key] = runtimeIgnore{r.User, time.Now().Format("Mon Jan 2 15:04:05 MST 2006")}
+	} else {
+		delete(ignored, key)
+	}
+	if ret := updateDatum(ignoredUsersKey, tok, ignored); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s' for user '%s': %s", ignoredUsersKey, user, ret))
+		r.Say(fmt.Sprintf("Sorry, I had a problem updating the list of ignored users: %s", ret))
+		return
+	}
+	runtimeIgnores.u = ignored
+	if ignore {
+		Log(Audit, fmt.Sprintf("User '%s' was ignored at runtime by %s", user, r.User))
+		r.Say(fmt.Sprintf("Ignoring '%s' until they're unignored", user))
+		return
+	}
+	Log(Audit, fmt.Sprintf("User '%s' was unignored at runtime by %s", user, r.User))
+	r.Say(fmt.Sprintf("No longer ignoring '%s'", user))
+}
@@ -0,0 +1,478 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* incident.go - the incident builtin, a structured incident lifecycle.
+   Opening an incident creates a channel for it when CreateChannels is set and
+   the connector is a ChannelCreator; otherwise the incident is handled in a
+   thread of the channel where it was opened, or in the channel itself for
+   connectors without threads. Incidents, with their participants and
+   timelines, are kept in the brain; the timeline is also written to the
+   history provider with the "incident" tag and the incident number as the
+   run index. When an incident is closed, a summary is posted to the
+   incident channel and the configured SummaryChannel.
+*/
+
+const incidentKey = "bot:incidents"
+
+// history tag for incident timelines
+const incidentHistoryTag = "incident"
+
+const defaultIncidentChannelPrefix = "incident-"
+
+// defaultIncidentsKept is how many closed incidents (and timelines) are kept
+// when KeepClosed isn't set.
+const defaultIncidentsKept = 20
+
+var defaultSeverities = []string{"SEV1", "SEV2", "SEV3", "SEV4"}
+
+const incidentTimeFormat = "Mon Jan 2 15:04:05 MST 2006"
+
+type incidentConfig struct {
+	SummaryChannel  string   // channel for summaries of closed incidents
+	CreateChannels  bool     // create a channel for each incident, when the connector can
+	ChannelPrefix   string   // prefix for incident channel names, default "incident-"
+	Severities      []string // allowed severities, most severe first
+	DefaultSeverity string   // severity when none is given, default the least severe
+	KeepClosed      int      // number of closed incidents to remember
+}
+
+// incidentEvent is a single timeline entry
+type incidentEvent struct {
+	Time  time.Time
+	User  string
+	Event string
+}
+
+// incident is a single incident and its timeline
+type incident struct {
+	Number       int
+	Title        string
+	Severity     string
+	Status       string
+	Open         bool
+	Channel      string // channel where the incident is handled
+	Thread       string // thread in Channel, when handled in a thread
+	OpenedBy     string
+	Opened       time.Time
+	Closed       time.Time
+	Participants []string
+	Timeline     []incidentEvent
+}
+
+// incidentList is the datum stored in the brain
+type incidentList struct {
+	NextNumber int
+	Incidents  []*incident
+}
+
+func init() {
+	RegisterPlugin("builtin-incident", PluginHandler{
+		Handler: incidents,
+		Config:  &incidentConfig{},
+	})
+}
+
+// incidentSettings returns the plugin configuration with defaults applied
+func incidentSettings(r *Robot) *incidentConfig {
+	var cfg *incidentConfig
+	settings := &incidentConfig{}
+	if ret := r.GetTaskConfig(&cfg); ret == Ok {
+		*settings = *cfg
+	}
+	if len(settings.ChannelPrefix) == 0 {
+		settings.ChannelPrefix = defaultIncidentChannelPrefix
+	}
+	if len(settings.Severities) == 0 {
+		settings.Severities = defaultSeverities
+	}
+	if len(settings.DefaultSeverity) == 0 {
+		settings.DefaultSeverity = settings.Severities[len(settings.Severities)-1]
+	}
+	if settings.KeepClosed <= 0 {
+		settings.KeepClosed = defaultIncidentsKept
+	}
+	return settings
+}
+
+// severity normalizes a severity to the configured form, returning false
+// if it isn't one of the Severities.
+func (cfg *incidentConfig) severity(sev string) (string, bool) {
+	for _, s := range cfg.Severities {
+		if strings.EqualFold(s, sev) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// record adds an event to the timeline, and the user to the participants
+func (inc *incident) record(user, event string) {
+	inc.Timeline = append(inc.Timeline, incidentEvent{time.Now(), user, event})
+	for _, p := range inc.Participants {
+		if p == user {
+			return
+		}
+	}
+	inc.Participants = append(inc.Participants, user)
+}
+
+// location describes where the incident is handled
+func (inc *incident) location() string {
+	if len(inc.Thread) > 0 {
+		return fmt.Sprintf("a thread in channel '%s'", inc.Channel)
+	}
+	return fmt.Sprintf("channel '%s'", inc.Channel)
+}
+
+// timeline formats the timeline, one event per line
+func (inc *incident) timeline(tz *time.Location) []string {
+	lines := make([]string, 0, len(inc.Timeline))
+	for _, e := range inc.Timeline {
+		t := e.Time
+		if tz != nil {
+			t = t.In(tz)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s", t.Format(incidentTimeFormat), e.User, e.Event))
+	}
+	return lines
+}
+
+// post sends a message to the incident channel or thread
+func (inc *incident) post(r *Robot, msg string) {
+	r.InThread(inc.Thread).SendChannelMessage(inc.Channel, msg)
+}
+
+// pruneIncidents drops the oldest closed incidents beyond keep
+func pruneIncidents(il *incidentList, keep int) {
+	closed := 0
+	for _, inc := range il.Incidents {
+		if !inc.Open {
+			closed++
+		}
+	}
+	kept := make([]*incident, 0, len(il.Incidents))
+	for _, inc := range il.Incidents {
+		if !inc.Open && closed > keep {
+			closed--
+			continue
+		}
+		kept = append(kept, inc)
+	}
+	il.Incidents = kept
+}
+
+// findIncident finds the incident a command refers to: the given number,
+// or else the open incident for the channel / thread the command came from.
+// It returns "" or a reply for the user when the incident can't be found.
+func (il *incidentList) findIncident(r *Robot, number string) (*incident, string) {
+	if len(number) > 0 {
+		n, _ := strconv.Atoi(number)
+		for _, inc := range il.Incidents {
+			if inc.Number == n {
+				return inc, ""
+			}
+		}
+		return nil, fmt.Sprintf("Sorry, I don't have an incident #%s", number)
+	}
+	var thread string
+	if r.Incoming != nil {
+		thread = r.Incoming.ThreadID
+	}
+	var candidates []*incident
+	for _, inc := range il.Incidents {
+		if !inc.Open || inc.Channel != r.Channel {
+			continue
+		}
+		if len(thread) > 0 && inc.Thread == thread {
+			return inc, ""
+		}
+		candidates = append(candidates, inc)
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, "Sorry, there's no open incident here; give the incident number, e.g. 'incident 3 status ...'"
+	case 1:
+		return candidates[0], ""
+	}
+	return nil, "There's more than one open incident here; give the incident number, e.g. 'incident 3 status ...'"
+}
+
+// writeIncidentHistory writes the full timeline to the history provider,
+// replacing any earlier version.
+func writeIncidentHistory(inc *incident, keep int, tz *time.Location) {
+	botCfg.RLock()
+	hp := botCfg.history
+	botCfg.RUnlock()
+	if hp == nil {
+		return
+	}
+	hl, err := hp.NewHistory(incidentHistoryTag, inc.Number, keep)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Error writing history for incident #%d: %v", inc.Number, err))
+		return
+	}
+	hl.Section(fmt.Sprintf("incident #%d", inc.Number), fmt.Sprintf("%s (%s)", inc.Title, inc.Severity))
+	for _, line := range inc.timeline(tz) {
+		hl.Log(line)
+	}
+	hl.Close()
+}
+
+// incidentHistoryURL returns a link to the timeline, if the history
+// provider has one.
+func incidentHistoryURL(number int) (string, bool) {
+	botCfg.RLock()
+	hp := botCfg.history
+	botCfg.RUnlock()
+	if hp == nil {
+		return "", false
+	}
+	return hp.GetHistoryURL(incidentHistoryTag, number)
+}
+
+// reserveIncidentNumber allocates the next incident number
+func reserveIncidentNumber() (int, bool) {
+	var il incidentList
+	tok, _, ret := checkoutDatum(incidentKey, &il, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to open incident", incidentKey))
+		return 0, false
+	}
+	if il.NextNumber == 0 {
+		il.NextNumber = 1
+	}
+	number := il.NextNumber
+	il.NextNumber++
+	if ret := updateDatum(incidentKey, tok, il); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to open incident", incidentKey))
+		return 0, false
+	}
+	return number, true
+}
+
+func openIncident(r *Robot, cfg *incidentConfig, sev, title string) {
+	severity := cfg.DefaultSeverity
+	if len(sev) > 0 {
+		var ok bool
+		if severity, ok = cfg.severity(sev); !ok {
+			r.Say(fmt.Sprintf("Sorry, '%s' isn't a valid severity; use one of: %s", sev, strings.Join(cfg.Severities, ", ")))
+			return
+		}
+	}
+	number, ok := reserveIncidentNumber()
+	if !ok {
+		r.Say("I had a problem opening the incident, check the log")
+		return
+	}
+	inc := &incident{
+		Number:   number,
+		Title:    title,
+		Severity: severity,
+		Status:   "investigating",
+		Open:     true,
+		Channel:  r.Channel,
+		OpenedBy: r.User,
+		Opened:   time.Now(),
+	}
+	created := false
+	if cfg.CreateChannels {
+		name := fmt.Sprintf("%s%d", cfg.ChannelPrefix, number)
+		channel, ret, supported := createChannel(r.connector(), name, []string{r.ProtocolUser})
+		switch {
+		case !supported:
+			r.Log(Debug, "Connector can't create channels, handling incident in the current channel")
+		case ret != Ok:
+			r.Log(Warn, fmt.Sprintf("Creating channel '%s' for incident #%d failed (%s), handling incident in the current channel", name, number, ret))
+		default:
+			inc.Channel = channel
+			created = true
+		}
+	}
+	if !created && connectorSupports(r.connector(), CapThreads) && r.Incoming != nil {
+		inc.Thread = r.Incoming.ThreadID
+		if len(inc.Thread) == 0 {
+			inc.Thread = r.Incoming.MessageID
+		}
+	}
+	inc.record(r.User, fmt.Sprintf("Incident opened (%s): %s", severity, title))
+
+	var il incidentList
+	tok, _, ret := checkoutDatum(incidentKey, &il, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to store incident #%d", incidentKey, number))
+		r.Say("I had a problem opening the incident, check the log")
+		return
+	}
+	il.Incidents = append(il.Incidents, inc)
+	if ret := updateDatum(incidentKey, tok, il); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to store incident #%d", incidentKey, number))
+		r.Say("I had a problem opening the incident, check the log")
+		return
+	}
+	r.Log(Audit, fmt.Sprintf("User '%s' opened incident #%d (%s) in %s: %s", r.User, number, severity, inc.location(), title))
+	tz := r.getContext().timeZone
+	writeIncidentHistory(inc, cfg.KeepClosed, tz)
+	if created {
+		r.Say(fmt.Sprintf("Opened incident #%d (%s): %s - please join channel '%s'", number, severity, title, inc.Channel))
+	}
+	inc.post(r, fmt.Sprintf("Incident #%d (%s) opened by %s: %s\nUse 'incident status <update>', 'incident severity <severity>', 'incident note <text>' and 'close incident: <summary>' to update it", number, severity, r.User, title))
+}
+
+// updateIncident handles all the commands that change an open incident
+func updateIncident(r *Robot, cfg *incidentConfig, command string, args ...string) {
+	number := args[0]
+	var arg string
+	if len(args) > 1 {
+		arg = strings.TrimSpace(args[1])
+	}
+	var il incidentList
+	tok, _, ret := checkoutDatum(incidentKey, &il, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to update incident", incidentKey))
+		r.Say("I had a problem retrieving incidents, check the log")
+		return
+	}
+	inc, problem := il.findIncident(r, number)
+	if inc == nil {
+		checkinDatum(incidentKey, tok)
+		r.Say(problem)
+		return
+	}
+	if !inc.Open {
+		checkinDatum(incidentKey, tok)
+		r.Say(fmt.Sprintf("Incident #%d is already closed", inc.Number))
+		return
+	}
+	var announce string
+	switch command {
+	case "status":
+		inc.Status = arg
+		inc.record(r.User, "Status: "+arg)
+		announce = fmt.Sprintf("Incident #%d status from %s: %s", inc.Number, r.User, arg)
+	case "severity":
+		severity, ok := cfg.severity(arg)
+		if !ok {
+			checkinDatum(incidentKey, tok)
+			r.Say(fmt.Sprintf("Sorry, '%s' isn't a valid severity; use one of: %s", arg, strings.Join(cfg.Severities, ", ")))
+			return
+		}
+		inc.record(r.User, fmt.Sprintf("Severity changed from %s to %s", inc.Severity, severity))
+		announce = fmt.Sprintf("Incident #%d severity changed from %s to %s by %s", inc.Number, inc.Severity, severity, r.User)
+		inc.Severity = severity
+	case "note":
+		inc.record(r.User, "Note: "+arg)
+	case "join":
+		inc.record(r.User, "Joined the incident")
+	case "close":
+		inc.Open = false
+		inc.Closed = time.Now()
+		event := "Incident closed"
+		if len(arg) > 0 {
+			event += ": " + arg
+		}
+		inc.record(r.User, event)
+		pruneIncidents(&il, cfg.KeepClosed)
+	}
+	if ret := updateDatum(incidentKey, tok, il); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to update incident #%d", incidentKey, inc.Number))
+		r.Say("I had a problem updating the incident, check the log")
+		return
+	}
+	tz := r.getContext().timeZone
+	writeIncidentHistory(inc, cfg.KeepClosed, tz)
+	switch command {
+	case "note":
+		r.Say(fmt.Sprintf("Added to the timeline for incident #%d", inc.Number))
+	case "join":
+		r.Say(fmt.Sprintf("Added %s to the participants for incident #%d", r.User, inc.Number))
+	case "close":
+		r.Log(Audit, fmt.Sprintf("User '%s' closed incident #%d", r.User, inc.Number))
+		summary := inc.summary(arg)
+		inc.post(r, summary)
+		if len(cfg.SummaryChannel) > 0 && (cfg.SummaryChannel != inc.Channel || len(inc.Thread) > 0) {
+			r.SendChannelMessage(cfg.SummaryChannel, summary)
+		}
+	default:
+		inc.post(r, announce)
+	}
+}
+
+// summary describes a closed incident
+func (inc *incident) summary(closing string) string {
+	s := make([]string, 0, 6)
+	s = append(s, fmt.Sprintf("Incident #%d closed: %s", inc.Number, inc.Title))
+	duration := inc.Closed.Sub(inc.Opened).Round(time.Second)
+	s = append(s, fmt.Sprintf("Severity: %s; duration: %s; last status: %s", inc.Severity, duration, inc.Status))
+	if len(closing) > 0 {
+		s = append(s, "Summary: "+closing)
+	}
+	s = append(s, "Participants: "+strings.Join(inc.Participants, ", "))
+	if url, ok := incidentHistoryURL(inc.Number); ok {
+		s = append(s, "Timeline: "+url)
+	} else {
+		s = append(s, fmt.Sprintf("Timeline: %d events; use 'incident %d timeline' for details", len(inc.Timeline), inc.Number))
+	}
+	return strings.Join(s, "\n")
+}
+
+func incidents(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	cfg := incidentSettings(r)
+	switch command {
+	case "open":
+		openIncident(r, cfg, args[0], strings.TrimSpace(args[1]))
+	case "list":
+		var il incidentList
+		_, _, ret := checkoutDatum(incidentKey, &il, false)
+		if ret != Ok {
+			r.Say("I had a problem retrieving incidents, check the log")
+			return
+		}
+		all := len(args[0]) > 0
+		ll := make([]string, 0, len(il.Incidents)+1)
+		for _, inc := range il.Incidents {
+			if !inc.Open && !all {
+				continue
+			}
+			state := "open, " + inc.location()
+			if !inc.Open {
+				state = "closed"
+			}
+			ll = append(ll, fmt.Sprintf("#%d (%s) %s - %s; status: %s", inc.Number, inc.Severity, inc.Title, state, inc.Status))
+		}
+		if len(ll) == 0 {
+			r.Say("There are no open incidents")
+			return
+		}
+		r.MessageFormat(Variable).Say("Incidents:\n" + strings.Join(ll, "\n"))
+	case "timeline":
+		var il incidentList
+		_, _, ret := checkoutDatum(incidentKey, &il, false)
+		if ret != Ok {
+			r.Say("I had a problem retrieving incidents, check the log")
+			return
+		}
+		inc, problem := il.findIncident(r, args[0])
+		if inc == nil {
+			r.Say(problem)
+			return
+		}
+		tl := []string{fmt.Sprintf("Timeline for incident #%d (%s): %s", inc.Number, inc.Severity, inc.Title)}
+		tl = append(tl, inc.timeline(r.getContext().timeZone)...)
+		if url, ok := incidentHistoryURL(inc.Number); ok {
+			tl = append(tl, "Link: "+url)
+		}
+		r.Fixed().Say(strings.Join(tl, "\n"))
+	default:
+		updateIncident(r, cfg, command, args...)
+	}
+	return
+}
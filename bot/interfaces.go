@@ -14,6 +14,12 @@ type Handler interface {
 	// can hear. See the fields for ConnectorMessage for information about
 	// this object.
 	IncomingMessage(*ConnectorMessage)
+	// IncomingReaction is called by connectors declaring CapReactionEvents
+	// when a user adds a reaction to a message; see ConnectorReaction.
+	IncomingReaction(*ConnectorReaction)
+	// IncomingAction is called by connectors declaring CapBlocks when a user
+	// clicks a button in a message; see ConnectorAction.
+	IncomingAction(*ConnectorAction)
 	// GetProtocolConfig unmarshals the ProtocolConfig section of gopherbot.yaml
 	// into a connector-provided struct
 	GetProtocolConfig(interface{}) error
@@ -36,6 +42,8 @@ type Handler interface {
 	GetInstallPath() string
 	// GetConfigPath returns the path to the config directory if set
 	GetConfigPath() string
+	// GetWorkSpace returns the path to the robot's read/write WorkSpace
+	GetWorkSpace() string
 	// Log provides a standard logging interface with a level as defined in
 	// bot/logging.go
 	Log(l LogLevel, v ...interface{})
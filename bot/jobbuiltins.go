@@ -177,6 +177,9 @@ func jobhistory(r *Robot, command string, args ...string) (retval TaskRetVal) {
 		latest = args[1]
 		histSpec = args[2]
 		index = args[3]
+	case "showlog":
+		histSpec = args[0]
+		index = args[1]
 	case "mailhistory":
 		histType = "email"
 		latest = args[0]
@@ -199,7 +202,7 @@ func jobhistory(r *Robot, command string, args ...string) (retval TaskRetVal) {
 	vr := r.MessageFormat(Variable)
 
 	switch command {
-	case "history", "mailhistory":
+	case "history", "showlog", "mailhistory":
 		botCfg.RLock()
 		hp := botCfg.history
 		if hp == nil {
@@ -253,10 +256,14 @@ func jobhistory(r *Robot, command string, args ...string) (retval TaskRetVal) {
 
 		var idx int
 		if len(latest) == 0 && len(index) == 0 {
-			hl := make([]string, len(jh.Histories)+1)
+			hl := make([]string, 0, len(jh.Histories)+1)
 			hl = append(hl, fmt.Sprintf("History of job runs for '%s':", histSpec))
 			for _, he := range jh.Histories {
-				hl = append(hl, fmt.Sprintf("Run %d - %s", he.LogIndex, he.CreateTime))
+				status := he.Status
+				if len(status) == 0 {
+					status = "running"
+				}
+				hl = append(hl, fmt.Sprintf("Run %d - %s - %s", he.LogIndex, he.CreateTime, status))
 			}
 			vr.Say(strings.Join(hl, "\n"))
 			rep, ret := r.PromptForReply("selection", "Which run #?")
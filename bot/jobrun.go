@@ -60,6 +60,7 @@ func (c *botContext) checkJobMatchersAndRun() (messageMatched bool) {
 				messageMatched = true
 				newbot := c.clone()
 				newbot.automaticTask = true
+				newbot.setGroupParameters(trigger.groups, triggerArgs)
 				robots = append(robots, newbot)
 				runTasks = append(runTasks, t)
 				taskArgs = append(taskArgs, triggerArgs)
@@ -167,7 +168,8 @@ func (c *botContext) checkJobMatchersAndRun() (messageMatched bool) {
 			}
 			c.deregister()
 			c.verbose = true
-			c.startPipeline(nil, t, jobCmd, "run", args...)
+			c.dispatchRet = c.startPipeline(nil, t, jobCmd, "run", args...)
+			c.dispatched = true
 		} // jobAvailable sends a message if it's not
 	}
 	return
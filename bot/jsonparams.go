@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* jsonparams.go - JSONParameters for job triggers, setting parameters from
+   fields of a webhook's JSON payload, for when a regex over the raw body
+   would be brittle. Each parameter is given a JSONPath-style expression,
+   e.g. "$.repository.full_name" or "$.commits[0].id"; expressions are
+   checked when the configuration loads. A field that's missing from the
+   payload leaves the parameter empty. Strings are set as-is, and other
+   values as JSON.
+*/
+
+// jsonStep is one step in a jsonPath, a key or an array index
+type jsonStep struct {
+	key   string
+	index int // used when key is ""
+}
+
+type jsonPath []jsonStep
+
+// parseJSONPath parses an expression like $.a.b[2]['c d']; the leading "$"
+// is optional.
+func parseJSONPath(expr string) (jsonPath, error) {
+	var path jsonPath
+	rest := strings.TrimPrefix(strings.TrimSpace(expr), "$")
+	if len(rest) > 0 && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in '%s'", expr)
+			}
+			path = append(path, jsonStep{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing ']' in '%s'", expr)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				if len(sel) == 2 {
+					return nil, fmt.Errorf("empty key in '%s'", expr)
+				}
+				path = append(path, jsonStep{key: sel[1 : len(sel)-1]})
+				continue
+			}
+			i, err := strconv.Atoi(sel)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid index '[%s]' in '%s'", sel, expr)
+			}
+			path = append(path, jsonStep{index: i})
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in '%s'", rest[0], expr)
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("no fields in '%s'", expr)
+	}
+	return path, nil
+}
+
+// lookup finds the value at the path in a decoded JSON document
+func (path jsonPath) lookup(doc interface{}) (interface{}, bool) {
+	v := doc
+	for _, step := range path {
+		if len(step.key) > 0 {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[step.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		arr, ok := v.([]interface{})
+		if !ok || step.index >= len(arr) {
+			return nil, false
+		}
+		v = arr[step.index]
+	}
+	return v, true
+}
+
+// compileJSONParameters checks a trigger's JSONParameters when the
+// configuration loads.
+func (trigger *JobTrigger) compileJSONParameters() error {
+	if len(trigger.JSONParameters) == 0 {
+		return nil
+	}
+	trigger.jsonPaths = make(map[string]jsonPath, len(trigger.JSONParameters))
+	for name, expr := range trigger.JSONParameters {
+		if !groupNameRe.MatchString(name) {
+			return fmt.Errorf("invalid JSONParameters name '%s', must be a valid parameter name", name)
+		}
+		path, err := parseJSONPath(expr)
+		if err != nil {
+			return fmt.Errorf("JSONParameters '%s': %v", name, err)
+		}
+		trigger.jsonPaths[name] = path
+	}
+	return nil
+}
+
+// setJSONParameters sets the trigger's JSONParameters from a webhook payload
+func (c *botContext) setJSONParameters(jobName string, trigger *JobTrigger, payload []byte) {
+	if len(trigger.jsonPaths) == 0 {
+		return
+	}
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		Log(Warn, fmt.Sprintf("Webhook payload for job '%s' isn't JSON, leaving JSONParameters empty: %v", jobName, err))
+		doc = nil
+	}
+	for name, path := range trigger.jsonPaths {
+		c.environment[name] = ""
+		if doc == nil {
+			continue
+		}
+		v, found := path.lookup(doc)
+		if !found {
+			Log(Warn, fmt.Sprintf("Webhook payload for job '%s' has no '%s', leaving parameter '%s' empty", jobName, trigger.JSONParameters[name], name))
+			continue
+		}
+		switch val := v.(type) {
+		case nil:
+		case string:
+			c.environment[name] = val
+		default:
+			js, _ := json.Marshal(val)
+			c.environment[name] = string(js)
+		}
+	}
+}
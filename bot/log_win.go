@@ -11,50 +11,43 @@ import (
 
 var eventLog *eventlog.Log
 
-// Log logs messages whenever the connector log level is
-// less than the given level
-func Log(l LogLevel, v ...interface{}) bool {
-
+// writeLog writes a log message regardless of the log level
+func writeLog(l LogLevel, v ...interface{}) {
 	botLogger.Lock()
-	currlevel := botLogger.level
 	logger := botLogger.l
 	botLogger.Unlock()
 
-	if l >= currlevel || l == Audit {
-		prefix := logLevelToStr(l) + ":"
-		p := []interface{}{prefix}
-		var msg string
-		if len(v) == 1 {
-			msg = fmt.Sprintln(prefix, v[0])
-		} else {
-			v = append(p, v...)
-			msg = fmt.Sprintln(v...)
-		}
+	prefix := logLevelToStr(l) + ":"
+	p := []interface{}{prefix}
+	var msg string
+	if len(v) == 1 {
+		msg = fmt.Sprintln(prefix, v[0])
+	} else {
+		v = append(p, v...)
+		msg = fmt.Sprintln(v...)
+	}
 
-		if l == Fatal {
-			if eventLog != nil {
-				eventLog.Error(1, "Fatal error: "+msg)
-			}
-			logger.Fatal(msg)
-		} else {
-			logger.Print(msg)
-			if eventLog != nil {
-				switch l {
-				case Info, Audit:
-					eventLog.Info(1, msg)
-				case Warn:
-					eventLog.Warning(1, msg)
-				case Error:
-					eventLog.Error(1, msg)
-				}
+	if l == Fatal {
+		if eventLog != nil {
+			eventLog.Error(1, "Fatal error: "+msg)
+		}
+		logger.Fatal(msg)
+	} else {
+		logger.Print(msg)
+		if eventLog != nil {
+			switch l {
+			case Info, Audit:
+				eventLog.Info(1, msg)
+			case Warn:
+				eventLog.Warning(1, msg)
+			case Error:
+				eventLog.Error(1, msg)
 			}
-			tsMsg := fmt.Sprintf("%s %s", time.Now().Format("Jan 2 15:04:05"), msg)
-			botLogger.Lock()
-			botLogger.buffer[botLogger.buffLine] = tsMsg
-			botLogger.buffLine = (botLogger.buffLine + 1) % (buffLines - 1)
-			botLogger.Unlock()
 		}
-		return true
+		tsMsg := fmt.Sprintf("%s %s", time.Now().Format("Jan 2 15:04:05"), msg)
+		botLogger.Lock()
+		botLogger.buffer[botLogger.buffLine] = tsMsg
+		botLogger.buffLine = (botLogger.buffLine + 1) % (buffLines - 1)
+		botLogger.Unlock()
 	}
-	return false
 }
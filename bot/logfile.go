@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* logfile.go - the robot's log goes to the console, or the file given with
+   -l / GOPHER_LOGFILE, which grows without bound. LogFile in gopherbot.yaml
+   sends the log to a file that's rotated when it reaches MaxSize megabytes,
+   keeping at most MaxBackups rotated logs, none older than MaxAge. With
+   Console, the log is written to both. LogFile takes effect when the
+   configuration is loaded, and can be changed with a reload.
+*/
+
+// suffix of a rotated log, e.g. robot.log.20200102-150405.000
+const logRotateFormat = "20060102-150405.000"
+
+// LogFile configures writing the robot's log to a rotated file
+type LogFile struct {
+	Path       string // where to write the log
+	MaxSize    int    // rotate the log when it reaches this many megabytes; default 100
+	MaxBackups int    // how many rotated logs to keep; 0 keeps them all
+	MaxAge     string // remove rotated logs older than this, e.g. "7d"
+	Console    bool   // also write the log to the console
+}
+
+// rotatingLog is an io.Writer for a log file rotated by size; it's shared
+// by the robot's logger and the standard library logger, so writes are
+// serialized.
+type rotatingLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	f          *os.File
+	size       int64
+	sync.Mutex
+}
+
+// logDest tracks where the log is going; console is where Start sent it
+var logDest = struct {
+	cfg     LogFile
+	w       *rotatingLog
+	console io.Writer
+	sync.Mutex
+}{}
+
+func newRotatingLog(cfg LogFile) (*rotatingLog, error) {
+	rl := &rotatingLog{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSize) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+	}
+	if rl.maxSize <= 0 {
+		rl.maxSize = 100 * 1024 * 1024
+	}
+	if len(cfg.MaxAge) > 0 {
+		age, err := parseHistoryAge(cfg.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MaxAge '%s': %v", cfg.MaxAge, err)
+		}
+		rl.maxAge = age
+	}
+	if err := os.MkdirAll(filepath.Dir(rl.path), 0755); err != nil {
+		return nil, err
+	}
+	if err := rl.open(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// open opens the log for appending; called with the lock held
+func (rl *rotatingLog) open() error {
+	f, err := os.OpenFile(rl.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rl.f = f
+	rl.size = info.Size()
+	return nil
+}
+
+// Write writes a log line, first rotating the log if the line would take
+// it past maxSize.
+func (rl *rotatingLog) Write(p []byte) (int, error) {
+	rl.Lock()
+	defer rl.Unlock()
+	if rl.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rl.size > 0 && rl.size+int64(len(p)) > rl.maxSize {
+		if err := rl.rotate(); err != nil {
+			// keep logging to the old file rather than lose the message
+			fmt.Fprintf(os.Stderr, "Error rotating log file '%s': %v\n", rl.path, err)
+		}
+	}
+	n, err := rl.f.Write(p)
+	rl.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current log with a timestamp suffix and starts a new
+// one; called with the lock held.
+func (rl *rotatingLog) rotate() error {
+	rotated := rl.path + "." + time.Now().Format(logRotateFormat)
+	if err := os.Rename(rl.path, rotated); err != nil {
+		return err
+	}
+	rl.f.Close()
+	rl.f = nil
+	if err := rl.open(); err != nil {
+		return err
+	}
+	go rl.prune()
+	return nil
+}
+
+// prune removes rotated logs beyond maxBackups or older than maxAge
+func (rl *rotatingLog) prune() {
+	if rl.maxBackups <= 0 && rl.maxAge <= 0 {
+		return
+	}
+	matches, _ := filepath.Glob(rl.path + ".*")
+	type backup struct {
+		path    string
+		rotated time.Time
+	}
+	var backups []backup
+	for _, m := range matches {
+		t, err := time.ParseInLocation(logRotateFormat, strings.TrimPrefix(m, rl.path+"."), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{m, t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	cutoff := time.Now().Add(-rl.maxAge)
+	for i, b := range backups {
+		if (rl.maxBackups > 0 && i >= rl.maxBackups) || (rl.maxAge > 0 && b.rotated.Before(cutoff)) {
+			os.Remove(b.path)
+		}
+	}
+}
+
+// Close closes the log file
+func (rl *rotatingLog) Close() error {
+	rl.Lock()
+	defer rl.Unlock()
+	if rl.f == nil {
+		return nil
+	}
+	err := rl.f.Close()
+	rl.f = nil
+	return err
+}
+
+// setLogFile directs the log according to LogFile; called when the
+// configuration is loaded. With no LogFile, the log goes back to the
+// console.
+func setLogFile(cfg *LogFile) {
+	logDest.Lock()
+	defer logDest.Unlock()
+	botLogger.Lock()
+	logger := botLogger.l
+	botLogger.Unlock()
+	if logDest.console == nil {
+		logDest.console = logger.Writer()
+	}
+	old := logDest.w
+	if cfg == nil || len(cfg.Path) == 0 {
+		if old == nil {
+			return
+		}
+		logger.SetOutput(logDest.console)
+		log.SetOutput(logDest.console)
+		logDest.w = nil
+		logDest.cfg = LogFile{}
+		old.Close()
+		Log(Info, "LogFile removed from configuration, logging to the console")
+		return
+	}
+	if old != nil && *cfg == logDest.cfg {
+		return
+	}
+	rl, err := newRotatingLog(*cfg)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Unable to log to '%s', continuing with the current log: %v", cfg.Path, err))
+		return
+	}
+	Log(Info, fmt.Sprintf("Switching log to file '%s'", cfg.Path))
+	var out io.Writer = rl
+	if cfg.Console {
+		out = io.MultiWriter(logDest.console, rl)
+	} else {
+		logToFile = true
+	}
+	logger.SetOutput(out)
+	log.SetOutput(out)
+	logDest.w = rl
+	logDest.cfg = *cfg
+	if old != nil {
+		old.Close()
+	}
+	Log(Info, fmt.Sprintf("Logging to file '%s', rotating at %d MB", cfg.Path, rl.maxSize/(1024*1024)))
+}
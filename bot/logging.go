@@ -44,6 +44,46 @@ var botLogger = struct {
 	sync.Mutex{},
 }
 
+// Log logs messages whenever the connector log level is
+// less than the given level
+func Log(l LogLevel, v ...interface{}) bool {
+	if l >= getLogLevel() || l == Audit {
+		writeLog(l, v...)
+		return true
+	}
+	return false
+}
+
+// log logs a message about the context's current task; a task's LogLevel
+// makes messages about it more verbose than the robot's log level.
+func (c *botContext) log(l LogLevel, v ...interface{}) bool {
+	if c.currentTask != nil {
+		task, _, _ := getTask(c.currentTask)
+		if tl, ok := task.logLevel(); ok && l >= tl && l < getLogLevel() {
+			writeLog(l, v...)
+			return true
+		}
+	}
+	return Log(l, v...)
+}
+
+// logLevel returns the task's LogLevel, if it has one
+func (task *BotTask) logLevel() (LogLevel, bool) {
+	if len(task.LogLevel) == 0 {
+		return Trace, false
+	}
+	return logStrToLevel(task.LogLevel), true
+}
+
+// validLogLevel checks a configured log level
+func validLogLevel(l string) bool {
+	switch strings.ToLower(l) {
+	case "trace", "debug", "info", "audit", "warn", "error":
+		return true
+	}
+	return false
+}
+
 func logStrToLevel(l string) LogLevel {
 	switch strings.ToLower(l) {
 	case "trace":
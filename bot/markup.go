@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/* markup.go - chat platforms disagree on markup; Slack bolds *text*, where
+   Mattermost and others want **text**. Messages sent with the Markup format
+   use a neutral, markdown-like markup that's rendered for the connector the
+   message goes to:
+     **bold**, _italic_, ~~strikethrough~~, [text](url)
+   Code spans and ``` blocks are left as-is. Connectors implementing
+   MarkupRenderer render the markup for their platform; messages for other
+   connectors get plain text, with the markup removed. Raw, Fixed and
+   Variable messages are never translated.
+*/
+
+// MarkupRenderer is an optional interface for Connectors that can render
+// Markup format messages in the platform's own markup. RenderMarkup is
+// called with each stretch of text outside of code, and the rendered
+// message is sent with the Raw format.
+type MarkupRenderer interface {
+	RenderMarkup(text string) string
+}
+
+var (
+	markupCodeRe   = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+	markupBoldRe   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markupItalicRe = regexp.MustCompile(`(^|[^\w])_([^_\n]+)_([^\w]|$)`)
+	markupStrikeRe = regexp.MustCompile(`~~([^~\n]+)~~`)
+	markupLinkRe   = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+)
+
+// markupText splits a Markup format message into code and the text around
+// it, returning the message with render applied to the text.
+func markupText(msg string, render func(text string) string) string {
+	var rendered strings.Builder
+	last := 0
+	for _, loc := range markupCodeRe.FindAllStringIndex(msg, -1) {
+		rendered.WriteString(render(msg[last:loc[0]]))
+		rendered.WriteString(msg[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	rendered.WriteString(render(msg[last:]))
+	return rendered.String()
+}
+
+// ReplaceMarkup replaces the neutral markup in text using fmt templates,
+// where the argument is the marked-up text; the link template gets the
+// text and then the url. Connectors can use it to implement RenderMarkup,
+// e.g. ReplaceMarkup(text, "*%s*", "_%s_", "~%s~", "<%[2]s|%[1]s>").
+func ReplaceMarkup(text, bold, italic, strike, link string) string {
+	text = markupBoldRe.ReplaceAllStringFunc(text, func(m string) string {
+		return fmt.Sprintf(bold, markupBoldRe.FindStringSubmatch(m)[1])
+	})
+	text = markupItalicRe.ReplaceAllStringFunc(text, func(m string) string {
+		sm := markupItalicRe.FindStringSubmatch(m)
+		return sm[1] + fmt.Sprintf(italic, sm[2]) + sm[3]
+	})
+	text = markupStrikeRe.ReplaceAllStringFunc(text, func(m string) string {
+		return fmt.Sprintf(strike, markupStrikeRe.FindStringSubmatch(m)[1])
+	})
+	return markupLinkRe.ReplaceAllStringFunc(text, func(m string) string {
+		sm := markupLinkRe.FindStringSubmatch(m)
+		return fmt.Sprintf(link, sm[1], sm[2])
+	})
+}
+
+// plainMarkup removes the markup for connectors that don't render it
+func plainMarkup(text string) string {
+	return ReplaceMarkup(text, "%s", "%s", "%s", "%s (%s)")
+}
+
+// renderMarkup renders a Markup format message for a connector, returning
+// the message to send and it's new format; other formats are unchanged.
+func renderMarkup(conn Connector, msg string, f MessageFormat) (string, MessageFormat) {
+	if f != Markup {
+		return msg, f
+	}
+	render := plainMarkup
+	if mr, ok := conn.(MarkupRenderer); ok {
+		render = mr.RenderMarkup
+	}
+	return markupText(msg, render), Raw
+}
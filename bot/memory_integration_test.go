@@ -57,3 +57,32 @@ func TestMemory(t *testing.T) {
 
 	teardown(t, done, conn)
 }
+
+func TestMemoryLockExpired(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";count up", []testc.TestMessage{{null, general, "Lock expired, checking out again"}, {null, general, "Counter is 2"}, {null, general, "No checkout for that token"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, general, ";count up", []testc.TestMessage{{null, general, "Lock expired, checking out again"}, {null, general, "Counter is 4"}, {null, general, "No checkout for that token"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestBrainList(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, null, "brain list bot", []testc.TestMessage{{alice, null, "I don't have any memories in namespace 'bot'"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";add user bob to group deployers", []testc.TestMessage{{null, general, "Ok, I added bob to the deployers group"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "brain list bot", []testc.TestMessage{{alice, null, "(?s:^MEMORIES IN NAMESPACE 'BOT':.*BOT:GROUPS)"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, null, "brain dump bot", []testc.TestMessage{{alice, null, "Sorry, I won't dump the 'bot' namespace.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";count up", []testc.TestMessage{{null, general, "Lock expired, checking out again"}, {null, general, "Counter is 2"}, {null, general, "No checkout for that token"}}, []Event{CommandTaskRan, ExternalTaskRan}, 0},
+		{aliceID, null, "brain dump test", []testc.TestMessage{{alice, null, `(?s:"TEST:COUNTER": 2)`}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{bobID, null, "brain dump bot", []testc.TestMessage{{bob, null, "Sorry, that didn't match any commands.*"}}, []Event{BotDirectMessage, CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
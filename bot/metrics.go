@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+/* metrics.go - /metrics on the robot's http listener, in the Prometheus
+   text format, for watching how busy the robot is: pipelines running in
+   each pipeline pool against MaxPipelines, plugins against their
+   MaxConcurrent, the command shaper's rate and queue, and plugin panics and
+   crashes. A max of 0 is unlimited.
+*/
+
+// metricSample is one labelled value of a metric
+type metricSample struct {
+	label string
+	value int
+}
+
+// writeMetric writes a metric's HELP and TYPE, then it's samples
+func writeMetric(w io.Writer, name, mtype, help, label string, samples []metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, mtype)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, s.label, s.value)
+	}
+}
+
+// poolSamples picks one value from each pool for a metric
+func poolSamples(pools []poolMetric, value func(poolMetric) int) []metricSample {
+	samples := make([]metricSample, len(pools))
+	for i, p := range pools {
+		samples[i] = metricSample{p.name, value(p)}
+	}
+	return samples
+}
+
+// metricsHandler serves /metrics
+type metricsHandler struct{}
+
+func (metricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	botCfg.RLock()
+	active := botCfg.pluginsRunning
+	botCfg.RUnlock()
+	fmt.Fprintf(w, "# HELP gopherbot_pipelines_active Pipelines running, including sub-jobs and builtin-admin\n# TYPE gopherbot_pipelines_active gauge\ngopherbot_pipelines_active %d\n", active)
+
+	pools := pipelineMetrics()
+	writeMetric(w, "gopherbot_pipelines_running", "gauge", "Pipelines running in each pipeline pool", "pool", poolSamples(pools, func(p poolMetric) int { return p.running }))
+	writeMetric(w, "gopherbot_pipelines_max", "gauge", "Maximum pipelines for each pipeline pool, 0 for unlimited", "pool", poolSamples(pools, func(p poolMetric) int { return p.max }))
+	writeMetric(w, "gopherbot_pipelines_queued", "gauge", "Pipelines waiting for a slot in each pipeline pool", "pool", poolSamples(pools, func(p poolMetric) int { return p.waiting }))
+	writeMetric(w, "gopherbot_pipelines_queue_max", "gauge", "MaxQueuedPipelines for each pipeline pool", "pool", poolSamples(pools, func(p poolMetric) int { return p.queueMax }))
+	writeMetric(w, "gopherbot_pipelines_rejected_total", "counter", "Pipelines rejected since start because the pool was full", "pool", poolSamples(pools, func(p poolMetric) int { return p.rejected }))
+
+	plugins := pluginMetrics()
+	writeMetric(w, "gopherbot_plugin_commands_running", "gauge", "Commands running for plugins with a MaxConcurrent", "plugin", poolSamples(plugins, func(p poolMetric) int { return p.running }))
+	writeMetric(w, "gopherbot_plugin_commands_max", "gauge", "MaxConcurrent for each plugin", "plugin", poolSamples(plugins, func(p poolMetric) int { return p.max }))
+	writeMetric(w, "gopherbot_plugin_commands_queued", "gauge", "Commands waiting for MaxConcurrent", "plugin", poolSamples(plugins, func(p poolMetric) int { return p.waiting }))
+	writeMetric(w, "gopherbot_plugin_commands_rejected_total", "counter", "Commands rejected since start because the plugin's queue was full", "plugin", poolSamples(plugins, func(p poolMetric) int { return p.rejected }))
+
+	rate, waiting, queueMax, rejected := shaperMetrics()
+	fmt.Fprintf(w, "# HELP gopherbot_command_shaper_rate CommandRate in commands per second, 0 when commands aren't paced\n# TYPE gopherbot_command_shaper_rate gauge\ngopherbot_command_shaper_rate %g\n", rate)
+	fmt.Fprintf(w, "# HELP gopherbot_command_shaper_queued Commands waiting for the command shaper\n# TYPE gopherbot_command_shaper_queued gauge\ngopherbot_command_shaper_queued %d\n", waiting)
+	fmt.Fprintf(w, "# HELP gopherbot_command_shaper_queue_max CommandQueue, the most commands that can wait for the command shaper\n# TYPE gopherbot_command_shaper_queue_max gauge\ngopherbot_command_shaper_queue_max %d\n", queueMax)
+	fmt.Fprintf(w, "# HELP gopherbot_command_shaper_rejected_total Commands rejected since start because the command shaper's queue was full\n# TYPE gopherbot_command_shaper_rejected_total counter\ngopherbot_command_shaper_rejected_total %d\n", rejected)
+
+	taskPanics.Lock()
+	panics := make([]metricSample, 0, len(taskPanics.count))
+	for name, n := range taskPanics.count {
+		panics = append(panics, metricSample{name, n})
+	}
+	taskPanics.Unlock()
+	sort.Slice(panics, func(i, j int) bool { return panics[i].label < panics[j].label })
+	writeMetric(w, "gopherbot_task_panics_total", "counter", "Go plugin panics and external plugin crashes since start", "task", panics)
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/* namedgroups.go - support for named capture groups, e.g. (?P<env>prod|stag),
+   in command & message matchers and job triggers. When a regex names it's
+   groups, matched values are set as pipeline parameters of the same name,
+   and a matcher's Contexts apply to the group with the context's name,
+   instead of by position. Regexes without named groups work as before.
+*/
+
+var groupNameRe = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// captureGroups maps group name to argument index for a compiled regex, or
+// returns nil if none of the groups are named.
+func captureGroups(re *regexp.Regexp) (map[string]int, error) {
+	var groups map[string]int
+	for i, name := range re.SubexpNames() {
+		if i == 0 || len(name) == 0 {
+			continue
+		}
+		if !groupNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid capture group name '%s', must be a valid parameter name", name)
+		}
+		if groups == nil {
+			groups = make(map[string]int)
+		}
+		// arguments don't include the full match
+		groups[name] = i - 1
+	}
+	return groups, nil
+}
+
+// compileGroups builds the map of named groups for a compiled matcher, and
+// makes sure every context of a matcher with named groups names one of the
+// groups.
+func (matcher *InputMatcher) compileGroups() error {
+	groups, err := captureGroups(matcher.re)
+	if err != nil {
+		return err
+	}
+	matcher.groups = groups
+	if groups == nil {
+		return nil
+	}
+	for _, contextLabel := range matcher.Contexts {
+		if contextLabel == "" {
+			continue
+		}
+		contextName := strings.Split(contextLabel, ":")[0]
+		if _, ok := groups[contextName]; !ok {
+			return fmt.Errorf("context '%s' doesn't name a capture group", contextName)
+		}
+	}
+	return nil
+}
+
+// setGroupParameters sets a pipeline parameter for each named group that
+// matched.
+func (c *botContext) setGroupParameters(groups map[string]int, args []string) {
+	for name, i := range groups {
+		if i < len(args) && len(args[i]) > 0 {
+			c.environment[name] = args[i]
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* namespaces.go - tasks that share a NameSpace share long-term memories and
+   stored parameters. checkNameSpaces warns at load time about shared
+   namespaces that probably don't work the way they were meant to, and
+   'list namespaces' shows which tasks share one.
+*/
+
+// nameSpaceTasks maps each namespace to the names of the tasks using it
+func nameSpaceTasks(tlist []interface{}) map[string][]string {
+	ns := make(map[string][]string)
+	for _, t := range tlist {
+		task, _, _ := getTask(t)
+		n := task.nameSpace()
+		ns[n] = append(ns[n], task.name)
+	}
+	for _, names := range ns {
+		sort.Strings(names)
+	}
+	return ns
+}
+
+// checkNameSpaces logs a warning for tasks that share a namespace but set
+// the same parameter to different values, since only one value can be in
+// effect; and for tasks whose NameSpace is the name of another task that
+// uses a different namespace, so the two don't actually share memories.
+func checkNameSpaces(tlist []interface{}) {
+	byName := make(map[string]*BotTask)
+	for _, t := range tlist {
+		task, _, _ := getTask(t)
+		byName[task.name] = task
+	}
+	for n, names := range nameSpaceTasks(tlist) {
+		if other, ok := byName[n]; ok && other.nameSpace() != n {
+			Log(Warn, fmt.Sprintf("Task(s) %s use NameSpace '%s', but task '%s' uses NameSpace '%s' and doesn't share their memories", strings.Join(names, ", "), n, n, other.nameSpace()))
+		}
+		if len(names) < 2 {
+			continue
+		}
+		type setting struct{ task, value string }
+		params := make(map[string]setting)
+		for _, name := range names {
+			for _, p := range byName[name].Parameters {
+				prev, ok := params[p.Name]
+				if !ok {
+					params[p.Name] = setting{name, p.Value}
+					continue
+				}
+				if prev.value != p.Value {
+					Log(Warn, fmt.Sprintf("Tasks '%s' and '%s' share NameSpace '%s' but have different values for parameter '%s'", prev.task, name, n, p.Name))
+				}
+			}
+		}
+	}
+}
+
+// nameSpaceListing is the 'list namespaces' admin command, showing the
+// namespaces shared by more than one task.
+func nameSpaceListing() string {
+	currentTasks.Lock()
+	tlist := currentTasks.t
+	currentTasks.Unlock()
+	ns := nameSpaceTasks(tlist)
+	shared := make([]string, 0, len(ns))
+	for n, names := range ns {
+		if len(names) > 1 {
+			shared = append(shared, fmt.Sprintf("%s: %s", n, strings.Join(names, ", ")))
+		}
+	}
+	if len(shared) == 0 {
+		return "No tasks share a namespace"
+	}
+	sort.Strings(shared)
+	return "Namespaces shared by more than one task:\n" + strings.Join(shared, "\n")
+}
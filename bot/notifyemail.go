@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/* notifyemail.go - with NotifyEmail set, a failed scheduled job emails it's
+   Notify user the job's output, since nobody may be watching the job
+   channel when a nightly job fails. Output is captured as the job runs,
+   whether or not a history provider is configured. When the robot has no
+   usable MailConfig, or the user has no email address, the Notify user gets
+   a direct message instead.
+*/
+
+// maxNotifyOutput is how much job output to keep for the email; when a job
+// writes more than this, the email gets the end of the output.
+const maxNotifyOutput = 64 * 1024
+
+// outputCapture is a HistoryLogger that keeps the last maxNotifyOutput bytes
+// of job output, passing everything on to the job's history log, if any.
+type outputCapture struct {
+	HistoryLogger                 // the job's history log, or nil
+	retired       []HistoryLogger // logs replaced by ExtendNamespace, closed with the capture
+	lines         []string
+	size          int
+	truncated     bool
+	sync.Mutex
+}
+
+func (oc *outputCapture) add(line string) {
+	oc.Lock()
+	oc.lines = append(oc.lines, line)
+	oc.size += len(line) + 1
+	for oc.size > maxNotifyOutput && len(oc.lines) > 1 {
+		oc.size -= len(oc.lines[0]) + 1
+		oc.lines = oc.lines[1:]
+		oc.truncated = true
+	}
+	oc.Unlock()
+}
+
+// Log records a line of output
+func (oc *outputCapture) Log(line string) {
+	oc.add(line)
+	if oc.HistoryLogger != nil {
+		oc.HistoryLogger.Log(line)
+	}
+}
+
+// Section records the start of a task in the pipeline
+func (oc *outputCapture) Section(task, desc string) {
+	oc.add(fmt.Sprintf("*** %s - %s", task, desc))
+	if oc.HistoryLogger != nil {
+		oc.HistoryLogger.Section(task, desc)
+	}
+}
+
+// Close closes the history logs
+func (oc *outputCapture) Close() {
+	oc.Lock()
+	logs := append(oc.retired, oc.HistoryLogger)
+	oc.retired = nil
+	oc.Unlock()
+	for _, hl := range logs {
+		if hl != nil {
+			hl.Close()
+		}
+	}
+}
+
+// setHistory switches to a new history log when a job extends it's
+// namespace; the old log is still in use by the running task, so it's closed
+// along with the capture.
+func (oc *outputCapture) setHistory(hl HistoryLogger) {
+	oc.Lock()
+	if oc.HistoryLogger != nil {
+		oc.retired = append(oc.retired, oc.HistoryLogger)
+	}
+	oc.HistoryLogger = hl
+	oc.Unlock()
+}
+
+// output returns the captured output for the body of the email
+func (oc *outputCapture) output() *bytes.Buffer {
+	oc.Lock()
+	defer oc.Unlock()
+	var body bytes.Buffer
+	if oc.truncated {
+		fmt.Fprintf(&body, "(output truncated to the last %d bytes)\n", maxNotifyOutput)
+	}
+	body.WriteString(strings.Join(oc.lines, "\n"))
+	body.WriteString("\n")
+	return &body
+}
+
+// emailFailure emails the Notify user of a failed job it's output; it
+// returns false when the email couldn't be sent, logging a warning.
+func (c *botContext) emailFailure(job *BotJob, jobName string, ret TaskRetVal, oc *outputCapture) bool {
+	botCfg.RLock()
+	mailConf := botCfg.mailConf
+	botCfg.RUnlock()
+	if len(mailConf.Mailhost) == 0 {
+		Log(Warn, fmt.Sprintf("NotifyEmail set for job '%s', but no MailConfig is configured; notifying '%s' by chat only", jobName, job.Notify))
+		return false
+	}
+	switch mailConf.Authtype {
+	case "", "none", "plain":
+	default:
+		Log(Warn, fmt.Sprintf("NotifyEmail set for job '%s', but MailConfig has invalid Authtype '%s'; notifying '%s' by chat only", jobName, mailConf.Authtype, job.Notify))
+		return false
+	}
+	r := c.makeRobot()
+	subject := fmt.Sprintf("Job '%s', run %d failed: %s", jobName, c.runIndex, ret)
+	if mret := r.EmailUser(job.Notify, subject, oc.output()); mret != Ok {
+		Log(Warn, fmt.Sprintf("Emailing '%s' of failed job '%s': %s; notifying by chat only", job.Notify, jobName, mret))
+		return false
+	}
+	Log(Info, fmt.Sprintf("Emailed '%s' the output of failed job '%s', run %d", job.Notify, jobName, c.runIndex))
+	return true
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/* outputxform.go - transforms for the stdout of external tasks, applied
+   before the output is stored in the pipeline history (and so before it's
+   displayed). Transforms are configured per task with OutputTransforms, e.g.
+   [ "ansi-strip", "truncate:4000" ], and applied in order; tasks without
+   their own transforms use those of the job or plugin that started the
+   pipeline. Output for tasks with transforms is collected until the task
+   finishes, so it's no longer interleaved with stderr in the history.
+*/
+
+// OutputTransform processes the captured stdout of an external task; arg
+// is the text after the ':' in the configured name, if any.
+type OutputTransform func(output, arg string) string
+
+// default length for 'truncate' with no argument
+const defaultTruncate = 4000
+
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+var outputTransforms = map[string]OutputTransform{
+	"ansi-strip": func(output, arg string) string {
+		return ansiRe.ReplaceAllString(output, "")
+	},
+	"truncate": func(output, arg string) string {
+		max := defaultTruncate
+		if len(arg) > 0 {
+			if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+				max = n
+			} else {
+				Log(Warn, fmt.Sprintf("Invalid length for 'truncate' output transform: '%s', using %d", arg, defaultTruncate))
+			}
+		}
+		if len(output) <= max {
+			return output
+		}
+		cut := max
+		for cut > 0 && !utf8.RuneStart(output[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%s\n... (truncated %d bytes)", output[:cut], len(output)-cut)
+	},
+	"jsonpretty": func(output, arg string) string {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, []byte(strings.TrimSpace(output)), "", "  "); err != nil {
+			// not JSON, leave it alone
+			return output
+		}
+		return pretty.String()
+	},
+}
+
+// RegisterOutputTransform allows Go code to register a custom transform for
+// the output of external tasks, for use in OutputTransforms. Like
+// RegisterPlugin, it should be called from an init() function.
+func RegisterOutputTransform(name string, transform OutputTransform) {
+	if stopRegistrations {
+		return
+	}
+	if !identifierRe.MatchString(name) {
+		log.Fatalf("Output transform name '%s' doesn't match identifier regex '%s'", name, identifierRe.String())
+	}
+	if _, exists := outputTransforms[name]; exists {
+		log.Fatalf("Attempted output transform registration duplicates builtin or other transform: %s", name)
+	}
+	outputTransforms[name] = transform
+}
+
+// splitTransform splits a configured transform into name and argument
+func splitTransform(spec string) (name, arg string) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// checkTransforms returns an error for the first unknown transform
+func checkTransforms(specs []string) error {
+	for _, spec := range specs {
+		name, _ := splitTransform(spec)
+		if _, ok := outputTransforms[name]; !ok {
+			return fmt.Errorf("unknown output transform '%s'", name)
+		}
+	}
+	return nil
+}
+
+// outputTransformsFor returns the transforms for a task, falling back to
+// those of the job or plugin that started the pipeline.
+func (c *botContext) outputTransformsFor(task *BotTask) []string {
+	if len(task.OutputTransforms) > 0 {
+		return task.OutputTransforms
+	}
+	if len(c.pipeName) == 0 {
+		return nil
+	}
+	if t := c.tasks.getTaskByName(c.pipeName); t != nil {
+		ptask, _, _ := getTask(t)
+		return ptask.OutputTransforms
+	}
+	return nil
+}
+
+// logOutput copies the stdout of an external task to the history logger,
+// applying any configured transforms.
+func (c *botContext) logOutput(task *BotTask, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	transforms := c.outputTransformsFor(task)
+	if len(transforms) == 0 {
+		for scanner.Scan() {
+			c.logger.Log("OUT " + scanner.Text())
+		}
+		return
+	}
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) == 0 {
+		return
+	}
+	output := strings.Join(lines, "\n")
+	for _, spec := range transforms {
+		name, arg := splitTransform(spec)
+		if transform, ok := outputTransforms[name]; ok {
+			output = transform(output, arg)
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		c.logger.Log("OUT " + line)
+	}
+}
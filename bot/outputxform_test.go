@@ -0,0 +1,71 @@
+package bot
+
+// outputxform_test.go - the builtin transforms for external task output.
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestOutputTransforms(t *testing.T) {
+	// 'truncate' logs a warning for an invalid length
+	botLogger.Lock()
+	if botLogger.l == nil {
+		botLogger.l = log.New(ioutil.Discard, "", 0)
+	}
+	botLogger.Unlock()
+	long := strings.Repeat("x", defaultTruncate+10)
+	tests := []struct {
+		spec, in, want string
+	}{
+		{"ansi-strip", "plain text", "plain text"},
+		{"ansi-strip", "\x1b[1;32mok\x1b[0m done", "ok done"},
+		{"ansi-strip", "\x1b[2K\x1b[?25lprogress\x1b[?25h", "progress"},
+		{"ansi-strip", "a\x1b[Kb\x1b[10;20Hc", "abc"},
+		{"truncate:5", "short", "short"},
+		{"truncate:5", "longer text", "longe\n... (truncated 6 bytes)"},
+		// never cut a multi-byte character in half
+		{"truncate:4", "abcé", "abc\n... (truncated 2 bytes)"},
+		{"truncate:3", "日本", "日\n... (truncated 3 bytes)"},
+		{"truncate", long, strings.Repeat("x", defaultTruncate) + "\n... (truncated 10 bytes)"},
+		{"truncate:bogus", long, strings.Repeat("x", defaultTruncate) + "\n... (truncated 10 bytes)"},
+		{"truncate:0", "abc", "abc"},
+		{"jsonpretty", `{"a":1,"b":[true,null]}`, "{\n  \"a\": 1,\n  \"b\": [\n    true,\n    null\n  ]\n}"},
+		{"jsonpretty", "\n  [1,2]  \n", "[\n  1,\n  2\n]"},
+		{"jsonpretty", "not json", "not json"},
+		{"jsonpretty", `{"a":`, `{"a":`},
+	}
+	for _, tc := range tests {
+		name, arg := splitTransform(tc.spec)
+		transform, ok := outputTransforms[name]
+		if !ok {
+			t.Fatalf("no builtin output transform '%s'", name)
+		}
+		if got := transform(tc.in, arg); got != tc.want {
+			t.Errorf("%s(%q): got %q, want %q", tc.spec, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSplitTransform(t *testing.T) {
+	tests := []struct {
+		spec, name, arg string
+	}{
+		{"ansi-strip", "ansi-strip", ""},
+		{"truncate:4000", "truncate", "4000"},
+		{"custom:a:b", "custom", "a:b"},
+	}
+	for _, tc := range tests {
+		if name, arg := splitTransform(tc.spec); name != tc.name || arg != tc.arg {
+			t.Errorf("splitTransform(%q): got (%q, %q), want (%q, %q)", tc.spec, name, arg, tc.name, tc.arg)
+		}
+	}
+	if err := checkTransforms([]string{"ansi-strip", "truncate:10", "jsonpretty"}); err != nil {
+		t.Errorf("checkTransforms rejected builtin transforms: %v", err)
+	}
+	if err := checkTransforms([]string{"truncate", "nonesuch:1"}); err == nil {
+		t.Errorf("checkTransforms accepted an unknown transform")
+	}
+}
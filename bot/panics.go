@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/* panics.go - containment for plugins that crash. A Go plugin that panics
+   while handling a command is disabled for the life of the process, rather
+   than taking the robot down with it; the disable survives reloads, but an
+   administrator can 'enable plugin' once it's fixed. External plugins run
+   in their own process, so a crash only fails the pipeline; either way
+   the crash is counted for the 'status' command and /health.
+*/
+
+var taskPanics = struct {
+	count    map[string]int    // panics and crashes by task name
+	disabled map[string]string // Go plugins disabled after a panic, with the reason
+	sync.Mutex
+}{
+	count:    make(map[string]int),
+	disabled: make(map[string]string),
+}
+
+// countPanic records a panic or crash for a task
+func countPanic(name string) {
+	taskPanics.Lock()
+	taskPanics.count[name]++
+	taskPanics.Unlock()
+}
+
+// panicCounts returns the total number of panics and crashes, and a
+// sorted summary by task.
+func panicCounts() (int, []string) {
+	taskPanics.Lock()
+	defer taskPanics.Unlock()
+	total := 0
+	list := make([]string, 0, len(taskPanics.count))
+	for name, n := range taskPanics.count {
+		total += n
+		list = append(list, fmt.Sprintf("%s: %d", name, n))
+	}
+	sort.Strings(list)
+	return total, list
+}
+
+// disablePanicked disables a Go plugin that panicked, in the live task list
+// and for later reloads.
+func disablePanicked(name, reason string) {
+	taskPanics.Lock()
+	taskPanics.disabled[name] = reason
+	taskPanics.Unlock()
+	currentTasks.Lock()
+	updateLiveTask(name, func(task *BotTask) {
+		task.Disabled = true
+		task.runtimeDisabled = true
+		task.reason = reason
+	})
+	currentTasks.Unlock()
+	Log(Error, fmt.Sprintf("Disabled plugin '%s' after a %s", name, reason))
+}
+
+// forgetPanicked clears a panic disable when a plugin is enabled again
+func forgetPanicked(name string) {
+	taskPanics.Lock()
+	delete(taskPanics.disabled, name)
+	taskPanics.Unlock()
+}
+
+// applyPanicDisables keeps plugins that panicked disabled across reloads;
+// called from loadTaskConfig before the new tasks are live.
+func applyPanicDisables(tlist []interface{}) {
+	taskPanics.Lock()
+	defer taskPanics.Unlock()
+	if len(taskPanics.disabled) == 0 {
+		return
+	}
+	for _, t := range tlist {
+		task, _, _ := getTask(t)
+		if reason, ok := taskPanics.disabled[task.name]; ok && !task.Disabled {
+			Log(Info, fmt.Sprintf("Plugin '%s' was disabled after a panic, keeping it disabled", task.name))
+			task.Disabled = true
+			task.runtimeDisabled = true
+			task.reason = reason
+		}
+	}
+}
@@ -4,9 +4,9 @@ package bot
 
 import "strconv"
 
-const _Protocol_name = "SlackTerminalTest"
+const _Protocol_name = "SlackTerminalTestMattermostTelegram"
 
-var _Protocol_index = [...]uint8{0, 5, 13, 17}
+var _Protocol_index = [...]uint8{0, 5, 13, 17, 27, 35}
 
 func (i Protocol) String() string {
 	if i < 0 || i >= Protocol(len(_Protocol_index)-1) {
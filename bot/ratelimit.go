@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/* ratelimit.go - per-user command rate limits, for plugins that call an
+   expensive backend. A plugin can set it's own RateLimit, or use the robot's
+   default RateLimit from gopherbot.yaml. Each user gets a token bucket for
+   each of the plugin's commands (and optionally each channel), stored in the
+   brain so limits survive a restart; a throttled user is told how long to
+   wait. This is separate from the global CommandRate (see shaper.go).
+*/
+
+const rateLimitsKey = "bot:rateLimits"
+
+// RateLimit limits how often a user can run each of a plugin's commands
+type RateLimit struct {
+	Commands     int    // commands a user can run in a burst, refilled evenly over Period
+	Period       string // e.g. "1m" or "1h"
+	PerChannel   bool   // keep a separate limit for each channel
+	ExemptAdmins bool   // the limit doesn't apply to admins
+}
+
+// rateLimiter is the parsed form of RateLimit
+type rateLimiter struct {
+	burst        float64
+	rate         float64 // tokens per second
+	perChannel   bool
+	exemptAdmins bool
+}
+
+// rateBucket is the stored state of one user's bucket
+type rateBucket struct {
+	Tokens  float64
+	Updated int64 // unix time in milliseconds
+	Full    int64 // when the bucket will be full again, for pruning
+}
+
+// limiter checks and parses the configured RateLimit
+func (rl *RateLimit) limiter() (*rateLimiter, error) {
+	if rl.Commands <= 0 {
+		return nil, fmt.Errorf("Commands must be greater than 0")
+	}
+	period, err := time.ParseDuration(rl.Period)
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("invalid Period '%s', should be e.g. '1m'", rl.Period)
+	}
+	return &rateLimiter{
+		burst:        float64(rl.Commands),
+		rate:         float64(rl.Commands) / period.Seconds(),
+		perChannel:   rl.PerChannel,
+		exemptAdmins: rl.ExemptAdmins,
+	}, nil
+}
+
+// rateLimiterFor returns the limiter for a plugin, defaulting to the
+// robot's; nil when there's no limit.
+func rateLimiterFor(plugin *BotPlugin) *rateLimiter {
+	if plugin.limiter != nil {
+		return plugin.limiter
+	}
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	return botCfg.rateLimit
+}
+
+// checkRateLimit takes a token for the user's command, returning false
+// (after telling the user when to try again) if they're throttled.
+func (c *botContext) checkRateLimit(plugin *BotPlugin, command string) bool {
+	rl := rateLimiterFor(plugin)
+	if rl == nil {
+		return true
+	}
+	if rl.exemptAdmins {
+		botCfg.RLock()
+		admins := botCfg.adminUsers
+		botCfg.RUnlock()
+		for _, adminUser := range admins {
+			if c.User == adminUser {
+				return true
+			}
+		}
+	}
+	key := c.User + ":" + plugin.name + ":" + command
+	if rl.perChannel {
+		key += ":" + c.Channel
+	}
+	buckets := make(map[string]rateBucket)
+	tok, _, ret := checkoutDatum(rateLimitsKey, &buckets, true)
+	if ret != Ok {
+		// fail open; a brain problem shouldn't stop every command
+		Log(Error, fmt.Sprintf("Error retrieving '%s', not rate limiting command '%s': %s", rateLimitsKey, command, ret))
+		return true
+	}
+	now := time.Now()
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	for k, b := range buckets {
+		if b.Full <= nowMs {
+			delete(buckets, k)
+		}
+	}
+	b, exists := buckets[key]
+	if !exists {
+		b = rateBucket{Tokens: rl.burst, Updated: nowMs}
+	}
+	elapsed := float64(nowMs-b.Updated) / 1000
+	b.Tokens = math.Min(rl.burst, b.Tokens+elapsed*rl.rate)
+	b.Updated = nowMs
+	allowed := b.Tokens >= 1
+	if allowed {
+		b.Tokens--
+	}
+	b.Full = nowMs + int64((rl.burst-b.Tokens)/rl.rate*1000)
+	buckets[key] = b
+	if ret := updateDatum(rateLimitsKey, tok, buckets); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s': %s", rateLimitsKey, ret))
+	}
+	if allowed {
+		return true
+	}
+	wait := int(math.Ceil((1 - b.Tokens) / rl.rate))
+	Log(Warn, fmt.Sprintf("Rate limiting command '%s' of plugin '%s' for user '%s' in channel '%s'", command, plugin.name, c.User, c.Channel))
+	c.makeRobot().Reply(fmt.Sprintf("Sorry, you're running '%s' too often - try again in %ds", command, wait))
+	return false
+}
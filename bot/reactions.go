@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* reactions.go - waiting for a user to react to a prompt with an emoji, as
+   an alternative to typing a reply for e.g. yes/no prompts. The plugin
+   posts the prompt with SayWithID, then calls WaitForReaction with the
+   emoji it accepts. Connectors that declare CapReactionEvents deliver
+   reactions with Handler.IncomingReaction, giving the same opaque message
+   ID returned by the MessageEditor send methods.
+*/
+
+// ConnectorReaction is passed in to the robot when a user adds a reaction
+// to a message.
+type ConnectorReaction struct {
+	// Protocol - string name of connector, e.g. "Slack"
+	Protocol string
+	// optional UserName and required internal UserID
+	UserName, UserID string
+	// ChannelID - internal ID of the channel the message is in
+	ChannelID string
+	// MessageID - the ID of the message, as returned by
+	// SendProtocolChannelMessageID / SendProtocolUserMessageID
+	MessageID string
+	// Emoji - name of the emoji, without colons, e.g. "white_check_mark"
+	Emoji string
+}
+
+// a reactionWaiter is used when a plugin is waiting for a reaction
+type reactionWaiter struct {
+	user     string      // the user whose reaction counts
+	emojis   []string    // the reactions that count
+	reaction chan string // receives the first matching reaction
+}
+
+// reactionWaiters are indexed by the message ID of the prompt
+var reactionWaiters = struct {
+	m map[string][]*reactionWaiter
+	sync.Mutex
+}{
+	make(map[string][]*reactionWaiter),
+	sync.Mutex{},
+}
+
+// IncomingReaction accepts a reaction to a message from the connector, and
+// hands it to any plugin waiting for it.
+func (h handler) IncomingReaction(rc *ConnectorReaction) {
+	if len(rc.UserID) == 0 || len(rc.MessageID) == 0 {
+		Log(Error, "incoming reaction with no user ID or message ID")
+		return
+	}
+	currentUCMaps.Lock()
+	maps := currentUCMaps.ucmap
+	currentUCMaps.Unlock()
+	var userName string
+	if un, ok := maps.userID[protocolKey{h.protocol, rc.UserID}]; ok {
+		userName = un.UserName
+	} else if len(h.protocol) > 0 {
+		// see incomingMessage; users of ExtraConnectors must be listed
+		return
+	} else if len(rc.UserName) > 0 {
+		userName = rc.UserName
+	} else {
+		userName = bracket(rc.UserID)
+	}
+	emoji := strings.Trim(rc.Emoji, ":")
+	Log(Trace, fmt.Sprintf("Incoming reaction '%s' from user '%s' to message '%s'", emoji, userName, rc.MessageID))
+
+	reactionWaiters.Lock()
+	defer reactionWaiters.Unlock()
+	for _, w := range reactionWaiters.m[rc.MessageID] {
+		if w.user != userName {
+			continue
+		}
+		found := false
+		for _, e := range w.emojis {
+			if e == emoji {
+				found = true
+				break
+			}
+		}
+		if !found {
+			Log(Debug, fmt.Sprintf("Ignoring reaction '%s' from user '%s', waiting for one of: %s", emoji, userName, strings.Join(w.emojis, ", ")))
+			continue
+		}
+		select {
+		case w.reaction <- emoji:
+		default: // already got one
+		}
+	}
+}
+
+// WaitForReaction waits for the user who sent the command to react to the
+// last message sent with SayWithID, returning the emoji name (without
+// colons) and Ok. Only the emojis given count; other reactions are ignored,
+// so a stray ":eyes:" doesn't answer the prompt. A timeout of 0 waits as long
+// as PromptForReply. E.g.:
+//  r.SayWithID("Deploy to production? React with :+1: or :-1:")
+//  emoji, ret := r.WaitForReaction([]string{"+1", "-1"}, time.Minute)
+// On failure the emoji is "", with one of the following RetVals:
+//  ReactionsNotSupported - the connector doesn't deliver reactions
+//  MissingArguments - no emojis were given, or there's no prompt message
+//  TimeoutExpired - the user didn't react in time
+//  Interrupted - the pipeline was cancelled while waiting
+func (r *Robot) WaitForReaction(emojis []string, timeout time.Duration) (string, RetVal) {
+	if !connectorSupports(r.connector(), CapReactionEvents) {
+		return "", ReactionsNotSupported
+	}
+	c := r.getContext()
+	c.Lock()
+	id := c.lastMessageID
+	c.Unlock()
+	if len(emojis) == 0 || len(id) == 0 {
+		r.Log(Warn, "WaitForReaction called with no emojis, or no message sent with SayWithID")
+		return "", MissingArguments
+	}
+	w := &reactionWaiter{
+		user:     r.User,
+		emojis:   make([]string, len(emojis)),
+		reaction: make(chan string, 1),
+	}
+	for i, e := range emojis {
+		w.emojis[i] = strings.Trim(e, ":")
+	}
+	if timeout <= 0 {
+		timeout = replyTimeout
+	}
+
+	reactionWaiters.Lock()
+	reactionWaiters.m[id] = append(reactionWaiters.m[id], w)
+	reactionWaiters.Unlock()
+	defer func() {
+		reactionWaiters.Lock()
+		waiters := reactionWaiters.m[id]
+		for i, rw := range waiters {
+			if rw == w {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(reactionWaiters.m, id)
+		} else {
+			reactionWaiters.m[id] = waiters
+		}
+		reactionWaiters.Unlock()
+	}()
+
+	select {
+	case emoji := <-w.reaction:
+		return emoji, Ok
+	case <-time.After(timeout):
+		Log(Debug, fmt.Sprintf("Timed out waiting for a reaction from user '%s' to message '%s'", r.User, id))
+		return "", TimeoutExpired
+	case <-c.taskContext().Done():
+		return "", Interrupted
+	}
+}
@@ -0,0 +1,42 @@
+package bot
+
+import (
+	"regexp"
+	"sync"
+)
+
+/* regexcache.go - compiled regular expressions for task configuration.
+   loadTaskConfig compiles every matcher regex on each reload, and many
+   patterns repeat across matchers and plugins. The cache is keyed on the
+   final pattern string (after the robot adds anchors and flags), so
+   nothing needs invalidating on reload; a changed pattern is simply a new
+   key. A *regexp.Regexp is safe for concurrent use, so tasks share them.
+   Patterns dropped from configuration stay cached until the robot exits.
+*/
+
+var regexCache = struct {
+	m map[string]*regexp.Regexp
+	sync.Mutex
+}{
+	make(map[string]*regexp.Regexp),
+	sync.Mutex{},
+}
+
+// compileRegex returns the compiled regex for a pattern, from the cache
+// when possible; patterns that fail to compile aren't cached.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCache.Lock()
+	re, ok := regexCache.m[pattern]
+	regexCache.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Lock()
+	regexCache.m[pattern] = re
+	regexCache.Unlock()
+	return re, nil
+}
@@ -0,0 +1,80 @@
+package bot
+
+// regexcache_test.go - benchmarks for compiling the matchers of the stock
+// plugins, as loadTaskConfig does on every reload.
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+// templateRe matches the template actions in configuration files
+var templateRe = regexp.MustCompile(`{{[^}]*}}`)
+
+// stockPatterns returns the matcher patterns from the distributed plugin
+// configuration, with the anchors loadTaskConfig adds.
+func stockPatterns(b *testing.B) []string {
+	files, err := filepath.Glob("../conf/plugins/*.yaml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var patterns []string
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// the matchers don't depend on the environment
+		data = templateRe.ReplaceAll(data, nil)
+		var cfg struct {
+			CommandMatchers, ReplyMatchers, MessageMatchers []InputMatcher
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			b.Fatalf("unmarshalling %s: %v", file, err)
+		}
+		for _, matchers := range [][]InputMatcher{cfg.CommandMatchers, cfg.ReplyMatchers} {
+			for _, m := range matchers {
+				patterns = append(patterns, `^\s*`+m.Regex+`\s*$`)
+			}
+		}
+		for _, m := range cfg.MessageMatchers {
+			patterns = append(patterns, m.Regex)
+		}
+	}
+	if len(patterns) == 0 {
+		b.Fatal("no matchers found in ../conf/plugins")
+	}
+	return patterns
+}
+
+// BenchmarkCompileMatchers compiles every pattern, as for each reload
+// without the cache.
+func BenchmarkCompileMatchers(b *testing.B) {
+	patterns := stockPatterns(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkCompileMatchersCached gets every pattern from the cache, as for
+// each reload after the first.
+func BenchmarkCompileMatchersCached(b *testing.B) {
+	patterns := stockPatterns(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range patterns {
+			if _, err := compileRegex(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
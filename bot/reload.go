@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reloadLock serializes reloads, so two admins reloading at once can't
+// interleave loading configuration and scheduling tasks.
+var reloadLock sync.Mutex
+
+// snapshotTasks returns the current tasks by name
+func snapshotTasks() map[string]interface{} {
+	currentTasks.Lock()
+	defer currentTasks.Unlock()
+	tasks := make(map[string]interface{}, len(currentTasks.t))
+	for _, t := range currentTasks.t {
+		task, _, _ := getTask(t)
+		tasks[task.name] = t
+	}
+	return tasks
+}
+
+// ReloadConfiguration reloads gopherbot.yaml and the configuration for all
+// jobs and plugins, and re-schedules jobs, without disconnecting from the
+// chat service. It returns a summary of the tasks that were added, removed,
+// newly disabled, or that failed to reload and kept their previous
+// configuration. If gopherbot.yaml can't be loaded, the current
+// configuration is left in place and an error returned.
+func (r *Robot) ReloadConfiguration() (string, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	before := snapshotTasks()
+	if err := r.getContext().loadConfig(false); err != nil {
+		return "", err
+	}
+	after := snapshotTasks()
+	var added, removed, disabled, kept []string
+	for name, t := range after {
+		task, _, _ := getTask(t)
+		prev, existed := before[name]
+		if !existed {
+			added = append(added, name)
+			continue
+		}
+		if t == prev {
+			kept = append(kept, name)
+			continue
+		}
+		ptask, _, _ := getTask(prev)
+		if task.Disabled && !ptask.Disabled {
+			disabled = append(disabled, fmt.Sprintf("%s (%s)", name, task.reason))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	summary := []string{"Configuration reloaded successfully"}
+	for _, changes := range []struct {
+		desc  string
+		tasks []string
+	}{
+		{"Added", added},
+		{"Removed", removed},
+		{"Newly disabled", disabled},
+		{"Failed to reload, kept previous configuration", kept},
+	} {
+		if len(changes.tasks) > 0 {
+			sort.Strings(changes.tasks)
+			summary = append(summary, fmt.Sprintf("%s: %s", changes.desc, strings.Join(changes.tasks, ", ")))
+		}
+	}
+	return strings.Join(summary, "\n"), nil
+}
@@ -49,6 +49,7 @@ type replyWaiter struct {
 // a reply matcher is used as the key in the replys map
 type replyMatcher struct {
 	user, channel string // Only one reply at a time can be requested for a given user/channel combination
+	thread        string // ... in a given thread; "" for the main channel
 }
 
 // a reply is sent over the replyWaiter channel when a user replies
@@ -171,12 +172,20 @@ func (r *Robot) PromptUserChannelForReply(regexID string, user string, channel s
 
 // promptInternal can return 'RetryPrompt'
 func (r *Robot) promptInternal(regexID string, user string, channel string, prompt string) (string, RetVal) {
+	c := r.getContext()
+	// A prompt in the channel the command came from stays in the same
+	// thread, so the user's reply there is matched.
+	thread := r.thread
+	if len(thread) == 0 && channel == c.Channel {
+		thread = c.thread
+	}
 	matcher := replyMatcher{
 		user:    user,
 		channel: channel,
+		thread:  thread,
 	}
 	var rep replyWaiter
-	task, _, job := getTask(r.getContext().currentTask)
+	task, _, job := getTask(c.currentTask)
 	isJob := job != nil
 	if stockRepliesRe.MatchString(regexID) {
 		rep.re = stockReplies[regexID]
@@ -214,18 +223,14 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		replies.Unlock()
 	} else {
 		Log(Debug, fmt.Sprintf("Prompting for \"%s \" and creating reply waiters list and prompting for matcher: %q", prompt, matcher))
-		c := r.getContext()
-		var puser string
-		if ui, ok := c.maps.user[user]; ok {
-			puser = bracket(ui.UserID)
-		} else {
-			puser = user
-		}
+		conn, _, puser := userConnector(c.maps, r.source, user)
+		opts := r.messageOptions()
+		opts.Thread = thread
 		var ret RetVal
 		if channel == "" {
-			ret = botCfg.SendProtocolUserMessage(puser, prompt, r.Format)
+			ret = sendProtocolUserMessage(conn, puser, prompt, r.Format, opts)
 		} else {
-			ret = botCfg.SendProtocolUserChannelMessage(puser, user, channel, prompt, r.Format)
+			ret = sendProtocolUserChannelMessage(conn, puser, user, channel, prompt, r.Format, opts)
 		}
 		if ret != Ok {
 			replies.Unlock()
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"reflect"
@@ -17,6 +18,7 @@ const (
 	Raw MessageFormat = iota // protocol native, zero value -> default if not specified
 	Fixed
 	Variable
+	Markup // neutral markup rendered for the destination protocol, see markup.go
 )
 
 // Robot is passed to each task as it runs, initialized from the botContext.
@@ -29,6 +31,9 @@ type Robot struct {
 	Protocol        Protocol          // slack, terminal, test, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
 	Incoming        *ConnectorMessage // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
 	Format          MessageFormat     // The outgoing message format, one of Raw, Fixed, or Variable
+	source          string            // ExtraConnectors protocol the message arrived on, "" for the primary connector
+	noUnfurl        bool              // Suppress link and media previews, see NoUnfurl()
+	thread          string            // Thread for messages, see InThread()
 	id              int               // For looking up the botContext
 }
 
@@ -40,6 +45,13 @@ func (r *Robot) getContext() *botContext {
 	return getBotContextInt(r.id)
 }
 
+// Context returns a context that's cancelled when the pipeline ends or the
+// robot shuts down. Long-running Go plugins should watch ctx.Done() and
+// return early when it's closed.
+func (r *Robot) Context() context.Context {
+	return r.getContext().taskContext()
+}
+
 // CheckAdmin returns true if the user is a configured administrator of the
 // robot, and true for automatic tasks. Should be used sparingly, when a single
 // plugin has multiple commands, some which require admin. Otherwise the plugin
@@ -237,6 +249,32 @@ func (r *Robot) MessageFormat(f MessageFormat) *Robot {
 	return &nr
 }
 
+// NoUnfurl returns a robot object that asks the connector not to show link
+// and media previews for messages, e.g. for compact status messages.
+// Connectors without previews ignore it.
+func (r *Robot) NoUnfurl() *Robot {
+	nr := *r
+	nr.noUnfurl = true
+	return &nr
+}
+
+// messageOptions returns the per-message options for the connector
+func (r *Robot) messageOptions() MessageOptions {
+	botCfg.RLock()
+	noUnfurl := botCfg.noUnfurl
+	botCfg.RUnlock()
+	return MessageOptions{NoUnfurl: r.noUnfurl || noUnfurl, Thread: r.thread}
+}
+
+// InThread returns a robot object that posts channel messages as replies in
+// the given thread, for connectors with thread support; other connectors post
+// to the channel as usual.
+func (r *Robot) InThread(id string) *Robot {
+	nr := *r
+	nr.thread = id
+	return &nr
+}
+
 // Direct is a convenience function for initiating a DM conversation with a
 // user. Created initially so a plugin could prompt for a password in a DM.
 func (r *Robot) Direct() *Robot {
@@ -327,12 +365,15 @@ and call GetTaskConfig with a double-pointer:
 	var c *pConf
 	r.GetTaskConfig(&c)
 
-... And voila! *pConf is populated with the contents from the configured Config: stanza
+... And voila! *pConf is populated with the contents from the configured Config: stanza.
+If the plugin has ChannelOverrides for the channel it was triggered in, the
+channel's Config is used instead.
 */
 func (r *Robot) GetTaskConfig(dptr interface{}) RetVal {
 	c := r.getContext()
 	task, _, _ := getTask(c.currentTask)
-	if task.config == nil {
+	cfg := task.forChannel(c.Channel)
+	if cfg.config == nil {
 		Log(Debug, fmt.Sprintf("Task \"%s\" called GetTaskConfig, but no config was found.", task.name))
 		return NoConfigFound
 	}
@@ -346,11 +387,11 @@ func (r *Robot) GetTaskConfig(dptr interface{}) RetVal {
 		Log(Debug, fmt.Sprintf("Task \"%s\" called GetTaskConfig, but didn't pass a double-pointer to a struct", task.name))
 		return InvalidDblPtr
 	}
-	if p.Type() != reflect.ValueOf(task.config).Type() {
+	if p.Type() != reflect.ValueOf(cfg.config).Type() {
 		Log(Debug, fmt.Sprintf("Task \"%s\" called GetTaskConfig with an invalid double-pointer", task.name))
 		return InvalidCfgStruct
 	}
-	p.Set(reflect.ValueOf(task.config))
+	p.Set(reflect.ValueOf(cfg.config))
 	return Ok
 }
 
@@ -358,7 +399,7 @@ func (r *Robot) GetTaskConfig(dptr interface{}) RetVal {
 // is lower than or equal to the robot's current log level
 func (r *Robot) Log(l LogLevel, v ...interface{}) {
 	c := r.getContext()
-	if Log(l, v...) && c.logger != nil {
+	if c.log(l, v...) && c.logger != nil {
 		line := "LOG " + logLevelToStr(l) + " " + fmt.Sprintln(v...)
 		c.logger.Log(strings.TrimSpace(line))
 	}
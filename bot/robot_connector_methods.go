@@ -1,6 +1,17 @@
 package bot
 
-import "strings"
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supports reports whether the connector the message arrived on declares a
+// capability, e.g. r.Supports(bot.CapBlocks), so plugins can choose between
+// rich and plain messages.
+func (r *Robot) Supports(capability ConnectorCapability) bool {
+	return connectorSupports(r.connector(), capability)
+}
 
 // GetUserAttribute returns a AttrRet with
 // - The string Attribute of a user, or "" if unknown/error
@@ -12,14 +23,8 @@ import "strings"
 func (r *Robot) GetUserAttribute(u, a string) *AttrRet {
 	a = strings.ToLower(a)
 	c := r.getContext()
-	var user string
-	var ui *UserInfo
-	var ok bool
-	if ui, ok = c.maps.user[u]; ok {
-		user = "<" + ui.UserID + ">"
-	} else {
-		user = u
-	}
+	ui := c.maps.user[u]
+	conn, _, user := userConnector(c.maps, r.source, u)
 	if ui != nil {
 		var attr string
 		switch a {
@@ -42,7 +47,7 @@ func (r *Robot) GetUserAttribute(u, a string) *AttrRet {
 			return &AttrRet{attr, Ok}
 		}
 	}
-	attr, ret := botCfg.GetProtocolUserAttribute(user, a)
+	attr, ret := conn.GetProtocolUserAttribute(user, a)
 	return &AttrRet{attr, ret}
 }
 
@@ -56,7 +61,7 @@ func (c *botContext) messageHeard() {
 	if len(channel) == 0 {
 		channel = c.Channel
 	}
-	botCfg.MessageHeard(user, channel)
+	connectorFor(c.source).MessageHeard(user, channel)
 }
 
 // GetSenderAttribute returns a AttrRet with
@@ -98,7 +103,7 @@ func (r *Robot) GetSenderAttribute(a string) *AttrRet {
 	if len(user) == 0 {
 		user = r.User
 	}
-	attr, ret := botCfg.GetProtocolUserAttribute(user, a)
+	attr, ret := r.connector().GetProtocolUserAttribute(user, a)
 	return &AttrRet{attr, ret}
 }
 
@@ -111,39 +116,24 @@ func (r *Robot) SendChannelMessage(ch, msg string) RetVal {
 		return Ok
 	}
 	c := r.getContext()
-	var channel string
-	if ci, ok := c.maps.channel[ch]; ok {
-		channel = bracket(ci.ChannelID)
-	} else {
-		channel = ch
-	}
-	return botCfg.SendProtocolChannelMessage(channel, msg, r.Format)
+	channel := protocolChannel(c.maps, r.source, ch)
+	return sendProtocolChannelMessage(r.connector(), channel, msg, r.Format, r.messageOptions())
 }
 
 // SendUserChannelMessage lets a plugin easily send a message directed to
 // a specific user in a specific channel without fiddling with the robot
 // object. Note that this will fail with UserNotFound if the connector
 // can't resolve usernames, or the username isn't mapped to a user ID in
-// the UserRoster.
+// the UserRoster. The message goes out the connector the user is on.
 func (r *Robot) SendUserChannelMessage(u, ch, msg string) RetVal {
 	if len(msg) == 0 {
 		r.Log(Warn, "Ignoring zero-length message in SendUserChannelMessage")
 		return Ok
 	}
 	c := r.getContext()
-	var user string
-	if ui, ok := c.maps.user[u]; ok {
-		user = bracket(ui.UserID)
-	} else {
-		user = u
-	}
-	var channel string
-	if ci, ok := c.maps.channel[ch]; ok {
-		channel = bracket(ci.ChannelID)
-	} else {
-		channel = ch
-	}
-	return botCfg.SendProtocolUserChannelMessage(user, u, channel, msg, r.Format)
+	conn, protocol, user := userConnector(c.maps, r.source, u)
+	channel := protocolChannel(c.maps, protocol, ch)
+	return sendProtocolUserChannelMessage(conn, user, u, channel, msg, r.Format, r.messageOptions())
 }
 
 // SendUserMessage lets a plugin easily send a DM to a user. If a DM
@@ -154,13 +144,8 @@ func (r *Robot) SendUserMessage(u, msg string) RetVal {
 		return Ok
 	}
 	c := r.getContext()
-	var user string
-	if ui, ok := c.maps.user[u]; ok {
-		user = bracket(ui.UserID)
-	} else {
-		user = u
-	}
-	return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+	conn, _, user := userConnector(c.maps, r.source, u)
+	return sendProtocolUserMessage(conn, user, msg, r.Format, r.messageOptions())
 }
 
 // Reply directs a message to the user
@@ -175,7 +160,7 @@ func (r *Robot) Reply(msg string) RetVal {
 	}
 	// Support for Direct()
 	if r.Channel == "" {
-		return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+		return sendProtocolUserMessage(r.connector(), user, msg, r.Format, r.messageOptions())
 	}
 	channel := r.ProtocolChannel
 	if len(channel) == 0 {
@@ -183,9 +168,9 @@ func (r *Robot) Reply(msg string) RetVal {
 	}
 	c := r.getContext()
 	if c != nil && c.BotUser {
-		return botCfg.SendProtocolChannelMessage(r.Channel, r.User+": "+msg, r.Format)
+		return sendProtocolChannelMessage(r.connector(), r.Channel, r.User+": "+msg, r.Format, r.messageOptions())
 	}
-	return botCfg.SendProtocolUserChannelMessage(user, r.User, r.Channel, msg, r.Format)
+	return sendProtocolUserChannelMessage(r.connector(), user, r.User, r.Channel, msg, r.Format, r.messageOptions())
 }
 
 // Say just sends a message to the user or channel
@@ -200,11 +185,236 @@ func (r *Robot) Say(msg string) RetVal {
 		if len(user) == 0 {
 			user = r.User
 		}
-		return botCfg.SendProtocolUserMessage(user, msg, r.Format)
+		return sendProtocolUserMessage(r.connector(), user, msg, r.Format, r.messageOptions())
+	}
+	channel := r.ProtocolChannel
+	if len(channel) == 0 {
+		channel = r.Channel
+	}
+	return sendProtocolChannelMessage(r.connector(), channel, msg, r.Format, r.messageOptions())
+}
+
+// SendEphemeral sends a message in the current channel that only the user
+// sees, e.g. for help in a busy channel. Connectors without ephemeral
+// messages send it to the user as a DM.
+func (r *Robot) SendEphemeral(msg string) RetVal {
+	if len(msg) == 0 {
+		r.Log(Warn, "Ignoring zero-length message in SendEphemeral")
+		return Ok
+	}
+	user := r.ProtocolUser
+	if len(user) == 0 {
+		user = r.User
+	}
+	if r.Channel == "" {
+		return sendProtocolUserMessage(r.connector(), user, msg, r.Format, r.messageOptions())
+	}
+	channel := r.ProtocolChannel
+	if len(channel) == 0 {
+		channel = r.Channel
+	}
+	return sendProtocolEphemeralMessage(r.connector(), user, r.User, channel, msg, r.Format, r.messageOptions())
+}
+
+// SendFile uploads a file, e.g. a CSV report or a PNG graph, to the current
+// channel, with an optional comment. For a direct message, and for plugins
+// configured with DirectOnly, the file is sent to the user by DM. Returns
+// FileSendNotSupported if the connector can't upload files.
+func (r *Robot) SendFile(name string, content io.Reader, comment string) RetVal {
+	user := r.ProtocolUser
+	if len(user) == 0 {
+		user = r.User
+	}
+	opts := r.messageOptions()
+	if r.Channel == "" {
+		return sendProtocolUserFile(r.connector(), user, name, content, comment, opts)
+	}
+	c := r.getContext()
+	if c != nil && c.currentTask != nil {
+		if task, _, _ := getTask(c.currentTask); task.DirectOnly {
+			// a channel thread doesn't apply to the DM
+			opts.Thread = ""
+			return sendProtocolUserFile(r.connector(), user, name, content, comment, opts)
+		}
 	}
 	channel := r.ProtocolChannel
 	if len(channel) == 0 {
 		channel = r.Channel
 	}
-	return botCfg.SendProtocolChannelMessage(channel, msg, r.Format)
+	return sendProtocolChannelFile(r.connector(), channel, name, content, comment, opts)
+}
+
+// SayWithID is like Say, but returns an opaque ID for the message that can
+// be passed to UpdateMessage or DeleteMessage; the ID is also kept for
+// UpdateLastMessage. Connectors that can't edit messages send it normally
+// and return an empty ID.
+func (r *Robot) SayWithID(msg string) (string, RetVal) {
+	if len(msg) == 0 {
+		r.Log(Warn, "Ignoring zero-length message in SayWithID")
+		return "", Ok
+	}
+	conn := r.connector()
+	editor, ok := conn.(MessageEditor)
+	if !ok {
+		return "", r.Say(msg)
+	}
+	var id string
+	var ret RetVal
+	msg, f := renderMarkup(conn, msg, r.Format)
+	if r.Channel == "" {
+		user := r.ProtocolUser
+		if len(user) == 0 {
+			user = r.User
+		}
+		id, ret = editor.SendProtocolUserMessageID(user, msg, f, r.messageOptions())
+	} else {
+		channel := r.ProtocolChannel
+		if len(channel) == 0 {
+			channel = r.Channel
+		}
+		id, ret = editor.SendProtocolChannelMessageID(channel, msg, f, r.messageOptions())
+	}
+	if ret == Ok {
+		if c := r.getContext(); c != nil {
+			c.Lock()
+			c.lastMessageID = id
+			c.Unlock()
+		}
+	}
+	return id, ret
+}
+
+// UpdateMessage replaces the text of a message sent with SayWithID.
+// Returns MessageEditNotSupported if the connector can't edit messages.
+func (r *Robot) UpdateMessage(id, msg string) RetVal {
+	conn := r.connector()
+	editor, ok := conn.(MessageEditor)
+	if !ok {
+		return MessageEditNotSupported
+	}
+	if len(id) == 0 || len(msg) == 0 {
+		r.Log(Warn, "Ignoring UpdateMessage with empty message ID or text")
+		return MissingArguments
+	}
+	msg, f := renderMarkup(conn, msg, r.Format)
+	return editor.UpdateMessage(id, msg, f)
+}
+
+// UpdateLastMessage updates the last message sent with SayWithID in the
+// current pipeline, e.g. "running..." -> "done".
+func (r *Robot) UpdateLastMessage(msg string) RetVal {
+	var id string
+	if c := r.getContext(); c != nil {
+		c.Lock()
+		id = c.lastMessageID
+		c.Unlock()
+	}
+	return r.UpdateMessage(id, msg)
+}
+
+// DeleteMessage deletes a message sent with SayWithID. Returns
+// MessageEditNotSupported if the connector can't delete messages.
+func (r *Robot) DeleteMessage(id string) RetVal {
+	editor, ok := r.connector().(MessageEditor)
+	if !ok {
+		return MessageEditNotSupported
+	}
+	if len(id) == 0 {
+		r.Log(Warn, "Ignoring DeleteMessage with empty message ID")
+		return MissingArguments
+	}
+	ret := editor.DeleteMessage(id)
+	if ret == Ok {
+		if c := r.getContext(); c != nil {
+			c.Lock()
+			if c.lastMessageID == id {
+				c.lastMessageID = ""
+			}
+			c.Unlock()
+		}
+	}
+	return ret
+}
+
+// AddReaction reacts to the message that triggered the current command
+// with an emoji, given by name with or without colons, e.g. "white_check_mark".
+// Connectors without reactions ignore it.
+func (r *Robot) AddReaction(emoji string) RetVal {
+	return r.reactIncoming(emoji, true)
+}
+
+// RemoveReaction removes the robot's reaction from the message that
+// triggered the current command.
+func (r *Robot) RemoveReaction(emoji string) RetVal {
+	return r.reactIncoming(emoji, false)
+}
+
+// AddReactionTo reacts to an arbitrary message in a channel, given the
+// MessageID from a prior Robot.Incoming.
+func (r *Robot) AddReactionTo(ch, messageID, emoji string) RetVal {
+	return r.react(ch, messageID, emoji, true)
+}
+
+// RemoveReactionFrom removes the robot's reaction from an arbitrary
+// message.
+func (r *Robot) RemoveReactionFrom(ch, messageID, emoji string) RetVal {
+	return r.react(ch, messageID, emoji, false)
+}
+
+func (r *Robot) reactIncoming(emoji string, add bool) RetVal {
+	c := r.getContext()
+	if c == nil || c.Incoming == nil || len(c.Incoming.MessageID) == 0 {
+		r.Log(Warn, fmt.Sprintf("No triggering message to react to with '%s'", emoji))
+		return FailedReaction
+	}
+	return r.react(bracket(c.Incoming.ChannelID), c.Incoming.MessageID, emoji, add)
+}
+
+func (r *Robot) react(ch, messageID, emoji string, add bool) RetVal {
+	emoji = strings.Trim(emoji, ":")
+	if len(emoji) == 0 || len(messageID) == 0 {
+		r.Log(Warn, "Ignoring reaction with empty emoji or message ID")
+		return MissingArguments
+	}
+	channel := ch
+	if c := r.getContext(); c != nil {
+		channel = protocolChannel(c.maps, r.source, ch)
+	}
+	return react(r.connector(), channel, messageID, emoji, add)
+}
+
+// replyThread returns the thread for replies to the incoming message: the
+// thread it was posted in, or else a new thread started on the message.
+func (r *Robot) replyThread() string {
+	c := r.getContext()
+	if c == nil {
+		return ""
+	}
+	if len(c.thread) > 0 {
+		return c.thread
+	}
+	if c.Incoming != nil {
+		return c.Incoming.MessageID
+	}
+	return ""
+}
+
+// ReplyInThread directs a message to the user in the thread of the message
+// that started the pipeline, starting a thread if the message was posted in
+// the main channel. Connectors without threads send a normal Reply.
+func (r *Robot) ReplyInThread(msg string) RetVal {
+	return r.InThread(r.replyThread()).Reply(msg)
+}
+
+// SayInThread is like ReplyInThread, but doesn't direct the message to the
+// user.
+func (r *Robot) SayInThread(msg string) RetVal {
+	return r.InThread(r.replyThread()).Say(msg)
+}
+
+// SendChannelMessageThread sends a message to the given thread in a channel,
+// e.g. one saved from Robot.Incoming.ThreadID. Connectors without threads
+// send it to the channel.
+func (r *Robot) SendChannelMessageThread(ch, thread, msg string) RetVal {
+	return r.InThread(thread).SendChannelMessage(ch, msg)
 }
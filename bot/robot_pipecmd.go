@@ -81,19 +81,19 @@ func (r *Robot) ExtendNamespace(ext string, histories int) bool {
 		}
 	}
 
-	var nh int
+	var rememberRuns int
+	var logged bool
 	if histories != -1 {
-		nh = histories
+		rememberRuns, logged = histories, histories > 0
+		if rememberRuns == 0 {
+			rememberRuns = 1
+		}
 	} else {
 		j := c.tasks.getTaskByName(c.jobName)
 		_, _, job := getTask(j)
-		nh = job.HistoryLogs
+		rememberRuns, logged = job.historyRetention()
 	}
 	var jh jobHistory
-	rememberRuns := nh
-	if rememberRuns == 0 {
-		rememberRuns = 1
-	}
 	key := histPrefix + c.jobName + ":" + ext
 	tok, _, ret := checkoutDatum(key, &jh, true)
 	if ret != Ok {
@@ -110,27 +110,32 @@ func (r *Robot) ExtendNamespace(ext string, histories int) bool {
 		hist := historyLog{
 			LogIndex:   c.runIndex,
 			CreateTime: start.Format("Mon Jan 2 15:04:05 MST 2006"),
+			Started:    start,
 		}
 		jh.NextIndex++
 		jh.Histories = append(jh.Histories, hist)
 		l := len(jh.Histories)
-		if l > rememberRuns {
+		if rememberRuns > 0 && l > rememberRuns {
 			jh.Histories = jh.Histories[l-rememberRuns:]
 		}
 		ret := updateDatum(key, tok, jh)
 		if ret != Ok {
 			Log(Error, fmt.Sprintf("Error updating '%s', no history will be remembered for '%s'", key, c.pipeName))
 		} else {
-			if nh > 0 && c.history != nil {
+			if logged && c.history != nil {
 				hspec := c.pipeName + ":" + ext
-				pipeHistory, err := c.history.NewHistory(hspec, hist.LogIndex, nh)
+				pipeHistory, err := c.history.NewHistory(hspec, hist.LogIndex, keepLogs(rememberRuns, hist.LogIndex))
 				if err != nil {
 					Log(Error, fmt.Sprintf("Error starting history for '%s', no history will be recorded: %v", c.pipeName, err))
 				} else {
 					if c.logger != nil {
 						c.logger.Section("close log", fmt.Sprintf("Job '%s' extended namespace: '%s'; starting new log on next task", c.jobName, ext))
 					}
-					c.logger = pipeHistory
+					if oc, ok := c.logger.(*outputCapture); ok {
+						oc.setHistory(pipeHistory)
+					} else {
+						c.logger = pipeHistory
+					}
 					c.logger.Section("new log", fmt.Sprintf("Extended log created by job '%s'", c.jobName))
 					r.Log(Debug, fmt.Sprintf("Started new history for job '%s' with namespace '%s'", c.jobName, ext))
 					if c.verbose {
@@ -149,13 +154,9 @@ func (r *Robot) ExtendNamespace(ext string, histories int) bool {
 			}
 		}
 	}
-	for _, param := range repository.Parameters {
-		name := param.Name
-		value := param.Value
-		_, exists := c.environment[name]
-		if !exists {
-			c.environment[name] = value
-		}
+	if err := setParameters(repository.Parameters, c.environment); err != nil {
+		// unresolved secrets are left unset
+		r.Log(Error, fmt.Sprintf("Setting parameters for repository '%s': %v", repo, err))
 	}
 	// Populate the environment with encrypted parameters for this repository.
 	// Task secrets are populated in runtasks.go/callTask. Note that repo+branch
@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/* runbook.go - the runbook builtin, for running a list of commands in
+   order, e.g. a manual runbook. The list can be given inline, one command
+   per line, or fetched from a URL such as a gist. Each command goes through
+   the same dispatch path as a message from the invoking user in the same
+   channel, so all the normal access controls apply. The runbook stops at the
+   first failed command unless ContinueOnError is configured or requested,
+   then reports a summary. Uploaded files aren't a runbook source, since
+   connectors don't deliver file contents to the robot.
+*/
+
+// maximum size of a runbook fetched from a URL
+const maxRunbookSize = 64 * 1024
+
+const runbookFetchTimeout = 20 * time.Second
+
+type runbookConfig struct {
+	ContinueOnError bool // keep going after a command fails
+	MaxSteps        int  // maximum number of commands in a runbook
+}
+
+const defaultRunbookSteps = 50
+
+func init() {
+	RegisterPlugin("builtin-runbook", PluginHandler{
+		Handler: runbook,
+		Config:  &runbookConfig{},
+	})
+}
+
+// runbookSteps splits a runbook into commands, skipping blank lines and
+// #comments; a robot name or alias for the channel at the start of a line is
+// removed.
+func runbookSteps(text, channel string) []string {
+	var steps []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if msg, addressed := checkAddressed(line, channel); addressed {
+			line = strings.TrimSpace(msg)
+		}
+		if len(line) > 0 {
+			steps = append(steps, line)
+		}
+	}
+	return steps
+}
+
+// fetchRunbook retrieves a runbook from an http(s) URL; a gist page URL is
+// converted to the URL for the raw content.
+func fetchRunbook(ref string) (string, error) {
+	ref = strings.Trim(ref, "<>")
+	u, err := url.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("'%s' isn't an http(s) URL", ref)
+	}
+	if u.Host == "gist.github.com" && !strings.Contains(u.Path, "/raw") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/raw"
+	}
+	client := &http.Client{Timeout: runbookFetchTimeout}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching '%s': %s", u, resp.Status)
+	}
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxRunbookSize + 1})
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxRunbookSize {
+		return "", fmt.Errorf("runbook is larger than %d bytes", maxRunbookSize)
+	}
+	return string(body), nil
+}
+
+// runStep dispatches a single runbook command as if the user had sent it to
+// the robot, checking command, message and job matchers in the same order as
+// handleMessage; it returns "" for success or the reason it failed.
+func (c *botContext) runStep(step string) string {
+	sc := c.clone()
+	sc.pipeName = ""
+	sc.pipeDesc = ""
+	sc.elevated = false
+	sc.isCommand = true
+	sc.runbook = true
+	sc.msg = step
+	if !sc.checkPluginMatchersAndRun(plugCommand) {
+		if !sc.checkPluginMatchersAndRun(plugMessage) {
+			sc.checkJobMatchersAndRun()
+		}
+	}
+	if !sc.dispatched {
+		return "no command was run"
+	}
+	if sc.dispatchRet != Normal {
+		return sc.dispatchRet.String()
+	}
+	return ""
+}
+
+func runbook(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	c := r.getContext()
+	if c.runbook {
+		r.Say("Sorry, runbooks can't run other runbooks")
+		return Fail
+	}
+	var cfg *runbookConfig
+	continueOnError := len(args[0]) > 0
+	maxSteps := defaultRunbookSteps
+	if ret := r.GetTaskConfig(&cfg); ret == Ok {
+		continueOnError = continueOnError || cfg.ContinueOnError
+		if cfg.MaxSteps > 0 {
+			maxSteps = cfg.MaxSteps
+		}
+	}
+	var text string
+	switch command {
+	case "inline":
+		text = args[1]
+	case "fetch":
+		var err error
+		if text, err = fetchRunbook(args[1]); err != nil {
+			r.Log(Error, fmt.Sprintf("Retrieving runbook for user '%s': %v", r.User, err))
+			r.Say(fmt.Sprintf("Sorry, I couldn't retrieve that runbook: %v", err))
+			return Fail
+		}
+	}
+	steps := runbookSteps(text, r.Channel)
+	if len(steps) == 0 {
+		r.Say("That runbook doesn't have any commands")
+		return Fail
+	}
+	if len(steps) > maxSteps {
+		r.Say(fmt.Sprintf("Sorry, that runbook has %d commands, and the maximum is %d", len(steps), maxSteps))
+		return Fail
+	}
+	r.Log(Audit, fmt.Sprintf("User '%s' running runbook of %d commands in channel '%s'", r.User, len(steps), r.Channel))
+	summary := make([]string, 0, len(steps)+1)
+	failed := 0
+	for i, step := range steps {
+		if failed > 0 && !continueOnError {
+			summary = append(summary, fmt.Sprintf("%d. %s - skipped", i+1, step))
+			continue
+		}
+		if reason := c.runStep(step); len(reason) > 0 {
+			failed++
+			summary = append(summary, fmt.Sprintf("%d. %s - FAILED: %s", i+1, step, reason))
+		} else {
+			summary = append(summary, fmt.Sprintf("%d. %s - ok", i+1, step))
+		}
+	}
+	result := "all commands succeeded"
+	if failed > 0 {
+		result = fmt.Sprintf("%d of %d commands failed", failed, len(steps))
+		retval = Fail
+	}
+	r.Fixed().Say(fmt.Sprintf("Runbook finished, %s:\n%s", result, strings.Join(summary, "\n")))
+	return
+}
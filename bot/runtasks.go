@@ -2,6 +2,7 @@ package bot
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,6 +24,14 @@ var envPassThrough = []string{
 // runPipeline.
 func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipelineType, command string, args ...string) (ret TaskRetVal) {
 	task, _, job := getTask(t)
+	// A sub-job, or a runbook step, runs in the pipeline slot of it's parent
+	if parent == nil && !c.runbook {
+		release, ok := c.acquirePipelineSlot(task, ptype)
+		if !ok {
+			return PipelineAborted
+		}
+		defer release()
+	}
 	privThread(fmt.Sprintf("task %s / %s", task.name, command))
 	isJob := job != nil
 	ppipeName := c.pipeName
@@ -34,7 +43,14 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 	botCfg.Lock()
 	botCfg.pluginsRunning++
 	c.timeZone = botCfg.timeZone
+	pctx := botCfg.ctx
 	botCfg.Unlock()
+	// A sub-job is cancelled along with the pipeline that started it
+	if parent != nil {
+		pctx = parent.ctx
+	}
+	c.ctx, c.cancel = context.WithCancel(pctx)
+	defer c.cancel()
 	defer func() {
 		botCfg.Lock()
 		botCfg.pluginsRunning--
@@ -50,6 +66,7 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 	// Once Active, we need to use the Mutex for access to some fields; see
 	// botcontext/type botContext
 	c.registerActive(nil)
+	defer c.startTypingTimer()()
 
 	// A job is always the first task in a pipeline; a new sub-pipeline is created
 	// if a job is added in another pipeline.
@@ -64,10 +81,7 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 		c.workingDirectory = ""
 		c.protected = task.Protected
 		var jh jobHistory
-		rememberRuns := job.HistoryLogs
-		if rememberRuns == 0 {
-			rememberRuns = 1
-		}
+		rememberRuns, logged := job.historyRetention()
 		key := histPrefix + c.jobName
 		tok, _, ret := checkoutDatum(key, &jh, true)
 		if ret != Ok {
@@ -84,19 +98,20 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 			hist := historyLog{
 				LogIndex:   c.runIndex,
 				CreateTime: start.Format("Mon Jan 2 15:04:05 MST 2006"),
+				Started:    start,
 			}
 			jh.NextIndex++
 			jh.Histories = append(jh.Histories, hist)
 			l := len(jh.Histories)
-			if l > rememberRuns {
+			if rememberRuns > 0 && l > rememberRuns {
 				jh.Histories = jh.Histories[l-rememberRuns:]
 			}
 			ret := updateDatum(key, tok, jh)
 			if ret != Ok {
 				Log(Error, fmt.Sprintf("Error updating '%s', no history will be remembered for '%s'", key, c.pipeName))
 			} else {
-				if job.HistoryLogs > 0 && c.history != nil {
-					pipeHistory, err := c.history.NewHistory(c.jobName, hist.LogIndex, job.HistoryLogs)
+				if logged && c.history != nil {
+					pipeHistory, err := c.history.NewHistory(c.jobName, hist.LogIndex, keepLogs(rememberRuns, hist.LogIndex))
 					if err != nil {
 						Log(Error, fmt.Sprintf("Error starting history for '%s', no history will be recorded: %v", c.pipeName, err))
 					} else {
@@ -109,11 +124,13 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 				}
 			}
 		}
-		for _, p := range task.Parameters {
-			_, exists := c.environment[p.Name]
-			if !exists {
-				c.environment[p.Name] = p.Value
-			}
+		if job.NotifyEmail && len(job.Notify) > 0 && ptype == scheduled {
+			c.logger = &outputCapture{HistoryLogger: c.logger}
+		}
+		// Unresolved secrets are left unset here, and fail the job when
+		// callTask resolves it's parameters.
+		if err := setParameters(task.Parameters, c.environment); err != nil {
+			Log(Warn, fmt.Sprintf("Setting parameters for job '%s': %v", task.name, err))
 		}
 		if !job.Quiet || c.verbose {
 			r := c.makeRobot()
@@ -166,10 +183,22 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 		c.runPipeline(ptype, false)
 	}
 	if ret != Normal {
-		if !c.automaticTask && errString != "" {
+		// a catchall exiting Fail is passing the message to the next catchall
+		declined := ptype == catchAll && ret == Fail
+		if !c.automaticTask && errString != "" && !declined {
 			c.makeRobot().Reply(errString)
 		}
 	}
+	if isJob {
+		histSpec := c.jobName
+		if len(c.nsExtension) > 0 {
+			histSpec += ":" + c.nsExtension
+		}
+		recordRunStatus(histSpec, c.runIndex, ret)
+		if job.maxHistoryAge > 0 {
+			pruneHistoryAge(histSpec, c.runIndex, job, c.history)
+		}
+	}
 	if isJob && (!job.Quiet || ret != Normal) {
 		r := c.makeRobot()
 		if ret == Normal {
@@ -185,11 +214,30 @@ func (c *botContext) startPipeline(parent *botContext, t interface{}, ptype pipe
 			}
 			if ret == PipelineAborted {
 				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Job '%s', run number %d aborted, job '%s' already in progress", jobName, c.runIndex, c.exclusiveTag))
+			} else if ret == TaskTimedOut {
+				msg := fmt.Sprintf("Job '%s', run number %d failed, task '%s'%s timed out and was stopped", jobName, c.runIndex, c.failedTask, td)
+				r.SendChannelMessage(c.jobChannel, msg)
+				if len(job.Notify) > 0 {
+					if ret := r.SendUserMessage(job.Notify, msg); ret != Ok {
+						Log(Error, fmt.Sprintf("Notifying user '%s' of timeout for job '%s': %s", job.Notify, jobName, ret))
+					}
+				}
+			} else if ret == TaskCancelled {
+				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Job '%s', run number %d cancelled in task '%s'%s", jobName, c.runIndex, c.failedTask, td))
 			} else {
 				r.SendChannelMessage(c.jobChannel, fmt.Sprintf("Job '%s', run number %d failed in task: '%s'%s, exit code: %s", jobName, c.runIndex, c.failedTask, td, ret))
 			}
+			if oc, ok := c.logger.(*outputCapture); ok && ret != PipelineAborted {
+				if !c.emailFailure(job, jobName, ret, oc) && ret != TaskTimedOut {
+					msg := fmt.Sprintf("Scheduled job '%s', run number %d failed in task '%s'%s, exit code: %s", jobName, c.runIndex, c.failedTask, td, ret)
+					if ret := r.SendUserMessage(job.Notify, msg); ret != Ok {
+						Log(Error, fmt.Sprintf("Notifying user '%s' of failed job '%s': %s", job.Notify, jobName, ret))
+					}
+				}
+			}
 		}
 	}
+	c.auditPipeline(t, ptype, command, args, ret)
 	c.deregister()
 	if c.exclusive {
 		tag := c.exclusiveTag
@@ -236,6 +284,12 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 
 	l := len(p)
 	for i := 0; i < l; i++ {
+		// Final tasks still run, but external ones are killed right away
+		if c.stage != finalTasks && c.ctx.Err() != nil {
+			ret = TaskCancelled
+			errString = "Pipeline cancelled, the robot is shutting down"
+			break
+		}
 		ts := p[i]
 		command := ts.Command
 		args := ts.Arguments
@@ -243,6 +297,9 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 		task, plugin, job := getTask(t)
 		isJob := job != nil
 		isPlugin := plugin != nil
+		// Only tasks added to the pipeline can ignore failures; the task
+		// that started the pipeline always determines the outcome.
+		ignoreFailure := task.IgnoreFailure && c.stage == primaryTasks && !(initialRun && i == 0)
 
 		// Security checks for jobs & plugins
 		if (isJob || isPlugin) && !c.automaticTask && c.stage != finalTasks {
@@ -264,6 +321,10 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 					}
 				}
 			}
+			if plugin != nil && !c.checkBusinessHours(plugin, command) {
+				ret = Fail
+				break
+			}
 			if c.checkAuthorization(t, command, args...) != Success {
 				ret = Fail
 				break
@@ -271,10 +332,12 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 			if !c.elevated {
 				eret, required := c.checkElevation(t, command)
 				if eret != Success {
+					c.elevation = "failed"
 					ret = Fail
 					break
 				}
 				if required {
+					c.elevation = "succeeded"
 					c.elevated = true
 				}
 			}
@@ -287,6 +350,8 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 				emit(CommandTaskRan) // for testing, otherwise noop
 			case plugMessage:
 				emit(AmbientTaskRan)
+			case plugAction:
+				emit(ActionTaskRan)
 			case catchAll:
 				emit(CatchAllTaskRan)
 			case jobTrigger:
@@ -304,9 +369,18 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 			ret = child.startPipeline(c, t, ptype, command, args...)
 		} else {
 			c.debugT(t, fmt.Sprintf("Running task with command '%s' and arguments: %v", command, args), false)
+			// A Format configured for the command applies to the Robot the
+			// task starts with; the task can still change it at runtime.
+			pformat := c.Format
+			if isPlugin {
+				if f, ok := plugin.commandFormat(command); ok {
+					c.Format = f
+				}
+			}
 			errString, ret = c.callTask(t, command, args...)
+			c.Format = pformat
 			c.debug(fmt.Sprintf("Task finished with return value: %s", ret), false)
-			if c.stage != finalTasks && ret != Normal {
+			if c.stage != finalTasks && ret != Normal && !ignoreFailure {
 				c.failedTask = task.name
 				if len(args) > 0 {
 					c.failedTask += " " + strings.Join(args, " ")
@@ -314,6 +388,15 @@ func (c *botContext) runPipeline(ptype pipelineType, initialRun bool) (ret TaskR
 				c.failedTaskDescription = task.Description
 			}
 		}
+		if c.stage == finalTasks && ret != Normal {
+			Log(Warn, fmt.Sprintf("Final task '%s' in pipeline '%s' failed: %s; continuing with remaining final tasks", task.name, c.pipeName, ret))
+		}
+		if ret != Normal && ret != TaskCancelled && ignoreFailure {
+			Log(Warn, fmt.Sprintf("Ignoring failure of task '%s' in pipeline '%s': %s", task.name, c.pipeName, ret))
+			c.debugT(t, fmt.Sprintf("Task failed with '%s', continuing pipeline (IgnoreFailure)", ret), false)
+			ret = Normal
+			errString = ""
+		}
 		if c.stage != finalTasks && ret != Normal {
 			// task / job in pipeline failed
 			break
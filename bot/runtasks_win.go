@@ -4,6 +4,7 @@ package bot
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -93,7 +94,7 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 	// This should only happen in the rare case that a configured authorizer or elevator is disabled
 	if task.Disabled {
 		msg := fmt.Sprintf("callTask failed on disabled task %s; reason: %s", task.name, task.reason)
-		Log(Error, msg)
+		c.log(Error, msg)
 		c.debug(msg, false)
 		return msg, ConfigurationError
 	}
@@ -124,9 +125,9 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 		}
 	}
 	if c.directMsg {
-		Log(Debug, fmt.Sprintf("Dispatching command '%s' to task '%s' with arguments '(omitted for DM)'", command, task.name))
+		c.log(Debug, fmt.Sprintf("Dispatching command '%s' to task '%s' with arguments '(omitted for DM)'", command, task.name))
 	} else {
-		Log(Debug, fmt.Sprintf("Dispatching command '%s' to task '%s' with arguments '%#v'", command, task.name, args))
+		c.log(Debug, fmt.Sprintf("Dispatching command '%s' to task '%s' with arguments '%#v'", command, task.name, args))
 	}
 
 	// Set up the per-task environment
@@ -153,7 +154,7 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 					if !exists {
 						value, err := decrypt(encvalue, key)
 						if err != nil {
-							Log(Error, fmt.Sprintf("Error decrypting '%s' for task namespace '%s': %v", name, task.NameSpace, err))
+							c.log(Error, fmt.Sprintf("Error decrypting '%s' for task namespace '%s': %v", name, task.NameSpace, err))
 							break
 						}
 						envhash[name] = string(value)
@@ -164,22 +165,21 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 	}
 
 	// Configured parameters for a pipeline task don't apply if already set
-	for _, p := range task.Parameters {
-		_, exists := envhash[p.Name]
-		if !exists {
-			envhash[p.Name] = p.Value
-		}
+	if err := setParameters(task.Parameters, envhash); err != nil {
+		errString = fmt.Sprintf("Unable to run task '%s': %v", task.name, err)
+		c.log(Error, errString)
+		return errString, ConfigurationError
 	}
 
 	if isPlugin && plugin.taskType == taskGo {
 		if command != "init" {
 			emit(GoPluginRan)
 		}
-		Log(Debug, fmt.Sprintf("Call go plugin: '%s' with args: %q", task.name, args))
+		c.log(Debug, fmt.Sprintf("Call go plugin: '%s' with args: %q", task.name, args))
 		c.taskenvironment = envhash
-		ret := pluginHandlers[task.name].Handler(r, command, args...)
+		errString, retval = callGoPlugin(r, task.name, command, args...)
 		c.taskenvironment = nil
-		return "", ret
+		return
 	}
 	var taskPath string // full path to the executable
 	var err error
@@ -222,7 +222,7 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 	if winInterpreter {
 		externalArgs = fixInterpreterArgs(interpreter, externalArgs)
 	}
-	Log(Debug, fmt.Sprintf("Calling '%s' with interpreter '%s' and args: %q", taskPath, interpreter, externalArgs))
+	c.log(Debug, fmt.Sprintf("Calling '%s' with interpreter '%s' and args: %q", taskPath, interpreter, externalArgs))
 	var cmd *exec.Cmd
 	if winInterpreter {
 		cmd = exec.Command(interpreter, externalArgs...)
@@ -263,7 +263,7 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 	keys := make([]string, 0, len(envhash))
 	for k, v := range envhash {
 		if len(k) == 0 {
-			Log(Error, fmt.Sprintf("Empty Name value while populating environment for '%s', skipping", task.name))
+			c.log(Error, fmt.Sprintf("Empty Name value while populating environment for '%s', skipping", task.name))
 			continue
 		}
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
@@ -281,12 +281,12 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 			botCfg.RUnlock()
 		}
 	}
-	Log(Debug, fmt.Sprintf("Running '%s' in '%s' with environment vars: '%s'", taskPath, cmd.Dir, strings.Join(keys, "', '")))
+	c.log(Debug, fmt.Sprintf("Running '%s' in '%s' with environment vars: '%s'", taskPath, cmd.Dir, strings.Join(keys, "', '")))
 	var stderr, stdout io.ReadCloser
 	// hold on to stderr in case we need to log an error
 	stderr, err = cmd.StderrPipe()
 	if err != nil {
-		Log(Error, fmt.Errorf("Creating stderr pipe for external command '%s': %v", taskPath, err))
+		c.log(Error, fmt.Errorf("Creating stderr pipe for external command '%s': %v", taskPath, err))
 		errString = fmt.Sprintf("There were errors calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 		return errString, MechanismFail
 	}
@@ -296,29 +296,31 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 	} else {
 		stdout, err = cmd.StdoutPipe()
 		if err != nil {
-			Log(Error, fmt.Errorf("Creating stdout pipe for external command '%s': %v", taskPath, err))
+			c.log(Error, fmt.Errorf("Creating stdout pipe for external command '%s': %v", taskPath, err))
 			errString = fmt.Sprintf("There were errors calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 			return errString, MechanismFail
 		}
 	}
+	timer := newTaskTimer(task, cmd)
 	if err = cmd.Start(); err != nil {
-		Log(Error, fmt.Errorf("Starting command '%s': %v", taskPath, err))
+		c.log(Error, fmt.Errorf("Starting command '%s': %v", taskPath, err))
 		errString = fmt.Sprintf("There were errors calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 		return errString, MechanismFail
 	}
+	timer.start(c.taskContext(), task.name, cmd)
 	if command != "init" {
 		emit(ExternalTaskRan)
 	}
 	if c.logger == nil {
 		var stdErrBytes []byte
 		if stdErrBytes, err = ioutil.ReadAll(stderr); err != nil {
-			Log(Error, fmt.Errorf("Reading from stderr for external command '%s': %v", taskPath, err))
+			c.log(Error, fmt.Errorf("Reading from stderr for external command '%s': %v", taskPath, err))
 			errString = fmt.Sprintf("There were errors calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 			return errString, MechanismFail
 		}
 		stdErrString := string(stdErrBytes)
 		if len(stdErrString) > 0 {
-			Log(Warn, fmt.Errorf("Output from stderr of external command '%s': %s", taskPath, stdErrString))
+			c.log(Warn, fmt.Errorf("Output from stderr of external command '%s': %s", taskPath, stdErrString))
 			errString = fmt.Sprintf("There was error output while calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 			emit(ExternalTaskStderrOutput)
 		}
@@ -326,11 +328,7 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 		closed := make(chan struct{})
 		hl := c.logger
 		go func() {
-			scanner := bufio.NewScanner(stdout)
-			for scanner.Scan() {
-				line := scanner.Text()
-				c.logger.Log("OUT " + line)
-			}
+			c.logOutput(task, stdout)
 			closed <- struct{}{}
 		}()
 		go func() {
@@ -356,7 +354,20 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 			hl.Close()
 		}
 	}
-	if err = cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	switch timer.stop() {
+	case context.DeadlineExceeded:
+		c.log(Error, fmt.Sprintf("External command '%s' was killed after running longer than %s", taskPath, timer.timeout))
+		errString = fmt.Sprintf("Task '%s' timed out after %s and was stopped", task.name, timer.timeout)
+		emit(ExternalTaskTimedOut)
+		return errString, TaskTimedOut
+	case context.Canceled:
+		c.log(Warn, fmt.Sprintf("External command '%s' was killed when it's pipeline was cancelled", taskPath))
+		errString = fmt.Sprintf("Task '%s' was cancelled and stopped", task.name)
+		emit(ExternalTaskCancelled)
+		return errString, TaskCancelled
+	}
+	if err != nil {
 		retval = Fail
 		success := false
 		if exitstatus, ok := err.(*exec.ExitError); ok {
@@ -365,10 +376,14 @@ func (c *botContext) callTask(t interface{}, command string, args ...string) (er
 				if retval == Success {
 					success = true
 				}
+				if status.Signaled() {
+					countPanic(task.name)
+					c.log(Error, fmt.Sprintf("External command '%s' crashed with signal: %s", taskPath, status.Signal()))
+				}
 			}
 		}
 		if !success {
-			Log(Error, fmt.Errorf("Waiting on external command '%s': %v", taskPath, err))
+			c.log(Error, fmt.Errorf("Waiting on external command '%s': %v", taskPath, err))
 			errString = fmt.Sprintf("There were errors calling external task '%s', you might want to ask an administrator to check the logs", task.name)
 			emit(ExternalTaskErrExit)
 		}
@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* scheduled_messages.go - messages to be sent at a future time. Pending
+   messages are stored in the brain, and a sweeper sends them when they come
+   due; since the sweeper reads the brain on every pass, messages scheduled
+   before a restart are still delivered (late, if the robot was down at the
+   due time).
+*/
+
+const scheduledMessageKey = "bot:scheduledmessages"
+
+// how often the sweeper checks for due messages
+const messageSweep = 15 * time.Second
+
+// scheduledMessage is a single pending outbound message
+type scheduledMessage struct {
+	ID              int           // incrementing identifier for list/cancel
+	Due             time.Time     // when to send
+	Creator         string        // user that scheduled the message
+	User            string        // for direct messages, when Channel is ""
+	ProtocolUser    string        // protocol-internal user ID, if known
+	Channel         string        // channel to send to
+	ProtocolChannel string        // protocol-internal channel ID, if known
+	Format          MessageFormat // message format
+	NoUnfurl        bool          // suppress link and media previews
+	Connector       string        // ExtraConnectors protocol to send with, "" for the primary connector
+	Message         string
+}
+
+// scheduledMessages is the datum stored in the brain
+type scheduledMessages struct {
+	NextID   int
+	Messages []scheduledMessage
+}
+
+// time formats accepted by the "say at" command, interpreted in the
+// configured TimeZone
+var sayAtFormats = []string{
+	"2006-01-02 15:04",
+	"2006-01-02 3:04pm",
+	"15:04",
+	"3:04pm",
+	"3pm",
+}
+
+func init() {
+	RegisterPlugin("builtin-schedmsg", PluginHandler{Handler: schedmsg})
+}
+
+// SayAt schedules a message to be sent to the current channel (or user, for
+// Direct()) at time t.
+func (r *Robot) SayAt(t time.Time, msg string) RetVal {
+	if len(msg) == 0 {
+		r.Log(Warn, "Ignoring zero-length message in SayAt")
+		return Ok
+	}
+	if t.Before(time.Now()) {
+		r.Log(Warn, fmt.Sprintf("SayAt called with time in the past (%s), sending immediately", t))
+		return r.Say(msg)
+	}
+	_, ret := scheduleMessage(scheduledMessage{
+		Due:             t,
+		Creator:         r.User,
+		User:            r.User,
+		ProtocolUser:    r.ProtocolUser,
+		Channel:         r.Channel,
+		ProtocolChannel: r.ProtocolChannel,
+		Format:          r.Format,
+		NoUnfurl:        r.messageOptions().NoUnfurl,
+		Connector:       r.source,
+		Message:         msg,
+	})
+	return ret
+}
+
+// scheduleMessage stores a message in the brain, returning the assigned ID
+func scheduleMessage(sm scheduledMessage) (int, RetVal) {
+	var sms scheduledMessages
+	tok, _, ret := checkoutDatum(scheduledMessageKey, &sms, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to schedule message for user '%s'", scheduledMessageKey, sm.Creator))
+		return 0, ret
+	}
+	if sms.NextID == 0 {
+		sms.NextID = 1
+	}
+	sm.ID = sms.NextID
+	sms.NextID++
+	sms.Messages = append(sms.Messages, sm)
+	if ret := updateDatum(scheduledMessageKey, tok, sms); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to schedule message for user '%s'", scheduledMessageKey, sm.Creator))
+		return 0, ret
+	}
+	Log(Debug, fmt.Sprintf("Scheduled message #%d from user '%s' for %s", sm.ID, sm.Creator, sm.Due))
+	return sm.ID, Ok
+}
+
+// cancelMessage removes a scheduled message; only the creator or an admin
+// may cancel. Returns false if not found or not allowed.
+func cancelMessage(id int, user string, admin bool) bool {
+	var sms scheduledMessages
+	tok, _, ret := checkoutDatum(scheduledMessageKey, &sms, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to cancel message #%d", scheduledMessageKey, id))
+		return false
+	}
+	for i, sm := range sms.Messages {
+		if sm.ID == id && (admin || sm.Creator == user) {
+			sms.Messages = append(sms.Messages[:i], sms.Messages[i+1:]...)
+			if ret := updateDatum(scheduledMessageKey, tok, sms); ret != Ok {
+				Log(Error, fmt.Sprintf("Error updating '%s', unable to cancel message #%d", scheduledMessageKey, id))
+				return false
+			}
+			return true
+		}
+	}
+	checkinDatum(scheduledMessageKey, tok)
+	return false
+}
+
+// sendDueMessages sends and removes any messages whose time has come
+func sendDueMessages() {
+	var sms scheduledMessages
+	tok, exists, ret := checkoutDatum(scheduledMessageKey, &sms, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to send scheduled messages", scheduledMessageKey))
+		return
+	}
+	if !exists {
+		checkinDatum(scheduledMessageKey, tok)
+		return
+	}
+	now := time.Now()
+	var due []scheduledMessage
+	pending := make([]scheduledMessage, 0, len(sms.Messages))
+	for _, sm := range sms.Messages {
+		if sm.Due.After(now) {
+			pending = append(pending, sm)
+		} else {
+			due = append(due, sm)
+		}
+	}
+	if len(due) == 0 {
+		checkinDatum(scheduledMessageKey, tok)
+		return
+	}
+	sms.Messages = pending
+	if ret := updateDatum(scheduledMessageKey, tok, sms); ret != Ok {
+		// don't send, or the messages would be sent again on the next sweep
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to send scheduled messages", scheduledMessageKey))
+		return
+	}
+	for _, sm := range due {
+		var ret RetVal
+		conn := connectorFor(sm.Connector)
+		if sm.Channel == "" {
+			user := sm.ProtocolUser
+			if len(user) == 0 {
+				user = sm.User
+			}
+			ret = sendProtocolUserMessage(conn, user, sm.Message, sm.Format, MessageOptions{NoUnfurl: sm.NoUnfurl})
+		} else {
+			channel := sm.ProtocolChannel
+			if len(channel) == 0 {
+				channel = sm.Channel
+			}
+			ret = sendProtocolChannelMessage(conn, channel, sm.Message, sm.Format, MessageOptions{NoUnfurl: sm.NoUnfurl})
+		}
+		if ret != Ok {
+			Log(Error, fmt.Sprintf("Sending scheduled message #%d from user '%s' failed: %s", sm.ID, sm.Creator, ret))
+		} else if late := now.Sub(sm.Due); late > 2*messageSweep {
+			Log(Warn, fmt.Sprintf("Sent scheduled message #%d from user '%s' %s late", sm.ID, sm.Creator, late.Round(time.Second)))
+		}
+	}
+}
+
+// runMessageSweeper sends scheduled messages as they come due, until the
+// robot shuts down. Overdue messages from before a restart are sent on the
+// first pass, after the connector has had time to connect.
+func runMessageSweeper(done <-chan struct{}) {
+	sweep := time.NewTicker(messageSweep)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-sweep.C:
+			sendDueMessages()
+		case <-done:
+			return
+		}
+	}
+}
+
+// parseSayAt interprets a time in the configured TimeZone; times without a
+// date are taken as the next occurrence.
+func parseSayAt(ts string) (time.Time, bool) {
+	botCfg.RLock()
+	tz := botCfg.timeZone
+	botCfg.RUnlock()
+	if tz == nil {
+		tz = time.Local
+	}
+	now := time.Now().In(tz)
+	ts = strings.ToLower(ts)
+	for _, layout := range sayAtFormats {
+		t, err := time.ParseInLocation(layout, ts, tz)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(layout, "2006") {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, tz)
+			if t.Before(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func schedmsg(r *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return
+	}
+	botCfg.RLock()
+	tz := botCfg.timeZone
+	botCfg.RUnlock()
+	if tz == nil {
+		tz = time.Local
+	}
+	const tf = "Mon Jan 2 15:04 MST 2006"
+	switch command {
+	case "schedule":
+		t, ok := parseSayAt(args[0])
+		if !ok {
+			r.Say(fmt.Sprintf("Sorry, I don't understand the time '%s'; try e.g. '17:00', '5pm' or '2006-01-02 15:04'", args[0]))
+			return
+		}
+		id, ret := scheduleMessage(scheduledMessage{
+			Due:             t,
+			Creator:         r.User,
+			User:            r.User,
+			ProtocolUser:    r.ProtocolUser,
+			Channel:         r.Channel,
+			ProtocolChannel: r.ProtocolChannel,
+			Format:          r.Format,
+			Connector:       r.source,
+			Message:         args[1],
+		})
+		if ret != Ok {
+			r.Say("I had a problem scheduling the message, check the log")
+			return
+		}
+		r.Say(fmt.Sprintf("Ok, I'll send that at %s (message #%d)", t.In(tz).Format(tf), id))
+	case "list":
+		var sms scheduledMessages
+		_, _, ret := checkoutDatum(scheduledMessageKey, &sms, false)
+		if ret != Ok {
+			r.Say("I had a problem retrieving scheduled messages, check the log")
+			return
+		}
+		admin := r.CheckAdmin()
+		sml := make([]string, 0, len(sms.Messages)+1)
+		sml = append(sml, "Here are the scheduled messages:")
+		for _, sm := range sms.Messages {
+			if !admin && sm.Creator != r.User {
+				continue
+			}
+			dest := sm.Channel
+			if dest == "" {
+				dest = "(direct message to " + sm.User + ")"
+			}
+			sml = append(sml, fmt.Sprintf("#%d: %s in %s from %s: %s", sm.ID, sm.Due.In(tz).Format(tf), dest, sm.Creator, sm.Message))
+		}
+		if len(sml) == 1 {
+			r.Say("There are no scheduled messages")
+			return
+		}
+		r.Fixed().Say(strings.Join(sml, "\n"))
+	case "cancel":
+		id, _ := strconv.Atoi(args[0])
+		if cancelMessage(id, r.User, r.CheckAdmin()) {
+			r.Say(fmt.Sprintf("Cancelled scheduled message #%d", id))
+		} else {
+			r.Say(fmt.Sprintf("I don't have a scheduled message #%s that you can cancel", args[0]))
+		}
+	}
+	return
+}
@@ -0,0 +1,30 @@
+// +build integration
+
+package bot_test
+
+// scheduled_messages_integration_test.go - verification of scheduling,
+// listing and cancelling messages.
+
+import (
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func TestScheduledMessages(t *testing.T) {
+	done, conn := setup("resources/cfg/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{aliceID, general, ";at 2099-01-01 12:00 say Happy new century!", []testc.TestMessage{{null, general, `Ok, I'll send that at .* \(message #1\)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";at 25:00 say lunch", []testc.TestMessage{{null, general, `Sorry, I don't understand the time.*`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bobID, general, ";list scheduled messages", []testc.TestMessage{{null, general, "There are no scheduled messages"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckFailed}, 0},
+		{aliceID, general, ";list scheduled messages", []testc.TestMessage{{null, general, `(?s:^HERE ARE THE SCHEDULED MESSAGES:\n#1: .* IN GENERAL FROM ALICE: HAPPY NEW CENTURY!$)`}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{bobID, general, ";cancel scheduled message 1", []testc.TestMessage{{null, general, "I don't have a scheduled message #1 that you can cancel"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckFailed}, 0},
+		{aliceID, general, ";cancel scheduled message 1", []testc.TestMessage{{null, general, "Cancelled scheduled message #1"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{aliceID, general, ";list scheduled messages", []testc.TestMessage{{null, general, "There are no scheduled messages"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
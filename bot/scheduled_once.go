@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* scheduled_once.go - one-shot tasks, run once after a delay with
+   ScheduleAfter, e.g. for a "remind me in 30 minutes" plugin. Each pending
+   task has a timer, and is also stored in the brain; when the robot starts,
+   timers are re-armed for tasks still pending, and tasks whose time passed
+   while the robot was down are dropped.
+*/
+
+const oneShotKey = "bot:oneshottasks"
+
+// oneShotTask is a single pending task
+type oneShotTask struct {
+	ID      int       // incrementing identifier for cancelling
+	Due     time.Time // when to run
+	Creator string    // user that scheduled the task
+	Channel string    // channel the task runs in
+	TaskSpec
+}
+
+// oneShotTasks is the datum stored in the brain
+type oneShotTasks struct {
+	NextID int
+	Tasks  []oneShotTask
+}
+
+var oneShotTimers = struct {
+	m map[int]*time.Timer
+	sync.Mutex
+}{
+	make(map[int]*time.Timer),
+	sync.Mutex{},
+}
+
+// ScheduleAfter runs a job or plugin once, after duration d, returning an ID
+// that can be used with CancelScheduled. For plugins, the first argument is
+// the plugin command, followed by the command's arguments; a plugin can only
+// schedule it's own commands. The task runs in it's configured Channel, or
+// the current channel if none is configured, without authorization or
+// elevation checks, like ScheduledJobs. Pending tasks are stored in the
+// brain, and survive a restart.
+func (r *Robot) ScheduleAfter(d time.Duration, name string, args ...string) (int, RetVal) {
+	c := r.getContext()
+	t := c.tasks.getTaskByName(name)
+	if t == nil {
+		r.Log(Error, fmt.Sprintf("task '%s' not found in ScheduleAfter", name))
+		return 0, TaskNotFound
+	}
+	task, plugin, job := getTask(t)
+	if plugin == nil && job == nil {
+		r.Log(Error, fmt.Sprintf("ScheduleAfter called with '%s', not a job or plugin", name))
+		return 0, InvalidTaskType
+	}
+	if task.Disabled {
+		r.Log(Error, fmt.Sprintf("ScheduleAfter called with disabled task '%s'", name))
+		return 0, TaskDisabled
+	}
+	ts := TaskSpec{Name: name, Arguments: args}
+	if plugin != nil {
+		// the one-shot skips the checks the plugin's commands normally get,
+		// so a plugin can't schedule, say, an admin command
+		caller := ""
+		if c.currentTask != nil {
+			ct, _, _ := getTask(c.currentTask)
+			caller = ct.name
+		}
+		if caller != task.name {
+			r.Log(Error, fmt.Sprintf("ScheduleAfter called for plugin '%s' from another task; plugins can only schedule their own commands", name))
+			return 0, InvalidTaskType
+		}
+		if len(args) == 0 || len(args[0]) == 0 {
+			r.Log(Error, fmt.Sprintf("ScheduleAfter called for plugin '%s' with no command", name))
+			return 0, MissingArguments
+		}
+		ts.Command = args[0]
+		ts.Arguments = args[1:]
+	}
+	channel := task.Channel
+	if len(channel) == 0 {
+		channel = r.Channel
+	}
+	if len(channel) == 0 {
+		r.Log(Error, fmt.Sprintf("ScheduleAfter called for '%s' with no Channel configured, from a direct message", name))
+		return 0, ChannelNotFound
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	var ots oneShotTasks
+	tok, _, ret := checkoutDatum(oneShotKey, &ots, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to schedule task '%s'", oneShotKey, name))
+		return 0, ret
+	}
+	if ots.NextID == 0 {
+		ots.NextID = 1
+	}
+	ot := oneShotTask{
+		ID:       ots.NextID,
+		Due:      time.Now().Add(d),
+		Creator:  r.User,
+		Channel:  channel,
+		TaskSpec: ts,
+	}
+	ots.NextID++
+	ots.Tasks = append(ots.Tasks, ot)
+	if ret := updateDatum(oneShotKey, tok, ots); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to schedule task '%s'", oneShotKey, name))
+		return 0, ret
+	}
+	armOneShot(ot.ID, d)
+	r.Log(Debug, fmt.Sprintf("Scheduled one-shot task #%d: '%s %s' in %s", ot.ID, name, strings.Join(args, " "), d))
+	return ot.ID, Ok
+}
+
+// CancelScheduled cancels a pending task from ScheduleAfter.
+func (r *Robot) CancelScheduled(id int) RetVal {
+	oneShotTimers.Lock()
+	if timer, ok := oneShotTimers.m[id]; ok {
+		timer.Stop()
+		delete(oneShotTimers.m, id)
+	}
+	oneShotTimers.Unlock()
+	if _, found := removeOneShot(id); !found {
+		r.Log(Warn, fmt.Sprintf("CancelScheduled called for unknown one-shot task #%d", id))
+		return TaskNotFound
+	}
+	r.Log(Debug, fmt.Sprintf("Cancelled one-shot task #%d", id))
+	return Ok
+}
+
+func armOneShot(id int, d time.Duration) {
+	oneShotTimers.Lock()
+	oneShotTimers.m[id] = time.AfterFunc(d, func() { runOneShot(id) })
+	oneShotTimers.Unlock()
+}
+
+// removeOneShot removes a pending task from the brain, returning it if found.
+func removeOneShot(id int) (oneShotTask, bool) {
+	var ots oneShotTasks
+	tok, _, ret := checkoutDatum(oneShotKey, &ots, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to remove one-shot task #%d", oneShotKey, id))
+		return oneShotTask{}, false
+	}
+	for i, ot := range ots.Tasks {
+		if ot.ID == id {
+			ots.Tasks = append(ots.Tasks[:i], ots.Tasks[i+1:]...)
+			if ret := updateDatum(oneShotKey, tok, ots); ret != Ok {
+				Log(Error, fmt.Sprintf("Error updating '%s', unable to remove one-shot task #%d", oneShotKey, id))
+				return oneShotTask{}, false
+			}
+			return ot, true
+		}
+	}
+	checkinDatum(oneShotKey, tok)
+	return oneShotTask{}, false
+}
+
+// runOneShot is called when a one-shot timer fires.
+func runOneShot(id int) {
+	oneShotTimers.Lock()
+	delete(oneShotTimers.m, id)
+	oneShotTimers.Unlock()
+	botCfg.RLock()
+	shuttingDown := botCfg.shuttingDown
+	botCfg.RUnlock()
+	if shuttingDown {
+		Log(Warn, fmt.Sprintf("Not running one-shot task #%d during shutdown", id))
+		return
+	}
+	ot, found := removeOneShot(id)
+	if !found {
+		// cancelled
+		return
+	}
+	currentTasks.Lock()
+	tasks := taskList{
+		currentTasks.t,
+		currentTasks.nameMap,
+		currentTasks.idMap,
+		currentTasks.nameSpaces,
+	}
+	currentTasks.Unlock()
+	t := tasks.getTaskByName(ot.Name)
+	if t == nil {
+		Log(Error, fmt.Sprintf("Task '%s' not found running one-shot task #%d", ot.Name, id))
+		return
+	}
+	task, _, _ := getTask(t)
+	if task.Disabled {
+		Log(Error, fmt.Sprintf("Not running one-shot task #%d, '%s' is disabled; reason: %s", id, ot.Name, task.reason))
+		return
+	}
+	confLock.RLock()
+	repolist := repositories
+	confLock.RUnlock()
+	runScheduledTask(t, ot.TaskSpec, ot.Channel, tasks, repolist)
+}
+
+// restoreOneShots re-arms timers for pending one-shot tasks when the robot
+// starts, dropping any whose time has passed.
+func restoreOneShots() {
+	var ots oneShotTasks
+	tok, exists, ret := checkoutDatum(oneShotKey, &ots, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to restore one-shot tasks", oneShotKey))
+		return
+	}
+	if !exists || len(ots.Tasks) == 0 {
+		checkinDatum(oneShotKey, tok)
+		return
+	}
+	now := time.Now()
+	pending := make([]oneShotTask, 0, len(ots.Tasks))
+	for _, ot := range ots.Tasks {
+		if ot.Due.Before(now) {
+			Log(Warn, fmt.Sprintf("Dropping one-shot task #%d, '%s' from user '%s', due at %s while the robot was down", ot.ID, ot.Name, ot.Creator, ot.Due.Format(time.RFC3339)))
+			continue
+		}
+		pending = append(pending, ot)
+	}
+	if len(pending) < len(ots.Tasks) {
+		ots.Tasks = pending
+		if ret := updateDatum(oneShotKey, tok, ots); ret != Ok {
+			Log(Error, fmt.Sprintf("Error updating '%s' removing expired one-shot tasks", oneShotKey))
+		}
+	} else {
+		checkinDatum(oneShotKey, tok)
+	}
+	for _, ot := range pending {
+		armOneShot(ot.ID, ot.Due.Sub(now))
+	}
+	Log(Info, fmt.Sprintf("Restored %d pending one-shot task(s)", len(pending)))
+}
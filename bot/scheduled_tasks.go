@@ -2,7 +2,10 @@ package bot
 
 import (
 	"fmt"
+	"math/rand"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron"
 )
@@ -10,6 +13,115 @@ import (
 var taskRunner *cron.Cron
 var schedMutex sync.Mutex
 
+// when true, ScheduledJobs are loaded but the taskRunner isn't running;
+// protected by schedMutex
+var schedulesPaused bool
+
+const everyPrefix = "@every "
+
+// last successful runs of ScheduledJobs with CatchUp
+const scheduledRunsKey = "bot:scheduledruns"
+
+// how long to wait before catch-up runs at startup, giving the connector
+// time to connect
+const catchUpDelay = 10 * time.Second
+
+// whether missed runs have been checked since startup; protected by
+// schedMutex
+var catchUpChecked bool
+
+// parseSchedule parses the Schedule for a ScheduledJob; either a cron spec
+// with seconds, a descriptor like "@hourly", or an interval from startup
+// like "@every 10m". Intervals run independently of the TimeZone.
+func parseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, everyPrefix) {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, everyPrefix)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in schedule '%s': %v", spec, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval in schedule '%s' is less than one second", spec)
+		}
+		return cron.Every(interval), nil
+	}
+	sched, err := cron.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule '%s': %v", spec, err)
+	}
+	return sched, nil
+}
+
+// zoneSchedule computes the runs for a job with it's own TimeZone, rather
+// than the robot's.
+type zoneSchedule struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+func (z zoneSchedule) Next(t time.Time) time.Time {
+	return z.Schedule.Next(t.In(z.loc))
+}
+
+// parseJitter parses the optional Jitter for a ScheduledJob.
+func parseJitter(jitter string) (time.Duration, error) {
+	if len(jitter) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(jitter)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Jitter '%s': %v", jitter, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative Jitter '%s'", jitter)
+	}
+	return d, nil
+}
+
+// limitJitter keeps a job's jitter under half of the shortest time between
+// runs, so a delayed run can't reach the next one.
+func limitJitter(sched cron.Schedule, jitter time.Duration) time.Duration {
+	var minGap time.Duration
+	next := sched.Next(time.Now())
+	for i := 0; i < 10 && !next.IsZero(); i++ {
+		following := sched.Next(next)
+		if following.IsZero() {
+			break
+		}
+		if gap := following.Sub(next); minGap == 0 || gap < minGap {
+			minGap = gap
+		}
+		next = following
+	}
+	if minGap > 0 && jitter > minGap/2 {
+		return minGap / 2
+	}
+	return jitter
+}
+
+// scheduleKey identifies a ScheduledJob for recording it's runs
+func scheduleKey(st ScheduledTask) string {
+	return fmt.Sprintf("%s %s|%s", st.Name, strings.Join(st.Arguments, " "), st.Schedule)
+}
+
+// recordScheduledRun stores the time of a successful run of a ScheduledJob
+// with CatchUp.
+func recordScheduledRun(key string, t time.Time) {
+	var runs map[string]time.Time
+	tok, _, ret := checkoutDatum(scheduledRunsKey, &runs, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to record run of '%s'", scheduledRunsKey, key))
+		return
+	}
+	if runs == nil {
+		runs = make(map[string]time.Time)
+	}
+	runs[key] = t
+	if ret := updateDatum(scheduledRunsKey, tok, runs); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to record run of '%s'", scheduledRunsKey, key))
+	}
+}
+
 func scheduleTasks() {
 	schedMutex.Lock()
 	if taskRunner != nil {
@@ -37,6 +149,19 @@ func scheduleTasks() {
 	confLock.RLock()
 	repolist := repositories
 	confLock.RUnlock()
+	var lastRuns map[string]time.Time
+	var catchUps []func()
+	if !catchUpChecked {
+		if _, _, ret := checkoutDatum(scheduledRunsKey, &lastRuns, false); ret != Ok {
+			Log(Error, fmt.Sprintf("Error retrieving '%s', not checking for missed scheduled runs", scheduledRunsKey))
+		}
+		catchUpChecked = true
+	}
+	now := time.Now()
+	loc := time.Local
+	if tz != nil {
+		loc = tz
+	}
 	for _, st := range scheduled {
 		t := tasks.getTaskByName(st.Name)
 		if t == nil {
@@ -56,27 +181,207 @@ func scheduleTasks() {
 			Log(Error, fmt.Sprintf("Not scheduling job '%s'; zero-length Channel", st.Name))
 			continue
 		}
+		sched, err := parseSchedule(st.Schedule)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Not scheduling job '%s': %v", st.Name, err))
+			continue
+		}
+		if len(st.TimeZone) > 0 {
+			zone, err := time.LoadLocation(st.TimeZone)
+			if err != nil {
+				Log(Error, fmt.Sprintf("Not scheduling job '%s': invalid TimeZone '%s': %v", st.Name, st.TimeZone, err))
+				continue
+			}
+			if _, ok := sched.(cron.ConstantDelaySchedule); ok {
+				Log(Warn, fmt.Sprintf("TimeZone '%s' for job '%s' has no effect with an interval schedule", st.TimeZone, st.Name))
+			} else {
+				Log(Info, fmt.Sprintf("Job '%s' scheduled in TimeZone: %s", st.Name, zone))
+				sched = zoneSchedule{sched, zone}
+			}
+		}
+		jitter, err := parseJitter(st.Jitter)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Not scheduling job '%s': %v", st.Name, err))
+			continue
+		}
+		if limited := limitJitter(sched, jitter); limited < jitter {
+			Log(Warn, fmt.Sprintf("Jitter %s for job '%s' is too long for it's schedule, reducing to %s", jitter, st.Name, limited))
+			jitter = limited
+		}
 		ts := st.TaskSpec
-		Log(Info, fmt.Sprintf("Scheduling job '%s', args '%v' with schedule: %s", ts.Name, ts.Arguments, st.Schedule))
-		taskRunner.AddFunc(st.Schedule, func() { runScheduledTask(t, ts, tasks, repolist) })
+		if every, ok := sched.(cron.ConstantDelaySchedule); ok {
+			Log(Info, fmt.Sprintf("Scheduling job '%s', args '%v' to run every %s", ts.Name, ts.Arguments, every.Delay))
+		} else {
+			Log(Info, fmt.Sprintf("Scheduling job '%s', args '%v' with schedule: %s", ts.Name, ts.Arguments, st.Schedule))
+		}
+		if jitter > 0 {
+			Log(Info, fmt.Sprintf("Runs of job '%s' will be delayed randomly by up to %s", ts.Name, jitter))
+		}
+		catchUp := st.CatchUp
+		key := scheduleKey(st)
+		run := func(started time.Time) {
+			if ret := runScheduledTask(t, ts, task.Channel, tasks, repolist); ret == Normal && catchUp {
+				recordScheduledRun(key, started)
+			}
+		}
+		if last, ok := lastRuns[key]; ok && catchUp {
+			// times from the brain need the scheduler's location
+			last = last.In(loc)
+			if missed := sched.Next(last); !missed.IsZero() && missed.Before(now) {
+				Log(Info, fmt.Sprintf("Job '%s' missed a scheduled run at %s (last run: %s), catching up", ts.Name, missed.Format(time.RFC3339), last.Format(time.RFC3339)))
+				catchUps = append(catchUps, func() { run(time.Now()) })
+			}
+		}
+		taskRunner.Schedule(sched, cron.FuncJob(func() {
+			started := time.Now()
+			if jitter > 0 {
+				delay := time.Duration(rand.Int63n(int64(jitter)))
+				Log(Info, fmt.Sprintf("Delaying scheduled job '%s' by %s (Jitter: %s)", ts.Name, delay.Round(time.Millisecond), jitter))
+				time.Sleep(delay)
+				schedMutex.Lock()
+				paused := schedulesPaused
+				schedMutex.Unlock()
+				if paused {
+					Log(Info, fmt.Sprintf("Not running scheduled job '%s', schedules paused during Jitter delay", ts.Name))
+					return
+				}
+			}
+			run(started)
+		}))
+	}
+	if len(catchUps) > 0 {
+		time.AfterFunc(catchUpDelay, func() {
+			for _, catchUp := range catchUps {
+				go catchUp()
+			}
+		})
+	}
+	if schedulesPaused {
+		Log(Info, "Scheduled jobs are paused, not starting the scheduler")
+	} else {
+		taskRunner.Start()
 	}
-	taskRunner.Start()
 	schedMutex.Unlock()
 }
 
-func runScheduledTask(t interface{}, ts TaskSpec, tasks taskList, repolist map[string]repository) {
+// PauseSchedules stops running ScheduledJobs, e.g. during a maintenance
+// window, until ResumeSchedules is called; jobs that come due while paused
+// are skipped, not run on resume. Returns false if already paused.
+func (r *Robot) PauseSchedules() bool {
+	schedMutex.Lock()
+	defer schedMutex.Unlock()
+	if schedulesPaused {
+		return false
+	}
+	schedulesPaused = true
+	if taskRunner != nil {
+		taskRunner.Stop()
+	}
+	r.Log(Info, fmt.Sprintf("Scheduled jobs paused, requested by user '%s'", r.User))
+	return true
+}
+
+// ResumeSchedules restarts ScheduledJobs paused with PauseSchedules. Returns
+// false if they weren't paused.
+func (r *Robot) ResumeSchedules() bool {
+	schedMutex.Lock()
+	defer schedMutex.Unlock()
+	if !schedulesPaused {
+		return false
+	}
+	schedulesPaused = false
+	if taskRunner != nil {
+		taskRunner.Start()
+	}
+	r.Log(Info, fmt.Sprintf("Scheduled jobs resumed, requested by user '%s'", r.User))
+	return true
+}
+
+// SchedulesPaused reports whether ScheduledJobs are paused.
+func (r *Robot) SchedulesPaused() bool {
+	schedMutex.Lock()
+	defer schedMutex.Unlock()
+	return schedulesPaused
+}
+
+// scheduleStatus is the 'schedule status' admin command.
+func scheduleStatus(r *Robot) {
+	schedMutex.Lock()
+	paused := schedulesPaused
+	var jobs int
+	if taskRunner != nil {
+		jobs = len(taskRunner.Entries())
+	}
+	schedMutex.Unlock()
+	state := "running"
+	if paused {
+		state = "paused"
+	}
+	r.Say(fmt.Sprintf("Scheduled jobs are %s; %d job(s) scheduled", state, jobs))
+}
+
+// runJobNow is the 'run job <name> now' admin command, for testing a
+// scheduled job without waiting for it's schedule. The job runs out of band
+// just as the scheduler would run it, without authorization or elevation,
+// and reports status in the job's channel.
+func runJobNow(r *Robot, name, argstr string) {
+	currentTasks.Lock()
+	tasks := taskList{
+		currentTasks.t,
+		currentTasks.nameMap,
+		currentTasks.idMap,
+		currentTasks.nameSpaces,
+	}
+	currentTasks.Unlock()
+	var t interface{}
+	if i, ok := tasks.nameMap[name]; ok {
+		t = tasks.t[i]
+	}
+	if t == nil {
+		r.Say(fmt.Sprintf("I don't have a job named '%s'", name))
+		return
+	}
+	task, _, job := getTask(t)
+	if job == nil {
+		r.Say(fmt.Sprintf("'%s' isn't a job", name))
+		return
+	}
+	if task.Disabled {
+		r.Say(fmt.Sprintf("Job '%s' is disabled: %s", name, task.reason))
+		return
+	}
+	args := strings.Fields(argstr)
+	if len(args) != len(job.Arguments) {
+		r.Say(fmt.Sprintf("Wrong number of arguments for job '%s', %d configured but %d given", name, len(job.Arguments), len(args)))
+		return
+	}
+	for i, arg := range args {
+		if !job.Arguments[i].re.MatchString(arg) {
+			r.Say(fmt.Sprintf("'%s' doesn't match the pattern for argument '%s'", arg, job.Arguments[i].Label))
+			return
+		}
+	}
+	confLock.RLock()
+	repolist := repositories
+	confLock.RUnlock()
+	r.Log(Info, fmt.Sprintf("Running job '%s' out of band, requested by user '%s'", name, r.User))
+	r.Say(fmt.Sprintf("Running job '%s' now; status will be posted in channel '%s'", name, task.Channel))
+	go runScheduledTask(t, TaskSpec{Name: name, Arguments: args}, task.Channel, tasks, repolist)
+}
+
+func runScheduledTask(t interface{}, ts TaskSpec, channel string, tasks taskList, repolist map[string]repository) TaskRetVal {
 	task, plugin, _ := getTask(t)
 	isPlugin := plugin != nil
 	if isPlugin && len(ts.Command) == 0 {
 		Log(Error, fmt.Sprintf("Empty 'Command' when running scheduled task '%s' of type plugin", ts.Name))
-		return
+		return ConfigurationError
 	}
 
 	botCfg.RLock()
 	// Create the botContext to carry state through the pipeline.
 	// startPipeline will take care of registerActive()
 	c := &botContext{
-		Channel:       task.Channel,
+		Channel:       channel,
 		tasks:         tasks,
 		repositories:  repolist,
 		isCommand:     isPlugin,
@@ -92,5 +397,5 @@ func runScheduledTask(t interface{}, ts TaskSpec, tasks taskList, repolist map[s
 		command = "run"
 	}
 	Log(Info, fmt.Sprintf("Starting scheduled task: %s", task.name))
-	c.startPipeline(nil, t, scheduled, command, ts.Arguments...)
+	return c.startPipeline(nil, t, scheduled, command, ts.Arguments...)
 }
@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* secretparams.go - resolution of ${secret:NAME} references in configured
+   task, job and repository Parameters, so API keys don't need to appear in
+   plaintext yaml. References are resolved from the configured SecretSource
+   when the environment is built for a task; a reference that can't be
+   resolved fails the task, rather than handing it the literal string.
+   Resolved values are never logged.
+
+   Sources are pluggable with RegisterSecretSource; built in are:
+   - brain (default): encrypted secrets stored by an admin with
+     'store global secret NAME=value', in a namespace tasks can't read
+   - env: environment variables, SecretSourceConfig: { Prefix: "..." }
+   - file: one file per secret, SecretSourceConfig: { Directory: "..." }
+*/
+
+const globalSecretKey = "bot:globalsecrets"
+
+const defaultSecretSource = "brain"
+
+var secretRefRe = regexp.MustCompile(`\$\{secret:([\w-.]+)\}`)
+
+// SecretSource looks up the value of secrets referenced in Parameters; found
+// is false when the source doesn't have the secret.
+type SecretSource interface {
+	Secret(name string) (value string, found bool, err error)
+}
+
+var secretSources = make(map[string]func(json.RawMessage) (SecretSource, error))
+
+// RegisterSecretSource allows secret source implementations to register a
+// function with a named source type, called with the SecretSourceConfig from
+// gopherbot.yaml.
+func RegisterSecretSource(name string, source func(json.RawMessage) (SecretSource, error)) {
+	if stopRegistrations {
+		return
+	}
+	if secretSources[name] != nil {
+		log.Fatal("Attempted registration of duplicate secret source name:", name)
+	}
+	secretSources[name] = source
+}
+
+func init() {
+	RegisterSecretSource("brain", func(json.RawMessage) (SecretSource, error) {
+		return brainSecrets{}, nil
+	})
+	RegisterSecretSource("env", func(cfg json.RawMessage) (SecretSource, error) {
+		var es envSecrets
+		if cfg != nil {
+			if err := json.Unmarshal(cfg, &es); err != nil {
+				return nil, err
+			}
+		}
+		return es, nil
+	})
+	RegisterSecretSource("file", func(cfg json.RawMessage) (SecretSource, error) {
+		fs := fileSecrets{Directory: "/run/secrets"}
+		if cfg != nil {
+			if err := json.Unmarshal(cfg, &fs); err != nil {
+				return nil, err
+			}
+		}
+		return fs, nil
+	})
+}
+
+// brainSecrets are stored encrypted in the brain by an administrator
+type brainSecrets struct{}
+
+func (brainSecrets) Secret(name string) (string, bool, error) {
+	cryptKey.RLock()
+	initialized := cryptKey.initialized
+	key := cryptKey.key
+	cryptKey.RUnlock()
+	if !initialized {
+		return "", false, fmt.Errorf("encryption not initialized")
+	}
+	var secrets map[string][]byte
+	_, _, ret := checkoutDatum(globalSecretKey, &secrets, false)
+	if ret != Ok {
+		return "", false, fmt.Errorf("retrieving '%s': %s", globalSecretKey, ret)
+	}
+	encvalue, ok := secrets[name]
+	if !ok {
+		return "", false, nil
+	}
+	value, err := decrypt(encvalue, key)
+	if err != nil {
+		return "", false, fmt.Errorf("decrypting: %v", err)
+	}
+	return string(value), true, nil
+}
+
+// envSecrets are read from the robot's environment, optionally with a prefix
+type envSecrets struct {
+	Prefix string
+}
+
+func (es envSecrets) Secret(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(es.Prefix + name)
+	return value, ok, nil
+}
+
+// fileSecrets are read from files in a directory, as provided by e.g. Docker
+// and Kubernetes; a trailing newline is removed.
+type fileSecrets struct {
+	Directory string
+}
+
+func (fs fileSecrets) Secret(name string) (string, bool, error) {
+	value, err := ioutil.ReadFile(filepath.Join(fs.Directory, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(string(value), "\r\n"), true, nil
+}
+
+// storeGlobalSecret encrypts and stores a secret for the brain source
+func storeGlobalSecret(name, rawvalue string) RetVal {
+	cryptKey.RLock()
+	initialized := cryptKey.initialized
+	key := cryptKey.key
+	cryptKey.RUnlock()
+	if !initialized {
+		return BrainFailed
+	}
+	value, err := encrypt([]byte(rawvalue), key)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Problem encrypting global secret '%s': %v", name, err))
+		return BrainFailed
+	}
+	var secrets map[string][]byte
+	tok, _, ret := checkoutDatum(globalSecretKey, &secrets, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error checking out '%s', unable to store secret '%s'", globalSecretKey, name))
+		return ret
+	}
+	if secrets == nil {
+		secrets = make(map[string][]byte)
+	}
+	secrets[name] = value
+	if ret := updateDatum(globalSecretKey, tok, secrets); ret != Ok {
+		Log(Error, fmt.Sprintf("Error updating '%s', unable to store secret '%s'", globalSecretKey, name))
+		return ret
+	}
+	Log(Audit, fmt.Sprintf("Stored global secret '%s'", name))
+	return Ok
+}
+
+// configureSecretSource sets the robot's SecretSource from gopherbot.yaml;
+// on error the source is left unchanged.
+func configureSecretSource(name string, cfg json.RawMessage) {
+	if len(name) == 0 {
+		name = defaultSecretSource
+	}
+	newSource, ok := secretSources[name]
+	if !ok {
+		Log(Error, fmt.Sprintf("No secret source registered for type: \"%s\"", name))
+		return
+	}
+	source, err := newSource(cfg)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Configuring secret source \"%s\": %v", name, err))
+		return
+	}
+	botCfg.Lock()
+	botCfg.secretSource = source
+	botCfg.Unlock()
+}
+
+// resolveSecrets replaces ${secret:NAME} references in a parameter value.
+func resolveSecrets(value string) (string, error) {
+	if !strings.Contains(value, "${secret:") {
+		return value, nil
+	}
+	botCfg.RLock()
+	source := botCfg.secretSource
+	botCfg.RUnlock()
+	if source == nil {
+		source = brainSecrets{}
+	}
+	var rerr error
+	resolved := secretRefRe.ReplaceAllStringFunc(value, func(ref string) string {
+		if rerr != nil {
+			return ""
+		}
+		name := secretRefRe.FindStringSubmatch(ref)[1]
+		secret, found, err := source.Secret(name)
+		if err != nil {
+			rerr = fmt.Errorf("unable to look up secret '%s': %v", name, err)
+			return ""
+		}
+		if !found {
+			rerr = fmt.Errorf("secret '%s' not found", name)
+			return ""
+		}
+		return secret
+	})
+	if rerr != nil {
+		return "", rerr
+	}
+	return resolved, nil
+}
+
+// setParameters adds configured parameters to an environment, except those
+// already set, resolving secret references. Parameters that resolve are set
+// even if another fails, and the first error is returned.
+func setParameters(params []Parameter, env map[string]string) error {
+	var perr error
+	for _, p := range params {
+		if _, exists := env[p.Name]; exists {
+			continue
+		}
+		value, err := resolveSecrets(p.Value)
+		if err != nil {
+			if perr == nil {
+				perr = fmt.Errorf("parameter '%s': %v", p.Name, err)
+			}
+			continue
+		}
+		env[p.Name] = value
+	}
+	return perr
+}
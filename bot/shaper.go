@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/* shaper.go - a global token bucket for pacing commands directed at the
+   robot. When CommandRate is set, commands beyond the burst are delayed to
+   the configured rate, up to CommandQueue waiting commands; anything beyond
+   that is rejected. This is a single throttle for operators during an
+   overload, independent of any per-user or per-plugin limits.
+*/
+
+// defaults when CommandRate is set but burst / queue aren't
+const defaultCommandBurst = 5
+const defaultCommandQueue = 20
+
+var commandShaper = struct {
+	rate     float64   // commands per second, 0 = unlimited
+	burst    float64   // bucket size
+	queueMax int       // maximum number of commands waiting for a token
+	tokens   float64   // tokens currently available; negative when commands are waiting
+	last     time.Time // last time tokens were added
+	waiting  int       // commands currently waiting
+	rejected int       // commands rejected since start
+	sync.Mutex
+}{}
+
+// configureShaper is called from loadConfig with the configured values.
+func configureShaper(rate float64, burst, queue int) {
+	commandShaper.Lock()
+	defer commandShaper.Unlock()
+	if burst <= 0 {
+		burst = defaultCommandBurst
+	}
+	if queue <= 0 {
+		queue = defaultCommandQueue
+	}
+	if rate != commandShaper.rate {
+		// start with a full bucket when enabled or changed
+		commandShaper.tokens = float64(burst)
+		commandShaper.last = time.Now()
+	}
+	commandShaper.rate = rate
+	commandShaper.burst = float64(burst)
+	commandShaper.queueMax = queue
+	if rate > 0 {
+		Log(Info, fmt.Sprintf("Pacing commands to %.2f/second, burst %d, queue %d", rate, burst, queue))
+	}
+}
+
+// shapeCommand blocks until the command may be dispatched, returning false
+// (after telling the user) if the queue is full.
+func (c *botContext) shapeCommand() bool {
+	commandShaper.Lock()
+	if commandShaper.rate <= 0 {
+		commandShaper.Unlock()
+		return true
+	}
+	now := time.Now()
+	commandShaper.tokens += now.Sub(commandShaper.last).Seconds() * commandShaper.rate
+	if commandShaper.tokens > commandShaper.burst {
+		commandShaper.tokens = commandShaper.burst
+	}
+	commandShaper.last = now
+	if commandShaper.tokens >= 1 {
+		commandShaper.tokens--
+		commandShaper.Unlock()
+		return true
+	}
+	if commandShaper.waiting >= commandShaper.queueMax {
+		commandShaper.rejected++
+		commandShaper.Unlock()
+		Log(Warn, fmt.Sprintf("Command queue full, rejecting command from user '%s' in channel '%s'", c.User, c.Channel))
+		c.makeRobot().Reply("Sorry, I'm receiving too many commands right now - please try again shortly")
+		return false
+	}
+	// reserve a token in the future; later commands wait longer, so they're
+	// dispatched in order
+	commandShaper.tokens--
+	delay := time.Duration(-commandShaper.tokens / commandShaper.rate * float64(time.Second))
+	commandShaper.waiting++
+	depth := commandShaper.waiting
+	commandShaper.Unlock()
+	Log(Debug, fmt.Sprintf("Delaying command from user '%s' by %s, queue depth %d", c.User, delay, depth))
+	time.Sleep(delay)
+	commandShaper.Lock()
+	commandShaper.waiting--
+	commandShaper.Unlock()
+	return true
+}
+
+// shaperMetrics returns the configured rate, commands waiting, the queue
+// limit and commands rejected, for /metrics
+func shaperMetrics() (rate float64, waiting, queueMax, rejected int) {
+	commandShaper.Lock()
+	defer commandShaper.Unlock()
+	return commandShaper.rate, commandShaper.waiting, commandShaper.queueMax, commandShaper.rejected
+}
+
+// shaperStatus reports the current rate and queue depth
+func shaperStatus() string {
+	commandShaper.Lock()
+	defer commandShaper.Unlock()
+	if commandShaper.rate <= 0 {
+		return "Command rate shaping is disabled"
+	}
+	return fmt.Sprintf("Command rate: %.2f/second, burst: %.0f; queue depth: %d of %d; rejected since start: %d", commandShaper.rate, commandShaper.burst, commandShaper.waiting, commandShaper.queueMax, commandShaper.rejected)
+}
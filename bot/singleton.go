@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/* singleton.go - for plugins with Singleton: true, a command is dropped
+   while an identical one, from the same user in the same channel with the
+   same arguments, is still running; e.g. when a user double-sends "deploy".
+   Other users, channels and arguments aren't affected.
+*/
+
+var singletons = struct {
+	m map[string]struct{}
+	sync.Mutex
+}{
+	make(map[string]struct{}),
+	sync.Mutex{},
+}
+
+// claimSingleton claims the command for a Singleton plugin, replying that
+// it's already running when it's claimed by another pipeline. The returned
+// release func must be called when the pipeline finishes; it's a no-op for
+// plugins that aren't Singleton.
+func (c *botContext) claimSingleton(plugin *BotPlugin, command string, args []string) (release func(), ok bool) {
+	if !plugin.Singleton {
+		return func() {}, true
+	}
+	key := strings.Join(append([]string{plugin.name, c.User, c.Channel, command}, args...), "\x00")
+	singletons.Lock()
+	if _, running := singletons.m[key]; running {
+		singletons.Unlock()
+		Log(Debug, fmt.Sprintf("Dropping duplicate command '%s' for plugin '%s' from user '%s' in channel '%s'", command, plugin.name, c.User, c.Channel))
+		c.makeRobot().Reply("That command is already running, I'll ignore this one")
+		return nil, false
+	}
+	singletons.m[key] = struct{}{}
+	singletons.Unlock()
+	return func() {
+		singletons.Lock()
+		delete(singletons.m, key)
+		singletons.Unlock()
+	}, true
+}
@@ -45,6 +45,14 @@ func Start(v VersionInfo) {
 	plusage := "omit timestamps from the log"
 	flag.BoolVar(&plainlog, "plainlog", false, plusage)
 	flag.BoolVar(&plainlog, "P", false, plusage+" (shorthand)")
+	var exportFormat string
+	xusage := "write the effective configuration to stdout as 'yaml' or 'json' and exit"
+	flag.StringVar(&exportFormat, "export-config", "", xusage)
+	flag.StringVar(&exportFormat, "x", "", xusage+" (shorthand)")
+	var validate bool
+	vusage := "validate the configuration, reporting tasks that would be disabled, and exit"
+	flag.BoolVar(&validate, "validate-config", false, vusage)
+	flag.BoolVar(&validate, "t", false, vusage+" (shorthand)")
 	flag.Parse()
 
 	private := ".env"
@@ -123,6 +131,13 @@ func Start(v VersionInfo) {
 		botLogger.Printf("Privilege separation not in use\n")
 	}
 
+	if len(exportFormat) > 0 {
+		exportConfig(configpath, installpath, exportFormat, botLogger)
+	}
+	if validate {
+		validateConfig(configpath, installpath, botLogger)
+	}
+
 	initBot(configpath, installpath, botLogger)
 
 	initializeConnector, ok := connectors[botCfg.protocol]
@@ -137,6 +152,7 @@ func Start(v VersionInfo) {
 	// NOTE: we use setConnector instead of passing the connector to run()
 	// because of the way Windows services run. See 'start_win.go'.
 	setConnector(conn)
+	initExtraConnectors(botLogger)
 
 	// Start the robot
 	stopped := run()
@@ -51,6 +51,7 @@ func StartTest(v VersionInfo, cfgdir, logfile string, t *testing.T) (<-chan stru
 	// NOTE: we use setConnector instead of passing the connector to run()
 	// because of the way Windows services run. See 'start_win.go'.
 	setConnector(conn)
+	initExtraConnectors(botLogger)
 
 	stopped := run()
 	return stopped, conn
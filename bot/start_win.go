@@ -57,6 +57,14 @@ func Start(v VersionInfo) {
 	lusage := "path to robot's log file"
 	flag.StringVar(&logFile, "log", "", lusage)
 	flag.StringVar(&logFile, "l", "", lusage+" (shorthand)")
+	var exportFormat string
+	xusage := "write the effective configuration to stdout as 'yaml' or 'json' and exit"
+	flag.StringVar(&exportFormat, "export-config", "", xusage)
+	flag.StringVar(&exportFormat, "x", "", xusage+" (shorthand)")
+	var validate bool
+	vusage := "validate the configuration, reporting tasks that would be disabled, and exit"
+	flag.BoolVar(&validate, "validate-config", false, vusage)
+	flag.BoolVar(&validate, "t", false, vusage+" (shorthand)")
 	var winCommand string
 	if isIntSess {
 		wusage := "manage Windows service, one of: install, remove, start, stop"
@@ -158,6 +166,13 @@ func Start(v VersionInfo) {
 		lp = configpath
 	}
 	botLogger.Printf("Starting up with config dir: %s, and install dir: %s\n", lp, installpath)
+	if len(exportFormat) > 0 {
+		exportConfig(configpath, installpath, exportFormat, botLogger)
+	}
+	if validate {
+		validateConfig(configpath, installpath, botLogger)
+	}
+
 	initBot(configpath, installpath, botLogger)
 
 	initializeConnector, ok := connectors[botCfg.protocol]
@@ -169,6 +184,7 @@ func Start(v VersionInfo) {
 	h := handler{}
 	conn := initializeConnector(h, log.New(ioutil.Discard, "", 0))
 	setConnector(conn)
+	initExtraConnectors(log.New(ioutil.Discard, "", 0))
 
 	if isIntSess {
 		// Start the connector's main loop for interactive sessions
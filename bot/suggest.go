@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+/* suggest.go - "did you mean" suggestions. When a message directed at the
+   robot matches no command, the first word is compared with the first word
+   of each command the user can run, taken from the literal start of the
+   CommandMatchers regex, and the closest words within SuggestDistance edits
+   are offered instead of calling the catchall. The internal Command names
+   aren't used, since they're often not something a user can type.
+*/
+
+// maximum number of commands offered in one suggestion
+const maxSuggestions = 3
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// commandWord returns the first word a user types for a command matcher
+// regex, e.g. "deploy" for '(?i:deploy (\w+) to (\w+))', or "" if the regex
+// doesn't start with a complete literal word.
+func commandWord(regex string) string {
+	// remove the anchors added by loadTaskConfig
+	regex = strings.TrimPrefix(regex, "(?i)")
+	regex = strings.TrimSuffix(strings.TrimPrefix(regex, `^\s*`), `\s*$`)
+	re, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	var prefix strings.Builder
+	complete := literalPrefix(re, &prefix)
+	word := strings.TrimLeft(prefix.String(), " ")
+	if i := strings.IndexByte(word, ' '); i > 0 {
+		return strings.ToLower(word[:i])
+	} else if complete {
+		return strings.ToLower(word)
+	}
+	return ""
+}
+
+// literalPrefix appends the literal text at the start of re to prefix,
+// returning true if all of re is literal.
+func literalPrefix(re *syntax.Regexp, prefix *strings.Builder) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		prefix.WriteString(string(re.Rune))
+		return true
+	case syntax.OpCapture:
+		return literalPrefix(re.Sub[0], prefix)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !literalPrefix(sub, prefix) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpEmptyMatch:
+		return true
+	}
+	return false
+}
+
+// suggestCommand replies with the closest command words to an unmatched
+// command, returning true if a suggestion was made.
+func (c *botContext) suggestCommand() bool {
+	botCfg.RLock()
+	maxDistance := botCfg.suggestDistance
+	botCfg.RUnlock()
+	if maxDistance <= 0 {
+		return false
+	}
+	fields := strings.Fields(c.msg)
+	if len(fields) == 0 {
+		return false
+	}
+	word := strings.ToLower(fields[0])
+	best := maxDistance + 1
+	var suggestions []string
+	seen := make(map[string]bool)
+	admin := isAdmin(c.User)
+	for _, t := range c.tasks.t {
+		task, plugin, _ := getTask(t)
+		if plugin == nil || plugin.NoSuggest || task.Disabled {
+			continue
+		}
+		if !c.pluginAvailable(task, false, true) {
+			continue
+		}
+	matchers:
+		for _, matcher := range plugin.CommandMatchers {
+			if !admin {
+				for _, ac := range plugin.AdminCommands {
+					if matcher.Command == ac {
+						continue matchers
+					}
+				}
+			}
+			command := commandWord(matcher.Regex)
+			if len(command) == 0 || seen[command] {
+				continue
+			}
+			seen[command] = true
+			d := editDistance(word, command)
+			// an exact word that didn't match is a usage problem, and very
+			// short words are too ambiguous to guess at
+			if d == 0 || d >= len(word) {
+				continue
+			}
+			if d < best {
+				best = d
+				suggestions = []string{command}
+			} else if d == best {
+				suggestions = append(suggestions, command)
+			}
+		}
+	}
+	if len(suggestions) == 0 {
+		return false
+	}
+	sort.Strings(suggestions)
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	Log(Debug, fmt.Sprintf("Unmatched command '%s' from user '%s', suggesting: %v", c.msg, c.User, suggestions))
+	r := c.makeRobot()
+	r.Say(fmt.Sprintf("Did you mean `%s`?", strings.Join(suggestions, "` or `")))
+	return true
+}
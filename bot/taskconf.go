@@ -3,8 +3,10 @@ package bot
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"reflect"
-	"regexp"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 )
@@ -78,6 +80,7 @@ func (c *botContext) loadTaskConfig() {
 		}
 		if script.Disabled {
 			task.Disabled = true
+			task.configDisabled = true
 			task.reason = "Disabled in installed / custom gopherbot.yaml"
 		}
 		p := &BotPlugin{
@@ -120,6 +123,7 @@ func (c *botContext) loadTaskConfig() {
 		}
 		if script.Disabled {
 			task.Disabled = true
+			task.configDisabled = true
 			task.reason = "Disabled in installed / custom gopherbot.yaml"
 		}
 		j := &BotJob{
@@ -151,18 +155,28 @@ func (c *botContext) loadTaskConfig() {
 		}
 		nameSpaceSet[nameSpace] = struct{}{}
 		task := &BotTask{
-			name:        script.Name,
-			taskType:    taskExternal,
-			taskID:      getTaskID(script.Name),
-			Description: script.Description,
-			Path:        script.Path,
-			Parameters:  script.Parameters,
-			NameSpace:   nameSpace,
+			name:          script.Name,
+			taskType:      taskExternal,
+			taskID:        getTaskID(script.Name),
+			Description:   script.Description,
+			Path:          script.Path,
+			Parameters:    script.Parameters,
+			NameSpace:     nameSpace,
+			IgnoreFailure: script.IgnoreFailure,
 		}
 		if script.Disabled {
 			task.Disabled = true
+			task.configDisabled = true
 			task.reason = "Disabled in installed / custom gopherbot.yaml"
 		}
+		if len(script.OutputTransforms) > 0 {
+			task.OutputTransforms = script.OutputTransforms
+			if err := checkTransforms(task.OutputTransforms); err != nil {
+				Log(Error, fmt.Sprintf("Disabling task '%s': %v", task.name, err))
+				task.Disabled = true
+				task.reason = err.Error()
+			}
+		}
 		tlist = append(tlist, task)
 		taskIndexByID[task.taskID] = i
 		taskIndexByName[task.name] = i
@@ -266,6 +280,7 @@ LoadLoop:
 				Log(Info, msg)
 				c.debugTask(task, msg, false)
 				task.Disabled = true
+				task.configDisabled = true
 				task.reason = msg
 				continue
 			}
@@ -285,24 +300,33 @@ LoadLoop:
 			var hval []PluginHelp
 			var mval []InputMatcher
 			var tval []JobTrigger
+			var bhval BusinessHours
+			var rlval RateLimit
+			var mapval map[string]string
 			var val interface{}
 			skip := false
 			switch key {
-			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel":
+			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "Notify", "LogLevel", "ElevateTimeout", "MaxHistoryAge", "Category":
 				val = &strval
-			case "HistoryLogs":
+			case "HistoryLogs", "MaxArgs", "MaxArgLength", "MaxConcurrent", "MaxQueued", "Timeout", "Priority":
 				val = &intval
-			case "Disabled", "AllowDirect", "DirectOnly", "DenyDirect", "AllChannels", "RequireAdmin", "Protected", "AuthorizeAllCommands", "CatchAll", "MatchUnlisted", "Quiet":
+			case "Disabled", "AllowDirect", "DirectOnly", "DenyDirect", "AllChannels", "RequireAdmin", "Protected", "AuthorizeAllCommands", "CatchAll", "MatchUnlisted", "NoSuggest", "Singleton", "Quiet", "IgnoreFailure", "NotifyEmail":
 				val = &boolval
-			case "Channels", "ElevatedCommands", "ElevateImmediateCommands", "Users", "AuthorizedCommands", "AdminCommands":
+			case "Channels", "ElevatedCommands", "ElevateImmediateCommands", "Users", "AuthorizedCommands", "AdminCommands", "OutputTransforms", "BusinessHoursCommands", "WebhookSources":
 				val = &sarrval
 			case "Help":
 				val = &hval
-			case "CommandMatchers", "ReplyMatchers", "MessageMatchers", "Arguments":
+			case "CommandMatchers", "ReplyMatchers", "MessageMatchers", "ActionMatchers", "Arguments":
 				val = &mval
 			case "Triggers":
 				val = &tval
-			case "Config":
+			case "BusinessHours":
+				val = &bhval
+			case "RateLimit":
+				val = &rlval
+			case "ActionCommands", "Aliases":
+				val = &mapval
+			case "Config", "ChannelOverrides", "ConfigSchema":
 				skip = true
 			default:
 				msg := fmt.Sprintf("Invalid configuration key for task '%s': %s - disabling", task.name, key)
@@ -349,6 +373,8 @@ LoadLoop:
 				task.RequireAdmin = *(val.(*bool))
 			case "Protected":
 				task.Protected = *(val.(*bool))
+			case "IgnoreFailure":
+				task.IgnoreFailure = *(val.(*bool))
 			case "AdminCommands":
 				if isPlugin {
 					plugin.AdminCommands = *(val.(*[]string))
@@ -387,14 +413,67 @@ LoadLoop:
 				} else {
 					mismatch = true
 				}
+			case "ElevateTimeout":
+				if isPlugin {
+					timeout := *(val.(*string))
+					if d, err := time.ParseDuration(timeout); err == nil && d >= 0 {
+						plugin.ElevateTimeout = timeout
+					} else {
+						Log(Error, fmt.Sprintf("Plugin '%s' has invalid ElevateTimeout '%s', using the robot's ElevateTimeout", task.name, timeout))
+					}
+				} else {
+					mismatch = true
+				}
 			case "Users":
 				task.Users = *(val.(*[]string))
+			case "OutputTransforms":
+				task.OutputTransforms = *(val.(*[]string))
+				if err := checkTransforms(task.OutputTransforms); err != nil {
+					msg := fmt.Sprintf("Disabling '%s': %v", task.name, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
 			case "HistoryLogs":
 				if isPlugin {
 					mismatch = true
 				} else {
 					job.HistoryLogs = *(val.(*int))
 				}
+			case "MaxHistoryAge":
+				if isPlugin {
+					mismatch = true
+				} else {
+					age := *(val.(*string))
+					if d, err := parseHistoryAge(age); err == nil {
+						job.MaxHistoryAge = age
+						job.maxHistoryAge = d
+					} else {
+						Log(Error, fmt.Sprintf("Job '%s' has invalid MaxHistoryAge '%s', runs will only be pruned by HistoryLogs: %v", task.name, age, err))
+					}
+				}
+			case "Notify":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.Notify = *(val.(*string))
+				}
+			case "NotifyEmail":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.NotifyEmail = *(val.(*bool))
+				}
+			case "Timeout":
+				task.Timeout = *(val.(*int))
+			case "LogLevel":
+				if level := *(val.(*string)); validLogLevel(level) {
+					task.LogLevel = level
+				} else {
+					Log(Error, fmt.Sprintf("Task '%s' has invalid LogLevel '%s', ignoring", task.name, level))
+				}
 			case "Authorizer":
 				task.Authorizer = *(val.(*string))
 			case "AuthRequire":
@@ -435,6 +514,12 @@ LoadLoop:
 				} else {
 					mismatch = true
 				}
+			case "ActionMatchers":
+				if isPlugin {
+					plugin.ActionMatchers = *(val.(*[]InputMatcher))
+				} else {
+					mismatch = true
+				}
 			case "Arguments":
 				if isPlugin {
 					mismatch = true
@@ -447,12 +532,100 @@ LoadLoop:
 				} else {
 					mismatch = true
 				}
+			case "Category":
+				if isPlugin {
+					plugin.Category = *(val.(*string))
+				} else {
+					mismatch = true
+				}
+			case "Priority":
+				if isPlugin {
+					plugin.Priority = *(val.(*int))
+				} else {
+					mismatch = true
+				}
 			case "MatchUnlisted":
 				if isPlugin {
 					plugin.MatchUnlisted = *(val.(*bool))
 				} else {
 					mismatch = true
 				}
+			case "NoSuggest":
+				if isPlugin {
+					plugin.NoSuggest = *(val.(*bool))
+				} else {
+					mismatch = true
+				}
+			case "Singleton":
+				if isPlugin {
+					plugin.Singleton = *(val.(*bool))
+				} else {
+					mismatch = true
+				}
+			case "MaxArgs":
+				if isPlugin {
+					plugin.MaxArgs = *(val.(*int))
+				} else {
+					mismatch = true
+				}
+			case "MaxArgLength":
+				if isPlugin {
+					plugin.MaxArgLength = *(val.(*int))
+				} else {
+					mismatch = true
+				}
+			case "MaxConcurrent":
+				if isPlugin {
+					plugin.MaxConcurrent = *(val.(*int))
+				} else {
+					mismatch = true
+				}
+			case "MaxQueued":
+				if isPlugin {
+					plugin.MaxQueued = *(val.(*int))
+				} else {
+					mismatch = true
+				}
+			case "BusinessHoursCommands":
+				if isPlugin {
+					plugin.BusinessHoursCommands = *(val.(*[]string))
+				} else {
+					mismatch = true
+				}
+			case "BusinessHours":
+				if isPlugin {
+					bh := val.(*BusinessHours)
+					bc, err := bh.calendar()
+					if err != nil {
+						msg := fmt.Sprintf("Disabling plugin '%s' - invalid BusinessHours: %v", task.name, err)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+					plugin.BusinessHours = bh
+					plugin.calendar = bc
+				} else {
+					mismatch = true
+				}
+			case "RateLimit":
+				if isPlugin {
+					rl := val.(*RateLimit)
+					limiter, err := rl.limiter()
+					if err != nil {
+						msg := fmt.Sprintf("Disabling plugin '%s' - invalid RateLimit: %v", task.name, err)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+					plugin.RateLimit = rl
+					plugin.limiter = limiter
+				} else {
+					mismatch = true
+				}
 			case "Quiet":
 				if isPlugin {
 					mismatch = true
@@ -465,8 +638,66 @@ LoadLoop:
 				} else {
 					job.Triggers = *(val.(*[]JobTrigger))
 				}
+			case "WebhookSources":
+				if isPlugin {
+					mismatch = true
+				} else {
+					sources, err := parseSources(*(val.(*[]string)))
+					if err != nil {
+						msg := fmt.Sprintf("Disabling job '%s': %v", task.name, err)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+					job.WebhookSources = *(val.(*[]string))
+					job.sources = sources
+				}
+			case "ActionCommands":
+				if isPlugin {
+					plugin.ActionCommands = *(val.(*map[string]string))
+				} else {
+					mismatch = true
+				}
+			case "Aliases":
+				if isPlugin {
+					plugin.Aliases = *(val.(*map[string]string))
+				} else {
+					mismatch = true
+				}
 			case "Config":
 				task.Config = value
+			case "ConfigSchema":
+				if isPlugin {
+					schema, err := parseSchema(value)
+					if err != nil {
+						msg := fmt.Sprintf("Disabling plugin '%s' - invalid ConfigSchema: %v", task.name, err)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+					plugin.schema = schema
+				} else {
+					mismatch = true
+				}
+			case "ChannelOverrides":
+				if isPlugin {
+					overrides, err := parseOverrides(value)
+					if err != nil {
+						msg := fmt.Sprintf("Disabling plugin '%s' - invalid ChannelOverrides: %v", task.name, err)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+					task.ChannelOverrides = overrides
+				} else {
+					mismatch = true
+				}
 			}
 			if mismatch {
 				var msg string
@@ -540,6 +771,24 @@ LoadLoop:
 			}
 		}
 
+		// Channels can include glob patterns, e.g. "memes-*"
+		if isPlugin {
+			for _, ch := range task.Channels {
+				if !strings.ContainsAny(ch, `*?[\`) {
+					continue
+				}
+				if _, err := filepath.Match(ch, ""); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', invalid channel pattern '%s': %v", task.name, ch, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+				task.channelPatterns = append(task.channelPatterns, ch)
+			}
+		}
+
 		// Considering possible default channels, is the plugin visible anywhere?
 		if isPlugin {
 			if len(task.Channels) > 0 {
@@ -571,12 +820,57 @@ LoadLoop:
 			}
 		}
 
+		// Overrides are merged with the rest of the configuration
+		if err := task.resolveOverrides(); err != nil {
+			msg := fmt.Sprintf("Disabling plugin '%s' - invalid ChannelOverrides: %v", task.name, err)
+			Log(Error, msg)
+			c.debugTask(task, msg, false)
+			task.Disabled = true
+			task.reason = msg
+			continue
+		}
+		if isPlugin {
+			if err := plugin.checkConfig(); err != nil {
+				msg := fmt.Sprintf("Disabling plugin '%s' - Config doesn't match schema: %v", task.name, err)
+				Log(Error, msg)
+				c.debugTask(task, msg, false)
+				task.Disabled = true
+				task.reason = msg
+				continue
+			}
+		}
+
 		// Compile the regex's
 		if isPlugin {
+			for _, matchers := range [][]InputMatcher{plugin.CommandMatchers, plugin.MessageMatchers, plugin.ActionMatchers} {
+				for _, matcher := range matchers {
+					switch strings.ToLower(matcher.Format) {
+					case "", "raw", "variable", "fixed", "markup":
+					default:
+						msg := fmt.Sprintf("Disabling '%s', invalid Format '%s' for command '%s'", task.name, matcher.Format, matcher.Command)
+						Log(Error, msg)
+						c.debugTask(task, msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+				}
+			}
+			if err := plugin.compileAliases(); err != nil {
+				msg := fmt.Sprintf("Disabling '%s', Aliases: %v", task.name, err)
+				Log(Error, msg)
+				c.debugTask(task, msg, false)
+				task.Disabled = true
+				task.reason = msg
+				continue LoadLoop
+			}
 			for i := range plugin.CommandMatchers {
 				command := &plugin.CommandMatchers[i]
 				regex := `^\s*` + command.Regex + `\s*$`
-				re, err := regexp.Compile(regex)
+				if command.Insensitive {
+					regex = `(?i)` + regex
+				}
+				re, err := compileRegex(regex)
 				if err != nil {
 					msg := fmt.Sprintf("Disabling '%s', couldn't compile command regular expression '%s': %v", task.name, regex, err)
 					Log(Error, msg)
@@ -589,12 +883,50 @@ LoadLoop:
 					command.Regex = regex
 					command.re = re
 				}
+				if err := command.compileGroups(); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', command regular expression '%s': %v", task.name, command.Regex, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+			}
+			for i := range plugin.ActionMatchers {
+				action := &plugin.ActionMatchers[i]
+				regex := `^` + action.Regex + `$`
+				if action.Insensitive {
+					regex = `(?i)` + regex
+				}
+				re, err := compileRegex(regex)
+				if err != nil {
+					msg := fmt.Sprintf("Disabling '%s', couldn't compile action regular expression '%s': %v", task.name, regex, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				} else {
+					action.Regex = regex
+					action.re = re
+				}
+				if err := action.compileGroups(); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', action regular expression '%s': %v", task.name, action.Regex, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
 			}
 			for i := range plugin.MessageMatchers {
 				// Note that full message regexes don't get the beginning and end anchors added - the individual plugin
 				// will need to do this if necessary.
 				message := &plugin.MessageMatchers[i]
-				re, err := regexp.Compile(message.Regex)
+				if message.Insensitive {
+					message.Regex = `(?i)` + message.Regex
+				}
+				re, err := compileRegex(message.Regex)
 				if err != nil {
 					msg := fmt.Sprintf("Disabling '%s', couldn't compile message regular expression '%s': %v", task.name, message.Regex, err)
 					Log(Error, msg)
@@ -605,6 +937,14 @@ LoadLoop:
 				} else {
 					message.re = re
 				}
+				if err := message.compileGroups(); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', message regular expression '%s': %v", task.name, message.Regex, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
 			}
 		} else {
 			for i := range job.Triggers {
@@ -617,7 +957,7 @@ LoadLoop:
 					task.reason = msg
 					continue LoadLoop
 				}
-				re, err := regexp.Compile(trigger.Regex)
+				re, err := compileRegex(trigger.Regex)
 				if err != nil {
 					msg := fmt.Sprintf("Disabling '%s', couldn't compile trigger regular expression '%s': %v", task.name, trigger.Regex, err)
 					Log(Error, msg)
@@ -628,6 +968,22 @@ LoadLoop:
 				} else {
 					trigger.re = re
 				}
+				if trigger.groups, err = captureGroups(re); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', trigger regular expression '%s': %v", task.name, trigger.Regex, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+				if err := trigger.compileJSONParameters(); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', trigger for user '%s': %v", task.name, trigger.User, err)
+					Log(Error, msg)
+					c.debugTask(task, msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
 			}
 			for i := range job.Arguments {
 				argument := &job.Arguments[i]
@@ -641,7 +997,7 @@ LoadLoop:
 					continue LoadLoop
 				}
 				regex := `^\s*` + argument.Regex + `\s*$`
-				re, err := regexp.Compile(regex)
+				re, err := compileRegex(regex)
 				if err != nil {
 					msg := fmt.Sprintf("Disabling '%s', couldn't compile argument regular expression '%s': %v", task.name, regex, err)
 					Log(Error, msg)
@@ -666,7 +1022,7 @@ LoadLoop:
 				task.reason = msg
 				continue LoadLoop
 			}
-			re, err := regexp.Compile(`^\s*` + reply.Regex + `\s*$`)
+			re, err := compileRegex(`^\s*` + reply.Regex + `\s*$`)
 			if err != nil {
 				msg := fmt.Sprintf("Skipping %s, couldn't compile reply regular expression '%s': %v", task.name, reply.Regex, err)
 				Log(Error, msg)
@@ -746,8 +1102,28 @@ LoadLoop:
 						Log(Warn, msg)
 						c.debugTask(task, msg, false)
 					}
+					for ch, cc := range task.channelConfigs {
+						if cc.Config == nil {
+							continue
+						}
+						cc.config = reflect.New(reflect.Indirect(pt).Type()).Interface()
+						if err := json.Unmarshal(cc.Config, cc.config); err != nil {
+							msg := fmt.Sprintf("Error unmarshalling plugin config json for channel '%s' to config, disabling: %v", ch, err)
+							Log(Error, msg)
+							c.debugTask(task, msg, false)
+							task.Disabled = true
+							task.reason = msg
+							continue LoadLoop
+						}
+					}
 				} else {
-					if task.Config != nil {
+					overrideConfig := false
+					for _, cc := range task.channelConfigs {
+						if cc.Config != nil {
+							overrideConfig = true
+						}
+					}
+					if task.Config != nil || overrideConfig {
 						msg := fmt.Sprintf("Custom configuration data provided for Go plugin '%s', but no config struct was registered; disabling", task.name)
 						Log(Error, msg)
 						c.debugTask(task, msg, false)
@@ -766,6 +1142,32 @@ LoadLoop:
 
 	reInitPlugins := false
 	currentTasks.Lock()
+	// On reload, a task that fails to load keeps it's previous configuration
+	// rather than disappearing; tasks disabled by configuration are still
+	// disabled.
+	for i, t := range tlist {
+		task, _, _ := getTask(t)
+		if !task.Disabled || task.configDisabled {
+			continue
+		}
+		pi, ok := currentTasks.nameMap[task.name]
+		if !ok {
+			continue
+		}
+		prev := currentTasks.t[pi]
+		ptask, _, _ := getTask(prev)
+		if ptask.Disabled || reflect.TypeOf(prev) != reflect.TypeOf(t) {
+			continue
+		}
+		Log(Warn, fmt.Sprintf("Task '%s' failed to reload, keeping previous configuration; reason: %s", task.name, task.reason))
+		tlist[i] = prev
+		nameSpaceSet[ptask.NameSpace] = struct{}{}
+	}
+	currentTasks.Unlock()
+	checkNameSpaces(tlist)
+	applyRuntimeDisables(tlist)
+	applyPanicDisables(tlist)
+	currentTasks.Lock()
 	currentTasks.t = tlist
 	currentTasks.idMap = taskIndexByID
 	currentTasks.nameMap = taskIndexByName
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+)
+
+/* taskdisable.go - lets an administrator silence a misbehaving plugin or job
+   without editing configuration and reloading, then enable it again. A task
+   disabled at runtime is normally enabled again by a reload; if the disable
+   is made persistent, it's remembered in the brain and re-applied on every
+   reload and restart until the task is enabled.
+*/
+
+const disabledTasksKey = "bot:disabledTasks"
+
+// runtimeDisable records a persistent runtime disable
+type runtimeDisable struct {
+	User string // administrator that disabled the task
+	Time string
+}
+
+// taskDisabledReason is the reason listed for a task disabled at runtime
+func taskDisabledReason(user, when string) string {
+	return fmt.Sprintf("disabled at runtime by %s at %s", user, when)
+}
+
+// applyRuntimeDisables disables tasks with a persistent runtime disable;
+// called from loadTaskConfig before the new tasks are live.
+func applyRuntimeDisables(tlist []interface{}) {
+	disabled := make(map[string]runtimeDisable)
+	_, exists, ret := checkoutDatum(disabledTasksKey, &disabled, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Error retrieving '%s', tasks disabled at runtime may be enabled: %s", disabledTasksKey, ret))
+		return
+	}
+	if !exists {
+		return
+	}
+	for _, t := range tlist {
+		task, _, _ := getTask(t)
+		if rd, ok := disabled[task.name]; ok && !task.Disabled {
+			Log(Info, fmt.Sprintf("Task '%s' was disabled at runtime by %s, keeping it disabled", task.name, rd.User))
+			task.Disabled = true
+			task.runtimeDisabled = true
+			task.reason = taskDisabledReason(rd.User, rd.Time)
+		}
+	}
+}
+
+// rememberDisable adds or removes a persistent runtime disable
+func rememberDisable(name, user, when string, disable bool) RetVal {
+	disabled := make(map[string]runtimeDisable)
+	tok, _, ret := checkoutDatum(disabledTasksKey, &disabled, true)
+	if ret != Ok {
+		return ret
+	}
+	if disable {
+		disabled[name] = runtimeDisable{user, when}
+	} else {
+		if _, ok := disabled[name]; !ok {
+			checkinDatum(disabledTasksKey, tok)
+			return Ok
+		}
+		delete(disabled, name)
+	}
+	return updateDatum(disabledTasksKey, tok, disabled)
+}
+
+// setTaskDisabled disables or enables a plugin or job in the live task list,
+// replying to the administrator with the result.
+func setTaskDisabled(r *Robot, ttype, name string, disable, persist bool) {
+	if name == "builtin-admin" {
+		r.Say("Sorry, I can't disable 'builtin-admin' - how would you enable it again?")
+		return
+	}
+	currentTasks.Lock()
+	var t interface{}
+	if i, ok := currentTasks.nameMap[name]; ok {
+		t = currentTasks.t[i]
+	}
+	var task *BotTask
+	if t != nil {
+		var plugin *BotPlugin
+		var job *BotJob
+		task, plugin, job = getTask(t)
+		if (ttype == "plugin" && plugin == nil) || (ttype == "job" && job == nil) {
+			task = nil
+		}
+	}
+	if task == nil {
+		currentTasks.Unlock()
+		r.Say(fmt.Sprintf("I don't have a %s named '%s'", ttype, name))
+		return
+	}
+	now := time.Now().Format("Mon Jan 2 15:04:05 MST 2006")
+	if disable {
+		if task.Disabled {
+			reason := task.reason
+			currentTasks.Unlock()
+			r.Say(fmt.Sprintf("The %s '%s' is already disabled; reason: %s", ttype, name, reason))
+			return
+		}
+		updateLiveTask(name, func(task *BotTask) {
+			task.Disabled = true
+			task.runtimeDisabled = true
+			task.reason = taskDisabledReason(r.User, now)
+		})
+	} else {
+		if task.Disabled && !task.runtimeDisabled {
+			reason := task.reason
+			currentTasks.Unlock()
+			r.Say(fmt.Sprintf("The %s '%s' wasn't disabled at runtime; fix the configuration and reload; reason: %s", ttype, name, reason))
+			return
+		}
+		if !task.Disabled {
+			currentTasks.Unlock()
+			r.Say(fmt.Sprintf("The %s '%s' isn't disabled", ttype, name))
+			return
+		}
+		updateLiveTask(name, func(task *BotTask) {
+			task.Disabled = false
+			task.runtimeDisabled = false
+			task.reason = ""
+		})
+		forgetPanicked(name)
+	}
+	currentTasks.Unlock()
+	// Enabling always forgets a persistent disable
+	if persist || !disable {
+		if ret := rememberDisable(name, r.User, now, disable); ret != Ok {
+			Log(Error, fmt.Sprintf("Error updating '%s' for %s '%s': %s", disabledTasksKey, ttype, name, ret))
+			if disable {
+				r.Say(fmt.Sprintf("Disabled %s '%s', but I had trouble remembering it; it'll be enabled again on reload", ttype, name))
+				return
+			}
+		}
+	}
+	if disable {
+		Log(Audit, fmt.Sprintf("The %s '%s' was disabled at runtime by %s, persistent: %t", ttype, name, r.User, persist))
+		if persist {
+			r.Say(fmt.Sprintf("Disabled %s '%s' until it's enabled again", ttype, name))
+		} else {
+			r.Say(fmt.Sprintf("Disabled %s '%s' until it's enabled again, or the next reload", ttype, name))
+		}
+		return
+	}
+	Log(Audit, fmt.Sprintf("The %s '%s' was enabled at runtime by %s", ttype, name, r.User))
+	r.Say(fmt.Sprintf("Enabled %s '%s'", ttype, name))
+}
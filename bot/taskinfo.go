@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* taskinfo.go - the 'list tasks' and 'task info' admin commands, for seeing
+   what the robot has loaded without reading the logs.
+*/
+
+// taskKind describes the type of a task, e.g. "plugin (Go)"
+func taskKind(t interface{}) string {
+	task, plugin, job := getTask(t)
+	kind := "task"
+	if plugin != nil {
+		kind = "plugin"
+	} else if job != nil {
+		kind = "job"
+	}
+	if task.taskType == taskGo {
+		return kind + " (Go)"
+	}
+	return kind + " (external)"
+}
+
+// taskStatus is "enabled", or "disabled" with the reason
+func taskStatus(task *BotTask) string {
+	if !task.Disabled {
+		return "enabled"
+	}
+	status := "disabled"
+	if task.runtimeDisabled {
+		status += " at runtime"
+	}
+	return status + ": " + task.reason
+}
+
+// taskChannels describes where a task can be used; plugins are available
+// in Channels, while jobs report in Channel.
+func taskChannels(t interface{}) string {
+	task, plugin, job := getTask(t)
+	switch {
+	case plugin != nil:
+		switch {
+		case task.DirectOnly:
+			return "direct messages only"
+		case len(task.Channels) > 0:
+			return "channels: " + strings.Join(task.Channels, ", ")
+		case task.AllChannels:
+			return "all channels"
+		}
+	case job != nil && len(task.Channel) > 0:
+		return "channel: " + task.Channel
+	}
+	return ""
+}
+
+// scheduledTasks returns the ScheduledJobs for each task name
+func scheduledTasks() map[string][]ScheduledTask {
+	botCfg.RLock()
+	scheduled := botCfg.ScheduledJobs
+	botCfg.RUnlock()
+	sched := make(map[string][]ScheduledTask)
+	for _, st := range scheduled {
+		sched[st.Name] = append(sched[st.Name], st)
+	}
+	return sched
+}
+
+// taskListing is the 'list tasks' admin command; one line per task, sorted
+// by name.
+func taskListing() string {
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	sched := scheduledTasks()
+	names := make([]string, 0, len(tasks))
+	byName := make(map[string]interface{})
+	for _, t := range tasks {
+		task, _, _ := getTask(t)
+		names = append(names, task.name)
+		byName[task.name] = t
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		t := byName[name]
+		task, _, _ := getTask(t)
+		fields := []string{taskKind(t), taskStatus(task)}
+		if ch := taskChannels(t); len(ch) > 0 {
+			fields = append(fields, ch)
+		}
+		if _, ok := sched[task.name]; ok {
+			fields = append(fields, "scheduled")
+		}
+		lines = append(lines, task.name+": "+strings.Join(fields, "; "))
+	}
+	return fmt.Sprintf("Loaded tasks (%d):\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// taskInfo is the 'task info <name>' admin command, a detailed view of one
+// task.
+func taskInfo(name string) string {
+	currentTasks.Lock()
+	var t interface{}
+	if i, ok := currentTasks.nameMap[name]; ok {
+		t = currentTasks.t[i]
+	}
+	tlist := currentTasks.t
+	currentTasks.Unlock()
+	if t == nil {
+		return fmt.Sprintf("I don't have a task named '%s'", name)
+	}
+	task, plugin, job := getTask(t)
+	info := []string{fmt.Sprintf("Task '%s': %s", name, taskKind(t))}
+	if len(task.Description) > 0 {
+		info = append(info, "Description: "+task.Description)
+	}
+	info = append(info, "Status: "+taskStatus(task))
+	ns := "Namespace: " + task.nameSpace()
+	if shared := nameSpaceTasks(tlist)[task.nameSpace()]; len(shared) > 1 {
+		ns += " (shared by " + strings.Join(shared, ", ") + ")"
+	}
+	info = append(info, ns)
+	if len(task.Path) > 0 {
+		info = append(info, "Path: "+task.Path)
+	}
+	if ch := taskChannels(t); len(ch) > 0 {
+		info = append(info, strings.ToUpper(ch[:1])+ch[1:])
+	}
+	if task.RequireAdmin {
+		info = append(info, "Requires admin: true")
+	}
+	if len(task.Users) > 0 {
+		info = append(info, "Users: "+strings.Join(task.Users, ", "))
+	}
+	for _, st := range scheduledTasks()[name] {
+		sched := "Scheduled: " + st.Schedule
+		if len(st.Arguments) > 0 {
+			sched += "; arguments: " + strings.Join(st.Arguments, " ")
+		}
+		info = append(info, sched)
+	}
+	matchers := func(kind string, ms []InputMatcher) {
+		if len(ms) == 0 {
+			return
+		}
+		info = append(info, kind+":")
+		for _, m := range ms {
+			label := m.Command
+			if len(label) == 0 {
+				label = m.Label
+			}
+			info = append(info, fmt.Sprintf("  %s: %s", label, m.Regex))
+		}
+	}
+	if plugin != nil {
+		matchers("Command matchers", plugin.CommandMatchers)
+		matchers("Message matchers", plugin.MessageMatchers)
+		matchers("Action matchers", plugin.ActionMatchers)
+		if plugin.CatchAll {
+			info = append(info, "CatchAll: true")
+		}
+		if plugin.Priority != 0 {
+			info = append(info, fmt.Sprintf("Priority: %d", plugin.Priority))
+		}
+	}
+	matchers("Reply matchers", task.ReplyMatchers)
+	if job != nil {
+		matchers("Arguments", job.Arguments)
+		if len(job.Triggers) > 0 {
+			info = append(info, "Triggers:")
+			for _, tr := range job.Triggers {
+				info = append(info, fmt.Sprintf("  user %s in %s: %s", tr.User, tr.Channel, tr.Regex))
+			}
+		}
+	}
+	return strings.Join(info, "\n")
+}
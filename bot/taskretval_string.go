@@ -4,9 +4,9 @@ package bot
 
 import "strconv"
 
-const _TaskRetVal_name = "NormalFailMechanismFailConfigurationErrorPipelineAborted"
+const _TaskRetVal_name = "NormalFailMechanismFailConfigurationErrorPipelineAbortedTaskTimedOutTaskCancelled"
 
-var _TaskRetVal_index = [...]uint8{0, 6, 10, 23, 41, 56}
+var _TaskRetVal_index = [...]uint8{0, 6, 10, 23, 41, 56, 68, 81}
 
 func (i TaskRetVal) String() string {
 	if i < 0 || i >= TaskRetVal(len(_TaskRetVal_index)-1) {
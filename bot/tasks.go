@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"regexp"
+	godebug "runtime/debug"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Regex for task/job/plugin/NameSpace names. NOTE: if this changes,
@@ -54,6 +58,49 @@ func getTask(t interface{}) (*BotTask, *BotPlugin, *BotJob) {
 	return t.(*BotTask), nil, nil
 }
 
+// updateLiveTask applies change to a copy of the named task and publishes a
+// new live task list with the copy. Task lists already handed out to
+// botContexts are read without a lock, so the tasks in them are never
+// modified after they go live. The caller must hold currentTasks.Lock();
+// returns false if there's no such task.
+func updateLiveTask(name string, change func(task *BotTask)) bool {
+	i, ok := currentTasks.nameMap[name]
+	if !ok {
+		return false
+	}
+	var nt interface{}
+	var task BotTask
+	switch t := currentTasks.t[i].(type) {
+	case *BotPlugin:
+		plugin := *t
+		task = *t.BotTask
+		plugin.BotTask = &task
+		nt = &plugin
+	case *BotJob:
+		job := *t
+		task = *t.BotTask
+		job.BotTask = &task
+		nt = &job
+	case *BotTask:
+		task = *t
+		nt = &task
+	}
+	change(&task)
+	tlist := make([]interface{}, len(currentTasks.t))
+	copy(tlist, currentTasks.t)
+	tlist[i] = nt
+	currentTasks.t = tlist
+	return true
+}
+
+// nameSpace returns the task's NameSpace, which defaults to it's name
+func (t *BotTask) nameSpace() string {
+	if len(t.NameSpace) > 0 {
+		return t.NameSpace
+	}
+	return t.name
+}
+
 func (tl *taskList) getTaskByName(name string) interface{} {
 	ti, ok := tl.nameMap[name]
 	if !ok {
@@ -83,11 +130,16 @@ type ExternalTask struct {
 	Name, Path, Description, NameSpace string
 	Disabled                           bool
 	Parameters                         []Parameter
+	OutputTransforms                   []string // ExternalTasks only; jobs and plugins configure these in their own yaml
+	IgnoreFailure                      bool     // ExternalTasks only; jobs and plugins configure this in their own yaml
 }
 
 // ScheduledTask items defined in gopherbot.yaml, mostly for scheduled jobs
 type ScheduledTask struct {
 	Schedule string // timespec for https://godoc.org/github.com/robfig/cron
+	Jitter   string // optional random delay of up to e.g. "5m", to spread out jobs with the same Schedule
+	TimeZone string // optional zone for the Schedule, e.g. "Europe/London", overriding the robot's TimeZone
+	CatchUp  bool   // at startup, run the job once if a scheduled run was missed while the robot was down
 	TaskSpec
 }
 
@@ -95,6 +147,7 @@ type ScheduledTask struct {
 type PluginHelp struct {
 	Keywords []string // match words for 'help XXX'
 	Helptext []string // help string to give for the keywords, conventionally starting with (bot) for commands or (hear) when the bot needn't be addressed directly
+	Category string   // heading to list this help under, overriding the plugin's Category
 }
 
 // Indicates what started the pipeline
@@ -109,59 +162,85 @@ const (
 	scheduled
 	jobCmd  // i.e. run job xx
 	pipeAdd // from e.g. AddCommand
+	plugAction
 )
 
 // InputMatcher specifies the command or message to match for a plugin
 type InputMatcher struct {
-	Regex    string         // The regular expression string to match - bot adds ^\w* & \w*$
-	Command  string         // The name of the command to pass to the plugin with it's arguments
-	Label    string         // ReplyMatchers use "Label" instead of "Command"
-	Contexts []string       // label the contexts corresponding to capture groups, for supporting "it" & optional args
-	re       *regexp.Regexp // The compiled regular expression. If the regex doesn't compile, the 'bot will log an error
+	Regex       string         // The regular expression string to match - bot adds ^\w* & \w*$
+	Command     string         // The name of the command to pass to the plugin with it's arguments
+	Label       string         // ReplyMatchers use "Label" instead of "Command"
+	Contexts    []string       // label the contexts corresponding to capture groups, for supporting "it" & optional args
+	Format      string         // optional message format (Raw, Variable or Fixed) for the command, overriding DefaultMessageFormat
+	Insensitive bool           // match case-insensitively, as if the Regex started with (?i)
+	re          *regexp.Regexp // The compiled regular expression. If the regex doesn't compile, the 'bot will log an error
+	groups      map[string]int // argument index of named capture groups, nil if the regex doesn't name them
 }
 
 // JobTrigger specifies a user and message to trigger a job
 type JobTrigger struct {
-	Regex   string         // The regular expression string to match - bot adds ^\w* & \w*$
-	User    string         // required user to trigger this job, normally git-activated webhook or integration
-	Channel string         // required channel for the trigger
-	re      *regexp.Regexp // The compiled regular expression. If the regex doesn't compile, the 'bot will log an error
+	Regex          string              // The regular expression string to match - bot adds ^\w* & \w*$
+	User           string              // required user to trigger this job, normally git-activated webhook or integration
+	Channel        string              // required channel for the trigger
+	Secret         string              // for webhooks, the secret for verifying GitHub or GitLab style signatures; may be a ${secret:NAME} reference
+	JSONParameters map[string]string   // for webhooks, parameters set from fields of a JSON payload, e.g. BRANCH: "$.pull_request.head.ref"
+	re             *regexp.Regexp      // The compiled regular expression. If the regex doesn't compile, the 'bot will log an error
+	groups         map[string]int      // argument index of named capture groups, nil if the regex doesn't name them
+	jsonPaths      map[string]jsonPath // compiled JSONParameters
 }
 
 // BotTask configuration is common to tasks, plugins or jobs. Any task, plugin or job can call bot methods. Note that tasks are only defined
 // in gopherbot.yaml, and no external configuration is read in.
 type BotTask struct {
-	name          string          // name of job or plugin; unique by type, but job & plugin can share
-	taskType      taskType        // taskGo or taskExternal
-	Path          string          // Path to the external executable for jobs or Plugtype=taskExternal only
-	NameSpace     string          // callers that share namespace share long-term memories and environment vars; defaults to name if not otherwise set
-	Parameters    []Parameter     // Fixed parameters for a given job; many jobs will use the same script with differing parameters
-	Description   string          // description of job or plugin
-	AllowDirect   bool            // Set this true if this plugin can be accessed via direct message
-	DirectOnly    bool            // Set this true if this plugin ONLY accepts direct messages
-	Channel       string          // channel where a job can be interracted with, channel where a scheduled task (job or plugin) runs
-	Channels      []string        // plugins only; Channels where the plugin is available - rifraf like "memes" should probably only be in random, but it's configurable. If empty uses DefaultChannels
-	AllChannels   bool            // If the Channels list is empty and AllChannels is true, the plugin should be active in all the channels the bot is in
-	RequireAdmin  bool            // Set to only allow administrators to access a plugin / run job
-	Protected     bool            // Protected jobs run with wd = custom config directory; all other jobs run in workSpace
-	Users         []string        // If non-empty, list of all the users with access to this plugin
-	Elevator      string          // Use an elevator other than the DefaultElevator
-	Authorizer    string          // a plugin to call for authorizing users, should handle groups, etc.
-	AuthRequire   string          // an optional group/role name to be passed to the Authorizer plugin, for group/role-based authorization determination
-	taskID        string          // 32-char random ID for identifying plugins/jobs
-	ReplyMatchers []InputMatcher  // store this here for prompt*reply methods
-	Config        json.RawMessage // Arbitrary Plugin configuration, will be stored and provided in a thread-safe manner via GetTaskConfig()
-	config        interface{}     // A pointer to an empty struct that the bot can Unmarshal custom configuration into
-	Disabled      bool
-	reason        string // why this job/plugin is disabled
+	name             string          // name of job or plugin; unique by type, but job & plugin can share
+	taskType         taskType        // taskGo or taskExternal
+	Path             string          // Path to the external executable for jobs or Plugtype=taskExternal only
+	NameSpace        string          // callers that share namespace share long-term memories and environment vars; defaults to name if not otherwise set
+	Parameters       []Parameter     // Fixed parameters for a given job; many jobs will use the same script with differing parameters
+	Description      string          // description of job or plugin
+	AllowDirect      bool            // Set this true if this plugin can be accessed via direct message
+	DirectOnly       bool            // Set this true if this plugin ONLY accepts direct messages
+	Channel          string          // channel where a job can be interracted with, channel where a scheduled task (job or plugin) runs
+	Channels         []string        // plugins only; Channels where the plugin is available - rifraf like "memes" should probably only be in random, but it's configurable. If empty uses DefaultChannels. Entries can be glob patterns, e.g. "memes-*"
+	channelPatterns  []string        // glob patterns from Channels
+	AllChannels      bool            // If the Channels list is empty and AllChannels is true, the plugin should be active in all the channels the bot is in
+	RequireAdmin     bool            // Set to only allow administrators to access a plugin / run job
+	Protected        bool            // Protected jobs run with wd = custom config directory; all other jobs run in workSpace
+	Users            []string        // If non-empty, list of all the users with access to this plugin
+	Elevator         string          // Use an elevator other than the DefaultElevator
+	Authorizer       string          // a plugin to call for authorizing users, should handle groups, etc.
+	AuthRequire      string          // an optional group/role name to be passed to the Authorizer plugin, for group/role-based authorization determination
+	taskID           string          // 32-char random ID for identifying plugins/jobs
+	ReplyMatchers    []InputMatcher  // store this here for prompt*reply methods
+	OutputTransforms []string        // transforms applied to stdout before it's stored in history, e.g. [ "ansi-strip", "truncate:4000" ]
+	Timeout          int             // seconds an external task may run before it's killed; overrides DefaultTaskTimeout
+	LogLevel         string          // log level for messages about this task, when more verbose than the robot's LogLevel
+	IgnoreFailure    bool            // when added to a pipeline with AddTask/AddJob/AddCommand, a failure doesn't abort the remaining tasks
+	Config           json.RawMessage // Arbitrary Plugin configuration, will be stored and provided in a thread-safe manner via GetTaskConfig()
+	config           interface{}     // A pointer to an empty struct that the bot can Unmarshal custom configuration into
+	Disabled         bool
+	reason           string // why this job/plugin is disabled
+	configDisabled   bool   // disabled by configuration, rather than for a load error
+	runtimeDisabled  bool   // disabled by an administrator with 'disable plugin|job'
+
+	// Plugins only; adjustments to RequireAdmin, Users and Config for
+	// specific channels, see channeloverrides.go
+	ChannelOverrides map[string]ChannelOverride
+	channelConfigs   map[string]*channelConfig // effective configuration for each channel in ChannelOverrides
 }
 
 // BotJob - configuration only applicable to jobs. Read in from conf/jobs/<job>.yaml, which can also include anything from a BotTask.
 type BotJob struct {
-	Quiet       bool           // whether to quash "job started/ended" messages
-	HistoryLogs int            // how many runs of this job/plugin to keep history for
-	Triggers    []JobTrigger   // user/regex that triggers a job, e.g. a git-activated webhook or integration
-	Arguments   []InputMatcher // list of arguments to prompt the user for
+	Quiet          bool           // whether to quash "job started/ended" messages
+	Notify         string         // user to notify directly when the job times out
+	NotifyEmail    bool           // also email Notify the output of a failed scheduled job
+	HistoryLogs    int            // how many runs of this job/plugin to keep history for
+	MaxHistoryAge  string         // how long to keep runs, e.g. "30d"; with HistoryLogs, runs must satisfy both
+	maxHistoryAge  time.Duration  // parsed MaxHistoryAge
+	Triggers       []JobTrigger   // user/regex that triggers a job, e.g. a git-activated webhook or integration
+	WebhookSources []string       // addresses or CIDR ranges allowed to trigger the job at /trigger/<job>; none when empty
+	sources        []*net.IPNet   // parsed WebhookSources
+	Arguments      []InputMatcher // list of arguments to prompt the user for
 	*BotTask
 }
 
@@ -171,22 +250,59 @@ type BotPlugin struct {
 	AdminCommands            []string       // A list of commands only a bot admin can use
 	ElevatedCommands         []string       // Commands that require elevation, usually via 2fa
 	ElevateImmediateCommands []string       // Commands that always require elevation promting, regardless of timeouts
+	ElevateTimeout           string         // Elevator plugins only; overrides the robot's ElevateTimeout, e.g. "30m"
 	AuthorizedCommands       []string       // Which commands to authorize
 	AuthorizeAllCommands     bool           // when ALL commands need to be authorized
 	Help                     []PluginHelp   // All the keyword sets / help texts for this plugin
+	Category                 string         // heading for this plugin's commands in help output; 'help <category>' lists just those commands
 	CommandMatchers          []InputMatcher // Input matchers for messages that need to be directed to the 'bot
 	MessageMatchers          []InputMatcher // Input matchers for messages the 'bot hears even when it's not being spoken to
 	CatchAll                 bool           // Whenever the robot is spoken to, but no plugin matches, plugins with CatchAll=true get called with command="catchall" and argument=<full text of message to robot>
+	Priority                 int            // Higher priority CatchAll plugins are called first, and win when commands for more than one plugin match; ties go by plugin name
 	MatchUnlisted            bool           // Set to true if ambient messages matches should be checked for users not listed in the UserRoster
+	NoSuggest                bool           // Don't offer this plugin's commands in "did you mean" suggestions
+	MaxArgs                  int            // Override the robot's MaxArgs for this plugin
+	MaxArgLength             int            // Override the robot's MaxArgLength for this plugin
+	BusinessHoursCommands    []string       // Commands only available during business hours
+	BusinessHours            *BusinessHours // Override the robot's BusinessHours for this plugin
+	RateLimit                *RateLimit     // Override the robot's RateLimit for this plugin
+	MaxConcurrent            int            // Maximum number of commands for this plugin running at once; 0 = unlimited
+	MaxQueued                int            // Commands waiting for MaxConcurrent beyond this are rejected
+	Singleton                bool           // Drop a command while an identical one from the same user and channel is running
+	calendar                 *hoursCalendar
+	limiter                  *rateLimiter
+	schema                   *configSchema // from ConfigSchema in the plugin's configuration
+
+	ActionCommands map[string]string // Maps the action_id of buttons in SendBlocks messages to command text, where $value is the button's value
+	ActionMatchers []InputMatcher    // Input matchers for the action_id of buttons; the button's value is passed as the last argument
+
+	Aliases   map[string]string   // Alternate words for commands, e.g. "d: deploy"; resolved before CommandMatchers are checked
+	aliasesOf map[string][]string // the aliases for each command, for help
 	*BotTask
 }
 
+// commandFormat returns the message format configured for a command in the
+// plugin's CommandMatchers or MessageMatchers, if any.
+func (p *BotPlugin) commandFormat(command string) (MessageFormat, bool) {
+	for _, matchers := range [][]InputMatcher{p.CommandMatchers, p.MessageMatchers} {
+		for _, matcher := range matchers {
+			if matcher.Command == command && len(matcher.Format) > 0 {
+				return setFormat(matcher.Format), true
+			}
+		}
+	}
+	return Raw, false
+}
+
 // PluginHandler is the struct a plugin registers for the Gopherbot plugin API.
 type PluginHandler struct {
 	DefaultConfig string /* A yaml-formatted multiline string defining the default Plugin configuration. It should be liberally commented for use in generating
 	custom configuration for the plugin. If a Config: section is defined, it should match the structure of the optional Config interface{} */
 	Handler func(bot *Robot, command string, args ...string) TaskRetVal // The callback function called by the robot whenever a Command is matched
 	Config  interface{}                                                 // An optional empty struct defining custom configuration for the plugin
+	// An optional yaml or json schema (a subset of JSON Schema) for checking Config at load; without one, fields of the Config
+	// struct tagged `gopherbot:"required"` are checked. See configschema.go.
+	ConfigSchema string
 }
 
 var pluginHandlers = make(map[string]PluginHandler)
@@ -194,7 +310,17 @@ var pluginHandlers = make(map[string]PluginHandler)
 // stopRegistrations is set "true" when the bot is created to prevent registration outside of init functions
 var stopRegistrations = false
 
-// initialize sends the "init" command to every plugin
+// defaultInitConcurrency is how many plugins are initialized at once,
+// unless InitConcurrency is set
+const defaultInitConcurrency = 4
+
+// errInitPanic starts the error from callTask when a Go plugin panics
+// during init
+const errInitPanic = "panic during init"
+
+// initializePlugins sends the "init" command to every plugin, up to
+// InitConcurrency at once so a slow external plugin doesn't hold up the
+// rest.
 func initializePlugins() {
 	currentTasks.Lock()
 	tasks := taskList{
@@ -204,29 +330,93 @@ func initializePlugins() {
 		currentTasks.nameSpaces,
 	}
 	currentTasks.Unlock()
-	c := &botContext{
-		environment: make(map[string]string),
-		tasks:       tasks,
+	botCfg.RLock()
+	workers := botCfg.initConcurrency
+	botCfg.RUnlock()
+	if workers < 1 {
+		workers = defaultInitConcurrency
 	}
-	c.registerActive(nil)
-	botCfg.Lock()
-	if !botCfg.shuttingDown {
-		botCfg.Unlock()
-		for _, t := range tasks.t {
-			task, plugin, _ := getTask(t)
-			if plugin == nil {
-				continue
+	plugins := make(chan interface{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// callTask sets the current task, so each worker needs it's
+			// own context
+			c := &botContext{
+				environment: make(map[string]string),
+				tasks:       tasks,
 			}
-			if task.Disabled {
-				continue
+			c.registerActive(nil)
+			for t := range plugins {
+				c.initializePlugin(t)
 			}
-			Log(Info, "Initializing plugin:", task.name)
-			c.callTask(t, "init")
+			c.deregister()
+		}()
+	}
+	for _, t := range tasks.t {
+		task, plugin, _ := getTask(t)
+		if plugin == nil {
+			continue
 		}
-	} else {
-		botCfg.Unlock()
+		if task.Disabled {
+			continue
+		}
+		botCfg.RLock()
+		shuttingDown := botCfg.shuttingDown
+		botCfg.RUnlock()
+		if shuttingDown {
+			break
+		}
+		plugins <- t
 	}
-	c.deregister()
+	close(plugins)
+	wg.Wait()
+}
+
+// initializePlugin sends "init" to a plugin, logging any failure; a Go
+// plugin that panics is disabled rather than crashing the robot.
+func (c *botContext) initializePlugin(t interface{}) {
+	task, _, _ := getTask(t)
+	Log(Info, "Initializing plugin:", task.name)
+	errString, ret := c.callTask(t, "init")
+	if strings.HasPrefix(errString, errInitPanic) {
+		currentTasks.Lock()
+		updateLiveTask(task.name, func(task *BotTask) {
+			task.Disabled = true
+			task.reason = errString
+		})
+		currentTasks.Unlock()
+		Log(Error, fmt.Sprintf("Disabled plugin '%s' after a %s", task.name, errString))
+		return
+	}
+	if len(errString) > 0 {
+		Log(Error, fmt.Sprintf("Error initializing plugin '%s': %s", task.name, errString))
+	} else if ret != Normal {
+		Log(Warn, fmt.Sprintf("Plugin '%s' returned %s from init", task.name, ret))
+	}
+}
+
+// callGoPlugin calls a Go plugin's handler. A panic is recovered and
+// returned as an error; a plugin that panics during init is disabled by
+// initializePlugin, and one that panics in a command is disabled here.
+func callGoPlugin(r *Robot, name, command string, args ...string) (errString string, ret TaskRetVal) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			countPanic(name)
+			ret = MechanismFail
+			if command == "init" {
+				Log(Error, fmt.Sprintf("PANIC from plugin '%s' during init: %s\nStack trace:%s", name, rcv, godebug.Stack()))
+				errString = fmt.Sprintf("%s: %v", errInitPanic, rcv)
+				return
+			}
+			Log(Error, fmt.Sprintf("PANIC from plugin '%s' in command '%s': %s\nStack trace:%s", name, command, rcv, godebug.Stack()))
+			disablePanicked(name, fmt.Sprintf("panic in command '%s': %v", command, rcv))
+			errString = fmt.Sprintf("Plugin '%s' crashed and has been disabled, you might want to ask an administrator to check the logs", name)
+		}
+	}()
+	return "", pluginHandlers[name].Handler(r, command, args...)
 }
 
 // RegisterPlugin allows Go plugins to register a PluginHandler in a func init().
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+/* tasktimeout.go - limits on how long an external task can run, so a hung
+   script doesn't block it's pipeline forever. A task's Timeout (seconds)
+   overrides the robot's DefaultTaskTimeout; a negative Timeout means no
+   limit. Go tasks run in the robot's process and can't be killed, so the
+   limit only applies to external tasks. External tasks are also killed
+   when the pipeline's context is cancelled, e.g. when the robot shuts down;
+   Go tasks get the context from Robot.Context().
+*/
+
+// taskTimer kills an external task when it runs past it's timeout, or it's
+// context is cancelled
+type taskTimer struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the watcher exits
+	exited  bool          // set when the task exits on it's own
+	err     error         // why the task was killed, nil if it wasn't
+	sync.Mutex
+}
+
+// newTaskTimer returns the timer for a task, to be started after
+// cmd.Start(). It must be created before cmd.Start() so the task can be
+// started in a way that lets it be killed along with it's children.
+func newTaskTimer(task *BotTask, cmd *exec.Cmd) *taskTimer {
+	var timeout time.Duration
+	switch {
+	case task.Timeout > 0:
+		timeout = time.Duration(task.Timeout) * time.Second
+	case task.Timeout == 0:
+		botCfg.RLock()
+		timeout = botCfg.taskTimeout
+		botCfg.RUnlock()
+	}
+	setKillGroup(cmd)
+	return &taskTimer{timeout: timeout}
+}
+
+// start starts the clock on a running task, killing it if ctx is cancelled
+// first
+func (tt *taskTimer) start(ctx context.Context, name string, cmd *exec.Cmd) {
+	if tt.timeout > 0 {
+		ctx, tt.cancel = context.WithTimeout(ctx, tt.timeout)
+	} else {
+		ctx, tt.cancel = context.WithCancel(ctx)
+	}
+	tt.done = make(chan struct{})
+	go func() {
+		defer close(tt.done)
+		<-ctx.Done()
+		tt.Lock()
+		if tt.exited {
+			tt.Unlock()
+			return
+		}
+		tt.err = ctx.Err()
+		tt.Unlock()
+		if tt.err == context.DeadlineExceeded {
+			Log(Warn, fmt.Sprintf("Task '%s' still running after timeout of %s, killing", name, tt.timeout))
+		} else {
+			Log(Warn, fmt.Sprintf("Task '%s' still running when it's pipeline was cancelled, killing", name))
+		}
+		if err := killTask(cmd); err != nil {
+			Log(Error, fmt.Sprintf("Killing task '%s': %v", name, err))
+		}
+	}()
+}
+
+// stop stops the clock when the task exits, returning
+// context.DeadlineExceeded if the task was killed for running too long,
+// or context.Canceled if it was killed when it's context was cancelled.
+func (tt *taskTimer) stop() error {
+	if tt.done == nil {
+		return nil
+	}
+	tt.Lock()
+	tt.exited = true
+	tt.Unlock()
+	tt.cancel()
+	<-tt.done
+	return tt.err
+}
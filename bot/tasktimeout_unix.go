@@ -0,0 +1,21 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package bot
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setKillGroup starts the task in it's own process group, so anything it
+// spawns is killed with it.
+func setKillGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func killTask(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,15 @@
+// +build windows
+
+package bot
+
+import (
+	"os/exec"
+)
+
+// setKillGroup is a no-op on Windows, where only the task itself is killed
+func setKillGroup(cmd *exec.Cmd) {
+}
+
+func killTask(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* threads.go - optional thread engagement. With ThreadAddressing enabled,
+   once the robot is addressed in a thread, further messages in the same
+   thread are treated as addressed to the robot until no command has matched
+   in the thread for ThreadAddressWindow. Since other users may be talking
+   in the thread, these messages never call catch-alls or get command
+   suggestions. Engagement is tracked in
+   short-term memory per channel and thread, so it never applies to messages
+   in the main channel or other threads.
+*/
+
+// short-term memory key prefix for engaged threads
+const threadMemoryPrefix = "thread:"
+
+// defaultThreadWindow is used when ThreadAddressing is enabled without a
+// ThreadAddressWindow
+const defaultThreadWindow = 10 * time.Minute
+
+// threadWindow returns the configured engagement window, or 0 when thread
+// addressing is disabled.
+func threadWindow() time.Duration {
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	return botCfg.threadWindow
+}
+
+// checkThreadEngaged records engagement when a message in a thread is
+// addressed to the robot, and reports whether an unaddressed message is in
+// an engaged thread. Only addressed messages start or extend the window;
+// see extendThreadEngagement for engaged messages that match a command.
+func checkThreadEngaged(channel, threadID string, addressed bool) bool {
+	window := threadWindow()
+	if window == 0 || len(threadID) == 0 {
+		return false
+	}
+	ctx := memoryContext{threadMemoryPrefix + threadID, "", channel}
+	now := time.Now()
+	shortTermMemories.Lock()
+	defer shortTermMemories.Unlock()
+	if addressed {
+		shortTermMemories.m[ctx] = shortTermMemory{threadID, now}
+		return false
+	}
+	s, ok := shortTermMemories.m[ctx]
+	if !ok || now.Sub(s.timestamp) > window {
+		return false
+	}
+	Log(Debug, fmt.Sprintf("Treating message in engaged thread '%s' in channel '%s' as addressed to the robot", threadID, channel))
+	return true
+}
+
+// extendThreadEngagement restarts the window for an engaged thread when an
+// unaddressed message in it matched a command.
+func extendThreadEngagement(channel, threadID string) {
+	checkThreadEngaged(channel, threadID, true)
+}
+
+// shortTermLifetime gives the lifetime of a short-term memory; engaged
+// threads can last longer than other memories.
+func shortTermLifetime(key string, window time.Duration) time.Duration {
+	if strings.HasPrefix(key, threadMemoryPrefix) && window > shortTermDuration {
+		return window
+	}
+	return shortTermDuration
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"time"
+)
+
+/* typing.go - the typing indicator, for commands that take a while. Plugins
+   can show it with StartTyping, and with TypingDelay the robot shows it
+   for any command whose pipeline runs longer than the delay. Connectors
+   show the indicator from MessageHeard; most protocols clear it after a few
+   seconds, so it's refreshed until StopTyping is called or the pipeline
+   ends. Connectors without typing support ignore it.
+*/
+
+// typingRefresh is how often the indicator is re-sent while typing
+const typingRefresh = 3 * time.Second
+
+// typingDelay returns the configured TypingDelay, or 0 when the robot
+// doesn't show the indicator automatically.
+func typingDelay() time.Duration {
+	botCfg.RLock()
+	defer botCfg.RUnlock()
+	return botCfg.typingDelay
+}
+
+// StartTyping shows the user the robot is typing, until StopTyping is
+// called or the pipeline ends.
+func (r *Robot) StartTyping() {
+	r.getContext().startTyping()
+}
+
+// StopTyping stops the indicator from StartTyping.
+func (r *Robot) StopTyping() {
+	r.getContext().stopTyping()
+}
+
+func (c *botContext) startTyping() {
+	c.Lock()
+	if c.typing != nil || c.typingEnded {
+		c.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	c.typing = stop
+	c.Unlock()
+	go func() {
+		c.messageHeard()
+		refresh := time.NewTicker(typingRefresh)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-refresh.C:
+				c.messageHeard()
+			}
+		}
+	}()
+}
+
+func (c *botContext) stopTyping() {
+	c.Lock()
+	if c.typing != nil {
+		close(c.typing)
+		c.typing = nil
+	}
+	c.Unlock()
+}
+
+// startTypingTimer starts the indicator after the TypingDelay for commands,
+// returning a function to call when the pipeline ends, which clears it.
+func (c *botContext) startTypingTimer() func() {
+	var timer *time.Timer
+	if delay := typingDelay(); delay > 0 && !c.automaticTask {
+		timer = time.AfterFunc(delay, c.startTyping)
+	}
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		c.Lock()
+		c.typingEnded = true
+		c.Unlock()
+		c.stopTyping()
+	}
+}
@@ -85,6 +85,8 @@ func setFormat(format string) MessageFormat {
 		return Variable
 	case "raw":
 		return Raw
+	case "markup":
+		return Markup
 	default:
 		Log(Error, fmt.Sprintf("Unknown message format '%s', defaulting to 'raw'", format))
 		return Raw
@@ -98,6 +100,10 @@ func setProtocol(proto string) Protocol {
 		return Slack
 	case "term", "terminal":
 		return Terminal
+	case "mattermost":
+		return Mattermost
+	case "telegram":
+		return Telegram
 	default:
 		return Test
 	}
@@ -107,8 +113,11 @@ func updateRegexes() {
 	botCfg.RLock()
 	name := botCfg.botinfo.UserName
 	alias := botCfg.alias
+	channelAliases := botCfg.channelAliases
+	prefixes := botCfg.commandPrefixes
 	botCfg.RUnlock()
-	pre, post, bare, errpre, errpost, errbare := updateRegexesWrapped(name, alias)
+	pre, post, bare, errpre, errpost, errbare := updateRegexesWrapped(name, alias, prefixes)
+	channelPre := updateChannelRegexes(name, channelAliases, prefixes)
 	if errpre != nil {
 		Log(Error, fmt.Sprintf("Error compiling pre regex: %s", errpre))
 	}
@@ -131,35 +140,38 @@ func updateRegexes() {
 	botCfg.preRegex = pre
 	botCfg.postRegex = post
 	botCfg.bareRegex = bare
+	botCfg.channelPreRegex = channelPre
 	botCfg.Unlock()
 }
 
 // TODO: write unit test. The regexes produced shouldn't be checked, but rather
 // whether given strings do or don't match them. Note: this code is partially
 // tested in TestBotName
-func updateRegexesWrapped(name string, alias rune) (pre, post, bare *regexp.Regexp, errpre, errpost, errbare error) {
+func updateRegexesWrapped(name string, alias rune, prefixes []string) (pre, post, bare *regexp.Regexp, errpre, errpost, errbare error) {
 	pre = nil
 	post = nil
-	if alias == 0 && len(name) == 0 {
+	if alias == 0 && len(name) == 0 && len(prefixes) == 0 {
 		Log(Error, "Robot has no name or alias, and will only respond to direct messages")
 		return
 	}
-	preString := `^(?i:`
+	// The alias, command prefixes and name are alternatives, combined with
+	// an '|' (or); prefixes are longest first, so e.g. "!!" is tried before
+	// "!".
+	var alternatives []string
 	if alias != 0 {
 		if strings.ContainsRune(string(escapeAliases), alias) {
-			preString += `\` + string(alias)
+			alternatives = append(alternatives, `\`+string(alias))
 		} else {
-			preString += string(alias)
+			alternatives = append(alternatives, string(alias))
 		}
 	}
-	// If both name and alias present, combine with an '|' (or)
-	if alias != 0 && len(name) > 0 {
-		preString += `|`
+	for _, prefix := range prefixes {
+		alternatives = append(alternatives, regexp.QuoteMeta(prefix))
 	}
 	if len(name) > 0 {
-		preString += `@?` + name + `[:, ]`
+		alternatives = append(alternatives, `@?`+name+`[:, ]`)
 	}
-	preString += `\s*)(.*)$`
+	preString := `^(?i:` + strings.Join(alternatives, `|`) + `\s*)(.*)$`
 	pre, errpre = regexp.Compile(preString)
 	// NOTE: the preString regex matches a bare alias, but not a bare name
 	if len(name) > 0 {
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+/* validateconfig.go - validation of gopherbot.yaml and task configuration,
+   e.g. for gating merges to a robot's configuration repository in CI. The
+   configuration is loaded with loadConfig and loadTaskConfig, just as when
+   the robot starts, but without a connector, the configured brain or the
+   http listener; every task that would be disabled is reported with it's
+   reason.
+*/
+
+// set while validating, so a configured LogFile doesn't take over the log
+var validatingConfig bool
+
+// ValidateConfig loads the robot's configuration from the configuration and
+// install directories without connecting, returning an error for a configuration
+// that can't be loaded, or for each task that would be disabled. Tasks with
+// Disabled: true in their configuration aren't errors.
+func ValidateConfig(cpath, epath string) []error {
+	// the http listener isn't needed, and the port may be in use by a
+	// running robot
+	listening = true
+	validatingConfig = true
+	stopRegistrations = true
+	if botLogger.l == nil {
+		botLogger.l = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	configPath = cpath
+	installPath = epath
+	c := &botContext{
+		environment: make(map[string]string),
+	}
+	if err := c.loadConfig(true); err != nil {
+		return []error{err}
+	}
+	// tasks disabled at runtime are kept in the brain; the memory brain
+	// has none
+	botCfg.Lock()
+	botCfg.brain = brains["mem"](handler{}, botLogger.l)
+	botCfg.Unlock()
+	go runBrain()
+	c.registerActive(nil)
+	c.loadTaskConfig()
+	c.deregister()
+
+	currentTasks.Lock()
+	tasks := currentTasks.t
+	currentTasks.Unlock()
+	var errs []error
+	names := make([]string, 0, len(tasks))
+	reasons := make(map[string]string)
+	for _, t := range tasks {
+		task, plugin, job := getTask(t)
+		if !task.Disabled || task.configDisabled {
+			continue
+		}
+		ttype := "task"
+		switch {
+		case plugin != nil:
+			ttype = "plugin"
+		case job != nil:
+			ttype = "job"
+		}
+		name := fmt.Sprintf("%s '%s'", ttype, task.name)
+		names = append(names, name)
+		reasons[name] = task.reason
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("%s would be disabled: %s", name, reasons[name]))
+	}
+	return errs
+}
+
+// validateConfig validates the configuration, logging any errors, and exits;
+// used for the -validate-config command-line flag. The exit status is 1 when
+// there are errors.
+func validateConfig(cpath, epath string, logger *log.Logger) {
+	botLogger.l = logger
+	errs := ValidateConfig(cpath, epath)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "Configuration in '%s' has %d error(s)\n", cpath, len(errs))
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Configuration in '%s' is valid\n", cpath)
+	os.Exit(0)
+}
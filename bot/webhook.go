@@ -0,0 +1,335 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* webhook.go - the /trigger/<job> endpoint of the http listener, for CI
+   systems and other services to start jobs over http. The request body is
+   matched against the job's Triggers, with the trigger User and Channel
+   given in the 'user' and 'channel' query parameters; named capture groups
+   set parameters just like chat triggers, and the body is available to the
+   job in GOPHER_WEBHOOK_PAYLOAD. Requests need the LocalToken, and must come
+   from one of the job's WebhookSources. The response has a run ID the caller
+   can poll with GET /trigger/<job>/<id>, or with '?wait=true' the final
+   status of the job. Failed runs go in the dead letter queue.
+
+   Services like GitHub and GitLab can't send the LocalToken, but sign their
+   payloads with a secret instead; a trigger with a Secret only matches a
+   payload with a valid signature in X-Hub-Signature-256 or X-Hub-Signature
+   (GitHub), or X-Gitlab-Token (GitLab). A signed request doesn't need the
+   LocalToken, but can only match triggers with a Secret.
+*/
+
+// maximum size of a webhook payload
+const maxWebhookPayload = 1 << 20
+
+// how many finished runs are kept for polling
+const maxWebhookRuns = 100
+
+// webhookStatus is the JSON response for a webhook run
+type webhookStatus struct {
+	RunID  int
+	Job    string
+	Status string // "Running", or the TaskRetVal of the finished job
+}
+
+type webhookRun struct {
+	webhookStatus
+	done chan struct{}
+}
+
+var webhookRuns = struct {
+	m    map[int]*webhookRun
+	next int
+	sync.Mutex
+}{
+	make(map[int]*webhookRun),
+	1,
+	sync.Mutex{},
+}
+
+type webhookHandler struct{}
+
+// parseSources parses a job's WebhookSources, addresses or CIDR ranges
+func parseSources(sources []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(sources))
+	for _, source := range sources {
+		if !strings.Contains(source, "/") {
+			if strings.Contains(source, ":") {
+				source += "/128"
+			} else {
+				source += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook source '%s': %v", source, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// sourceAllowed checks the remote address of a request against a job's
+// sources; connections on a unix socket are treated as local.
+func sourceAllowed(job *BotJob, remoteAddr string) bool {
+	ip := net.ParseIP("127.0.0.1")
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return false
+	}
+	for _, n := range job.sources {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookSignature is the signature from a request's headers, if any
+type webhookSignature struct {
+	header  string
+	newHash func() hash.Hash // nil for a token compared to the secret directly
+	value   string
+}
+
+func requestSignature(req *http.Request) (webhookSignature, bool) {
+	for _, sh := range []struct {
+		header, prefix string
+		newHash        func() hash.Hash
+	}{
+		{"X-Hub-Signature-256", "sha256=", sha256.New},
+		{"X-Hub-Signature", "sha1=", sha1.New},
+		{"X-Gitlab-Token", "", nil},
+	} {
+		if value := req.Header.Get(sh.header); len(value) > 0 {
+			return webhookSignature{sh.header, sh.newHash, strings.TrimPrefix(value, sh.prefix)}, true
+		}
+	}
+	return webhookSignature{}, false
+}
+
+// verifySignature checks a payload's signature against the secret for a
+// trigger, returning the http status for rejecting the payload, or 0 when
+// it's verified.
+func verifySignature(jobName string, jt *JobTrigger, sig webhookSignature, signed bool, payload []byte) int {
+	if len(jt.Secret) == 0 || !signed {
+		return http.StatusUnauthorized
+	}
+	secret, err := resolveSecrets(jt.Secret)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Resolving Secret for a webhook trigger of job '%s': %v", jobName, err))
+		return http.StatusForbidden
+	}
+	if sig.newHash == nil {
+		if subtle.ConstantTimeCompare([]byte(sig.value), []byte(secret)) != 1 {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	got, err := hex.DecodeString(sig.value)
+	if err != nil {
+		return http.StatusForbidden
+	}
+	mac := hmac.New(sig.newHash, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+func sendStatus(rw http.ResponseWriter, code int, status webhookStatus) {
+	d, _ := json.Marshal(status)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(d)
+}
+
+func (h webhookHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/trigger/"), "/")
+	jobName := parts[0]
+	// signed payloads are checked against trigger secrets instead
+	sig, signed := requestSignature(req)
+	if (!signed || req.Method != http.MethodPost) && !authorized(rw, req) {
+		return
+	}
+	switch {
+	case len(parts) == 2 && req.Method == http.MethodGet:
+		id, _ := strconv.Atoi(parts[1])
+		webhookRuns.Lock()
+		run, ok := webhookRuns.m[id]
+		var status webhookStatus
+		if ok {
+			status = run.webhookStatus
+		}
+		webhookRuns.Unlock()
+		if !ok || status.Job != jobName {
+			http.Error(rw, fmt.Sprintf("no run %s of job '%s'", parts[1], jobName), http.StatusNotFound)
+			return
+		}
+		sendStatus(rw, http.StatusOK, status)
+		return
+	case len(parts) == 1 && req.Method == http.MethodPost:
+	default:
+		http.Error(rw, "use POST /trigger/<job>, or GET /trigger/<job>/<run id>", http.StatusMethodNotAllowed)
+		return
+	}
+
+	currentTasks.Lock()
+	tasks := taskList{
+		currentTasks.t,
+		currentTasks.nameMap,
+		currentTasks.idMap,
+		currentTasks.nameSpaces,
+	}
+	currentTasks.Unlock()
+	t := tasks.getTaskByName(jobName)
+	var task *BotTask
+	var job *BotJob
+	if t != nil {
+		task, _, job = getTask(t)
+	}
+	if job == nil {
+		http.Error(rw, fmt.Sprintf("no job named '%s'", jobName), http.StatusNotFound)
+		return
+	}
+	if !sourceAllowed(job, req.RemoteAddr) {
+		Log(Warn, fmt.Sprintf("Rejected webhook for job '%s' from unallowed source '%s'", jobName, req.RemoteAddr))
+		http.Error(rw, fmt.Sprintf("job '%s' can't be triggered from this address", jobName), http.StatusForbidden)
+		return
+	}
+	if task.Disabled {
+		http.Error(rw, fmt.Sprintf("job '%s' is disabled: %s", jobName, task.reason), http.StatusServiceUnavailable)
+		return
+	}
+	botCfg.RLock()
+	unavailable := botCfg.shuttingDown || botCfg.paused
+	botCfg.RUnlock()
+	if unavailable {
+		http.Error(rw, "not running jobs: shutting down or paused", http.StatusServiceUnavailable)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("reading payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	user := req.URL.Query().Get("user")
+	channel := req.URL.Query().Get("channel")
+	var trigger *JobTrigger
+	var args []string
+	// the status for a payload that matched a trigger, but wasn't verified
+	rejected := 0
+	for i := range job.Triggers {
+		jt := &job.Triggers[i]
+		if user != jt.User || channel != jt.Channel {
+			continue
+		}
+		matches := jt.re.FindStringSubmatch(string(payload))
+		if matches == nil {
+			continue
+		}
+		if signed || len(jt.Secret) > 0 {
+			if status := verifySignature(jobName, jt, sig, signed, payload); status != 0 {
+				if rejected != http.StatusForbidden {
+					rejected = status
+				}
+				continue
+			}
+		}
+		trigger = jt
+		args = matches[1:]
+		break
+	}
+	if trigger == nil {
+		switch rejected {
+		case http.StatusUnauthorized:
+			msg := fmt.Sprintf("payload for job '%s' must be signed", jobName)
+			if signed {
+				msg = fmt.Sprintf("signed payload for job '%s' only matched a trigger with no Secret", jobName)
+			}
+			Log(Warn, fmt.Sprintf("Rejected webhook from '%s': %s", req.RemoteAddr, msg))
+			http.Error(rw, msg, http.StatusUnauthorized)
+		case http.StatusForbidden:
+			Log(Warn, fmt.Sprintf("Rejected webhook for job '%s' from '%s': invalid %s", jobName, req.RemoteAddr, sig.header))
+			http.Error(rw, fmt.Sprintf("invalid signature for job '%s'", jobName), http.StatusForbidden)
+		default:
+			http.Error(rw, fmt.Sprintf("payload didn't match a trigger for job '%s'", jobName), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+
+	confLock.RLock()
+	repolist := repositories
+	confLock.RUnlock()
+	c := &botContext{
+		User:          user,
+		Channel:       channel,
+		tasks:         tasks,
+		repositories:  repolist,
+		msg:           string(payload),
+		automaticTask: true,
+		environment:   make(map[string]string),
+	}
+	c.setGroupParameters(trigger.groups, args)
+	c.setJSONParameters(jobName, trigger, payload)
+	c.environment["GOPHER_WEBHOOK_PAYLOAD"] = string(payload)
+	// kept for a dead letter, since the pipeline adds to the environment
+	params := make(map[string]string, len(c.environment))
+	for name, value := range c.environment {
+		params[name] = value
+	}
+
+	headers := make(map[string]string, len(req.Header))
+	for name, values := range req.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	webhookRuns.Lock()
+	run := &webhookRun{
+		webhookStatus{webhookRuns.next, jobName, "Running"},
+		make(chan struct{}),
+	}
+	webhookRuns.m[run.RunID] = run
+	webhookRuns.next++
+	delete(webhookRuns.m, run.RunID-maxWebhookRuns)
+	webhookRuns.Unlock()
+	Log(Info, fmt.Sprintf("Starting job '%s' from webhook, run ID %d", jobName, run.RunID))
+	go func() {
+		ret := c.startPipeline(nil, t, jobTrigger, "run", args...)
+		if ret != Normal {
+			recordDeadLetter(jobName, user, channel, args, params, headers, payload, ret)
+		}
+		webhookRuns.Lock()
+		run.Status = ret.String()
+		webhookRuns.Unlock()
+		close(run.done)
+	}()
+
+	if wait, _ := strconv.ParseBool(req.URL.Query().Get("wait")); wait {
+		<-run.done
+		webhookRuns.Lock()
+		status := run.webhookStatus
+		webhookRuns.Unlock()
+		sendStatus(rw, http.StatusOK, status)
+		return
+	}
+	sendStatus(rw, http.StatusAccepted, webhookStatus{run.RunID, jobName, "Running"})
+}
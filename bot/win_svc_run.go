@@ -40,7 +40,8 @@ loop:
 				botCfg.Lock()
 				if botCfg.shuttingDown {
 					botCfg.Unlock()
-					eventLog.Warning(1, "Received Windows service stop / shutdown while shutdown in progress")
+					eventLog.Warning(1, "Received Windows service stop / shutdown while shutdown in progress, cancelling running pipelines")
+					cancelPipelines()
 				} else {
 					botCfg.shuttingDown = true
 					if botCfg.pluginsRunning > 0 {
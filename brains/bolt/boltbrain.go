@@ -0,0 +1,165 @@
+// Package boltBrain is a bbolt implementation of the bot.SimpleBrain
+// interface, which gives the robot a place to permanently store it's
+// memories in a single embedded database file, without an external
+// service. Each namespace has it's own bucket, e.g. memory "memes:phrases"
+// is key "phrases" in bucket "memes". Every Store is a transaction synced
+// to disk; bbolt's file lock keeps a second robot from opening the same
+// database.
+package boltBrain
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+	bolt "go.etcd.io/bbolt"
+)
+
+var robot bot.Handler
+
+const defaultBrainFile = "brain.db"
+
+// how long to wait for the database lock at start-up
+const openTimeout = 10 * time.Second
+
+// bucket for keys that don't split into a namespace and key; a split
+// namespace never contains ':'
+const noNameSpace = ":"
+
+type brainConfig struct {
+	BrainFile string // path to the database, relative to the WorkSpace directory; default "brain.db"
+}
+
+type boltBrain struct {
+	db *bolt.DB
+}
+
+var boltcfg brainConfig
+
+// splitKey splits a memory key into bucket and key, e.g.
+// "memes:phrases" -> "memes", "phrases"
+func splitKey(k string) ([]byte, []byte) {
+	parts := strings.SplitN(k, ":", 2)
+	if len(parts) == 2 && len(parts[0]) > 0 && len(parts[1]) > 0 {
+		return []byte(parts[0]), []byte(parts[1])
+	}
+	return []byte(noNameSpace), []byte(k)
+}
+
+func (bb *boltBrain) Store(k string, b *[]byte) error {
+	bucket, key := splitKey(k)
+	err := bb.db.Update(func(tx *bolt.Tx) error {
+		mem, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return mem.Put(key, *b)
+	})
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return err
+	}
+	return nil
+}
+
+func (bb *boltBrain) CompareAndStore(k string, old, b *[]byte) (bool, error) {
+	bucket, key := splitKey(k)
+	stored := false
+	err := bb.db.Update(func(tx *bolt.Tx) error {
+		mem, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		current := mem.Get(key)
+		if old == nil && current != nil || old != nil && (current == nil || !bytes.Equal(*old, current)) {
+			return nil
+		}
+		stored = true
+		return mem.Put(key, *b)
+	})
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return false, err
+	}
+	return stored, nil
+}
+
+func (bb *boltBrain) Retrieve(k string) (*[]byte, bool, error) {
+	bucket, key := splitKey(k)
+	var datum []byte
+	exists := false
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		mem := tx.Bucket(bucket)
+		if mem == nil {
+			return nil
+		}
+		// values are only valid during the transaction
+		if v := mem.Get(key); v != nil {
+			datum = append([]byte{}, v...)
+			exists = true
+		}
+		return nil
+	})
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory '%s': %v", k, err))
+		return nil, false, err
+	}
+	if !exists {
+		// Memory doesn't exist yet
+		return nil, false, nil
+	}
+	return &datum, true, nil
+}
+
+func (bb *boltBrain) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := bb.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(bucket []byte, mem *bolt.Bucket) error {
+			ns := string(bucket)
+			return mem.ForEach(func(k, _ []byte) error {
+				key := string(k)
+				if ns != noNameSpace {
+					key = ns + ":" + key
+				}
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing memories: %v", err)
+	}
+	return keys, nil
+}
+
+func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
+	robot = r
+	robot.GetBrainConfig(&boltcfg)
+	path := boltcfg.BrainFile
+	if len(path) == 0 {
+		path = defaultBrainFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(robot.GetWorkSpace(), path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Creating directory for brain file '%s': %v", path, err))
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Opening brain file '%s' (is another robot using it?): %v", path, err))
+	}
+	robot.Log(bot.Info, fmt.Sprintf("Initialized bolt brain with database file: '%s'", path))
+	return &boltBrain{db}
+}
+
+func init() {
+	bot.RegisterSimpleBrain("bolt", provider)
+}
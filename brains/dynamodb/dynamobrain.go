@@ -1,122 +1,224 @@
 // Package dynamoBrain is a simple AWS DynamoDB implementation of the bot.SimpleBrain
 // interface, which gives the robot a place to permanently store it's memories.
+// Memories are kept in a single table, keyed either on the full memory key
+// ("Memory"), or on namespace and key ("NameSpace" and "Key"), whichever
+// primary key the table was created with. With KeyTTL, memories are stored
+// with an expiry in the table's TTL attribute.
 package dynamoBrain
 
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/lnxjedi/gopherbot/bot"
 )
 
 var robot bot.Handler
 var svc *dynamodb.DynamoDB
 
+// DynamoDB's limit on the size of an item, including attribute names
+const maxItemSize = 400 * 1024
+
+const defaultTTLAttribute = "Expires"
+
+// prefix of the robot's own memories, e.g. the encryption key; these never
+// expire unless "bot" is listed in TTLNameSpaces
+const botKeyPrefix = "bot:"
+
+// NameSpace for keys that don't split into a namespace and key; key
+// attributes can't be empty, and a split namespace never contains ':'
+const noNameSpace = ":"
+
 type brainConfig struct {
-	TableName, Region, AccessKeyID, SecretAccessKey string
+	TableName, Region string
+	// Leave blank to use the default credential chain, e.g. an IAM role
+	AccessKeyID, SecretAccessKey string
+	KeyTTL                       string   // optional expiry for memories, e.g. "168h"
+	TTLNameSpaces                []string // namespaces KeyTTL applies to; default all task namespaces
+	TTLAttribute                 string   // the table's TTL attribute, default "Expires"
 }
 
-type dynaMemory struct {
-	Memory  string
-	Content []byte
+type dynamoBrain struct {
+	composite bool // primary key is NameSpace + Key, rather than Memory
+	ttl       time.Duration
+	ttlAttr   string
 }
 
 var dynamocfg brainConfig
 
-func (db *brainConfig) Store(k string, b *[]byte) error {
-	input := &dynamodb.PutItemInput{
-		Item: map[string]*dynamodb.AttributeValue{
-			"Memory": {
-				S: aws.String(k),
-			},
-			"Content": {
-				B: *b,
-			},
-		},
-		TableName: aws.String(dynamocfg.TableName),
+// itemKey returns the primary key attributes for a memory
+func (db *dynamoBrain) itemKey(k string) map[string]*dynamodb.AttributeValue {
+	if !db.composite {
+		return map[string]*dynamodb.AttributeValue{
+			"Memory": {S: aws.String(k)},
+		}
+	}
+	ns, key := noNameSpace, k
+	if parts := strings.SplitN(k, ":", 2); len(parts) == 2 && len(parts[0]) > 0 && len(parts[1]) > 0 {
+		ns, key = parts[0], parts[1]
 	}
+	return map[string]*dynamodb.AttributeValue{
+		"NameSpace": {S: aws.String(ns)},
+		"Key":       {S: aws.String(key)},
+	}
+}
 
-	_, err := svc.PutItem(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v, %v", dynamodb.ErrCodeConditionalCheckFailedException, aerr.Error()))
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v, %v", dynamodb.ErrCodeProvisionedThroughputExceededException, aerr.Error()))
-			case dynamodb.ErrCodeResourceNotFoundException:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v, %v", dynamodb.ErrCodeResourceNotFoundException, aerr.Error()))
-			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v, %v", dynamodb.ErrCodeItemCollectionSizeLimitExceededException, aerr.Error()))
-			case dynamodb.ErrCodeInternalServerError:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v, %v", dynamodb.ErrCodeInternalServerError, aerr.Error()))
-			default:
-				robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v", aerr.Error()))
-			}
-			return aerr
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v", err.Error()))
-			return err
+// expires reports whether KeyTTL applies to a key
+func (db *dynamoBrain) expires(k string) bool {
+	if db.ttl == 0 {
+		return false
+	}
+	if len(dynamocfg.TTLNameSpaces) == 0 {
+		return !strings.HasPrefix(k, botKeyPrefix)
+	}
+	for _, ns := range dynamocfg.TTLNameSpaces {
+		if strings.HasPrefix(k, ns+":") {
+			return true
 		}
-		return err
 	}
+	return false
+}
 
-	return nil
+// itemSize approximates DynamoDB's size of an item: the lengths of
+// attribute names and values, with numbers at up to 21 bytes.
+func itemSize(item map[string]*dynamodb.AttributeValue) int {
+	size := 0
+	for name, v := range item {
+		size += len(name)
+		switch {
+		case v.S != nil:
+			size += len(*v.S)
+		case v.N != nil:
+			size += 21
+		default:
+			size += len(v.B)
+		}
+	}
+	return size
 }
 
-func (db *brainConfig) Retrieve(k string) (datum *[]byte, exists bool, err error) {
-	consistent := true
-	result, err := svc.GetItem(&dynamodb.GetItemInput{
+// logError logs an error from the DynamoDB API, with the error code when
+// there is one.
+func logError(op string, err error) {
+	if aerr, ok := err.(awserr.Error); ok {
+		robot.Log(bot.Error, fmt.Sprintf("Error %s: %v, %v", op, aerr.Code(), aerr.Message()))
+		return
+	}
+	robot.Log(bot.Error, fmt.Sprintf("Error %s: %v", op, err))
+}
+
+// item returns the item for storing a memory
+func (db *dynamoBrain) item(k string, b *[]byte) (map[string]*dynamodb.AttributeValue, error) {
+	item := db.itemKey(k)
+	item["Content"] = &dynamodb.AttributeValue{B: *b}
+	if db.expires(k) {
+		expires := time.Now().Add(db.ttl).Unix()
+		item[db.ttlAttr] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires, 10))}
+	}
+	// Checked here, rather than by DynamoDB, to give a clear error
+	if size := itemSize(item); size > maxItemSize {
+		err := fmt.Errorf("memory '%s' is %d bytes, larger than DynamoDB's limit of %d bytes for an item", k, size, maxItemSize)
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory: %v", err))
+		return nil, err
+	}
+	return item, nil
+}
+
+func (db *dynamoBrain) Store(k string, b *[]byte) error {
+	item, err := db.item(k, b)
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      item,
 		TableName: aws.String(dynamocfg.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"Memory": {
-				S: aws.String(k),
-			},
-		},
-		ConsistentRead: &consistent,
 	})
+	if err != nil {
+		logError(fmt.Sprintf("storing memory '%s'", k), err)
+		return err
+	}
+	return nil
+}
 
+// CompareAndStore stores with a conditional put, which fails if the Content
+// changed; a memory that expired but wasn't deleted yet doesn't exist.
+func (db *dynamoBrain) CompareAndStore(k string, old, b *[]byte) (bool, error) {
+	item, err := db.item(k, b)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory: %v, %v", dynamodb.ErrCodeProvisionedThroughputExceededException, aerr.Error()))
-			case dynamodb.ErrCodeResourceNotFoundException:
-				robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory: %v, %v", dynamodb.ErrCodeResourceNotFoundException, aerr.Error()))
-			case dynamodb.ErrCodeInternalServerError:
-				robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory: %v, %v", dynamodb.ErrCodeInternalServerError, aerr.Error()))
-			default:
-				robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory: %v", aerr.Error()))
-			}
-			return nil, false, aerr
-		} else {
-			robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory: %v", err.Error()))
-			return nil, false, err
+		return false, err
+	}
+	input := &dynamodb.PutItemInput{
+		Item:                     item,
+		TableName:                aws.String(dynamocfg.TableName),
+		ExpressionAttributeNames: map[string]*string{"#content": aws.String("Content")},
+	}
+	if old == nil {
+		input.ConditionExpression = aws.String("attribute_not_exists(#content) OR #expires <= :now")
+		input.ExpressionAttributeNames["#expires"] = aws.String(db.ttlAttr)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		}
+	} else {
+		input.ConditionExpression = aws.String("#content = :old")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":old": {B: *old},
 		}
 	}
+	_, err = svc.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		logError(fmt.Sprintf("storing memory '%s'", k), err)
+		return false, err
+	}
+	return true, nil
+}
 
-	m := dynaMemory{}
-
-	err = dynamodbattribute.UnmarshalMap(result.Item, &m)
-
+func (db *dynamoBrain) Retrieve(k string) (datum *[]byte, exists bool, err error) {
+	consistent := true
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(dynamocfg.TableName),
+		Key:            db.itemKey(k),
+		ConsistentRead: &consistent,
+	})
 	if err != nil {
-		robot.Log(bot.Error, fmt.Sprintf("Failed to unmarshal Record, %v", err))
+		logError(fmt.Sprintf("retrieving memory '%s'", k), err)
 		return nil, false, err
 	}
-
-	if m.Memory == "" {
+	if len(result.Item) == 0 {
 		return nil, false, nil
 	}
+	// DynamoDB deletes expired items lazily, up to a couple of days late
+	if exp, ok := result.Item[db.ttlAttr]; ok && exp.N != nil {
+		if expires, err := strconv.ParseInt(*exp.N, 10, 64); err == nil && expires <= time.Now().Unix() {
+			return nil, false, nil
+		}
+	}
+	content := []byte{}
+	if c, ok := result.Item["Content"]; ok && c.B != nil {
+		content = c.B
+	}
+	return &content, true, nil
+}
 
-	return &m.Content, true, nil
+// hasKey reports whether the table's primary key has the attribute as the
+// given key type.
+func hasKey(schema []*dynamodb.KeySchemaElement, attr, keyType string) bool {
+	for _, k := range schema {
+		if aws.StringValue(k.AttributeName) == attr && aws.StringValue(k.KeyType) == keyType {
+			return true
+		}
+	}
+	return false
 }
 
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
@@ -126,7 +228,7 @@ func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	var err error
 	AccessKeyID := dynamocfg.AccessKeyID
 	SecretAccessKey := dynamocfg.SecretAccessKey
-	// ec2 provided credentials
+	// credentials from the environment, shared config, or an IAM role
 	if len(AccessKeyID) == 0 {
 		sess, err = session.NewSession(&aws.Config{
 			Region: aws.String(dynamocfg.Region),
@@ -143,28 +245,49 @@ func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 			robot.Log(bot.Fatal, fmt.Sprintf("Unable to establish AWS session: %v", err))
 		}
 	}
+	db := &dynamoBrain{ttlAttr: dynamocfg.TTLAttribute}
+	if len(db.ttlAttr) == 0 {
+		db.ttlAttr = defaultTTLAttribute
+	}
+	if len(dynamocfg.KeyTTL) > 0 {
+		if db.ttl, err = time.ParseDuration(dynamocfg.KeyTTL); err != nil || db.ttl < time.Second {
+			robot.Log(bot.Fatal, fmt.Sprintf("Invalid KeyTTL '%s' in BrainConfig, should be e.g. '168h'", dynamocfg.KeyTTL))
+		}
+	}
 	// Create DynamoDB client
 	svc = dynamodb.New(sess)
-	input := &dynamodb.DescribeTableInput{
+	table, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
 		TableName: aws.String(dynamocfg.TableName),
-	}
-	_, err = svc.DescribeTable(input)
+	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeResourceNotFoundException:
-				robot.Log(bot.Fatal, fmt.Sprintf("Error describing table '%s': %v, %v", dynamocfg.TableName, dynamodb.ErrCodeResourceNotFoundException, aerr.Error()))
-			case dynamodb.ErrCodeInternalServerError:
-				robot.Log(bot.Fatal, fmt.Sprintf("Error describing table '%s': %v, %v", dynamocfg.TableName, dynamodb.ErrCodeInternalServerError, aerr.Error()))
-			default:
-				robot.Log(bot.Fatal, fmt.Sprintf("Error describing table '%s': %v", dynamocfg.TableName, aerr.Error()))
-			}
+			robot.Log(bot.Fatal, fmt.Sprintf("Error describing table '%s': %v, %v", dynamocfg.TableName, aerr.Code(), aerr.Message()))
 		} else {
 			robot.Log(bot.Fatal, fmt.Sprintf("Error describing table '%s': %v", dynamocfg.TableName, err.Error()))
 		}
 	}
-
-	return &dynamocfg
+	schema := table.Table.KeySchema
+	switch {
+	case hasKey(schema, "NameSpace", dynamodb.KeyTypeHash) && hasKey(schema, "Key", dynamodb.KeyTypeRange):
+		db.composite = true
+	case hasKey(schema, "Memory", dynamodb.KeyTypeHash):
+	default:
+		robot.Log(bot.Fatal, fmt.Sprintf("Table '%s' needs a primary key of 'NameSpace' and 'Key', or 'Memory'", dynamocfg.TableName))
+	}
+	if db.ttl > 0 {
+		ttl, err := svc.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+			TableName: aws.String(dynamocfg.TableName),
+		})
+		if err != nil {
+			robot.Log(bot.Warn, fmt.Sprintf("Unable to check Time to Live for table '%s': %v", dynamocfg.TableName, err))
+		} else if desc := ttl.TimeToLiveDescription; desc == nil ||
+			aws.StringValue(desc.TimeToLiveStatus) != dynamodb.TimeToLiveStatusEnabled ||
+			aws.StringValue(desc.AttributeName) != db.ttlAttr {
+			robot.Log(bot.Warn, fmt.Sprintf("Time to Live isn't enabled on attribute '%s' for table '%s'; expired memories will be ignored, but not deleted", db.ttlAttr, dynamocfg.TableName))
+		}
+	}
+	robot.Log(bot.Info, fmt.Sprintf("Initialized DynamoDB brain with table '%s' in region '%s', namespace keys: %t", dynamocfg.TableName, dynamocfg.Region, db.composite))
+	return db
 }
 
 func init() {
@@ -3,15 +3,24 @@
 package fileBrain
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/bot"
 )
 
+// suffix of the lock file held while comparing and storing a memory, so
+// robots sharing the brain directory don't update it at the same time
+const lockSuffix = ".lock"
+
+// how long to wait for another robot's lock
+const lockTimeout = 5 * time.Second
+
 var brainPath string
 var robot bot.Handler
 
@@ -48,6 +57,52 @@ func (fb *brainConfig) Retrieve(k string) (*[]byte, bool, error) {
 	return nil, false, nil
 }
 
+func (fb *brainConfig) CompareAndStore(k string, old, b *[]byte) (bool, error) {
+	k = strings.Replace(k, `/`, ":", -1)
+	k = strings.Replace(k, `\`, ":", -1)
+	lockPath := brainPath + "/" + k + lockSuffix
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		lf, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			lf.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return false, fmt.Errorf("Locking datum \"%s\": %v", k, err)
+		}
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("Timed out locking datum \"%s\"; remove \"%s\" if it's stale", k, lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+	datum, exists, err := fb.Retrieve(k)
+	if err != nil {
+		return false, err
+	}
+	if old == nil && exists || old != nil && (!exists || !bytes.Equal(*old, *datum)) {
+		return false, nil
+	}
+	return true, fb.Store(k, b)
+}
+
+// List lists the memory files; note that '/' and '\' in keys are stored as
+// ':', and listed that way.
+func (fb *brainConfig) List(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(brainPath)
+	if err != nil {
+		return nil, fmt.Errorf("Reading brain directory \"%s\": %v", brainPath, err)
+	}
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasPrefix(f.Name(), prefix) && !strings.HasSuffix(f.Name(), lockSuffix) {
+			keys = append(keys, f.Name())
+		}
+	}
+	return keys, nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	robot = r
@@ -0,0 +1,314 @@
+// Package postgresBrain is a PostgreSQL implementation of the bot.SimpleBrain
+// interface, which gives the robot a place to permanently store it's memories.
+// Memories are kept in a table keyed on namespace and key, with the datum in
+// a JSONB column so they can be queried and audited with normal SQL.
+package postgresBrain
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lnxjedi/gopherbot/bot"
+	// registers the "postgres" database/sql driver
+	_ "github.com/lib/pq"
+)
+
+var robot bot.Handler
+
+const defaultTable = "memories"
+const defaultConnections = 4
+
+// formats of the stored value; memories that aren't JSON (e.g. with
+// EncryptBrain) are stored as a base64 JSON string
+const (
+	formatJSON   = "json"
+	formatBase64 = "base64"
+)
+
+var tableRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type brainConfig struct {
+	ConnectionString string // e.g. postgres://gopherbot@dbhost/robot?sslmode=require
+	Password         string // optional, kept out of the ConnectionString
+	Table            string // default "memories"
+	MaxConnections   int    // connection pool size, default 4
+}
+
+type postgresBrain struct {
+	db      *sql.DB
+	table   string
+	created bool // whether the table has been checked / created
+	sync.Mutex
+}
+
+var pgcfg brainConfig
+
+// splitKey splits a memory key into namespace and key, e.g.
+// "memes:phrases" -> "memes", "phrases"
+func splitKey(k string) (string, string) {
+	parts := strings.SplitN(k, ":", 2)
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// ensureTable creates the memories table if needed, once per run
+func (pb *postgresBrain) ensureTable() error {
+	pb.Lock()
+	defer pb.Unlock()
+	if pb.created {
+		return nil
+	}
+	_, err := pb.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	namespace text NOT NULL,
+	key text NOT NULL,
+	value jsonb NOT NULL,
+	format text NOT NULL DEFAULT '%s',
+	updated timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (namespace, key)
+)`, pb.table, formatJSON))
+	if err != nil {
+		return fmt.Errorf("creating table '%s': %v", pb.table, err)
+	}
+	pb.created = true
+	return nil
+}
+
+// encodeValue returns the stored value and format of a memory
+func encodeValue(b []byte) ([]byte, string) {
+	if json.Valid(b) {
+		return b, formatJSON
+	}
+	value, _ := json.Marshal(base64.StdEncoding.EncodeToString(b))
+	return value, formatBase64
+}
+
+// decodeValue returns a memory from it's stored value and format
+func decodeValue(value, format string) ([]byte, error) {
+	datum := []byte(value)
+	if format == formatBase64 {
+		var encoded string
+		if err := json.Unmarshal(datum, &encoded); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	return datum, nil
+}
+
+func (pb *postgresBrain) Store(k string, b *[]byte) error {
+	if err := pb.ensureTable(); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return err
+	}
+	ns, key := splitKey(k)
+	value, format := encodeValue(*b)
+	// The upsert is done in a transaction, so concurrent writes to the same
+	// memory are applied one at a time.
+	tx, err := pb.db.Begin()
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s', starting transaction: %v", k, err))
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (namespace, key, value, format, updated)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (namespace, key) DO UPDATE
+SET value = EXCLUDED.value, format = EXCLUDED.format, updated = EXCLUDED.updated`, pb.table), ns, key, string(value), format)
+	if err != nil {
+		tx.Rollback()
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s', committing transaction: %v", k, err))
+		return err
+	}
+	return nil
+}
+
+func (pb *postgresBrain) Retrieve(k string) (*[]byte, bool, error) {
+	if err := pb.ensureTable(); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory '%s': %v", k, err))
+		return nil, false, err
+	}
+	ns, key := splitKey(k)
+	var value, format string
+	err := pb.db.QueryRow(fmt.Sprintf("SELECT value, format FROM %s WHERE namespace = $1 AND key = $2", pb.table), ns, key).Scan(&value, &format)
+	if err == sql.ErrNoRows {
+		// Memory doesn't exist yet
+		return nil, false, nil
+	}
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory '%s': %v", k, err))
+		return nil, false, err
+	}
+	datum, err := decodeValue(value, format)
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error decoding memory '%s': %v", k, err))
+		return nil, false, err
+	}
+	return &datum, true, nil
+}
+
+// CompareAndStore compares with the value as Retrieve returns it, since
+// jsonb doesn't keep the stored bytes; the row is locked until the update
+// commits.
+func (pb *postgresBrain) CompareAndStore(k string, old, b *[]byte) (bool, error) {
+	if err := pb.ensureTable(); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return false, err
+	}
+	ns, key := splitKey(k)
+	value, format := encodeValue(*b)
+	if old == nil {
+		res, err := pb.db.Exec(fmt.Sprintf(`INSERT INTO %s (namespace, key, value, format, updated)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (namespace, key) DO NOTHING`, pb.table), ns, key, string(value), format)
+		if err != nil {
+			robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+			return false, err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+			return false, err
+		}
+		return inserted == 1, nil
+	}
+	tx, err := pb.db.Begin()
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s', starting transaction: %v", k, err))
+		return false, err
+	}
+	var current, currentFormat string
+	err = tx.QueryRow(fmt.Sprintf("SELECT value, format FROM %s WHERE namespace = $1 AND key = $2 FOR UPDATE", pb.table), ns, key).Scan(&current, &currentFormat)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return false, err
+	}
+	datum, err := decodeValue(current, currentFormat)
+	if err != nil {
+		tx.Rollback()
+		robot.Log(bot.Error, fmt.Sprintf("Error decoding memory '%s': %v", k, err))
+		return false, err
+	}
+	if !bytes.Equal(datum, *old) {
+		tx.Rollback()
+		return false, nil
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET value = $3, format = $4, updated = now() WHERE namespace = $1 AND key = $2", pb.table), ns, key, string(value), format)
+	if err != nil {
+		tx.Rollback()
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s', committing transaction: %v", k, err))
+		return false, err
+	}
+	return true, nil
+}
+
+func (pb *postgresBrain) List(prefix string) ([]string, error) {
+	if err := pb.ensureTable(); err != nil {
+		return nil, err
+	}
+	rows, err := pb.db.Query(fmt.Sprintf(`SELECT full_key FROM (
+	SELECT CASE WHEN namespace = '' THEN key ELSE namespace || ':' || key END AS full_key FROM %s
+) AS keys WHERE left(full_key, length($1)) = $1`, pb.table), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing memories: %v", err)
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("listing memories: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// connectionString adds the configured Password to either form of
+// connection string.
+func connectionString(cs, password string) (string, error) {
+	if len(password) == 0 {
+		return cs, nil
+	}
+	if strings.Contains(cs, "://") {
+		u, err := url.Parse(cs)
+		if err != nil {
+			return "", err
+		}
+		user := ""
+		if u.User != nil {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, password)
+		return u.String(), nil
+	}
+	quoted := strings.Replace(strings.Replace(password, `\`, `\\`, -1), `'`, `\'`, -1)
+	return fmt.Sprintf("%s password='%s'", cs, quoted), nil
+}
+
+func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
+	robot = r
+	robot.GetBrainConfig(&pgcfg)
+	if len(pgcfg.ConnectionString) == 0 {
+		robot.Log(bot.Fatal, "BrainConfig missing value for ConnectionString required by 'postgres' brain")
+	}
+	table := pgcfg.Table
+	if len(table) == 0 {
+		table = defaultTable
+	}
+	if !tableRe.MatchString(table) {
+		robot.Log(bot.Fatal, fmt.Sprintf("Invalid Table '%s' in BrainConfig for 'postgres' brain", table))
+	}
+	cs, err := connectionString(pgcfg.ConnectionString, pgcfg.Password)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Invalid ConnectionString in BrainConfig for 'postgres' brain: %v", err))
+	}
+	db, err := sql.Open("postgres", cs)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Invalid ConnectionString in BrainConfig for 'postgres' brain: %v", err))
+	}
+	connections := pgcfg.MaxConnections
+	if connections <= 0 {
+		connections = defaultConnections
+	}
+	db.SetMaxOpenConns(connections)
+	db.SetMaxIdleConns(connections)
+	pb := &postgresBrain{
+		db:    db,
+		table: table,
+	}
+	if err := pb.ensureTable(); err != nil {
+		// The robot can still start; memory operations fail (or, with
+		// BrainFallback, are served from memory) until the database is
+		// reachable, and the table is created then.
+		robot.Log(bot.Error, fmt.Sprintf("PostgreSQL brain unavailable at start-up, will keep trying: %v", err))
+	} else {
+		robot.Log(bot.Info, fmt.Sprintf("Initialized postgres brain with table '%s', %d connections", table, connections))
+	}
+	return pb
+}
+
+func init() {
+	bot.RegisterSimpleBrain("postgres", provider)
+}
@@ -0,0 +1,284 @@
+// Package redisBrain is a Redis implementation of the bot.SimpleBrain
+// interface, which gives the robot a place to permanently store it's memories.
+// It speaks the Redis protocol directly, so it needs no client library.
+package redisBrain
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+var robot bot.Handler
+
+const defaultKeyPrefix = "gopherbot:"
+const defaultPort = "6379"
+
+// timeout for connecting and for each command
+const opTimeout = 10 * time.Second
+
+// prefix of the robot's own memories, e.g. the encryption key; these never
+// expire unless "bot" is listed in TTLNameSpaces
+const botKeyPrefix = "bot:"
+
+// compareAndSet stores a memory only if it still holds the expected value,
+// run atomically by the server; ARGV: whether the memory should exist, the
+// expected value, the new value, and the expiry in seconds or "".
+const compareAndSet = `local current = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+	if current ~= ARGV[2] then return 0 end
+elseif current then
+	return 0
+end
+if ARGV[4] ~= '' then
+	redis.call('SET', KEYS[1], ARGV[3], 'EX', ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[3])
+end
+return 1`
+
+type brainConfig struct {
+	URL           string   // e.g. redis://localhost:6379/0, or rediss:// for TLS
+	Password      string   // optional, overrides a password in the URL
+	KeyPrefix     string   // prepended to every key, default "gopherbot:"
+	KeyTTL        string   // optional expiry for memories, e.g. "168h"
+	TTLNameSpaces []string // namespaces KeyTTL applies to; default all task namespaces
+}
+
+type redisBrain struct {
+	address  string
+	useTLS   bool
+	password string
+	db       int
+	prefix   string
+	ttl      time.Duration
+	conn     net.Conn
+	rw       *bufio.ReadWriter
+	sync.Mutex
+}
+
+var rediscfg brainConfig
+
+// redisError is an error reply from the server; the connection is still
+// usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// expires reports whether KeyTTL applies to a key
+func (rb *redisBrain) expires(k string) bool {
+	if rb.ttl == 0 {
+		return false
+	}
+	if len(rediscfg.TTLNameSpaces) == 0 {
+		return !strings.HasPrefix(k, botKeyPrefix)
+	}
+	for _, ns := range rediscfg.TTLNameSpaces {
+		if strings.HasPrefix(k, ns+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// connect dials the server and authenticates; called with the lock held.
+func (rb *redisBrain) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: opTimeout}
+	if rb.useTLS {
+		host, _, _ := net.SplitHostPort(rb.address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", rb.address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", rb.address)
+	}
+	if err != nil {
+		return err
+	}
+	rb.conn = conn
+	rb.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if len(rb.password) > 0 {
+		if _, _, err := rb.do("AUTH", []byte(rb.password)); err != nil {
+			rb.disconnect()
+			return fmt.Errorf("authenticating: %v", err)
+		}
+	}
+	if rb.db != 0 {
+		if _, _, err := rb.do("SELECT", []byte(strconv.Itoa(rb.db))); err != nil {
+			rb.disconnect()
+			return fmt.Errorf("selecting database %d: %v", rb.db, err)
+		}
+	}
+	return nil
+}
+
+// disconnect drops a failed connection, so the next command reconnects
+func (rb *redisBrain) disconnect() {
+	if rb.conn != nil {
+		rb.conn.Close()
+	}
+	rb.conn = nil
+	rb.rw = nil
+}
+
+// do sends a command and reads the reply, returning the reply and whether
+// it was a nil reply; called with the lock held and a connection.
+func (rb *redisBrain) do(cmd string, args ...[]byte) ([]byte, bool, error) {
+	rb.conn.SetDeadline(time.Now().Add(opTimeout))
+	fmt.Fprintf(rb.rw, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, arg := range args {
+		fmt.Fprintf(rb.rw, "$%d\r\n", len(arg))
+		rb.rw.Write(arg)
+		rb.rw.WriteString("\r\n")
+	}
+	if err := rb.rw.Flush(); err != nil {
+		return nil, false, err
+	}
+	line, err := rb.rw.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, false, fmt.Errorf("empty reply to %s", cmd)
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), false, nil
+	case '-':
+		return nil, false, redisError(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid bulk reply to %s: %s", cmd, line)
+		}
+		if size < 0 {
+			return nil, true, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rb.rw, buf); err != nil {
+			return nil, false, err
+		}
+		return buf[:size], false, nil
+	}
+	return nil, false, fmt.Errorf("unexpected reply to %s: %s", cmd, line)
+}
+
+// command runs a command, reconnecting first if needed; a connection error
+// drops the connection so the next command tries again.
+func (rb *redisBrain) command(cmd string, args ...[]byte) ([]byte, bool, error) {
+	rb.Lock()
+	defer rb.Unlock()
+	if rb.conn == nil {
+		if err := rb.connect(); err != nil {
+			return nil, false, fmt.Errorf("connecting to redis at '%s': %v", rb.address, err)
+		}
+	}
+	reply, isNil, err := rb.do(cmd, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		rb.disconnect()
+	}
+	return reply, isNil, err
+}
+
+func (rb *redisBrain) Store(k string, b *[]byte) error {
+	args := [][]byte{[]byte(rb.prefix + k), *b}
+	if rb.expires(k) {
+		args = append(args, []byte("EX"), []byte(strconv.Itoa(int(rb.ttl.Seconds()))))
+	}
+	if _, _, err := rb.command("SET", args...); err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return err
+	}
+	return nil
+}
+
+func (rb *redisBrain) Retrieve(k string) (*[]byte, bool, error) {
+	datum, isNil, err := rb.command("GET", []byte(rb.prefix+k))
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error retrieving memory '%s': %v", k, err))
+		return nil, false, err
+	}
+	if isNil {
+		// Memory doesn't exist yet, or expired
+		return nil, false, nil
+	}
+	return &datum, true, nil
+}
+
+func (rb *redisBrain) CompareAndStore(k string, old, b *[]byte) (bool, error) {
+	exists, expected, ex := []byte("0"), []byte{}, []byte{}
+	if old != nil {
+		exists, expected = []byte("1"), *old
+	}
+	if rb.expires(k) {
+		ex = []byte(strconv.Itoa(int(rb.ttl.Seconds())))
+	}
+	reply, _, err := rb.command("EVAL", []byte(compareAndSet), []byte("1"), []byte(rb.prefix+k), exists, expected, *b, ex)
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error storing memory '%s': %v", k, err))
+		return false, err
+	}
+	return string(reply) == "1", nil
+}
+
+func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
+	robot = r
+	robot.GetBrainConfig(&rediscfg)
+	if len(rediscfg.URL) == 0 {
+		rediscfg.URL = "redis://localhost:" + defaultPort
+	}
+	u, err := url.Parse(rediscfg.URL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+		robot.Log(bot.Fatal, fmt.Sprintf("Invalid redis URL '%s' in BrainConfig, should be e.g. 'redis://localhost:6379/0'", rediscfg.URL))
+	}
+	rb := &redisBrain{
+		address:  u.Host,
+		useTLS:   u.Scheme == "rediss",
+		password: rediscfg.Password,
+		prefix:   rediscfg.KeyPrefix,
+	}
+	if len(u.Port()) == 0 {
+		rb.address = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	if len(rb.password) == 0 && u.User != nil {
+		rb.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		if rb.db, err = strconv.Atoi(db); err != nil {
+			robot.Log(bot.Fatal, fmt.Sprintf("Invalid database '%s' in redis URL, should be a number", db))
+		}
+	}
+	if len(rb.prefix) == 0 {
+		rb.prefix = defaultKeyPrefix
+	}
+	if len(rediscfg.KeyTTL) > 0 {
+		if rb.ttl, err = time.ParseDuration(rediscfg.KeyTTL); err != nil || rb.ttl < time.Second {
+			robot.Log(bot.Fatal, fmt.Sprintf("Invalid KeyTTL '%s' in BrainConfig, should be e.g. '168h'", rediscfg.KeyTTL))
+		}
+	}
+	if _, _, err := rb.command("PING"); err != nil {
+		// The robot can still start; memory operations fail (or, with
+		// BrainFallback, are served from memory) until redis is reachable.
+		robot.Log(bot.Error, fmt.Sprintf("Redis brain unreachable at start-up, will keep trying: %v", err))
+	} else {
+		robot.Log(bot.Info, fmt.Sprintf("Initialized redis brain at '%s' with key prefix '%s'", rb.address, rb.prefix))
+	}
+	return rb
+}
+
+func init() {
+	bot.RegisterSimpleBrain("redis", provider)
+}
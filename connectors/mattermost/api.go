@@ -0,0 +1,258 @@
+package mattermost
+
+/* api.go has the types returned by the Mattermost API, the REST helpers,
+and the internal methods for maintaining the user and channel maps. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+const apiPath = "/api/v4"
+
+// usersPerPage is the page size when listing team members
+const usersPerPage = 200
+
+type mmUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	DeleteAt  int64  `json:"delete_at"`
+}
+
+type mmTeam struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type mmChannel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Type        string `json:"type"` // O(pen), P(rivate), D(irect), G(roup)
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type mmPost struct {
+	ID        string `json:"id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	Type      string `json:"type,omitempty"` // non-empty for system messages
+}
+
+// mmEvent is an event received on the WebSocket
+type mmEvent struct {
+	Event string                 `json:"event"`
+	Data  map[string]interface{} `json:"data"`
+	Seq   int64                  `json:"seq"`
+}
+
+// apiError is returned by the server for failed requests
+type apiError struct {
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code"`
+}
+
+// apiRequest makes a REST call, marshalling body if non-nil and
+// unmarshalling the response into result if non-nil.
+func (mc *mmConnector) apiRequest(method, path string, body, result interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, mc.serverURL+apiPath+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+mc.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := mc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var ae apiError
+		if json.Unmarshal(data, &ae) == nil && len(ae.Message) > 0 {
+			return fmt.Errorf("%s %s: %d - %s", method, path, resp.StatusCode, ae.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+func (mc *mmConnector) apiGet(path string, result interface{}) error {
+	return mc.apiRequest("GET", path, nil, result)
+}
+
+func (mc *mmConnector) apiPost(path string, body, result interface{}) error {
+	return mc.apiRequest("POST", path, body, result)
+}
+
+// updateUserList loads the members of the team
+func (mc *mmConnector) updateUserList() {
+	userIDs := make(map[string]string)
+	userInfo := make(map[string]mmUser)
+	for page := 0; ; page++ {
+		var users []mmUser
+		path := fmt.Sprintf("/users?in_team=%s&page=%d&per_page=%d", mc.teamID, page, usersPerPage)
+		if err := mc.apiGet(path, &users); err != nil {
+			mc.Log(bot.Error, fmt.Sprintf("Error loading mattermost users: %v", err))
+			return
+		}
+		for _, u := range users {
+			if u.DeleteAt != 0 {
+				continue
+			}
+			userIDs[u.Username] = u.ID
+			userInfo[u.ID] = u
+		}
+		if len(users) < usersPerPage {
+			break
+		}
+	}
+	mc.Lock()
+	mc.userIDs = userIDs
+	mc.userInfo = userInfo
+	mc.Unlock()
+	mc.Log(bot.Debug, fmt.Sprintf("Loaded %d mattermost users", len(userInfo)))
+}
+
+// updateChannelMaps loads the channels the robot is a member of
+func (mc *mmConnector) updateChannelMaps() {
+	var channels []mmChannel
+	if err := mc.apiGet(fmt.Sprintf("/users/me/teams/%s/channels", mc.teamID), &channels); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Error loading mattermost channels: %v", err))
+		return
+	}
+	channelIDs := make(map[string]string)
+	channelInfo := make(map[string]mmChannel)
+	dmChannels := make(map[string]string)
+	for _, ch := range channels {
+		channelInfo[ch.ID] = ch
+		switch ch.Type {
+		case "D":
+			// direct channel names are '<userid>__<userid>'
+			for _, uid := range strings.Split(ch.Name, "__") {
+				if uid != mc.botID {
+					dmChannels[uid] = ch.ID
+				}
+			}
+		case "G":
+			// group message channels have no usable name
+		default:
+			channelIDs[ch.Name] = ch.ID
+		}
+	}
+	mc.Lock()
+	mc.channelIDs = channelIDs
+	mc.channelInfo = channelInfo
+	mc.dmChannels = dmChannels
+	mc.Unlock()
+	mc.Log(bot.Debug, fmt.Sprintf("Loaded %d mattermost channels", len(channelInfo)))
+}
+
+// chanID returns the ID for a channel name
+func (mc *mmConnector) chanID(name string) (string, bool) {
+	mc.RLock()
+	id, ok := mc.channelIDs[name]
+	mc.RUnlock()
+	return id, ok
+}
+
+// getChannelInfo returns a channel by ID, looking it up if the robot was
+// added after the map was loaded
+func (mc *mmConnector) getChannelInfo(id string) (mmChannel, bool) {
+	mc.RLock()
+	ch, ok := mc.channelInfo[id]
+	mc.RUnlock()
+	if ok {
+		return ch, true
+	}
+	if err := mc.apiGet("/channels/"+id, &ch); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Error looking up mattermost channel '%s': %v", id, err))
+		return ch, false
+	}
+	mc.Lock()
+	mc.channelInfo[id] = ch
+	if ch.Type == "O" || ch.Type == "P" {
+		mc.channelIDs[ch.Name] = ch.ID
+	}
+	mc.Unlock()
+	return ch, true
+}
+
+// userID returns the ID for a username, falling back to the UserRoster
+func (mc *mmConnector) userID(name string) (string, bool) {
+	mc.RLock()
+	defer mc.RUnlock()
+	if id, ok := mc.userIDs[name]; ok {
+		return id, true
+	}
+	id, ok := mc.botUserMap[name]
+	return id, ok
+}
+
+// userName returns the username for a user ID
+func (mc *mmConnector) userName(id string) (string, bool) {
+	mc.RLock()
+	u, ok := mc.userInfo[id]
+	mc.RUnlock()
+	return u.Username, ok
+}
+
+// openDM returns the direct message channel for a user name or bracketed
+// ID, creating it if needed
+func (mc *mmConnector) openDM(u string) (string, bot.RetVal) {
+	var userID string
+	var ok bool
+	if userID, ok = bot.ExtractID(u); !ok {
+		userID, ok = mc.userID(u)
+	}
+	if !ok {
+		mc.Log(bot.Error, "User ID not found for:", u)
+		return "", bot.UserNotFound
+	}
+	mc.RLock()
+	chanID, ok := mc.dmChannels[userID]
+	mc.RUnlock()
+	if ok {
+		return chanID, bot.Ok
+	}
+	var ch mmChannel
+	if err := mc.apiPost("/channels/direct", []string{mc.botID, userID}, &ch); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Unable to open direct message channel with user '%s': %v", u, err))
+		return "", bot.FailedUserDM
+	}
+	mc.Lock()
+	mc.dmChannels[userID] = ch.ID
+	mc.channelInfo[ch.ID] = ch
+	mc.Unlock()
+	return ch.ID, bot.Ok
+}
@@ -0,0 +1,258 @@
+// Package mattermost implements the bot.Connector interface for Mattermost,
+// using the v4 REST API for sending and the WebSocket API for receiving.
+package mattermost
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type config struct {
+	ServerURL string // e.g. https://chat.example.com
+	BotToken  string // bot account or personal access token
+	Team      string // name of the team the robot operates in, e.g. 'devops'
+}
+
+// mmConnector holds all the relevant data about a connection
+type mmConnector struct {
+	serverURL    string               // base URL of the server, without a trailing /
+	token        string               // access token for the API
+	client       *http.Client         // for REST API calls
+	teamID       string               // internal ID of the configured team
+	botName      string               // username of the robot
+	botID        string               // internal ID of the robot's user
+	running      bool                 // set on call to Run
+	channelIDs   map[string]string    // channel name to channel ID
+	channelInfo  map[string]mmChannel // channel ID to channel
+	userIDs      map[string]string    // username to user ID
+	userInfo     map[string]mmUser    // user ID to user
+	botUserMap   map[string]string    // UserRoster username to user ID
+	dmChannels   map[string]string    // user ID to direct message channel ID
+	ws           *websocket.Conn      // current WebSocket connection
+	wsLock       sync.Mutex           // serializes WebSocket writes
+	seq          int64                // sequence number for WebSocket actions
+	bot.Handler                       // bot API for connectors
+	sync.RWMutex                      // shared mutex for locking connector data structures
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+func init() {
+	bot.RegisterConnector("mattermost", Initialize)
+}
+
+// Initialize looks up the robot's user and team, loads the user and channel
+// maps, and returns the connector object; the WebSocket is connected in Run.
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Errorf("Unable to retrieve protocol configuration: %v", err))
+	}
+	if len(c.ServerURL) == 0 {
+		robot.Log(bot.Fatal, "No ServerURL found in mattermost config")
+	}
+	if len(c.BotToken) == 0 {
+		robot.Log(bot.Fatal, "No BotToken found in mattermost config")
+	}
+	if len(c.Team) == 0 {
+		robot.Log(bot.Fatal, "No Team found in mattermost config")
+	}
+
+	mc := &mmConnector{
+		serverURL:   strings.TrimRight(c.ServerURL, "/"),
+		token:       c.BotToken,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		channelIDs:  make(map[string]string),
+		channelInfo: make(map[string]mmChannel),
+		userIDs:     make(map[string]string),
+		userInfo:    make(map[string]mmUser),
+		dmChannels:  make(map[string]string),
+	}
+	mc.Handler = robot
+
+	var me mmUser
+	if err := mc.apiGet("/users/me", &me); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Unable to look up the robot's user, check ServerURL and BotToken: %v", err))
+	}
+	mc.botName = me.Username
+	mc.botID = me.ID
+	mc.SetID(mc.botID)
+	mc.Log(bot.Info, "Mattermost setting bot internal ID to", mc.botID)
+
+	var team mmTeam
+	if err := mc.apiGet("/teams/name/"+c.Team, &team); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Unable to look up team '%s': %v", c.Team, err))
+	}
+	mc.teamID = team.ID
+	mc.Log(bot.Info, "Set team ID to", mc.teamID)
+
+	mc.updateUserList()
+	mc.updateChannelMaps()
+
+	return bot.Connector(mc)
+}
+
+// Minimum and maximum time to wait before reconnecting a dropped WebSocket
+const minReconnect = time.Second
+const maxReconnect = time.Minute
+
+// pingInterval is how often the robot pings the server, to detect
+// connections that have silently died
+const pingInterval = 30 * time.Second
+
+// pongWait is how long the connection can go without a pong or other
+// message from the server before it's considered dead
+const pongWait = 2 * pingInterval
+
+// Run connects the WebSocket and delivers messages to the robot until stop
+// is closed, reconnecting with backoff whenever the connection drops.
+func (mc *mmConnector) Run(stop <-chan struct{}) {
+	mc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if mc.running {
+		mc.Unlock()
+		return
+	}
+	mc.running = true
+	mc.Unlock()
+
+	wait := minReconnect
+	connected := false
+	for {
+		ws, err := mc.connect()
+		if err != nil {
+			mc.Log(bot.Error, fmt.Sprintf("Connecting to the mattermost WebSocket, retrying in %v: %v", wait, err))
+			select {
+			case <-stop:
+				mc.Log(bot.Debug, "Received stop in connector")
+				return
+			case <-time.After(wait):
+			}
+			wait *= 2
+			if wait > maxReconnect {
+				wait = maxReconnect
+			}
+			continue
+		}
+		wait = minReconnect
+		if connected {
+			// Users and channels may have changed while disconnected
+			mc.updateUserList()
+			mc.updateChannelMaps()
+		}
+		connected = true
+		mc.Log(bot.Info, "Connected to the mattermost WebSocket")
+		if mc.listen(ws, stop) {
+			return
+		}
+	}
+}
+
+// connect opens a new WebSocket connection
+func (mc *mmConnector) connect() (*websocket.Conn, error) {
+	wsURL := "ws" + strings.TrimPrefix(mc.serverURL, "http") + apiPath + "/websocket"
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+mc.token)
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		return nil, err
+	}
+	mc.wsLock.Lock()
+	mc.ws = ws
+	mc.wsLock.Unlock()
+	return ws, nil
+}
+
+// listen handles events from a connection until it drops, returning false,
+// or stop is closed, returning true.
+func (mc *mmConnector) listen(ws *websocket.Conn, stop <-chan struct{}) bool {
+	events := make(chan *mmEvent)
+	dropped := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	// Without a read deadline, ReadJSON blocks forever on a half-open
+	// connection; each pong or event pushes it back.
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go func() {
+		for {
+			var ev mmEvent
+			err := ws.ReadJSON(&ev)
+			if err == nil {
+				err = ws.SetReadDeadline(time.Now().Add(pongWait))
+			}
+			if err != nil {
+				dropped <- err
+				return
+			}
+			select {
+			case events <- &ev:
+			case <-done:
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-stop:
+			mc.Log(bot.Debug, "Received stop in connector")
+			mc.wsLock.Lock()
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			mc.wsLock.Unlock()
+			ws.Close()
+			return true
+		case err := <-dropped:
+			mc.Log(bot.Warn, fmt.Sprintf("Mattermost WebSocket connection dropped, reconnecting: %v", err))
+			ws.Close()
+			return false
+		case <-ping.C:
+			mc.wsLock.Lock()
+			err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			mc.wsLock.Unlock()
+			if err != nil {
+				mc.Log(bot.Warn, fmt.Sprintf("Mattermost WebSocket ping failed, reconnecting: %v", err))
+				ws.Close()
+				return false
+			}
+		case ev := <-events:
+			mc.Log(bot.Trace, fmt.Sprintf("Event Received (event, data): %s; %v", ev.Event, ev.Data))
+			switch ev.Event {
+			case "hello":
+				// Ignore hello
+			case "posted":
+				// Message processing is done concurrently
+				go mc.processPost(ev)
+			case "channel_created", "channel_updated", "channel_deleted",
+				"channel_converted", "direct_added", "group_added",
+				"user_added", "user_removed":
+				mc.updateChannelMaps()
+			case "new_user", "user_updated":
+				mc.updateUserList()
+			default:
+				// Ignore other events
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package mattermost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// Capabilities declares the optional features of the mattermost connector
+func (mc *mmConnector) Capabilities() []bot.ConnectorCapability {
+	return []bot.ConnectorCapability{
+		bot.CapJoinChannel,
+		bot.CapTypingIndicator,
+		bot.CapThreads,
+	}
+}
+
+// RenderMarkup leaves the robot's neutral markup as-is, since Mattermost
+// renders markdown
+func (mc *mmConnector) RenderMarkup(text string) string {
+	return text
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if mattermost
+// doesn't have that information
+func (mc *mmConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	var userID string
+	var ok bool
+	var user mmUser
+	if userID, ok = bot.ExtractID(u); !ok {
+		userID, ok = mc.userID(u)
+	}
+	if ok {
+		mc.RLock()
+		user, ok = mc.userInfo[userID]
+		mc.RUnlock()
+	}
+	if !ok {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, bot.Ok
+	case "internalid":
+		return user.ID, bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName), bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	// that's all the attributes we can currently get from mattermost
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notifier letting the user know the message
+// has been heard by the robot.
+func (mc *mmConnector) MessageHeard(user, channel string) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(channel); !ok {
+		return
+	}
+	mc.wsLock.Lock()
+	defer mc.wsLock.Unlock()
+	if mc.ws == nil {
+		return
+	}
+	mc.seq++
+	action := map[string]interface{}{
+		"action": "user_typing",
+		"seq":    mc.seq,
+		"data":   map[string]string{"channel_id": chanID},
+	}
+	if err := mc.ws.WriteJSON(action); err != nil {
+		mc.Log(bot.Debug, fmt.Sprintf("Failed sending typing notifier: %v", err))
+	}
+}
+
+// SetUserMap takes a map of username to userID mappings, built from the UserRoster
+// of gopherbot.yaml
+func (mc *mmConnector) SetUserMap(umap map[string]string) {
+	mc.Lock()
+	mc.botUserMap = umap
+	mc.Unlock()
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (mc *mmConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolChannelMessageOpts(ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolChannelMessageOpts sends a message to a channel with options
+func (mc *mmConnector) SendProtocolChannelMessageOpts(ch string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = mc.chanID(ch)
+	}
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	mc.sendMessages(mc.formatMessage("", msg, f), chanID, opts)
+	return
+}
+
+// SendProtocolUserChannelMessage directs a message to a user in a channel
+func (mc *mmConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolUserChannelMessageOpts(uid, u, ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserChannelMessageOpts directs a message to a user in a
+// channel with options
+func (mc *mmConnector) SendProtocolUserChannelMessageOpts(uid, u, ch, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	var chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = mc.chanID(ch)
+	}
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	userName := u
+	if userID, ok := bot.ExtractID(uid); ok {
+		if name, ok := mc.userName(userID); ok {
+			userName = name
+		}
+	}
+	if len(userName) == 0 {
+		mc.Log(bot.Error, "User name not found for:", uid)
+		return bot.UserNotFound
+	}
+	prefix := "@" + userName + ": "
+	mc.sendMessages(mc.formatMessage(prefix, msg, f), chanID, opts)
+	return
+}
+
+// SendProtocolUserMessage sends a direct message to a user
+func (mc *mmConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolUserMessageOpts(u, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserMessageOpts sends a direct message to a user with options
+func (mc *mmConnector) SendProtocolUserMessageOpts(u string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	chanID, ret := mc.openDM(u)
+	if ret != bot.Ok {
+		return
+	}
+	if !mc.sendMessages(mc.formatMessage("", msg, f), chanID, opts) {
+		return bot.FailedUserDM
+	}
+	return bot.Ok
+}
+
+// JoinChannel joins a channel given it's human-readable name, e.g. "general"
+func (mc *mmConnector) JoinChannel(c string) (ret bot.RetVal) {
+	if _, ok := mc.chanID(c); ok {
+		return bot.Ok
+	}
+	var ch mmChannel
+	if err := mc.apiGet(fmt.Sprintf("/teams/%s/channels/name/%s", mc.teamID, c), &ch); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Channel ID not found for '%s': %v", c, err))
+		return bot.ChannelNotFound
+	}
+	member := map[string]string{"user_id": mc.botID}
+	if err := mc.apiPost(fmt.Sprintf("/channels/%s/members", ch.ID), member, nil); err != nil {
+		mc.Log(bot.Error, "Failed to join channel", c, ":", err, "(try inviting the bot)")
+		return bot.FailedChannelJoin
+	}
+	mc.Lock()
+	mc.channelIDs[ch.Name] = ch.ID
+	mc.channelInfo[ch.ID] = ch
+	mc.Unlock()
+	return bot.Ok
+}
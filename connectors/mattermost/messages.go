@@ -0,0 +1,148 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// maxMessageLength is a little under the server's maximum post size, leaving
+// room for code block markers
+const maxMessageLength = 16000
+
+// maxMessageSplit is the maximum number of posts a long message is split into
+const maxMessageSplit = 4
+
+// markdownEscaper escapes characters with special meaning in Mattermost
+// markdown, for Variable format messages
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"#", `\#`,
+	">", `\>`,
+	"|", `\|`,
+	"[", `\[`,
+	"]", `\]`,
+)
+
+func optQuote(msg string, f bot.MessageFormat) string {
+	if f == bot.Fixed {
+		return "```\n" + msg + "\n```"
+	}
+	return msg
+}
+
+// formatMessage handles formatting for the message format, and splits long
+// messages into posts. Mentions don't need to be converted, since
+// Mattermost uses @username.
+func (mc *mmConnector) formatMessage(prefix, msg string, f bot.MessageFormat) []string {
+	if f == bot.Variable {
+		msg = markdownEscaper.Replace(msg)
+	}
+	msg = prefix + msg
+	if len(msg) <= maxMessageLength {
+		return []string{optQuote(msg, f)}
+	}
+	mc.Log(bot.Info, fmt.Sprintf("Message too long, segmenting: %d bytes", len(msg)))
+	msgs := make([]string, 0, maxMessageSplit+1)
+	for len(msg) > maxMessageLength && len(msgs) < maxMessageSplit {
+		lineEnd := strings.LastIndexByte(msg[:maxMessageLength], '\n')
+		if lineEnd == -1 { // no newline in this chunk
+			msgs = append(msgs, optQuote(msg[:maxMessageLength], f))
+			msg = msg[maxMessageLength:]
+		} else {
+			msgs = append(msgs, optQuote(msg[:lineEnd], f))
+			msg = msg[lineEnd+1:] // skip over the newline
+		}
+	}
+	if len(msgs) == maxMessageSplit {
+		if len(msg) > 0 {
+			msgs = append(msgs, "(message too long, truncated)")
+		}
+	} else {
+		msgs = append(msgs, optQuote(msg, f))
+	}
+	return msgs
+}
+
+// sendMessages posts messages to a channel ID, returning false if a post
+// failed; as with slack, there's no RetVal for a failed channel message, so
+// failures are logged.
+func (mc *mmConnector) sendMessages(msgs []string, chanID string, opts bot.MessageOptions) bool {
+	for _, msg := range msgs {
+		post := mmPost{
+			ChannelID: chanID,
+			Message:   msg,
+			RootID:    opts.Thread,
+		}
+		if err := mc.apiPost("/posts", post, nil); err != nil {
+			mc.Log(bot.Error, fmt.Sprintf("Failed sending message to channel '%s': %v", chanID, err))
+			return false
+		}
+	}
+	return true
+}
+
+// processPost examines a 'posted' event and routes it to the robot.
+func (mc *mmConnector) processPost(ev *mmEvent) {
+	postJSON, ok := ev.Data["post"].(string)
+	if !ok {
+		mc.Log(bot.Debug, "Ignoring 'posted' event with no post")
+		return
+	}
+	var post mmPost
+	if err := json.Unmarshal([]byte(postJSON), &post); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Unable to unmarshal mattermost post: %v", err))
+		return
+	}
+	mc.Log(bot.Trace, fmt.Sprintf("Message received: %v", post))
+	if len(post.Type) > 0 {
+		mc.Log(bot.Debug, fmt.Sprintf("Ignoring system message of type '%s'", post.Type))
+		return
+	}
+	if len(post.UserID) == 0 {
+		mc.Log(bot.Debug, "Zero-length userID, ignoring message")
+		return
+	}
+	if post.UserID == mc.botID {
+		mc.Log(bot.Debug, "Ignoring message from self")
+		return
+	}
+	ci, ok := mc.getChannelInfo(post.ChannelID)
+	if !ok {
+		mc.Log(bot.Error, "Couldn't find channel info for channel ID", post.ChannelID)
+		return
+	}
+	direct := ci.Type == "D"
+	if direct {
+		mc.Lock()
+		mc.dmChannels[post.UserID] = post.ChannelID
+		mc.Unlock()
+	}
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Mattermost",
+		UserID:        post.UserID,
+		ChannelID:     post.ChannelID,
+		DirectMessage: direct,
+		MessageText:   post.Message,
+		ThreadID:      post.RootID,
+		MessageID:     post.ID,
+		MessageObject: &post,
+		Client:        mc.client,
+	}
+	userName, ok := mc.userName(post.UserID)
+	if !ok {
+		mc.Log(bot.Debug, "Couldn't find user name for user ID", post.UserID)
+	} else {
+		botMsg.UserName = userName
+	}
+	if !direct {
+		botMsg.ChannelName = ci.Name
+	}
+	mc.IncomingMessage(botMsg)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/bot"
 	"github.com/nlopes/slack"
@@ -16,8 +17,13 @@ type botDefinition struct {
 }
 
 type config struct {
-	SlackToken      string // the 'bot token for connecting to Slack
-	MaxMessageSplit int    // the maximum # of ~4000 byte messages to split a large message into
+	SlackToken        string // the 'bot token for connecting to Slack
+	MaxMessageSplit   int    // the maximum # of ~4000 byte messages to split a large message into
+	InteractionListen string // address for the app's interactivity Request URL, e.g. ":3000"; button clicks are ignored when unset
+	SigningSecret     string // the app's signing secret, for verifying interaction requests
+	// MaxReconnectInterval caps the backoff between reconnect attempts when
+	// the connection drops, e.g. "2m"; default 5m
+	MaxReconnectInterval string
 }
 
 var lock sync.Mutex // package var lock
@@ -63,22 +69,33 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		slackOpts = append(slackOpts, slack.OptionDebug(true))
 	}
 
+	maxReconnect := defaultMaxReconnect
+	if len(c.MaxReconnectInterval) > 0 {
+		if d, err := time.ParseDuration(c.MaxReconnectInterval); err == nil && d >= time.Second {
+			maxReconnect = d
+		} else {
+			robot.Log(bot.Error, fmt.Sprintf("Invalid MaxReconnectInterval '%s', using %s", c.MaxReconnectInterval, defaultMaxReconnect))
+		}
+	}
+
 	api := slack.New(tok, slackOpts...)
 
 	sc := &slackConnector{
 		api:             api,
-		conn:            api.NewRTM(),
+		events:          make(chan slack.RTMEvent, 50),
+		token:           tok,
 		maxMessageSplit: c.MaxMessageSplit,
+		maxReconnect:    maxReconnect,
 		name:            "slack",
+		joined:          make(map[string]bool),
 	}
-	go sc.conn.ManageConnection()
-
 	sc.Handler = robot
+	go sc.manageConnection()
 
 Loop:
 	for {
 		select {
-		case msg := <-sc.conn.IncomingEvents:
+		case msg := <-sc.events:
 
 			switch ev := msg.Data.(type) {
 
@@ -93,9 +110,6 @@ Loop:
 				sc.teamID = ev.Info.Team.ID
 				sc.Log(bot.Info, "Set team ID to", sc.teamID)
 				break Loop
-
-			case *slack.InvalidAuthEvent:
-				sc.Log(bot.Fatal, "Invalid credentials")
 			}
 		}
 	}
@@ -105,6 +119,15 @@ Loop:
 	sc.botFullName, _ = sc.GetProtocolUserAttribute(sc.botName, "realname")
 	go sc.startSendLoop()
 
+	if len(c.InteractionListen) > 0 {
+		if len(c.SigningSecret) == 0 {
+			sc.Log(bot.Error, "InteractionListen configured without a SigningSecret, not listening for Slack interactions")
+		} else {
+			sc.signingSecret = c.SigningSecret
+			go sc.listenInteractions(c.InteractionListen)
+		}
+	}
+
 	return bot.Connector(sc)
 }
 
@@ -122,8 +145,9 @@ loop:
 		select {
 		case <-stop:
 			sc.Log(bot.Debug, "Received stop in connector")
+			sc.disconnect()
 			break loop
-		case msg := <-sc.conn.IncomingEvents:
+		case msg := <-sc.events:
 			sc.Log(bot.Trace, fmt.Sprintf("Event Received (msg, data, type): %v; %v; %T", msg, msg.Data, msg.Data))
 			switch ev := msg.Data.(type) {
 			case *slack.HelloEvent:
@@ -140,6 +164,9 @@ loop:
 				// Message processing is done concurrently
 				go sc.processMessage(ev)
 
+			case *slack.ReactionAddedEvent:
+				go sc.processReaction(ev)
+
 			case *slack.PresenceChangeEvent:
 				sc.Log(bot.Debug, fmt.Sprintf("Presence Change: %v", ev))
 
@@ -0,0 +1,210 @@
+package slack
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/nlopes/slack"
+)
+
+/* connection.go - managing the RTM connection. The slack library's
+   ManageConnection reconnects immediately when a connection drops, so when
+   Slack keeps dropping the socket the robot goes into a tight reconnect
+   loop. Instead, each connection gets it's own RTM, and when one is lost the
+   connector waits with exponential backoff and jitter, capped at
+   MaxReconnectInterval, before starting the next. Events from the current
+   connection are forwarded to Run.
+*/
+
+const (
+	defaultMaxReconnect = 5 * time.Minute
+	// a connection that stays up this long resets the backoff
+	stableConnection = time.Minute
+	// how long to drain a retired RTM; longer than the library's own
+	// maximum backoff between connection attempts
+	retireTimeout = 10 * time.Minute
+)
+
+// connState is the state of the connection to Slack, reported by
+// ConnectionStatus
+type connState struct {
+	connected   bool
+	since       time.Time // when the robot connected or lost the connection
+	reconnects  int       // successful reconnects since start-up
+	attempt     int       // reconnect attempt in progress, 0 when connected
+	nextAttempt time.Time // when the next reconnect attempt starts
+	lastError   string    // why the last connection was lost
+	stopping    bool      // set when Run returns, so the robot doesn't reconnect
+}
+
+// reconnectDelay returns the wait before reconnect attempt n: exponential
+// from one second, with jitter, capped at max.
+func reconnectDelay(attempt int, max time.Duration) time.Duration {
+	d := max
+	if attempt < 32 {
+		if exp := time.Second << uint(attempt-1); exp < max {
+			d = exp
+		}
+	}
+	// jitter between half and all of the delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// manageConnection keeps a connection to Slack, starting a new RTM with
+// backoff whenever the current one is lost; it returns when the robot
+// stops.
+func (s *slackConnector) manageConnection() {
+	attempt := 0
+	for {
+		rtm := s.api.NewRTM()
+		s.Lock()
+		s.conn = rtm
+		s.Unlock()
+		go rtm.ManageConnection()
+		up, reason := s.forwardEvents(rtm, attempt)
+		s.Lock()
+		stopping := s.state.stopping
+		s.state.connected = false
+		s.state.since = time.Now()
+		s.state.lastError = reason
+		s.Unlock()
+		if stopping {
+			return
+		}
+		go retire(rtm)
+		if up >= stableConnection {
+			attempt = 0
+		}
+		attempt++
+		delay := reconnectDelay(attempt, s.maxReconnect)
+		s.Lock()
+		s.state.attempt = attempt
+		s.state.nextAttempt = time.Now().Add(delay)
+		s.Unlock()
+		s.Log(bot.Warn, fmt.Sprintf("Slack connection lost (%s), reconnect attempt %d in %s", reason, attempt, delay.Round(time.Second)))
+		time.Sleep(delay)
+		s.RLock()
+		stopping = s.state.stopping
+		s.RUnlock()
+		if stopping {
+			return
+		}
+	}
+}
+
+// forwardEvents passes events from an RTM to Run until the connection is
+// lost, returning how long it was up and why it ended. The library
+// reconnects on it's own after an error; those connections are abandoned.
+func (s *slackConnector) forwardEvents(rtm *slack.RTM, attempt int) (up time.Duration, reason string) {
+	var connected time.Time
+	for msg := range rtm.IncomingEvents {
+		switch ev := msg.Data.(type) {
+		case *slack.ConnectingEvent:
+			s.Log(bot.Debug, fmt.Sprintf("Connecting to Slack, connection count %d", ev.ConnectionCount))
+			continue
+		case *slack.ConnectedEvent:
+			connected = time.Now()
+			s.Lock()
+			s.state.connected = true
+			s.state.since = connected
+			s.state.attempt = 0
+			if attempt > 0 {
+				s.state.reconnects++
+			}
+			s.Unlock()
+			if attempt > 0 {
+				s.Log(bot.Info, fmt.Sprintf("Reconnected to Slack after %d attempt(s)", attempt))
+				go s.resume()
+			}
+		case *slack.InvalidAuthEvent:
+			s.Log(bot.Fatal, "Invalid credentials")
+		case *slack.ConnectionErrorEvent:
+			return 0, ev.Error()
+		case *slack.DisconnectedEvent:
+			if connected.IsZero() {
+				return 0, "disconnected while connecting"
+			}
+			return time.Since(connected), "disconnected"
+		}
+		s.events <- msg
+	}
+	return 0, "event channel closed"
+}
+
+// resume refreshes the channel and user maps after a reconnect, since they
+// may have changed while the robot was away, and re-joins the channels the
+// robot joined.
+func (s *slackConnector) resume() {
+	s.updateChannelMaps("")
+	s.updateUserList("")
+	s.RLock()
+	joined := make([]string, 0, len(s.joined))
+	for channel := range s.joined {
+		joined = append(joined, channel)
+	}
+	s.RUnlock()
+	for _, channel := range joined {
+		s.JoinChannel(channel)
+	}
+}
+
+// retire shuts down an RTM that's no longer used. The library may already
+// be reconnecting, so the RTM is disconnected again if it connects, and
+// it's events are drained until the library gives up.
+func retire(rtm *slack.RTM) {
+	rtm.Disconnect()
+	timeout := time.After(retireTimeout)
+	for {
+		select {
+		case msg := <-rtm.IncomingEvents:
+			switch ev := msg.Data.(type) {
+			case *slack.ConnectedEvent:
+				go rtm.Disconnect()
+			case *slack.DisconnectedEvent:
+				if ev.Intentional {
+					return
+				}
+			case *slack.InvalidAuthEvent:
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection when the robot stops
+func (s *slackConnector) disconnect() {
+	s.Lock()
+	s.state.stopping = true
+	rtm := s.conn
+	s.Unlock()
+	rtm.Disconnect()
+}
+
+// rtm returns the current RTM connection
+func (s *slackConnector) rtm() *slack.RTM {
+	s.RLock()
+	defer s.RUnlock()
+	return s.conn
+}
+
+// ConnectionStatus reports the state of the connection to Slack
+func (s *slackConnector) ConnectionStatus() (bool, string) {
+	s.RLock()
+	defer s.RUnlock()
+	st := s.state
+	if st.connected {
+		return true, fmt.Sprintf("connected since %s, %d reconnect(s)", st.since.Format(time.RFC1123), st.reconnects)
+	}
+	if st.attempt > 0 {
+		next := time.Until(st.nextAttempt).Round(time.Second)
+		if next < 0 {
+			next = 0
+		}
+		return false, fmt.Sprintf("disconnected since %s (%s); reconnect attempt %d in %s", st.since.Format(time.RFC1123), st.lastError, st.attempt, next)
+	}
+	return false, "not connected"
+}
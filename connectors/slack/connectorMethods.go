@@ -1,7 +1,12 @@
 package slack
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/lnxjedi/gopherbot/bot"
@@ -59,17 +64,36 @@ func (s *slackConnector) GetProtocolUserAttribute(u, attr string) (value string,
 type sendMessage struct {
 	message, channel string
 	format           bot.MessageFormat
+	opts             bot.MessageOptions
 }
 
 var messages = make(chan *sendMessage)
 
+// RenderMarkup renders the robot's neutral markup in Slack's mrkdwn
+func (s *slackConnector) RenderMarkup(text string) string {
+	return bot.ReplaceMarkup(text, "*%s*", "_%s_", "~%s~", "<%[2]s|%[1]s>")
+}
+
+// Capabilities declares the optional features of the slack connector
+func (s *slackConnector) Capabilities() []bot.ConnectorCapability {
+	return []bot.ConnectorCapability{
+		bot.CapJoinChannel,
+		bot.CapTypingIndicator,
+		bot.CapThreads,
+		bot.CapEphemeral,
+		bot.CapReactionEvents,
+		bot.CapBlocks,
+	}
+}
+
 // Send a typing notifier letting the user know the message has been heard by
 // the robot.
 func (s *slackConnector) MessageHeard(user, channel string) {
 	var chanID string
 	var ok bool
 	if chanID, ok = bot.ExtractID(channel); ok {
-		s.conn.SendMessage(s.conn.NewTypingMessage(chanID))
+		rtm := s.rtm()
+		rtm.SendMessage(rtm.NewTypingMessage(chanID))
 	}
 }
 
@@ -94,11 +118,18 @@ func (s *slackConnector) startSendLoop() {
 		time.Sleep(typingDelay)
 		sent := false
 		for p := range []int{1, 2, 4} {
-			unfurl := slack.MsgOptionEnableLinkUnfurl()
-			if send.format == bot.Variable {
-				unfurl = slack.MsgOptionDisableLinkUnfurl()
+			msgOpts := []slack.MsgOption{slack.MsgOptionText(send.message, false), slack.MsgOptionAsUser(true)}
+			if len(send.opts.Thread) > 0 {
+				msgOpts = append(msgOpts, slack.MsgOptionTS(send.opts.Thread))
 			}
-			_, _, err := s.api.PostMessage(send.channel, slack.MsgOptionText(send.message, false), slack.MsgOptionAsUser(true), unfurl)
+			if send.opts.NoUnfurl {
+				msgOpts = append(msgOpts, slack.MsgOptionDisableLinkUnfurl(), slack.MsgOptionDisableMediaUnfurl())
+			} else if send.format == bot.Variable {
+				msgOpts = append(msgOpts, slack.MsgOptionDisableLinkUnfurl())
+			} else {
+				msgOpts = append(msgOpts, slack.MsgOptionEnableLinkUnfurl())
+			}
+			_, _, err := s.api.PostMessage(send.channel, msgOpts...)
 			if err != nil && p == 1 {
 				s.Log(bot.Warn, fmt.Sprintf("Error sending message '%s' initiating backoff: %v", send.message, err))
 			}
@@ -111,7 +142,8 @@ func (s *slackConnector) startSendLoop() {
 		}
 		if !sent {
 			s.Log(bot.Error, fmt.Sprintf("Failed sending message '%s' to channel '%s' after 3 tries, attempting fallback to RTM", send.message, send.channel))
-			s.conn.SendMessage(s.conn.NewOutgoingMessage(send.message, send.channel))
+			rtm := s.rtm()
+			rtm.SendMessage(rtm.NewOutgoingMessage(send.message, send.channel))
 		}
 		timeSinceBurst := msgTime.Sub(burstTime)
 		if msgTime.Sub(mtimes[windowStartMsg]) < burstWindow || timeSinceBurst < coolDown {
@@ -126,12 +158,13 @@ func (s *slackConnector) startSendLoop() {
 	}
 }
 
-func (s *slackConnector) sendMessages(msgs []string, chanID string, f bot.MessageFormat) {
+func (s *slackConnector) sendMessages(msgs []string, chanID string, f bot.MessageFormat, opts bot.MessageOptions) {
 	for _, msg := range msgs {
 		messages <- &sendMessage{
 			message: msg,
 			channel: chanID,
 			format:  f,
+			opts:    opts,
 		}
 	}
 }
@@ -147,21 +180,32 @@ func (s *slackConnector) SetUserMap(umap map[string]string) {
 
 // SendProtocolChannelMessage sends a message to a channel
 func (s *slackConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return s.SendProtocolChannelMessageOpts(ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolChannelMessageOpts sends a message to a channel with options
+func (s *slackConnector) SendProtocolChannelMessageOpts(ch string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
 	msgs := s.slackifyMessage("", msg, f)
 	if chanID, ok := bot.ExtractID(ch); ok {
-		s.sendMessages(msgs, chanID, f)
+		s.sendMessages(msgs, chanID, f, opts)
 		return
 	}
 	if chanID, ok := s.chanID(ch); ok {
-		s.sendMessages(msgs, chanID, f)
+		s.sendMessages(msgs, chanID, f, opts)
 		return
 	}
 	s.Log(bot.Error, "Channel ID not found for:", ch)
 	return bot.ChannelNotFound
 }
 
-// SendProtocolChannelMessage sends a message to a channel
+// SendProtocolUserChannelMessage directs a message to a user in a channel
 func (s *slackConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return s.SendProtocolUserChannelMessageOpts(uid, u, ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserChannelMessageOpts directs a message to a user in a
+// channel with options
+func (s *slackConnector) SendProtocolUserChannelMessageOpts(uid, u, ch, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
 	var userID, chanID string
 	var ok bool
 	if chanID, ok = bot.ExtractID(ch); !ok {
@@ -181,12 +225,136 @@ func (s *slackConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string,
 	// This gets converted to <@userID> in slackifyMessage
 	prefix := "<@" + userID + ">: "
 	msgs := s.slackifyMessage(prefix, msg, f)
-	s.sendMessages(msgs, chanID, f)
+	s.sendMessages(msgs, chanID, f, opts)
 	return
 }
 
 // SendProtocolUserMessage sends a direct message to a user
 func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return s.SendProtocolUserMessageOpts(u, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserMessageOpts sends a direct message to a user with options
+func (s *slackConnector) SendProtocolUserMessageOpts(u string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	userIMchan, ret := s.openIM(u)
+	if ret != bot.Ok {
+		return
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	s.sendMessages(msgs, userIMchan, f, opts)
+	return bot.Ok
+}
+
+// SendProtocolEphemeralMessage posts a message in a channel that only the
+// user sees, with chat.postEphemeral
+func (s *slackConnector) SendProtocolEphemeralMessage(uid, u, ch, msg string, f bot.MessageFormat, opts bot.MessageOptions) bot.RetVal {
+	var userID, chanID string
+	var ok bool
+	if chanID, ok = bot.ExtractID(ch); !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	if userID, ok = bot.ExtractID(uid); !ok {
+		userID, ok = s.userID(u)
+	}
+	if !ok {
+		s.Log(bot.Error, "User ID not found for:", uid)
+		return bot.UserNotFound
+	}
+	for _, m := range s.slackifyMessage("", msg, f) {
+		msgOpts := []slack.MsgOption{slack.MsgOptionText(m, false), slack.MsgOptionAsUser(true)}
+		if len(opts.Thread) > 0 {
+			msgOpts = append(msgOpts, slack.MsgOptionTS(opts.Thread))
+		}
+		if _, err := s.api.PostEphemeral(chanID, userID, msgOpts...); err != nil {
+			s.Log(bot.Error, fmt.Sprintf("Failed sending ephemeral message to user '%s' in channel '%s': %v", userID, chanID, err))
+			return bot.FailedUserDM
+		}
+	}
+	return bot.Ok
+}
+
+// SendProtocolChannelMessageID posts a message to a channel and returns an
+// ID for updating or deleting it. These messages are posted right away
+// instead of going through the send loop, since the ID is needed.
+func (s *slackConnector) SendProtocolChannelMessageID(ch, msg string, f bot.MessageFormat, opts bot.MessageOptions) (string, bot.RetVal) {
+	chanID, ok := bot.ExtractID(ch)
+	if !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return "", bot.ChannelNotFound
+	}
+	return s.postMessageID(chanID, msg, f, opts)
+}
+
+// SendProtocolUserMessageID sends a direct message to a user and returns an
+// ID for updating or deleting it
+func (s *slackConnector) SendProtocolUserMessageID(u, msg string, f bot.MessageFormat, opts bot.MessageOptions) (string, bot.RetVal) {
+	userIMchan, ret := s.openIM(u)
+	if ret != bot.Ok {
+		return "", ret
+	}
+	return s.postMessageID(userIMchan, msg, f, opts)
+}
+
+// postMessageID posts a message, returning "<channel ID>:<timestamp>" of
+// the last part as the message ID
+func (s *slackConnector) postMessageID(chanID, msg string, f bot.MessageFormat, opts bot.MessageOptions) (string, bot.RetVal) {
+	var id string
+	for _, m := range s.slackifyMessage("", msg, f) {
+		msgOpts := []slack.MsgOption{slack.MsgOptionText(m, false), slack.MsgOptionAsUser(true)}
+		if len(opts.Thread) > 0 {
+			msgOpts = append(msgOpts, slack.MsgOptionTS(opts.Thread))
+		}
+		respChannel, ts, err := s.api.PostMessage(chanID, msgOpts...)
+		if err != nil {
+			s.Log(bot.Error, fmt.Sprintf("Failed sending message to channel '%s': %v", chanID, err))
+			return "", bot.FailedMessageEdit
+		}
+		id = respChannel + ":" + ts
+	}
+	return id, bot.Ok
+}
+
+// UpdateMessage replaces the text of a message sent by the robot
+func (s *slackConnector) UpdateMessage(id, msg string, f bot.MessageFormat) bot.RetVal {
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) != 2 {
+		s.Log(bot.Error, "Invalid message ID for update:", id)
+		return bot.FailedMessageEdit
+	}
+	msgs := s.slackifyMessage("", msg, f)
+	if len(msgs) > 1 {
+		s.Log(bot.Warn, fmt.Sprintf("Message update for '%s' too long, truncating", id))
+	}
+	if _, _, _, err := s.api.UpdateMessage(parts[0], parts[1], slack.MsgOptionText(msgs[0], false)); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed updating message '%s': %v", id, err))
+		return bot.FailedMessageEdit
+	}
+	return bot.Ok
+}
+
+// DeleteMessage deletes a message sent by the robot
+func (s *slackConnector) DeleteMessage(id string) bot.RetVal {
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) != 2 {
+		s.Log(bot.Error, "Invalid message ID for delete:", id)
+		return bot.FailedMessageEdit
+	}
+	if _, _, err := s.api.DeleteMessage(parts[0], parts[1]); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed deleting message '%s': %v", id, err))
+		return bot.FailedMessageEdit
+	}
+	return bot.Ok
+}
+
+// openIM returns the IM channel for a user, opening one if needed
+func (s *slackConnector) openIM(u string) (string, bot.RetVal) {
 	var userID string
 	var ok bool
 	if userID, ok = bot.ExtractID(u); !ok {
@@ -194,24 +362,89 @@ func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 	}
 	if !ok {
 		s.Log(bot.Error, "No user ID found for user:", u)
-		ret = bot.UserNotFound
+		return "", bot.UserNotFound
 	}
-	var userIMchan string
-	var err error
-	userIMchan, ok = s.userIMID(userID)
+	userIMchan, ok := s.userIMID(userID)
 	if !ok {
 		s.Log(bot.Warn, "No IM channel found for user:", u, "ID:", userID, "trying to open IM")
-		_, _, userIMchan, err = s.conn.OpenIMChannel(userID)
+		var err error
+		_, _, userIMchan, err = s.api.OpenIMChannel(userID)
 		if err != nil {
 			s.Log(bot.Error, "Unable to open an IM channel to user:", u, "ID:", userID)
-			ret = bot.FailedUserDM
+			return "", bot.FailedUserDM
 		}
 	}
+	return userIMchan, bot.Ok
+}
+
+// SendProtocolChannelFile uploads a file to a channel with files.upload
+func (s *slackConnector) SendProtocolChannelFile(ch, name string, content io.Reader, comment string, opts bot.MessageOptions) bot.RetVal {
+	chanID, ok := bot.ExtractID(ch)
+	if !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	return s.uploadFile(chanID, name, content, comment, opts)
+}
+
+// SendProtocolUserFile uploads a file to a user's IM channel
+func (s *slackConnector) SendProtocolUserFile(u, name string, content io.Reader, comment string, opts bot.MessageOptions) bot.RetVal {
+	userIMchan, ret := s.openIM(u)
 	if ret != bot.Ok {
-		return
+		return ret
+	}
+	return s.uploadFile(userIMchan, name, content, comment, opts)
+}
+
+func (s *slackConnector) uploadFile(chanID, name string, content io.Reader, comment string, opts bot.MessageOptions) bot.RetVal {
+	_, err := s.api.UploadFile(slack.FileUploadParameters{
+		Reader:          content,
+		Filename:        name,
+		Title:           name,
+		InitialComment:  comment,
+		Channels:        []string{chanID},
+		ThreadTimestamp: opts.Thread,
+	})
+	if err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed uploading file '%s' to channel '%s': %v", name, chanID, err))
+		return bot.FailedFileSend
+	}
+	return bot.Ok
+}
+
+// AddReaction adds an emoji reaction to a message
+func (s *slackConnector) AddReaction(ch, messageID, emoji string) bot.RetVal {
+	return s.react(ch, messageID, emoji, true)
+}
+
+// RemoveReaction removes the robot's emoji reaction from a message
+func (s *slackConnector) RemoveReaction(ch, messageID, emoji string) bot.RetVal {
+	return s.react(ch, messageID, emoji, false)
+}
+
+func (s *slackConnector) react(ch, messageID, emoji string, add bool) bot.RetVal {
+	chanID, ok := bot.ExtractID(ch)
+	if !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	item := slack.NewRefToMessage(chanID, messageID)
+	var err error
+	if add {
+		err = s.api.AddReaction(emoji, item)
+	} else {
+		err = s.api.RemoveReaction(emoji, item)
+	}
+	if err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed updating reaction '%s' on message '%s' in channel '%s': %v", emoji, messageID, chanID, err))
+		return bot.FailedReaction
 	}
-	msgs := s.slackifyMessage("", msg, f)
-	s.sendMessages(msgs, userIMchan, f)
 	return bot.Ok
 }
 
@@ -227,5 +460,102 @@ func (s *slackConnector) JoinChannel(c string) (ret bot.RetVal) {
 		s.Log(bot.Error, "Failed to join channel", c, ":", err, "(try inviting the bot)")
 		return bot.FailedChannelJoin
 	}
+	s.Lock()
+	s.joined[c] = true
+	s.Unlock()
+	return bot.Ok
+}
+
+// CreateChannel creates a public channel and invites the given users
+func (s *slackConnector) CreateChannel(name string, users []string) (string, bot.RetVal) {
+	ch, err := s.api.CreateConversation(name, false)
+	if err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed to create channel '%s': %v", name, err))
+		return "", bot.FailedChannelCreate
+	}
+	// add the new channel to the maps, since a failed lookup doesn't
+	// refresh them
+	s.Lock()
+	s.channelToID[ch.Name] = ch.ID
+	s.idToChannel[ch.ID] = ch.Name
+	s.channelInfo[ch.ID] = ch
+	s.Unlock()
+	var userIDs []string
+	for _, u := range users {
+		userID, ok := bot.ExtractID(u)
+		if !ok {
+			userID, ok = s.userID(u)
+		}
+		if ok {
+			userIDs = append(userIDs, userID)
+		} else {
+			s.Log(bot.Warn, fmt.Sprintf("User ID not found for '%s', not inviting to channel '%s'", u, ch.Name))
+		}
+	}
+	if len(userIDs) > 0 {
+		if _, err := s.api.InviteUsersToConversation(ch.ID, userIDs...); err != nil {
+			s.Log(bot.Warn, fmt.Sprintf("Failed to invite users to channel '%s': %v", ch.Name, err))
+		}
+	}
+	return ch.Name, bot.Ok
+}
+
+// SendProtocolChannelBlocks posts a Block Kit message to a channel
+func (s *slackConnector) SendProtocolChannelBlocks(ch string, blocks json.RawMessage, fallback string, opts bot.MessageOptions) bot.RetVal {
+	chanID, ok := bot.ExtractID(ch)
+	if !ok {
+		chanID, ok = s.chanID(ch)
+	}
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	return s.postBlocks(chanID, blocks, fallback, opts)
+}
+
+// SendProtocolUserBlocks sends a Block Kit message to a user's IM channel
+func (s *slackConnector) SendProtocolUserBlocks(u string, blocks json.RawMessage, fallback string, opts bot.MessageOptions) bot.RetVal {
+	userIMchan, ret := s.openIM(u)
+	if ret != bot.Ok {
+		return ret
+	}
+	return s.postBlocks(userIMchan, blocks, fallback, opts)
+}
+
+// postBlocks calls chat.postMessage directly, since the vendored slack
+// library predates Block Kit and has no MsgOption for blocks.
+func (s *slackConnector) postBlocks(chanID string, blocks json.RawMessage, fallback string, opts bot.MessageOptions) bot.RetVal {
+	values := url.Values{
+		"token":   {s.token},
+		"channel": {chanID},
+		"text":    {fallback},
+		"blocks":  {string(blocks)},
+		"as_user": {"true"},
+	}
+	if len(opts.Thread) > 0 {
+		values.Set("thread_ts", opts.Thread)
+	}
+	if opts.NoUnfurl {
+		values.Set("unfurl_links", "false")
+		values.Set("unfurl_media", "false")
+	}
+	resp, err := http.PostForm(slack.APIURL+"chat.postMessage", values)
+	if err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed sending blocks to channel '%s': %v", chanID, err))
+		return bot.FailedMessageSend
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Failed decoding chat.postMessage reply for channel '%s': %v", chanID, err))
+		return bot.FailedMessageSend
+	}
+	if !reply.OK {
+		s.Log(bot.Error, fmt.Sprintf("Failed sending blocks to channel '%s': %s", chanID, reply.Error))
+		return bot.FailedMessageSend
+	}
 	return bot.Ok
 }
@@ -0,0 +1,154 @@
+package slack
+
+/* interactions.go - Slack posts interaction payloads, e.g. Block Kit button
+   clicks, to the app's interactivity Request URL rather than over the RTM
+   connection. When InteractionListen is configured, the connector listens
+   for these at /slack/actions, verifies them with the app's SigningSecret,
+   and hands each button click to the robot as a bot.ConnectorAction.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// Slack retries requests that aren't answered in 3 seconds, and recommends
+// rejecting requests with timestamps older than 5 minutes.
+const maxRequestAge = 5 * time.Minute
+
+// maximum size of an interaction request
+const maxInteractionPayload = 1 << 20
+
+// interactionPayload is the part of a Slack "block_actions" payload the
+// robot uses.
+type interactionPayload struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Message struct {
+		Timestamp       string `json:"ts"`
+		ThreadTimestamp string `json:"thread_ts"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// listenInteractions serves Slack's interactivity Request URL
+func (s *slackConnector) listenInteractions(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/actions", s.serveInteraction)
+	s.Log(bot.Info, "Listening for Slack interactions on", addr)
+	s.Log(bot.Error, fmt.Sprintf("Slack interaction listener exited: %v", http.ListenAndServe(addr, mux)))
+}
+
+// serveInteraction verifies an interaction request and answers right away;
+// the actions are processed concurrently, like messages.
+func (s *slackConnector) serveInteraction(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxInteractionPayload))
+	if err != nil {
+		http.Error(w, "unable to read request", http.StatusBadRequest)
+		return
+	}
+	if !s.verifyRequest(req.Header, body) {
+		s.Log(bot.Warn, "Ignoring Slack interaction request with an invalid signature from", req.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	var payload interactionPayload
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Unable to decode Slack interaction payload: %v", err))
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	go s.processInteraction(&payload)
+}
+
+// verifyRequest checks the X-Slack-Signature header, an HMAC of the request
+// timestamp and body keyed with the signing secret.
+func (s *slackConnector) verifyRequest(h http.Header, body []byte) bool {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(secs, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.signingSecret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(h.Get("X-Slack-Signature")))
+}
+
+// processInteraction passes each button click in a block_actions payload on
+// to the robot. The thread is that of the message with the button, so
+// replies land where the conversation is.
+func (s *slackConnector) processInteraction(p *interactionPayload) {
+	s.Log(bot.Trace, fmt.Sprintf("Interaction received: %+v", *p))
+	if p.Type != "block_actions" {
+		s.Log(bot.Debug, "Ignoring Slack interaction of type", p.Type)
+		return
+	}
+	if len(p.User.ID) == 0 || p.User.ID == s.botID {
+		return
+	}
+	userName := p.User.Username
+	if name, ok := s.userName(p.User.ID); ok {
+		userName = name
+	}
+	ci, ok := s.getChannelInfo(p.Channel.ID)
+	directMessage := ok && ci.IsIM
+	var channelName string
+	if !directMessage {
+		channelName = p.Channel.Name
+		if name, ok := s.channelName(p.Channel.ID); ok {
+			channelName = name
+		}
+	}
+	for _, a := range p.Actions {
+		s.IncomingAction(&bot.ConnectorAction{
+			Protocol:      "Slack",
+			UserName:      userName,
+			UserID:        p.User.ID,
+			ChannelName:   channelName,
+			ChannelID:     p.Channel.ID,
+			DirectMessage: directMessage,
+			ThreadID:      p.Message.ThreadTimestamp,
+			ActionID:      a.ActionID,
+			Value:         a.Value,
+			ActionObject:  p,
+			Client:        s.api,
+		})
+	}
+}
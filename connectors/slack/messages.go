@@ -201,6 +201,8 @@ func (s *slackConnector) processMessage(msg *slack.MessageEvent) {
 		ChannelID:     chanID,
 		DirectMessage: ci.IsIM,
 		MessageText:   text,
+		ThreadID:      message.ThreadTimestamp,
+		MessageID:     message.Timestamp,
 		MessageObject: msg,
 		Client:        s.api,
 	}
@@ -215,3 +217,25 @@ func (s *slackConnector) processMessage(msg *slack.MessageEvent) {
 	}
 	s.IncomingMessage(botMsg)
 }
+
+// processReaction passes a user's reaction to a message on to the robot,
+// with the message ID in the same form as postMessageID.
+func (s *slackConnector) processReaction(ev *slack.ReactionAddedEvent) {
+	s.Log(bot.Trace, fmt.Sprintf("Reaction received: %v", *ev))
+	if ev.Item.Type != "message" || ev.User == s.botID {
+		return
+	}
+	// e.g. "+1::skin-tone-2" is still a "+1"
+	emoji := strings.SplitN(ev.Reaction, "::", 2)[0]
+	reaction := &bot.ConnectorReaction{
+		Protocol:  "Slack",
+		UserID:    ev.User,
+		ChannelID: ev.Item.Channel,
+		MessageID: ev.Item.Channel + ":" + ev.Item.Timestamp,
+		Emoji:     emoji,
+	}
+	if userName, ok := s.userName(ev.User); ok {
+		reaction.UserName = userName
+	}
+	s.IncomingReaction(reaction)
+}
@@ -18,7 +18,13 @@ const optimeout = 1 * time.Minute
 // slackConnector holds all the relevant data about a connection
 type slackConnector struct {
 	api             *slack.Client
-	conn            *slack.RTM
+	conn            *slack.RTM                // the current RTM connection, see connection.go
+	events          chan slack.RTMEvent       // events from the current connection
+	maxReconnect    time.Duration             // cap for the backoff between reconnect attempts
+	state           connState                 // connection state, for ConnectionStatus
+	joined          map[string]bool           // channels joined with JoinChannel, re-joined after a reconnect
+	token           string                    // API token, for web API calls the slack library doesn't support
+	signingSecret   string                    // for verifying interaction requests
 	maxMessageSplit int                       // The maximum # of ~4000 byte messages to send before truncating
 	running         bool                      // set on call to Run
 	botName         string                    // human-readable name of bot
@@ -0,0 +1,137 @@
+package telegram
+
+/* api.go has the types returned by the Telegram Bot API, the API helper,
+and the internal methods for maintaining the user and chat maps. Telegram
+has no API for listing the chats a bot is in, or looking up users by name,
+so the maps are built from the messages the robot sees, along with the
+UserRoster. */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type tgUser struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+type tgChat struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"` // private, group, supergroup or channel
+	Title    string `json:"title"`
+	Username string `json:"username"`
+}
+
+type tgMessage struct {
+	MessageID      int64      `json:"message_id"`
+	From           *tgUser    `json:"from"`
+	Chat           tgChat     `json:"chat"`
+	Text           string     `json:"text"`
+	ReplyToMessage *tgMessage `json:"reply_to_message"`
+}
+
+type tgUpdate struct {
+	UpdateID int64      `json:"update_id"`
+	Message  *tgMessage `json:"message"`
+}
+
+// tgResponse wraps every Bot API result
+type tgResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+}
+
+// apiCall calls a Bot API method with a JSON request, unmarshalling the
+// result into result if non-nil.
+func (tc *tgConnector) apiCall(method string, req, result interface{}) error {
+	if req == nil {
+		req = struct{}{}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/bot%s/%s", tc.apiURL, tc.token, method)
+	resp, err := tc.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		// the error includes the URL, and with it the token
+		return fmt.Errorf("%s: %s", method, strings.Replace(err.Error(), tc.token, "<token>", -1))
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var tr tgResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !tr.OK {
+		return fmt.Errorf("%s: %d - %s", method, tr.ErrorCode, tr.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(tr.Result, result)
+	}
+	return nil
+}
+
+// chatName returns the channel name for a group chat; the public username
+// if it has one, otherwise the chat ID.
+func chatName(chat tgChat) string {
+	if len(chat.Username) > 0 {
+		return chat.Username
+	}
+	return strconv.FormatInt(chat.ID, 10)
+}
+
+// seen records the user and chat of an incoming message
+func (tc *tgConnector) seen(user tgUser, chat tgChat) {
+	tc.Lock()
+	tc.userInfo[user.ID] = user
+	if len(user.Username) > 0 {
+		tc.userIDs[user.Username] = user.ID
+	}
+	if chat.Type != "private" {
+		tc.chatIDs[chatName(chat)] = chat.ID
+	}
+	tc.Unlock()
+}
+
+// chatID returns the chat ID for a channel name or bracketed ID
+func (tc *tgConnector) chatID(ch string) (int64, bool) {
+	if id, ok := bot.ExtractID(ch); ok {
+		ch = id
+	}
+	if id, err := strconv.ParseInt(ch, 10, 64); err == nil {
+		return id, true
+	}
+	tc.RLock()
+	id, ok := tc.chatIDs[strings.TrimPrefix(ch, "@")]
+	tc.RUnlock()
+	return id, ok
+}
+
+// userID returns the user ID for a username or bracketed ID; for private
+// chats, the chat ID is the same as the user ID.
+func (tc *tgConnector) userID(u string) (int64, bool) {
+	if id, ok := bot.ExtractID(u); ok {
+		uid, err := strconv.ParseInt(id, 10, 64)
+		return uid, err == nil
+	}
+	tc.RLock()
+	id, ok := tc.userIDs[u]
+	tc.RUnlock()
+	return id, ok
+}
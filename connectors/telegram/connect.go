@@ -0,0 +1,185 @@
+// Package telegram implements the bot.Connector interface for Telegram, using
+// the Bot API with long polling.
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type config struct {
+	BotToken string // token from @BotFather
+	// BotName is the name the robot answers to, from BotInfo UserName in
+	// gopherbot.yaml; @mentions of the Telegram bot username are rewritten
+	// to @BotName, so the robot sees them as addressed to it. Defaults to
+	// the Telegram bot username.
+	BotName string
+	APIURL  string // optional, for a self-hosted Bot API server
+}
+
+// tgConnector holds all the relevant data about a connection
+type tgConnector struct {
+	token        string           // Bot API token
+	apiURL       string           // base URL of the Bot API
+	client       *http.Client     // for Bot API calls
+	botName      string           // name the robot answers to
+	botUsername  string           // Telegram username of the bot
+	botID        int64            // Telegram ID of the bot
+	mentionRe    *regexp.Regexp   // matches @botUsername
+	running      bool             // set on call to Run
+	chatIDs      map[string]int64 // channel name to chat ID
+	userIDs      map[string]int64 // username to user ID
+	userInfo     map[int64]tgUser // user ID to user
+	allowedChats map[int64]bool   // from JoinChannels; all group chats allowed if empty
+	bot.Handler                   // bot API for connectors
+	sync.RWMutex                  // shared mutex for locking connector data structures
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+func init() {
+	bot.RegisterConnector("telegram", Initialize)
+}
+
+// Initialize looks up the robot's Telegram user and returns the connector
+// object; updates are polled in Run.
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Errorf("Unable to retrieve protocol configuration: %v", err))
+	}
+	if len(c.BotToken) == 0 {
+		robot.Log(bot.Fatal, "No BotToken found in telegram config")
+	}
+	if len(c.APIURL) == 0 {
+		c.APIURL = "https://api.telegram.org"
+	}
+
+	tc := &tgConnector{
+		token:        c.BotToken,
+		apiURL:       strings.TrimRight(c.APIURL, "/"),
+		client:       &http.Client{Timeout: pollTimeout + 30*time.Second},
+		chatIDs:      make(map[string]int64),
+		userIDs:      make(map[string]int64),
+		userInfo:     make(map[int64]tgUser),
+		allowedChats: make(map[int64]bool),
+	}
+	tc.Handler = robot
+
+	var me tgUser
+	if err := tc.apiCall("getMe", nil, &me); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Unable to look up the robot's user, check BotToken: %v", err))
+	}
+	tc.botID = me.ID
+	tc.botUsername = me.Username
+	tc.mentionRe = regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(tc.botUsername) + `\b`)
+	tc.botName = c.BotName
+	if len(tc.botName) == 0 {
+		tc.botName = me.Username
+	}
+	tc.SetID(strconv.FormatInt(tc.botID, 10))
+	tc.Log(bot.Info, fmt.Sprintf("Telegram bot '@%s' has internal ID %d", tc.botUsername, tc.botID))
+
+	return bot.Connector(tc)
+}
+
+// pollTimeout is the long polling timeout for getUpdates
+const pollTimeout = 30 * time.Second
+
+// Minimum and maximum time to wait after a failed poll
+const minRetry = time.Second
+const maxRetry = time.Minute
+
+// Run polls for updates and delivers messages to the robot until stop is
+// closed.
+func (tc *tgConnector) Run(stop <-chan struct{}) {
+	tc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if tc.running {
+		tc.Unlock()
+		return
+	}
+	tc.running = true
+	tc.Unlock()
+
+	updates := make(chan []tgUpdate)
+	failed := make(chan error)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var offset int64
+		wait := minRetry
+		for {
+			var batch []tgUpdate
+			req := map[string]interface{}{
+				"offset":          offset,
+				"timeout":         int(pollTimeout / time.Second),
+				"allowed_updates": []string{"message"},
+			}
+			if err := tc.apiCall("getUpdates", req, &batch); err != nil {
+				select {
+				case failed <- err:
+				case <-done:
+					return
+				}
+				select {
+				case <-time.After(wait):
+				case <-done:
+					return
+				}
+				wait *= 2
+				if wait > maxRetry {
+					wait = maxRetry
+				}
+				continue
+			}
+			wait = minRetry
+			if len(batch) == 0 {
+				continue
+			}
+			offset = batch[len(batch)-1].UpdateID + 1
+			select {
+			case updates <- batch:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			tc.Log(bot.Debug, "Received stop in connector")
+			return
+		case err := <-failed:
+			tc.Log(bot.Warn, fmt.Sprintf("Error polling telegram for updates, retrying: %v", err))
+		case batch := <-updates:
+			for _, u := range batch {
+				tc.Log(bot.Trace, fmt.Sprintf("Update received: %d", u.UpdateID))
+				if u.Message != nil {
+					// Message processing is done concurrently
+					go tc.processMessage(u.Message)
+				}
+			}
+		}
+	}
+}
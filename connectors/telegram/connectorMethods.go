@@ -0,0 +1,157 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// Capabilities declares the optional features of the telegram connector
+func (tc *tgConnector) Capabilities() []bot.ConnectorCapability {
+	return []bot.ConnectorCapability{
+		bot.CapTypingIndicator,
+	}
+}
+
+// GetProtocolUserAttribute returns a string attribute or "" if telegram
+// doesn't have that information
+func (tc *tgConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	userID, ok := tc.userID(u)
+	var user tgUser
+	if ok {
+		tc.RLock()
+		user, ok = tc.userInfo[userID]
+		tc.RUnlock()
+	}
+	if !ok {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "internalid":
+		return strconv.FormatInt(user.ID, 10), bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName), bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	// that's all the attributes we can currently get from telegram
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// MessageHeard sends a typing notifier letting the user know the message
+// has been heard by the robot.
+func (tc *tgConnector) MessageHeard(user, channel string) {
+	chatID, ok := tc.chatID(channel)
+	if !ok {
+		if chatID, ok = tc.userID(user); !ok {
+			return
+		}
+	}
+	req := map[string]interface{}{"chat_id": chatID, "action": "typing"}
+	if err := tc.apiCall("sendChatAction", req, nil); err != nil {
+		tc.Log(bot.Debug, fmt.Sprintf("Failed sending typing notifier: %v", err))
+	}
+}
+
+// SetUserMap takes a map of username to userID mappings, built from the
+// UserRoster of gopherbot.yaml, so the robot can send direct messages to
+// users it hasn't heard from yet.
+func (tc *tgConnector) SetUserMap(umap map[string]string) {
+	tc.Lock()
+	for name, id := range umap {
+		if uid, err := strconv.ParseInt(id, 10, 64); err == nil {
+			if _, ok := tc.userIDs[name]; !ok {
+				tc.userIDs[name] = uid
+			}
+		}
+	}
+	tc.Unlock()
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (tc *tgConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return tc.SendProtocolChannelMessageOpts(ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolChannelMessageOpts sends a message to a channel with options
+func (tc *tgConnector) SendProtocolChannelMessageOpts(ch string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	chatID, ok := tc.chatID(ch)
+	if !ok {
+		tc.Log(bot.Error, "Chat ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	msgs, parseMode := tc.formatMessage("", msg, f)
+	tc.sendMessages(msgs, parseMode, chatID, opts)
+	return
+}
+
+// SendProtocolUserChannelMessage directs a message to a user in a channel
+func (tc *tgConnector) SendProtocolUserChannelMessage(uid, u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return tc.SendProtocolUserChannelMessageOpts(uid, u, ch, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserChannelMessageOpts directs a message to a user in a
+// channel with options
+func (tc *tgConnector) SendProtocolUserChannelMessageOpts(uid, u, ch, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	chatID, ok := tc.chatID(ch)
+	if !ok {
+		tc.Log(bot.Error, "Chat ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	// Users without a Telegram username can't be @mentioned, so they're
+	// addressed by first name
+	prefix := "@" + u + ": "
+	if len(u) == 0 {
+		if userID, ok := tc.userID(uid); ok {
+			tc.RLock()
+			user := tc.userInfo[userID]
+			tc.RUnlock()
+			prefix = user.FirstName + ": "
+		}
+	}
+	msgs, parseMode := tc.formatMessage(prefix, msg, f)
+	tc.sendMessages(msgs, parseMode, chatID, opts)
+	return
+}
+
+// SendProtocolUserMessage sends a direct message to a user
+func (tc *tgConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return tc.SendProtocolUserMessageOpts(u, msg, f, bot.MessageOptions{})
+}
+
+// SendProtocolUserMessageOpts sends a direct message to a user with options;
+// Telegram only allows this for users that have started a chat with the
+// robot.
+func (tc *tgConnector) SendProtocolUserMessageOpts(u string, msg string, f bot.MessageFormat, opts bot.MessageOptions) (ret bot.RetVal) {
+	userID, ok := tc.userID(u)
+	if !ok {
+		tc.Log(bot.Error, "User ID not found for:", u)
+		return bot.UserNotFound
+	}
+	msgs, parseMode := tc.formatMessage("", msg, f)
+	if !tc.sendMessages(msgs, parseMode, userID, opts) {
+		return bot.FailedUserDM
+	}
+	return bot.Ok
+}
+
+// JoinChannel adds a chat ID to the list of group chats the robot responds
+// in; Telegram bots can't join chats, they're added by a member. When
+// JoinChannels is empty, the robot responds in every chat it's added to.
+func (tc *tgConnector) JoinChannel(c string) (ret bot.RetVal) {
+	chatID, err := strconv.ParseInt(c, 10, 64)
+	if err != nil {
+		tc.Log(bot.Error, fmt.Sprintf("Invalid chat ID in JoinChannels: %s", c))
+		return bot.ChannelNotFound
+	}
+	tc.Lock()
+	tc.allowedChats[chatID] = true
+	tc.Unlock()
+	tc.Log(bot.Info, fmt.Sprintf("Responding in chat %d", chatID))
+	return bot.Ok
+}
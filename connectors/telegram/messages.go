@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// maxMessageLength is the Bot API limit for message text, less room for
+// <pre></pre>
+const maxMessageLength = 4000
+
+// maxMessageSplit is the maximum number of messages a long message is split into
+const maxMessageSplit = 4
+
+// formatMessage splits long messages, and returns the parse mode for the
+// format. Without a parse mode Telegram shows text as-is, so Variable and
+// Raw messages are sent as plain text, and Fixed messages as HTML <pre>.
+func (tc *tgConnector) formatMessage(prefix, msg string, f bot.MessageFormat) ([]string, string) {
+	msg = prefix + msg
+	var msgs []string
+	if len(msg) <= maxMessageLength {
+		msgs = []string{msg}
+	} else {
+		tc.Log(bot.Info, fmt.Sprintf("Message too long, segmenting: %d bytes", len(msg)))
+		msgs = make([]string, 0, maxMessageSplit+1)
+		for len(msg) > maxMessageLength && len(msgs) < maxMessageSplit {
+			lineEnd := strings.LastIndexByte(msg[:maxMessageLength], '\n')
+			if lineEnd == -1 { // no newline in this chunk
+				msgs = append(msgs, msg[:maxMessageLength])
+				msg = msg[maxMessageLength:]
+			} else {
+				msgs = append(msgs, msg[:lineEnd])
+				msg = msg[lineEnd+1:] // skip over the newline
+			}
+		}
+		if len(msgs) == maxMessageSplit {
+			if len(msg) > 0 {
+				msgs = append(msgs, "(message too long, truncated)")
+			}
+		} else {
+			msgs = append(msgs, msg)
+		}
+	}
+	if f != bot.Fixed {
+		return msgs, ""
+	}
+	for i, m := range msgs {
+		msgs[i] = "<pre>" + html.EscapeString(m) + "</pre>"
+	}
+	return msgs, "HTML"
+}
+
+// sendMessages sends messages to a chat, returning false if a send failed
+func (tc *tgConnector) sendMessages(msgs []string, parseMode string, chatID int64, opts bot.MessageOptions) bool {
+	for _, msg := range msgs {
+		req := map[string]interface{}{
+			"chat_id": chatID,
+			"text":    msg,
+		}
+		if len(parseMode) > 0 {
+			req["parse_mode"] = parseMode
+		}
+		if opts.NoUnfurl {
+			req["disable_web_page_preview"] = true
+		}
+		if len(opts.Thread) > 0 {
+			if id, err := strconv.ParseInt(opts.Thread, 10, 64); err == nil {
+				req["reply_to_message_id"] = id
+			}
+		}
+		if err := tc.apiCall("sendMessage", req, nil); err != nil {
+			tc.Log(bot.Error, fmt.Sprintf("Failed sending message to chat %d: %v", chatID, err))
+			return false
+		}
+	}
+	return true
+}
+
+// processMessage examines incoming messages, handles Telegram-style
+// addressing, and routes them to the robot.
+func (tc *tgConnector) processMessage(msg *tgMessage) {
+	if msg.From == nil || len(msg.Text) == 0 {
+		tc.Log(bot.Debug, "Ignoring message with no sender or text")
+		return
+	}
+	if msg.From.ID == tc.botID {
+		tc.Log(bot.Debug, "Ignoring message from self")
+		return
+	}
+	if msg.Chat.Type == "channel" {
+		tc.Log(bot.Debug, "Ignoring channel post")
+		return
+	}
+	direct := msg.Chat.Type == "private"
+	if !direct {
+		tc.RLock()
+		allowed := len(tc.allowedChats) == 0 || tc.allowedChats[msg.Chat.ID]
+		tc.RUnlock()
+		if !allowed {
+			tc.Log(bot.Debug, fmt.Sprintf("Ignoring message in chat %d, not listed in JoinChannels", msg.Chat.ID))
+			return
+		}
+	}
+	tc.seen(*msg.From, msg.Chat)
+
+	text := tc.addressing(msg)
+	botMsg := &bot.ConnectorMessage{
+		Protocol:      "Telegram",
+		UserName:      msg.From.Username,
+		UserID:        strconv.FormatInt(msg.From.ID, 10),
+		DirectMessage: direct,
+		MessageText:   text,
+		MessageID:     strconv.FormatInt(msg.MessageID, 10),
+		MessageObject: msg,
+		Client:        tc.client,
+	}
+	if !direct {
+		botMsg.ChannelName = chatName(msg.Chat)
+		botMsg.ChannelID = strconv.FormatInt(msg.Chat.ID, 10)
+	}
+	tc.IncomingMessage(botMsg)
+}
+
+// addressing rewrites Telegram-style addressing so the robot's name
+// matching sees it:
+//   - '/command@botusername args' becomes '/command args', so robots with
+//     Alias: '/' respond to Telegram commands in groups
+//   - '@botusername' becomes '@BotName'
+//   - a reply to one of the robot's messages is addressed to the robot
+func (tc *tgConnector) addressing(msg *tgMessage) string {
+	text := msg.Text
+	mention := tc.mentionRe
+	if strings.HasPrefix(text, "/") {
+		fields := strings.SplitN(text, " ", 2)
+		fields[0] = mention.ReplaceAllString(fields[0], "")
+		text = strings.Join(fields, " ")
+	}
+	if tc.botName != tc.botUsername {
+		text = mention.ReplaceAllString(text, "@"+tc.botName)
+	}
+	if msg.Chat.Type != "private" && msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil &&
+		msg.ReplyToMessage.From.ID == tc.botID && !strings.HasPrefix(text, "/") &&
+		!strings.Contains(strings.ToLower(text), "@"+strings.ToLower(tc.botName)) {
+		text = "@" + tc.botName + " " + text
+	}
+	return text
+}
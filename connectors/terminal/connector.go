@@ -15,6 +15,7 @@ import (
 type termConnector struct {
 	currentChannel string             // The current channel for the user
 	currentUser    string             // The current userid
+	direct         bool               // set when the user is sending direct messages
 	running        bool               // set on call to Run
 	botName        string             // human-readable name of bot
 	botFullName    string             // human-readble full name of the bot
@@ -46,7 +47,7 @@ func (tc *termConnector) Run(stop <-chan struct{}) {
 		}
 	}(tc)
 
-	tc.reader.Write([]byte("Terminal connector running; Use '|C<channel>' to change channel, or '|U<user>' to change user\n"))
+	tc.reader.Write([]byte("Terminal connector running; Use '|C<channel>' to change channel, '|U<user>' to change user, or '|D' to toggle direct messages\n"))
 
 loop:
 	// Main loop and prompting
@@ -80,7 +81,7 @@ loop:
 					tc.Lock()
 					if newchan == "" {
 						tc.currentChannel = ""
-						tc.reader.SetPrompt(fmt.Sprintf("c:(direct)/u:%s -> ", tc.currentUser))
+						tc.setPrompt()
 						tc.reader.Write([]byte("Changed current channel to: direct message\n"))
 					} else {
 						for _, ch := range tc.channels {
@@ -91,7 +92,8 @@ loop:
 						}
 						if exists {
 							tc.currentChannel = newchan
-							tc.reader.SetPrompt(fmt.Sprintf("c:%s/u:%s -> ", tc.currentChannel, tc.currentUser))
+							tc.direct = false
+							tc.setPrompt()
 							tc.reader.Write([]byte(fmt.Sprintf("Changed current channel to: %s\n", newchan)))
 						} else {
 							tc.reader.Write([]byte("Invalid channel\n"))
@@ -112,23 +114,37 @@ loop:
 						}
 						if exists {
 							tc.currentUser = newuser
-							tc.reader.SetPrompt(fmt.Sprintf("c:%s/u:%s -> ", tc.currentUser, tc.currentChannel))
+							tc.setPrompt()
 							tc.reader.Write([]byte(fmt.Sprintf("Changed current user to: %s\n", newuser)))
 						} else {
 							tc.reader.Write([]byte("Invalid user\n"))
 						}
 					}
 					tc.Unlock()
+				case 'D', 'd':
+					tc.Lock()
+					if len(tc.currentChannel) == 0 {
+						tc.reader.Write([]byte("Already sending direct messages; use '|C<channel>' to change channel\n"))
+					} else {
+						tc.direct = !tc.direct
+						tc.setPrompt()
+						if tc.direct {
+							tc.reader.Write([]byte("Sending direct messages\n"))
+						} else {
+							tc.reader.Write([]byte(fmt.Sprintf("Sending messages to: %s\n", tc.currentChannel)))
+						}
+					}
+					tc.Unlock()
 				default:
 					tc.reader.Write([]byte("Invalid terminal connector command\n"))
 				}
 			} else {
-				var channelID string
-				direct := false
-				if len(tc.currentChannel) > 0 {
+				var channelName, channelID string
+				tc.RLock()
+				direct := tc.direct || len(tc.currentChannel) == 0
+				if !direct {
+					channelName = tc.currentChannel
 					channelID = "#" + tc.currentChannel
-				} else {
-					direct = true
 				}
 				i := userMap[tc.currentUser]
 				ui := tc.users[i]
@@ -136,15 +152,24 @@ loop:
 					Protocol:      "Terminal",
 					UserName:      tc.currentUser,
 					UserID:        ui.InternalID,
-					ChannelName:   tc.currentChannel,
+					ChannelName:   channelName,
 					ChannelID:     channelID,
 					MessageText:   input,
 					DirectMessage: direct,
 				}
-				tc.RLock()
 				tc.IncomingMessage(botMsg)
 				tc.RUnlock()
 			}
 		}
 	}
 }
+
+// setPrompt updates the prompt for the current channel and user; the caller
+// should hold the lock.
+func (tc *termConnector) setPrompt() {
+	channel := tc.currentChannel
+	if tc.direct || len(channel) == 0 {
+		channel = "(direct)"
+	}
+	tc.reader.SetPrompt(fmt.Sprintf("c:%s/u:%s -> ", channel, tc.currentUser))
+}
@@ -32,6 +32,8 @@ var lock sync.Mutex // package var lock
 var started bool    // set when connector is started
 
 func init() {
+	bot.RegisterConnector("terminal", Initialize)
+	// "term" is the original name, kept for existing configurations
 	bot.RegisterConnector("term", Initialize)
 }
 
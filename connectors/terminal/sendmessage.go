@@ -27,6 +27,19 @@ func (tc *termConnector) sendMessage(ch, msg string, f bot.MessageFormat) (ret b
 		tc.Log(bot.Error, "Channel not found:", ch)
 		return bot.ChannelNotFound
 	}
+	// annotate the format, so plugin authors can see what their message
+	// would look like on a protocol that renders it
+	switch f {
+	case bot.Raw:
+		ch += " (raw)"
+	case bot.Fixed:
+		ch += " (fixed)"
+		if strings.Contains(msg, "\n") {
+			msg = "\n" + msg
+		}
+	case bot.Variable:
+		ch += " (variable)"
+	}
 	tc.reader.Write([]byte(fmt.Sprintf("%s: %s\n", ch, msg)))
 	return bot.Ok
 }
@@ -22,9 +22,12 @@ type TestConnector struct {
 	botFullName  string            // human-readble full name of the bot
 	botID        string            // slack internal bot ID
 	users        []testUser        // configured users
+	userIDMap    map[string]int    // map of user ID to index in users
+	userMap      map[string]int    // map of user name to index in users
 	channels     []string          // the channels the robot is in
 	listener     chan *TestMessage // input channel for test functions to send messages from a user
 	speaking     chan *TestMessage // output channel for test functions to get messages from the bot
+	sent         []TestMessage     // every message sent by the bot, for SentMessages
 	test         *testing.T        // for the connector to log
 	bot.Handler                    // bot API for connectors
 	sync.RWMutex                   // shared mutex for locking connector data structures
@@ -41,7 +44,7 @@ loop:
 			break loop
 		case msg := <-tc.listener:
 			var userName, channelID string
-			i, exists := userIDMap[msg.User]
+			i, exists := tc.userIDMap[msg.User]
 			if exists {
 				userName = tc.users[i].Name
 			}
@@ -115,6 +118,9 @@ func (tc *TestConnector) sendMessage(msg *BotMessage) (ret bot.RetVal) {
 	case bot.Raw:
 		spoken.Message = msg.Message
 	}
+	tc.Lock()
+	tc.sent = append(tc.sent, *spoken)
+	tc.Unlock()
 	select {
 	case tc.speaking <- spoken:
 	case <-time.After(200 * time.Millisecond):
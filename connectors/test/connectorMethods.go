@@ -16,9 +16,9 @@ func (tc *TestConnector) getUserInfo(u string) (*testUser, bool) {
 	var i int
 	var exists bool
 	if id, ok := bot.ExtractID(u); ok {
-		i, exists = userIDMap[id]
+		i, exists = tc.userIDMap[id]
 	} else {
-		i, exists = userMap[u]
+		i, exists = tc.userMap[u]
 	}
 	if exists {
 		return &tc.users[i], true
@@ -9,10 +9,6 @@ import (
 	"github.com/lnxjedi/gopherbot/bot"
 )
 
-// Global persistent map of user name to user index
-var userIDMap = make(map[string]int)
-var userMap = make(map[string]int)
-
 // ExportTest lets bot_integration_test safely supply the *testing.T
 var ExportTest = struct {
 	Test *testing.T
@@ -45,11 +41,6 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		robot.Log(bot.Fatal, fmt.Errorf("Unable to retrieve protocol configuration: %v", err))
 	}
 
-	for i, u := range c.Users {
-		userIDMap[u.InternalID] = i
-		userMap[u.Name] = i
-	}
-
 	ExportTest.Lock()
 	t := ExportTest.Test
 	ExportTest.Unlock()
@@ -59,12 +50,19 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		botFullName: c.BotFullName,
 		botID:       "deadbeef", // yes - hex in a string
 		users:       c.Users,
+		userIDMap:   make(map[string]int),
+		userMap:     make(map[string]int),
 		channels:    c.Channels,
 		listener:    make(chan *TestMessage),
 		speaking:    make(chan *TestMessage),
 		test:        t,
 	}
 
+	for i, u := range c.Users {
+		tc.userIDMap[u.InternalID] = i
+		tc.userMap[u.Name] = i
+	}
+
 	tc.Handler = robot
 	tc.SetID(tc.botID)
 	tc.Log(bot.Info, "Set bot ID to", tc.botID)
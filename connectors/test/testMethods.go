@@ -3,8 +3,13 @@ package test
 import (
 	"errors"
 	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
 )
 
+// replyWait is how long SendTestMessage waits for another reply
+const replyWait = time.Second
+
 /* testMethods.go - methods specific to the test connector */
 
 // SendBotMessage for tests to send messages to the 'bot
@@ -44,6 +49,32 @@ func (tc *TestConnector) SendBotMessage(msg *TestMessage) {
 	}
 }
 
+// SendBotAction for tests to click a button with the given action ID and
+// value, in a message in a channel ("" for a direct message).
+func (tc *TestConnector) SendBotAction(user, channel, actionID, value string) {
+	var userName, channelID string
+	if i, exists := tc.userIDMap[user]; exists {
+		userName = tc.users[i].Name
+	} else {
+		tc.test.Errorf("Invalid user: %s", user)
+	}
+	if len(channel) > 0 {
+		channelID = "#" + channel
+	}
+	tc.test.Logf("Action sent to robot: u:%s, c:%s, a:%s, v:%s", user, channel, actionID, value)
+	tc.IncomingAction(&bot.ConnectorAction{
+		Protocol:      "Test",
+		UserName:      userName,
+		UserID:        user,
+		ChannelName:   channel,
+		ChannelID:     channelID,
+		DirectMessage: len(channel) == 0,
+		ActionID:      actionID,
+		Value:         value,
+		Client:        tc,
+	})
+}
+
 // GetBotMessage for tests to get replies
 func (tc *TestConnector) GetBotMessage() (*TestMessage, error) {
 	select {
@@ -58,3 +89,44 @@ func (tc *TestConnector) GetBotMessage() (*TestMessage, error) {
 		return nil, errors.New("Timeout waiting for reply from robot")
 	}
 }
+
+// SendTestMessage sends a message to the robot from a user, given by name or
+// internal ID, in a channel (or "" for a direct message), and returns the
+// robot's replies; replies are gathered until none arrive for a second.
+// The formatting of replies is the same as for GetBotMessage.
+func (tc *TestConnector) SendTestMessage(user, channel, text string) []TestMessage {
+	if i, ok := tc.userMap[user]; ok {
+		tc.RLock()
+		user = tc.users[i].InternalID
+		tc.RUnlock()
+	}
+	tc.SendBotMessage(&TestMessage{user, channel, text})
+	var replies []TestMessage
+	for {
+		select {
+		case incoming := <-tc.speaking:
+			tc.test.Logf("Reply received from robot: u:%s, c:%s, m:%s", incoming.User, incoming.Channel, incoming.Message)
+			replies = append(replies, *incoming)
+		case <-time.After(replyWait):
+			return replies
+		}
+	}
+}
+
+// SentMessages returns every message the robot has sent since the connector
+// started or ClearSentMessages was called, including replies that weren't
+// read by the test.
+func (tc *TestConnector) SentMessages() []TestMessage {
+	tc.RLock()
+	sent := make([]TestMessage, len(tc.sent))
+	copy(sent, tc.sent)
+	tc.RUnlock()
+	return sent
+}
+
+// ClearSentMessages clears the messages returned by SentMessages.
+func (tc *TestConnector) ClearSentMessages() {
+	tc.Lock()
+	tc.sent = nil
+	tc.Unlock()
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	duoapi "github.com/duosecurity/duo_api_golang"
@@ -12,8 +11,6 @@ import (
 	"github.com/lnxjedi/gopherbot/bot"
 )
 
-var timeoutLock sync.RWMutex
-var lastElevate = make(map[string]time.Time)
 var auth *authapi.AuthApi
 
 type timeoutType int
@@ -24,14 +21,12 @@ const (
 )
 
 type config struct {
-	TimeoutSeconds int
-	tf64           float64
-	TimeoutType    string // TimeoutType - one of idle, absolute
-	tt             timeoutType
-	DuoIKey        string
-	DuoSKey        string
-	DuoHost        string
-	DuoUserString  string // DuoUserType - one of handle, email, emailUser
+	TimeoutType   string // TimeoutType - one of idle, absolute
+	tt            timeoutType
+	DuoIKey       string
+	DuoSKey       string
+	DuoHost       string
+	DuoUserString string // DuoUserType - one of handle, email, emailUser
 }
 
 type duoDefault struct {
@@ -383,7 +378,6 @@ func duocommands(r *bot.Robot, command string, args ...string) (retval bot.TaskR
 	if cfg.TimeoutType == "absolute" {
 		cfg.tt = absolute
 	}
-	cfg.tf64 = float64(cfg.TimeoutSeconds)
 	if len(cfg.DuoIKey) == 0 {
 		cfg.DuoIKey = r.GetSecret("IKEY")
 	}
@@ -433,36 +427,15 @@ func duocommands(r *bot.Robot, command string, args ...string) (retval bot.TaskR
 		return configure(r, duouser, res)
 	}
 
-	now := time.Now().UTC()
-	ask := false
-	if immediate {
+	// ElevationWindow is always 0 for ElevateImmediateCommands
+	ask := r.ElevationWindow() == 0
+	if ask {
 		retval = authduo(r, immediate, duouser, res)
 	} else {
-		timeoutLock.RLock()
-		le, ok := lastElevate[r.User]
-		timeoutLock.RUnlock()
-		if ok {
-			diff := now.Sub(le)
-			if diff.Seconds() > cfg.tf64 {
-				ask = true
-			} else {
-				retval = bot.Success
-			}
-		} else {
-			ask = true
-		}
-		if ask {
-			retval = authduo(r, immediate, duouser, res)
-		}
+		retval = bot.Success
 	}
-	if retval == bot.Success && cfg.tt == idle {
-		timeoutLock.Lock()
-		lastElevate[r.User] = now
-		timeoutLock.Unlock()
-	} else if retval == bot.Success && ask && cfg.tt == absolute {
-		timeoutLock.Lock()
-		lastElevate[r.User] = now
-		timeoutLock.Unlock()
+	if retval == bot.Success && (ask || cfg.tt == idle) {
+		r.ElevationSucceeded(ask)
 	}
 	return
 }
@@ -480,8 +453,9 @@ ReplyMatchers:
 CommandMatchers:
 - Command: duoconf
   Regex: (?i:config(?:ure)? duo)
+# How long elevation lasts; defaults to the robot's ElevateTimeout
+# ElevateTimeout: 2h
 Config:
-  TimeoutSeconds: 7200
   TimeoutType: idle # or absolute
 #  DuoIKey: <YourIKey> # ... or set in DUO_IKEY
 #  DuoSKey: <YourSKey> # ... or set in DUO_SKEY
@@ -0,0 +1,487 @@
+package oidc
+
+/* oidc.go - an Elevator that has the user sign in to an OpenID Connect
+   identity provider. When elevation is required, the user gets a direct
+   message with a one-time login URL; the plugin runs a small callback server
+   for the provider's redirect, exchanges the code for an ID token, and grants
+   elevation when the token validates and it's identity claim matches the
+   user. Logins the user abandons time out.
+*/
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type timeoutType int
+
+const (
+	idle timeoutType = iota
+	absolute
+)
+
+const (
+	defaultLoginTimeout = 300
+	// allowed clock skew when checking token expiry
+	clockSkew = time.Minute
+)
+
+type config struct {
+	TimeoutType         string // TimeoutType - one of idle, absolute
+	tt                  timeoutType
+	LoginTimeoutSeconds int      // how long the user has to complete the login, default 300
+	Issuer              string   // the provider's issuer URL, for discovery and token validation
+	ClientID            string   // client registered with the provider
+	ClientSecret        string   // or `store task secret oidc CLIENT_SECRET=<secret>`
+	RedirectURL         string   // public URL of the callback server, registered with the provider
+	ListenAddress       string   // where the callback server listens, e.g. ":8443"
+	TLSCert, TLSKey     string   // optional certificate and key, when not behind a TLS proxy
+	Scopes              []string // default [ "openid", "email" ]
+	Claim               string   // ID token claim identifying the user, default "email"
+	UserString          string   // what the claim should match - one of handle, email (default), emailUser
+}
+
+// provider holds the endpoints from the provider's discovery document
+type provider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+var providers = struct {
+	m map[string]*provider
+	sync.Mutex
+}{
+	make(map[string]*provider),
+	sync.Mutex{},
+}
+
+// pendingLogin is a login sent to a user that hasn't completed
+type pendingLogin struct {
+	user     string // chat user elevating
+	expect   string // value the identity claim should have
+	nonce    string
+	cfg      *config
+	provider *provider
+	result   chan error
+}
+
+var pending = struct {
+	m map[string]*pendingLogin // by state
+	sync.Mutex
+}{
+	make(map[string]*pendingLogin),
+	sync.Mutex{},
+}
+
+var server struct {
+	addr string
+	srv  *http.Server
+	sync.Mutex
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func getJSON(u string, v interface{}) error {
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover looks up and caches the provider's endpoints
+func discover(issuer string) (*provider, error) {
+	providers.Lock()
+	defer providers.Unlock()
+	if p, ok := providers.m[issuer]; ok {
+		return p, nil
+	}
+	p := &provider{}
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", p); err != nil {
+		return nil, err
+	}
+	if len(p.AuthURL) == 0 || len(p.TokenURL) == 0 || len(p.JWKSURL) == 0 {
+		return nil, fmt.Errorf("incomplete discovery document for issuer '%s'", issuer)
+	}
+	providers.m[issuer] = p
+	return p, nil
+}
+
+// signingKey fetches the provider's RSA key with the given key ID
+func (p *provider) signingKey(kid string) (*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(p.JWKSURL, &jwks); err != nil {
+		return nil, err
+	}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (len(kid) > 0 && k.Kid != kid) {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus of key '%s': %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent of key '%s': %v", k.Kid, err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	}
+	return nil, fmt.Errorf("no RSA key with ID '%s' at %s", kid, p.JWKSURL)
+}
+
+// validateIDToken checks the signature and standard claims of an ID token,
+// returning the claims.
+func validateIDToken(p *provider, cfg *config, token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm '%s'", header.Alg)
+	}
+	key, err := p.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %v", err)
+	}
+	var claims map[string]interface{}
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(cb, &claims) != nil {
+		return nil, fmt.Errorf("malformed ID token claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("ID token issuer '%s' doesn't match '%s'", iss, cfg.Issuer)
+	}
+	audOk := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOk = aud == cfg.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == cfg.ClientID {
+				audOk = true
+			}
+		}
+	}
+	if !audOk {
+		return nil, fmt.Errorf("ID token wasn't issued for client '%s'", cfg.ClientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Unix(int64(exp), 0).Add(clockSkew).Before(time.Now()) {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("ID token nonce doesn't match")
+	}
+	return claims, nil
+}
+
+// exchange trades an authorization code for an ID token
+func exchange(p *provider, cfg *config, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {cfg.RedirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s: %s", resp.Status, body)
+	}
+	var tr struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil || len(tr.IDToken) == 0 {
+		return "", fmt.Errorf("no id_token in token response")
+	}
+	return tr.IDToken, nil
+}
+
+// callback handles the provider's redirect after the user signs in
+func callback(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	state := q.Get("state")
+	pending.Lock()
+	pl, ok := pending.m[state]
+	delete(pending.m, state)
+	pending.Unlock()
+	if !ok {
+		http.Error(rw, "Unknown or expired login; try your command again", http.StatusBadRequest)
+		return
+	}
+	err := func() error {
+		if e := q.Get("error"); len(e) > 0 {
+			return fmt.Errorf("provider returned error '%s': %s", e, q.Get("error_description"))
+		}
+		token, err := exchange(pl.provider, pl.cfg, q.Get("code"))
+		if err != nil {
+			return err
+		}
+		claims, err := validateIDToken(pl.provider, pl.cfg, token, pl.nonce)
+		if err != nil {
+			return err
+		}
+		id, _ := claims[pl.cfg.Claim].(string)
+		if !strings.EqualFold(id, pl.expect) {
+			return fmt.Errorf("signed in as '%s', expected '%s'", id, pl.expect)
+		}
+		return nil
+	}()
+	pl.result <- err
+	if err != nil {
+		http.Error(rw, "Login failed, elevation not granted", http.StatusForbidden)
+		return
+	}
+	fmt.Fprintln(rw, "Login complete; you can close this window and return to chat.")
+}
+
+// startServer starts the callback server, or restarts it when the
+// ListenAddress changes.
+func startServer(cfg *config) error {
+	server.Lock()
+	defer server.Unlock()
+	if server.srv != nil {
+		if server.addr == cfg.ListenAddress {
+			return nil
+		}
+		server.srv.Close()
+		server.srv = nil
+	}
+	ru, err := url.Parse(cfg.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("invalid RedirectURL '%s': %v", cfg.RedirectURL, err)
+	}
+	path := ru.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, callback)
+	l, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: mux}
+	tlsCert, tlsKey := cfg.TLSCert, cfg.TLSKey
+	go func() {
+		var err error
+		if len(tlsCert) > 0 {
+			err = srv.ServeTLS(l, tlsCert, tlsKey)
+		} else {
+			err = srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			bot.Log(bot.Error, fmt.Sprintf("OIDC callback server on '%s' stopped: %v", l.Addr(), err))
+		}
+	}()
+	server.addr = cfg.ListenAddress
+	server.srv = srv
+	bot.Log(bot.Info, fmt.Sprintf("OIDC callback server listening on '%s'", cfg.ListenAddress))
+	return nil
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// expectedIdentity returns the value the identity claim should have for the
+// user
+func expectedIdentity(r *bot.Robot, cfg *config) string {
+	switch cfg.UserString {
+	case "handle":
+		return r.User
+	case "emailUser", "emailuser":
+		return strings.Split(r.GetSenderAttribute("email").Attribute, "@")[0]
+	default:
+		return r.GetSenderAttribute("email").Attribute
+	}
+}
+
+// login sends the user a login URL and waits for the callback
+func login(r *bot.Robot, cfg *config, immediate bool) bot.TaskRetVal {
+	expect := expectedIdentity(r, cfg)
+	if len(expect) == 0 {
+		r.Log(bot.Error, fmt.Sprintf("Couldn't determine an identity for user '%s' with UserString '%s'", r.User, cfg.UserString))
+		return bot.MechanismFail
+	}
+	p, err := discover(cfg.Issuer)
+	if err != nil {
+		r.Log(bot.Error, fmt.Sprintf("OIDC discovery for issuer '%s' failed: %v", cfg.Issuer, err))
+		r.Say("Sorry, there was a problem contacting the identity provider - ask an admin to check the log")
+		return bot.MechanismFail
+	}
+	if err := startServer(cfg); err != nil {
+		r.Log(bot.Error, fmt.Sprintf("Starting the OIDC callback server: %v", err))
+		r.Say("Sorry, there was a problem starting the login - ask an admin to check the log")
+		return bot.MechanismFail
+	}
+	pl := &pendingLogin{
+		user:     r.User,
+		expect:   expect,
+		nonce:    randomString(),
+		cfg:      cfg,
+		provider: p,
+		result:   make(chan error, 1),
+	}
+	state := randomString()
+	pending.Lock()
+	pending.m[state] = pl
+	pending.Unlock()
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {cfg.ClientID},
+		"redirect_uri":  {cfg.RedirectURL},
+		"scope":         {strings.Join(cfg.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {pl.nonce},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	loginURL := p.AuthURL + sep + params.Encode()
+
+	dm := ""
+	if r.Channel != "" {
+		dm = " - I'll message you directly"
+	}
+	if immediate {
+		r.Say("This command requires immediate elevation" + dm)
+	} else {
+		r.Say("This command requires elevation" + dm)
+	}
+	timeout := time.Duration(cfg.LoginTimeoutSeconds) * time.Second
+	r.Direct().Say(fmt.Sprintf("Please sign in within %s to continue: %s", timeout, loginURL))
+
+	select {
+	case err := <-pl.result:
+		if err != nil {
+			r.Log(bot.Warn, fmt.Sprintf("OIDC elevation failed for user '%s': %v", r.User, err))
+			r.Direct().Say("Sorry, your login didn't succeed; elevation failed")
+			return bot.Fail
+		}
+		r.Log(bot.Audit, fmt.Sprintf("User '%s' elevated with OIDC login as '%s'", r.User, expect))
+		return bot.Success
+	case <-time.After(timeout):
+		pending.Lock()
+		delete(pending.m, state)
+		pending.Unlock()
+		r.Log(bot.Warn, fmt.Sprintf("User '%s' didn't complete OIDC login within %s", r.User, timeout))
+		r.Direct().Say("Your login timed out; elevation failed")
+		return bot.Fail
+	}
+}
+
+func elevate(r *bot.Robot, command string, args ...string) (retval bot.TaskRetVal) {
+	if command != "elevate" {
+		return
+	}
+	immediate := false
+	if len(args) > 0 {
+		switch args[0] {
+		case "true", "True", "t", "T", "Yes", "yes", "Y":
+			immediate = true
+		}
+	}
+	cfg := &config{}
+	r.GetTaskConfig(&cfg)
+	if cfg.TimeoutType == "absolute" {
+		cfg.tt = absolute
+	}
+	if cfg.LoginTimeoutSeconds <= 0 {
+		cfg.LoginTimeoutSeconds = defaultLoginTimeout
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email"}
+	}
+	if len(cfg.Claim) == 0 {
+		cfg.Claim = "email"
+	}
+	if len(cfg.ClientSecret) == 0 {
+		cfg.ClientSecret = r.GetSecret("CLIENT_SECRET")
+	}
+	if len(cfg.Issuer) == 0 || len(cfg.ClientID) == 0 || len(cfg.ClientSecret) == 0 || len(cfg.RedirectURL) == 0 || len(cfg.ListenAddress) == 0 {
+		r.Log(bot.Error, "OIDC elevator requires Issuer, ClientID, ClientSecret, RedirectURL and ListenAddress")
+		return bot.ConfigurationError
+	}
+
+	// ElevationWindow is always 0 for ElevateImmediateCommands
+	ask := r.ElevationWindow() == 0
+	if ask {
+		retval = login(r, cfg, immediate)
+	} else {
+		retval = bot.Success
+	}
+	if retval == bot.Success && (ask || cfg.tt == idle) {
+		r.ElevationSucceeded(ask)
+	}
+	return
+}
+
+func init() {
+	bot.RegisterPlugin("oidc", bot.PluginHandler{
+		Handler: elevate,
+		Config:  &config{},
+	})
+}
@@ -0,0 +1,150 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer = "https://idp.example.com"
+	testClient = "gopherbot"
+	testKid    = "key1"
+	testNonce  = "n0nce"
+)
+
+// jwksServer serves a JWKS document with the public half of key
+func jwksServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(jwks)
+	}))
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(header) + "." + enc(claims)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestValidateIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := jwksServer(t, key)
+	defer srv.Close()
+	p := &provider{Issuer: testIssuer, JWKSURL: srv.URL}
+	cfg := &config{Issuer: testIssuer, ClientID: testClient}
+
+	header := map[string]interface{}{"alg": "RS256", "kid": testKid}
+	claims := func(change map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   testIssuer,
+			"aud":   testClient,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": testNonce,
+			"email": "alice@example.com",
+		}
+		for k, v := range change {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   string // expected error substring, "" for valid
+	}{
+		{"valid", signToken(t, key, header, claims(nil)), ""},
+		{"audience list", signToken(t, key, header, claims(map[string]interface{}{"aud": []string{"other", testClient}})), ""},
+		{"issuer trailing slash", signToken(t, key, header, claims(map[string]interface{}{"iss": testIssuer + "/"})), ""},
+		{"bad signature", signToken(t, other, header, claims(nil)), "invalid ID token signature"},
+		{"tampered claims", func() string {
+			parts := strings.Split(signToken(t, key, header, claims(nil)), ".")
+			b, _ := json.Marshal(claims(map[string]interface{}{"email": "mallory@example.com"}))
+			parts[1] = base64.RawURLEncoding.EncodeToString(b)
+			return strings.Join(parts, ".")
+		}(), "invalid ID token signature"},
+		{"unsigned", signToken(t, key, map[string]interface{}{"alg": "none", "kid": testKid}, claims(nil)), "unsupported ID token algorithm"},
+		{"unknown key", signToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "key2"}, claims(nil)), "no RSA key with ID 'key2'"},
+		{"wrong issuer", signToken(t, key, header, claims(map[string]interface{}{"iss": "https://evil.example.com"})), "doesn't match"},
+		{"wrong audience", signToken(t, key, header, claims(map[string]interface{}{"aud": "someone-else"})), "wasn't issued for client"},
+		{"missing audience", signToken(t, key, header, claims(map[string]interface{}{"aud": nil})), "wasn't issued for client"},
+		{"expired", signToken(t, key, header, claims(map[string]interface{}{"exp": time.Now().Add(-2 * clockSkew).Unix()})), "expired"},
+		{"within clock skew", signToken(t, key, header, claims(map[string]interface{}{"exp": time.Now().Add(-clockSkew / 2).Unix()})), ""},
+		{"missing expiry", signToken(t, key, header, claims(map[string]interface{}{"exp": nil})), "expired"},
+		{"nonce mismatch", signToken(t, key, header, claims(map[string]interface{}{"nonce": "replayed"})), "nonce doesn't match"},
+		{"missing nonce", signToken(t, key, header, claims(map[string]interface{}{"nonce": nil})), "nonce doesn't match"},
+		{"malformed", "not.a-token", "malformed ID token"},
+	}
+	for _, tc := range tests {
+		got, err := validateIDToken(p, cfg, tc.token, testNonce)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			} else if got["email"] != "alice@example.com" {
+				t.Errorf("%s: wrong claims returned: %v", tc.name, got)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: token validated, want error containing %q", tc.name, tc.err)
+		} else if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %q, want one containing %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := jwksServer(t, key)
+	defer srv.Close()
+	p := &provider{JWKSURL: srv.URL}
+
+	pub, err := p.signingKey(testKid)
+	if err != nil {
+		t.Fatalf("fetching key: %v", err)
+	}
+	if pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		t.Errorf("fetched key doesn't match the signing key")
+	}
+	if _, err := p.signingKey("nonesuch"); err == nil {
+		t.Errorf("got a key for an unknown key ID")
+	}
+}
@@ -5,15 +5,12 @@ import (
 	"encoding/base32"
 	"fmt"
 	"math/rand"
-	"sync"
 	"time"
 
 	otp "github.com/dgryski/dgoogauth"
 	"github.com/lnxjedi/gopherbot/bot"
 )
 
-var timeoutLock sync.RWMutex
-var lastElevate = make(map[string]time.Time)
 var random = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 type timeoutType int
@@ -24,10 +21,9 @@ const (
 )
 
 type config struct {
-	TimeoutSeconds int
-	tf64           float64
-	TimeoutType    string
-	tt             timeoutType
+	TimeoutType string
+	tt          timeoutType
+	Issuer      string // shown in the authenticator app, defaults to the robot's name
 }
 
 var cfg config
@@ -41,7 +37,8 @@ func checkOTP(r *bot.Robot, code string) (bool, bot.TaskRetVal) {
 	}
 	if !exists {
 		r.CheckinDatum(r.User, lock)
-		return false, bot.MechanismFail
+		r.Log(bot.Warn, fmt.Sprintf("User %s requested elevation but hasn't enrolled for TOTP", r.User))
+		return false, bot.ConfigurationError
 	}
 	valid, err := userOTP.Authenticate(code)
 	if err != nil {
@@ -74,6 +71,10 @@ func getcode(r *bot.Robot, immediate bool) (retval bot.TaskRetVal) {
 	}
 	if ret == bot.Ok {
 		ok, ret := checkOTP(r, rep)
+		if ret == bot.ConfigurationError {
+			r.Direct().Say("You don't have launch codes yet; ask me to 'enroll launch codes' and try again")
+			return bot.Fail
+		}
 		if ret != bot.Success {
 			r.Direct().Say("There were technical issues validating your code, ask an administrator to check the log")
 			return bot.MechanismFail
@@ -88,8 +89,57 @@ func getcode(r *bot.Robot, immediate bool) (retval bot.TaskRetVal) {
 	return bot.Fail
 }
 
+// newOTP generates a new random secret for a user
+func newOTP() otp.OTPConfig {
+	otpb := make([]byte, 10)
+	random.Read(otpb)
+	return otp.OTPConfig{
+		Secret:        base32.StdEncoding.EncodeToString(otpb),
+		WindowSize:    2,
+		DisallowReuse: []int{},
+	}
+}
+
+// enroll generates a secret for the user, stores it in the brain, and sends
+// it directly to the user as an otpauth:// URL for their authenticator app.
+func enroll(r *bot.Robot) {
+	if r.Channel != "" {
+		r.Say("Launch codes are secret - I'll message you directly")
+	}
+	var userOTP otp.OTPConfig
+	lock, exists, ret := r.CheckoutDatum(r.User, &userOTP, true)
+	if ret != bot.Ok {
+		r.Direct().Say("Yikes! - Something went wrong with my brain, have an admin check my log")
+		return
+	}
+	if exists {
+		r.CheckinDatum(r.User, lock)
+		r.Direct().Say("You already have launch codes, contact an administrator if you're having problems")
+		return
+	}
+	userOTP = newOTP()
+	if ret = r.UpdateDatum(r.User, lock, &userOTP); ret != bot.Ok {
+		r.Log(bot.Error, "Couldn't save OTP config")
+		r.Direct().Say("Good grief, I'm having trouble remembering your launch codes - have somebody check my log")
+		return
+	}
+	cfg := &config{}
+	r.GetTaskConfig(&cfg)
+	issuer := cfg.Issuer
+	if issuer == "" {
+		issuer = r.GetBotAttribute("name").String()
+	}
+	r.Log(bot.Audit, fmt.Sprintf("Enrolled user %s for TOTP elevation", r.User))
+	d := r.Direct()
+	d.Say("Here are your launch codes; add them to your authenticator app (most apps will accept the URL, or you can type the secret), then delete this message:")
+	d.Fixed().Say(fmt.Sprintf("%s\nSecret: %s", userOTP.ProvisionURIWithIssuer(r.User, issuer), userOTP.Secret))
+}
+
 func elevate(r *bot.Robot, command string, args ...string) (retval bot.TaskRetVal) {
 	switch command {
+	case "enroll":
+		enroll(r)
+		return
 	case "send":
 		var userOTP otp.OTPConfig
 		updated := false
@@ -115,11 +165,7 @@ func elevate(r *bot.Robot, command string, args ...string) (retval bot.TaskRetVa
 			r.Reply("I've already sent you the launch codes, contact an administrator if you're having problems")
 			return
 		}
-		otpb := make([]byte, 10)
-		random.Read(otpb)
-		userOTP.Secret = base32.StdEncoding.EncodeToString(otpb)
-		userOTP.WindowSize = 2
-		userOTP.DisallowReuse = []int{}
+		userOTP = newOTP()
 		var codeMail bytes.Buffer
 		fmt.Fprintf(&codeMail, "For your authenticator:\n%s\n", userOTP.Secret)
 		// Sending email takes longer than the timeout, so we check it in and check
@@ -144,37 +190,15 @@ func elevate(r *bot.Robot, command string, args ...string) (retval bot.TaskRetVa
 		if cfg.TimeoutType == "absolute" {
 			cfg.tt = absolute
 		}
-		cfg.tf64 = float64(cfg.TimeoutSeconds)
-		now := time.Now().UTC()
-		ask := false
-		if immediate {
+		// ElevationWindow is always 0 for ElevateImmediateCommands
+		ask := r.ElevationWindow() == 0
+		if ask {
 			retval = getcode(r, immediate)
 		} else {
-			timeoutLock.RLock()
-			le, ok := lastElevate[r.User]
-			timeoutLock.RUnlock()
-			if ok {
-				diff := now.Sub(le)
-				if diff.Seconds() > cfg.tf64 {
-					ask = true
-				} else {
-					retval = bot.Success
-				}
-			} else {
-				ask = true
-			}
-			if ask {
-				retval = getcode(r, immediate)
-			}
+			retval = bot.Success
 		}
-		if retval == bot.Success && cfg.tt == idle {
-			timeoutLock.Lock()
-			lastElevate[r.User] = now
-			timeoutLock.Unlock()
-		} else if retval == bot.Success && ask && cfg.tt == absolute {
-			timeoutLock.Lock()
-			lastElevate[r.User] = now
-			timeoutLock.Unlock()
+		if retval == bot.Success && (ask || cfg.tt == idle) {
+			r.ElevationSucceeded(ask)
 		}
 		return
 	}
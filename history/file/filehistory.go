@@ -98,6 +98,18 @@ func (fhc *historyConfig) GetHistory(tag string, index int) (io.Reader, error) {
 	return os.Open(filePath)
 }
 
+// RemoveHistory removes the log for a single run, for pruning histories by
+// age
+func (fhc *historyConfig) RemoveHistory(tag string, index int) error {
+	tag = strings.Replace(tag, `\`, ":", -1)
+	tag = strings.Replace(tag, `/`, ":", -1)
+	filePath := path.Join(fhc.Directory, tag, fmt.Sprintf("run-%d.log", index))
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // GetHistoryURL returns the permanent link to the history
 func (fhc *historyConfig) GetHistoryURL(tag string, index int) (string, bool) {
 	if len(fhc.URLPrefix) == 0 {
@@ -1,11 +1,24 @@
+import base64
+import httplib
 import os
 import json
 import random
+import socket
 import subprocess
 import sys
 import time
 import urllib2
 
+class UnixHTTPConnection(httplib.HTTPConnection):
+    "An HTTP connection to the robot's LocalSocket"
+    def __init__(self, socket_path):
+        httplib.HTTPConnection.__init__(self, "localhost")
+        self.socket_path = socket_path
+
+    def connect(self):
+        self.sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.sock.connect(self.socket_path)
+
 class Attribute:
     "A Gopherbot Attribute return object"
     def __init__(self, ret):
@@ -95,18 +108,45 @@ class Robot:
                     "Protocol": self.protocol, "CallerID": self.plugin_id,
                     "FuncArgs": func_args }
         func_json = json.dumps(func_call)
-        req = urllib2.Request(url="%s/json" % os.getenv("GOPHER_HTTP_POST"),
-            data=func_json)
-        req.add_header('Content-Type', 'application/json')
+        headers = { 'Content-Type': 'application/json' }
+        if os.getenv("GOPHER_HTTP_TOKEN"):
+            headers['X-Gopherbot-Token'] = os.getenv("GOPHER_HTTP_TOKEN")
+        if os.getenv("GOPHER_CALLER_TOKEN"):
+            headers['X-Gopherbot-Caller-Token'] = os.getenv("GOPHER_CALLER_TOKEN")
         # sys.stderr.write("Sending: %s\n" % func_json)
-        f = urllib2.urlopen(req)
-        body = f.read()
+        if os.getenv("GOPHER_HTTP_SOCKET"):
+            conn = UnixHTTPConnection(os.getenv("GOPHER_HTTP_SOCKET"))
+            conn.request("POST", "/json", func_json, headers)
+            body = conn.getresponse().read()
+            conn.close()
+        else:
+            req = urllib2.Request(url="%s/json" % os.getenv("GOPHER_HTTP_POST"),
+                data=func_json, headers=headers)
+            f = urllib2.urlopen(req)
+            body = f.read()
         # sys.stderr.write("Got back: %s\n" % body)
         return json.loads(body)
 
     def CheckAdmin(self):
         return self.Call("CheckAdmin", {})["Boolean"]
 
+    def WithinBusinessHours(self):
+        return self.Call("WithinBusinessHours", {})["Boolean"]
+
+    def StartTyping(self):
+        return self.Call("StartTyping", {})["RetVal"]
+
+    def StopTyping(self):
+        return self.Call("StopTyping", {})["RetVal"]
+
+    def ConsumeMessage(self):
+        return self.Call("ConsumeMessage", {})["RetVal"]
+
+    def SendEmail(self, to, subject, body, attachments={}):
+        """attachments is a dict of file name to content"""
+        return self.Call("SendEmail", { "To": to, "Subject": subject, "Body": body,
+        "Attachments": [ { "Name": n, "Content": base64.b64encode(c) } for n, c in attachments.items() ] })["RetVal"]
+
     def Elevate(self, immediate=False):
         return self.Call("Elevate", { "Immediate": immediate })["Boolean"]
 
@@ -138,6 +178,14 @@ class Robot:
     def FailTask(self, name, args):
         return self.Call("FailTask", { "Name": name, "CmdArgs": args })["RetVal"]
 
+    def ScheduleAfter(self, seconds, name, args):
+        "Run a job or plugin once after a delay; returns (ID, RetVal)"
+        ret = self.Call("ScheduleAfter", { "Seconds": int(seconds), "Name": name, "CmdArgs": args })
+        return ret["ID"], ret["RetVal"]
+
+    def CancelScheduled(self, id):
+        return self.Call("CancelScheduled", { "ID": id })["RetVal"]
+
     def AddCommand(self, plugin, cmd):
         return self.Call("AddCommand", { "Plugin": plugin, "Command": cmd })["RetVal"]
 
@@ -153,6 +201,9 @@ class Robot:
     def Exclusive(self, tag, queue_task=False):
         return self.Call("Exclusive", { "Tag": tag, "QueueTask": queue_task })["Boolean"]
 
+    def SaveArtifact(self, name, content):
+        return self.Call("SaveArtifact", { "Name": name, "Content": base64.b64encode(content) })["RetVal"]
+
     def ExtendNamespace(self, ns, hist):
         return self.Call("ExtendNamespace", { "Extend": ns, "Histories": hist })["Boolean"]
 
@@ -230,6 +281,12 @@ class Robot:
         else:
             return self.SendChannelMessage(self.channel, message, format)
 
+    def SayAt(self, when, message, format=""):
+        "Send a message later; when is Unix time in seconds"
+        ret = self.Call("SayAt", { "Time": int(when),
+        "Message": message }, format)
+        return ret["RetVal"]
+
     def Reply(self, message, format=""):
         if self.channel == '':
             return self.SendUserMessage(self.user, message, format)
@@ -1,7 +1,23 @@
+require 'base64'
 require 'json'
 require 'net/http'
+require 'socket'
 require 'uri'
 
+# An HTTP connection to the robot's LocalSocket
+class UnixHTTP < Net::HTTP
+	def initialize(socket_path)
+		super("localhost", 80)
+		@socket_path = socket_path
+	end
+
+	def connect
+		@socket = Net::BufferedIO.new(UNIXSocket.new(@socket_path))
+		@socket.read_timeout = @read_timeout
+		on_connect
+	end
+end
+
 class Attribute
 	def initialize(attr, ret)
 		@attr = attr
@@ -94,6 +110,28 @@ class BaseBot
 		return callBotFunc("CheckAdmin", {})["Boolean"]
 	end
 
+	def WithinBusinessHours()
+		return callBotFunc("WithinBusinessHours", {})["Boolean"]
+	end
+
+	def StartTyping()
+		return callBotFunc("StartTyping", {})["RetVal"]
+	end
+
+	def StopTyping()
+		return callBotFunc("StopTyping", {})["RetVal"]
+	end
+
+	def ConsumeMessage()
+		return callBotFunc("ConsumeMessage", {})["RetVal"]
+	end
+
+	# attachments is a hash of file name to content
+	def SendEmail(to, subject, body, attachments={})
+		files = attachments.map { |name, content| { "Name" => name, "Content" => Base64.strict_encode64(content) } }
+		return callBotFunc("SendEmail", { "To" => to, "Subject" => subject, "Body" => body, "Attachments" => files })["RetVal"]
+	end
+
 	def Elevate(immediate=false)
 		return callBotFunc("Elevate", { "Immediate" => immediate })["Boolean"]
 	end
@@ -118,6 +156,16 @@ class BaseBot
 		return callBotFunc("FailTask", { "Name" => name, "CmdArgs" => args })["RetVal"]
 	end
 
+	# Run a job or plugin once after a delay; returns [ ID, RetVal ]
+	def ScheduleAfter(seconds, name, args)
+		ret = callBotFunc("ScheduleAfter", { "Seconds" => seconds.to_i, "Name" => name, "CmdArgs" => args })
+		return ret["ID"], ret["RetVal"]
+	end
+
+	def CancelScheduled(id)
+		return callBotFunc("CancelScheduled", { "ID" => id })["RetVal"]
+	end
+
 	def AddCommand(name, arg)
 		return callBotFunc("AddCommand", { "Plugin" => name, "Command" => arg })["RetVal"]
 	end
@@ -138,6 +186,10 @@ class BaseBot
 		return callBotFunc("Exclusive", { "Tag" => tag, "QueueTask" => queue_task })["Boolean"]
 	end
 
+	def SaveArtifact(name, content)
+		return callBotFunc("SaveArtifact", { "Name" => name, "Content" => Base64.strict_encode64(content) })["RetVal"]
+	end
+
 	def ExtendNamespace(ns, hist)
 		return callBotFunc("ExtendNamespace", { "Extend" => ns, "Histories" => hist })["Boolean"]
 	end
@@ -247,6 +299,14 @@ class BaseBot
 		end
 	end
 
+	# Send a message later; time can be a Time or Unix time in seconds
+	def SayAt(time, message, format="")
+		format = format.to_s if format.class == Symbol
+		args = { "Time" => time.to_i, "Message" => message }
+		ret = callBotFunc("SayAt", args, format)
+		return ret["RetVal"]
+	end
+
 	def Pause(seconds)
 		sleep seconds
 	end
@@ -296,8 +356,14 @@ class BaseBot
 			"FuncArgs" => args
 		}
 		uri = URI.parse(ENV["GOPHER_HTTP_POST"] + "/json")
-		http = Net::HTTP.new(uri.host, uri.port)
+		if ENV["GOPHER_HTTP_SOCKET"]
+			http = UnixHTTP.new(ENV["GOPHER_HTTP_SOCKET"])
+		else
+			http = Net::HTTP.new(uri.host, uri.port)
+		end
 		req = Net::HTTP::Post.new(uri, initheader = {'Content-Type' =>'application/json'})
+		req['X-Gopherbot-Token'] = ENV["GOPHER_HTTP_TOKEN"] if ENV["GOPHER_HTTP_TOKEN"]
+		req['X-Gopherbot-Caller-Token'] = ENV["GOPHER_CALLER_TOKEN"] if ENV["GOPHER_CALLER_TOKEN"]
 		req.body = func.to_json
 #		STDERR.puts "Sending:\n#{req.body}"
 		res = http.request(req)
@@ -48,6 +48,10 @@ gbPostJSON(){
 	local GB_FUNCARGS="$2"
 	local FORMAT=${3:-$GB_FORMAT}
 	local JSON JSONRET
+	local CURLOPTS=()
+	[ -n "$GOPHER_HTTP_TOKEN" ] && CURLOPTS+=(-H "X-Gopherbot-Token: $GOPHER_HTTP_TOKEN")
+	[ -n "$GOPHER_CALLER_TOKEN" ] && CURLOPTS+=(-H "X-Gopherbot-Caller-Token: $GOPHER_CALLER_TOKEN")
+	[ -n "$GOPHER_HTTP_SOCKET" ] && CURLOPTS+=(--unix-socket "$GOPHER_HTTP_SOCKET")
 	#local GB_DEBUG="true"
 	JSON=$(cat <<EOF
 {
@@ -66,7 +70,7 @@ EOF
 		echo "Sending:" >&2
 		echo "$JSON" >&2
 	fi
-	JSONRET=$(echo "$JSON" | curl -f -X POST -d @- $GOPHER_HTTP_POST/json 2>/dev/null)
+	JSONRET=$(echo "$JSON" | curl -f "${CURLOPTS[@]}" -X POST -d @- $GOPHER_HTTP_POST/json 2>/dev/null)
 	if [ "$GB_DEBUG" = "true" ]
 	then
 		echo "Got back:" >&2
@@ -172,6 +176,12 @@ EOF
 	echo -n "$RETVAL"
 }
 
+# GetTaskConfig - print the task's Config as JSON, for use with jq, e.g.:
+# HOST=$(GetTaskConfig | jq -r .Host)
+GetTaskConfig(){
+	gbPostJSON GetTaskConfig "{}"
+}
+
 SetParameter() {
 	local NAME="$1"
 	local VALUE="$2"
@@ -193,6 +203,25 @@ EOF
 	fi
 }
 
+# SaveArtifact <name> <file> - store a file as a named artifact of the job
+# run; with no file, the artifact is read from stdin
+SaveArtifact() {
+	local SA_NAME="$1"
+	local SA_FILE="${2:--}"
+	local SA_CONTENT
+	SA_CONTENT=$(base64 -w0 "$SA_FILE") || return $GBRET_MissingArguments
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"Name": "$SA_NAME",
+	"Content": "$SA_CONTENT"
+}
+EOF
+)
+	local GB_FUNCNAME="SaveArtifact"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
 ExtendNamespace() {
 	local NS="$1"
 	local HIST="$2"
@@ -277,6 +306,46 @@ SpawnJob(){
 	_pipeTask "SpawnJob" "$@"
 }
 
+# ScheduleAfter <seconds> <job|plugin> [args...] - run a job or plugin once,
+# later; for plugins the first argument is the command. Prints the ID for
+# CancelScheduled.
+ScheduleAfter(){
+	local JSTR
+	local SA_SECONDS="$1"
+	local TNAME="$2"
+	shift 2
+	for ARG in "$@"
+	do
+		JSTR="$JSTR \"$ARG\""
+	done
+	if [ -n "$JSTR" ]
+	then
+		JSTR=$(echo ${JSTR//\" \"/\", \"})
+	fi
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"Seconds": $SA_SECONDS,
+	"Name": "$TNAME",
+	"CmdArgs": [ $JSTR ]
+}
+EOF
+)
+	GB_RET=$(gbPostJSON ScheduleAfter "$GB_FUNCARGS")
+	gbExtract "$GB_RET" ID
+	gbBotRet "$GB_RET"
+}
+
+CancelScheduled(){
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"ID": $1
+}
+EOF
+)
+	GB_RET=$(gbPostJSON CancelScheduled "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
 _cmdTask(){
 	local JSTR
 	local FNAME="$1"
@@ -330,6 +399,70 @@ EOF
 	fi
 }
 
+WithinBusinessHours(){
+	local GB_FUNCARGS="{}"
+	local GB_FUNCNAME="WithinBusinessHours"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS")
+	local RETVAL=$(echo "$GB_RET" | jq .Boolean)
+	if [ "$RETVAL" = "true" ]
+	then
+		return 0
+	else
+		return 1
+	fi
+}
+
+# StartTyping / StopTyping - show the user the robot is working on a long
+# command, on protocols that support it
+StartTyping(){
+	GB_RET=$(gbPostJSON StartTyping "{}")
+	gbBotRet "$GB_RET"
+}
+
+StopTyping(){
+	GB_RET=$(gbPostJSON StopTyping "{}")
+	gbBotRet "$GB_RET"
+}
+
+# ConsumeMessage - stop lower priority plugins with matching MessageMatchers,
+# and further catchalls, from seeing the message
+ConsumeMessage(){
+	GB_RET=$(gbPostJSON ConsumeMessage "{}")
+	gbBotRet "$GB_RET"
+}
+
+# SendEmail <to> <subject> <body> [file ...] - email a user or address
+# through the robot's MailConfig, attaching any files given
+SendEmail(){
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SendEmail"
+	local SE_TO="$1"
+	local SE_SUBJECT SE_BODY SE_FILE SE_CONTENT
+	local SE_ATTACH=""
+	[ $# -lt 3 ] && return $GBRET_MissingArguments
+	SE_SUBJECT=$(echo -n "$2" | jq -Rs .)
+	SE_BODY=$(base64_encode "$3")
+	shift 3
+	for SE_FILE in "$@"
+	do
+		SE_CONTENT=$(base64 -w0 "$SE_FILE") || return $GBRET_MissingArguments
+		[ -n "$SE_ATTACH" ] && SE_ATTACH+=","
+		SE_ATTACH+="{ \"Name\": \"$(basename "$SE_FILE")\", \"Content\": \"$SE_CONTENT\" }"
+	done
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"To": "$SE_TO",
+	"Subject": $SE_SUBJECT,
+	"Body": "$SE_BODY",
+	"Base64": true,
+	"Attachments": [ $SE_ATTACH ]
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
 Elevate(){
 	IMMEDIATE="false"
 	if [ -n "$1" ]
@@ -494,6 +627,9 @@ getFormat(){
 	"-v")
 		echo "Variable"
 		;;
+	"-m")
+		echo "Markup"
+		;;
 	esac
 }
 
@@ -577,6 +713,29 @@ Say(){
 	fi
 }
 
+# SayAt [-f] <unix time> <message> - send a message later
+SayAt(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SayAt"
+	local SA_TIME=$1
+	shift
+	MESSAGE="$*"
+	MESSAGE=$(base64_encode "$MESSAGE")
+
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"Time": $SA_TIME,
+	"Message": "$MESSAGE",
+	"Base64" : true
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
 Reply(){
 	local FARG
 	[[ $1 == -? ]] && { FARG=$1; shift; }
@@ -10,15 +10,20 @@ import (
 
 	// *** Included connectors
 
+	_ "github.com/lnxjedi/gopherbot/connectors/mattermost"
 	_ "github.com/lnxjedi/gopherbot/connectors/slack"
+	_ "github.com/lnxjedi/gopherbot/connectors/telegram"
 	// NOTE: if you build with '-tags test', the terminal connector will also
 	// show emitted events.
 	_ "github.com/lnxjedi/gopherbot/connectors/terminal"
 
 	// *** Included brain implementations
 
+	_ "github.com/lnxjedi/gopherbot/brains/bolt"
 	_ "github.com/lnxjedi/gopherbot/brains/dynamodb"
 	_ "github.com/lnxjedi/gopherbot/brains/file"
+	_ "github.com/lnxjedi/gopherbot/brains/postgres"
+	_ "github.com/lnxjedi/gopherbot/brains/redis"
 
 	// *** Included history implementations
 	_ "github.com/lnxjedi/gopherbot/history/file"
@@ -29,6 +34,7 @@ import (
 	// *** Included Elevator plugins
 
 	_ "github.com/lnxjedi/gopherbot/goplugins/duo"
+	_ "github.com/lnxjedi/gopherbot/goplugins/oidc"
 	_ "github.com/lnxjedi/gopherbot/goplugins/totp"
 
 	// *** Included Authorizer plugins
@@ -0,0 +1,55 @@
+#!/bin/bash
+
+# catchall.sh - a CatchAll plugin with a Priority, for testing catchall
+# ordering, command precedence and ConsumeMessage in the test suite. It
+# answers "knock knock" and passes anything else on to the next catchall by
+# exiting Fail.
+
+# START Boilerplate
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+command=$1
+shift
+# END Boilerplate
+
+configure(){
+	cat <<"EOF"
+---
+Channels: [ "bottest" ]
+AllowDirect: false
+CatchAll: true
+Priority: 10
+CommandMatchers:
+- Command: "ping"
+  Regex: '(?i:ping)'
+MessageMatchers:
+- Command: "heard"
+  Regex: '^(?i:(hear|consume) this)$'
+EOF
+}
+
+case "$command" in
+# NOTE: only "configure" should print anything to stdout
+	"configure")
+		configure
+		;;
+	"catchall")
+		if [[ "$1" =~ ^[Kk]nock\ knock ]]
+		then
+			Say "Who's there?"
+		else
+			exit $PLUGRET_Fail
+		fi
+		;;
+	"ping")
+		Say "Pong from the catchall plugin"
+		;;
+	"heard")
+		Say "$GOPHER_TASK_NAME heard it"
+		if [ "$1" = "consume" ]
+		then
+			ConsumeMessage
+		fi
+		;;
+esac
@@ -21,6 +21,11 @@ CommandMatchers:
   Command: "variable"
 - Regex: '(?i:format raw)'
   Command: "raw"
+- Regex: '(?i:format markup)'
+  Command: "markup"
+- Regex: '(?i:format configured)'
+  Command: "configured"
+  Format: Fixed
 EOF
 }
 
@@ -51,4 +56,11 @@ case "$COMMAND" in
   "raw")
     Say -r '_Italics_ <One> *Bold* `Code` @parsley'
     ;;
+  "markup")
+    Say -m '_Italics_ **Bold** ~~Struck~~ `**Code**` [Docs](https://example.com/docs)'
+    ;;
+  "configured")
+    # Use the format configured for the command
+    Say '_Italics_ <One> *Bold* `Code` @parsley'
+    ;;
 esac
\ No newline at end of file
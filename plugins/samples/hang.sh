@@ -0,0 +1,32 @@
+#!/bin/bash
+
+# hang.sh - a plugin that never finishes, for testing task timeouts in the
+# test suite.
+
+# START Boilerplate
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+command=$1
+shift
+# END Boilerplate
+
+configure(){
+	cat <<"EOF"
+---
+CommandMatchers:
+- Command: "hang"
+  Regex: '(?i:hang)'
+EOF
+}
+
+case "$command" in
+# NOTE: only "configure" should print anything to stdout
+	"configure")
+		configure
+		;;
+	"hang")
+		sleep 30
+		Say "I'm awake!"
+		;;
+esac
@@ -19,6 +19,21 @@ CommandMatchers:
   Regex: '(?i:waitask)'
 - Command: "asknow"
   Regex: '(?i:asknow)'
+- Command: "deploy"
+  Regex: '(?i:deploy (?P<BRANCH>[\w-]+) to (?P<ENVIRONMENT>prod|stage))'
+- Command: "shout"
+  Regex: 'shout (\w+)'
+  Insensitive: true
+- Command: "remind"
+  Regex: '(?i:remind me in (\d+)s (.+))'
+- Command: "cancelreminder"
+  Regex: '(?i:cancel reminder (\d+))'
+- Command: "schedulequit"
+  Regex: '(?i:quit in (\d+)s)'
+- Command: "greeting"
+  Regex: '(?i:greeting)'
+- Command: "count"
+  Regex: '(?i:count up)'
 EOF
 }
 
@@ -39,4 +54,54 @@ case "$command" in
 		REPLY=$(PromptForReply YesNo "Do you like puppies?")
 		sleep 1
 		Say "I like puppies too!"		
+		;;
+	"deploy")
+		# named capture groups are also set as parameters
+		Say "Deploying $BRANCH to $ENVIRONMENT ($1, $2)"
+		;;
+	"shout")
+		Say "${1^^}!"
+		;;
+	"remind")
+		ID=$(ScheduleAfter $1 test reminder "$2") || { Say "Couldn't schedule reminder"; exit 0; }
+		Say "Scheduled reminder #$ID"
+		;;
+	"schedulequit")
+		# plugins can only schedule their own commands
+		ScheduleAfter $1 builtin-admin quit >/dev/null || Say "Couldn't schedule quit"
+		;;
+	"reminder")
+		# run by ScheduleAfter
+		Say "Reminder: $1"
+		;;
+	"greeting")
+		Say "$(GetTaskConfig | jq -r .Greeting)"
+		;;
+	"count")
+		# Check out a counter read-write and let the lock expire while
+		# another checkout updates it; the first update then fails with
+		# DatumLockExpired, and is retried.
+		checkout(){ gbPostJSON CheckoutDatum '{ "Key": "counter", "RW": true }'; }
+		update(){ gbPostJSON UpdateDatum "{ \"Key\": \"counter\", \"Token\": \"$1\", \"Datum\": $2 }" | jq -r .RetVal; }
+		FIRST=$(checkout)
+		sleep 3
+		SECOND=$(checkout)
+		update $(echo "$SECOND" | jq -r .LockToken) $(echo "$SECOND" | jq '(.Datum // 0) + 1') >/dev/null
+		RET=$(update $(echo "$FIRST" | jq -r .LockToken) $(echo "$FIRST" | jq '(.Datum // 0) + 1'))
+		[ "$RET" -eq $GBRET_DatumLockExpired ] && Say "Lock expired, checking out again"
+		RETRY=$(checkout)
+		COUNT=$(echo "$RETRY" | jq '(.Datum // 0) + 1')
+		RET=$(update $(echo "$RETRY" | jq -r .LockToken) $COUNT)
+		[ "$RET" -eq $GBRET_Ok ] && Say "Counter is $COUNT"
+		RET=$(update "0123456789abcdef" $COUNT)
+		[ "$RET" -eq $GBRET_DatumNotFound ] && Say "No checkout for that token"
+		;;
+	"cancelreminder")
+		if CancelScheduled $1
+		then
+			Say "Cancelled reminder #$1"
+		else
+			Say "No reminder #$1"
+		fi
+		;;
 esac
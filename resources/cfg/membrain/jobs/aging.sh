@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# aging.sh - a job for testing pruning of runs by MaxHistoryAge.
+
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+Say "aging run $GOPHER_RUN_INDEX"
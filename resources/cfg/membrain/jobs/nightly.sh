@@ -0,0 +1,11 @@
+#!/bin/bash
+
+# nightly.sh - a failing job for testing NotifyEmail; the test robot has no
+# MailConfig, so the Notify user gets a direct message instead.
+
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+echo "checking the widgets"
+echo "the widgets are broken" >&2
+exit 1
@@ -0,0 +1,23 @@
+#!/bin/bash
+
+# pipeline.sh - a job for testing pipelines built with AddTask; also
+# configured as the 'pipestep' task, called with 'step'.
+
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+if [ "$1" = "step" ]
+then
+	Say "step sees greeting: $GREETING"
+	exit 0
+fi
+SetParameter GREETING "hello from $GOPHER_JOB_NAME"
+# final tasks run in reverse order, whether or not the pipeline fails
+FinalTask status "final task added first"
+FinalTask fail "failing final task"
+FinalTask status "final task added last"
+AddTask pipestep step
+AddTask softfail "failure that's ignored"
+AddTask status "after the ignored failure"
+AddTask fail "failure that isn't ignored"
+AddTask status "never reached"
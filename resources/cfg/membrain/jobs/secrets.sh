@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# secrets.sh - a job for testing ${secret:NAME} references in Parameters.
+
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+Say "the token is $API_TOKEN"
@@ -0,0 +1,15 @@
+#!/bin/bash
+
+# webhook.sh - a job for testing the /trigger/<job> webhook endpoint; BRANCH
+# is set from the named capture group in the trigger.
+
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+if [ "$BRANCH" = "broken" ]
+then
+	Say "Refusing to build $BRANCH"
+	exit 1
+fi
+echo "build log for $BRANCH" | SaveArtifact build.log
+Say "Building $BRANCH"